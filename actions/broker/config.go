@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/miniprow"
+)
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the MiniProw configuration file",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate [path]",
+		Short: "Validate a configuration file and print the effective configuration",
+		Long: "Validate a configuration file and print the effective configuration. " +
+			"The default path is " + filepath.Join(miniprow.MiniProwDir, miniprow.MiniProwConf) +
+			" in the repository root.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := filepath.Join(rootOpts.repoRoot, miniprow.MiniProwDir, miniprow.MiniProwConf)
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return validateConfig(cmd.OutOrStdout(), path)
+		},
+	})
+	return cmd
+}
+
+// effectiveConfig is the configuration after applying the defaults
+type effectiveConfig struct {
+	Path           string   `json:"path,omitempty"`
+	RequiredLabels []string `json:"requiredLabels"`
+	BlockingLabels []string `json:"blockingLabels"`
+	RequiredChecks []string `json:"requiredChecks"`
+	IgnoredChecks  []string `json:"ignoredChecks"`
+	FlakyChecks    []string `json:"flakyChecks"`
+	FlakyRetries   int      `json:"flakyRetries"`
+	AutoMerge      bool     `json:"autoMerge"`
+	RateLimitFloor int      `json:"rateLimitFloor"`
+	SweepMaxMerges int      `json:"sweepMaxMerges"`
+	MergeStrategy  string   `json:"mergeStrategy"`
+	MergePool      struct {
+		Mode         string `json:"mode"`
+		Branch       string `json:"branch"`
+		MaxBatchSize int    `json:"maxBatchSize"`
+		CheckTimeout string `json:"checkTimeout"`
+	} `json:"mergePool"`
+}
+
+func validateConfig(w io.Writer, path string) error {
+	conf := &miniprow.DefaultConfig
+	if fileExists(path) {
+		if err := miniprow.ValidateConfigFile(path); err != nil {
+			return fmt.Errorf("validating %s: %w", path, err)
+		}
+		var err error
+		conf, err = miniprow.ParseConfigFile(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+	} else {
+		path = ""
+	}
+
+	res := effectiveConfig{
+		Path:           path,
+		RequiredLabels: conf.RequiredLabels(),
+		BlockingLabels: conf.BlockingLabels(),
+		RequiredChecks: conf.RequiredChecks(),
+		IgnoredChecks:  conf.IgnoredChecks(),
+		FlakyChecks:    conf.FlakyChecks(),
+		FlakyRetries:   conf.FlakyRetries(),
+		AutoMerge:      conf.Options().AutoMerge,
+		RateLimitFloor: conf.RateLimitFloor(),
+		SweepMaxMerges: conf.SweepMaxMerges(),
+		MergeStrategy:  conf.MergeStrategy(),
+	}
+	pool := conf.MergePool()
+	res.MergePool.Mode = pool.Mode
+	res.MergePool.Branch = pool.Branch
+	res.MergePool.MaxBatchSize = pool.MaxBatchSize
+	res.MergePool.CheckTimeout = pool.CheckTimeout.String()
+	return rootOpts.print(w, res, func(w io.Writer) {
+		if res.Path == "" {
+			fmt.Fprintln(w, "No configuration file found, using the defaults")
+		} else {
+			fmt.Fprintf(w, "%s is valid\n", res.Path)
+		}
+		fmt.Fprintf(w, "Required labels: %s\n", strings.Join(res.RequiredLabels, ", "))
+		fmt.Fprintf(w, "Blocking labels: %s\n", strings.Join(res.BlockingLabels, ", "))
+		fmt.Fprintf(w, "Required checks: %s\n", strings.Join(res.RequiredChecks, ", "))
+		fmt.Fprintf(w, "Ignored checks:  %s\n", strings.Join(res.IgnoredChecks, ", "))
+		fmt.Fprintf(w, "Flaky checks:    %s\n", strings.Join(res.FlakyChecks, ", "))
+		if len(res.FlakyChecks) > 0 {
+			fmt.Fprintf(w, "  Retries per commit: %d\n", res.FlakyRetries)
+		}
+		fmt.Fprintf(w, "Auto merge:      %t\n", res.AutoMerge)
+		fmt.Fprintf(w, "Rate limit floor: %d\n", res.RateLimitFloor)
+		fmt.Fprintf(w, "Sweep max merges: %d\n", res.SweepMaxMerges)
+		fmt.Fprintf(w, "Merge strategy:  %s\n", res.MergeStrategy)
+		fmt.Fprintf(w, "Merge pool:      %s\n", res.MergePool.Mode)
+		if res.MergePool.Mode != miniprow.MergePoolOff {
+			fmt.Fprintf(w, "  Staging branch: %s\n", res.MergePool.Branch)
+			fmt.Fprintf(w, "  Max batch size: %d\n", res.MergePool.MaxBatchSize)
+			fmt.Fprintf(w, "  Check timeout:  %s\n", res.MergePool.CheckTimeout)
+		}
+	})
+}
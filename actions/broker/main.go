@@ -1,33 +1,128 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/github"
 	"github.com/uservers/miniprow/pkg/miniprow"
 )
 
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// rootOptions are the flags shared by all subcommands
+type rootOptions struct {
+	output   string
+	logLevel string
+	repoRoot string
+}
+
+var rootOpts = &rootOptions{}
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "-s" {
-		os.Setenv("MINIPROW_EVENT", "COMMENT")
-		os.Setenv("MINIPROW_TOKEN", os.Getenv("GITHUB_TOKEN"))
-		//nolint:gocritic
-		//	os.Setenv("MINIPROW_COMMENT", "805368288")
-		os.Setenv("MINIPROW_COMMENT", "806320613")
-		os.Setenv("MINIPROW_REPO", "uServers/ulabs-infrastructure")
-		//nolint:gocritic
-		// os.Setenv("MINIPROW_ISSUE", "839225055")
-		os.Setenv("MINIPROW_PR", "64")
-	}
-
-	broker, err := miniprow.NewBroker()
-	if err != nil {
-		logrus.Error(fmt.Errorf("creating MiniProw broker: %w", err))
+	if err := newRootCommand().ExecuteContext(context.Background()); err != nil {
+		logrus.Error(err)
 		os.Exit(1)
 	}
-	if err := broker.Run(); err != nil {
-		logrus.Error(fmt.Errorf("miniprow broker run returned error: %w", err))
-		os.Exit(1)
+}
+
+// newRootCommand builds the broker command line. Without a subcommand
+// the broker runs on the event defined in the environment, which is
+// how the GitHub action invokes it.
+func newRootCommand() *cobra.Command {
+	runOpts := &runOptions{}
+	root := &cobra.Command{
+		Use:           "broker",
+		Short:         "MiniProw broker: handles pull request events and merges",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			return rootOpts.validate()
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return executeBroker(cmd.Context(), cmd.OutOrStdout(), runOpts)
+		},
+	}
+	root.PersistentFlags().StringVarP(
+		&rootOpts.output, "output", "o", outputText, "output format: text or json",
+	)
+	root.PersistentFlags().StringVar(
+		&rootOpts.logLevel, "log-level", "info", "logging level: debug, info, warn, error",
+	)
+	root.PersistentFlags().StringVar(
+		&rootOpts.repoRoot, "repo-root", "",
+		"path to the repository clone (defaults to $GITHUB_WORKSPACE or the current directory)",
+	)
+	runOpts.addFlags(root)
+
+	root.AddCommand(
+		newRunCommand(),
+		newOwnersCommand(),
+		newConfigCommand(),
+		newPRCommand(),
+		newSimulateCommand(),
+		newSweepCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+// validate checks the shared flags and applies them
+func (o *rootOptions) validate() error {
+	if o.output != outputText && o.output != outputJSON {
+		return fmt.Errorf("invalid output format %q", o.output)
+	}
+	level, err := logrus.ParseLevel(o.logLevel)
+	if err != nil {
+		return fmt.Errorf("parsing log level: %w", err)
+	}
+	logrus.SetLevel(level)
+
+	// The broker finds the repository through GITHUB_WORKSPACE
+	if o.repoRoot == "" {
+		o.repoRoot = os.Getenv("GITHUB_WORKSPACE")
+	}
+	if o.repoRoot == "" {
+		o.repoRoot = "."
+	}
+	return os.Setenv("GITHUB_WORKSPACE", o.repoRoot)
+}
+
+// print writes v as JSON or calls text to write the human output
+func (o *rootOptions) print(w io.Writer, v any, text func(io.Writer)) error {
+	if o.output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(w)
+	return nil
+}
+
+// newGitHub returns a GitHub client authenticated with the token and
+// API URL from the environment
+func newGitHub() (*github.GitHub, error) {
+	data := miniprow.NewContextData()
+	token := data.GitHubToken()
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return nil, errors.New("GitHub token not found, set MINIPROW_TOKEN or GITHUB_TOKEN")
+	}
+	gh, err := github.NewWithToken(token, data.APIURL())
+	if err != nil {
+		return nil, fmt.Errorf("creating GitHub client: %w", err)
 	}
+	return gh, nil
 }
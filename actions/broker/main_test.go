@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/github/githubtest"
+	"github.com/uservers/miniprow/pkg/owners"
+)
+
+const testRepo = "uservers/test"
+
+// buildBroker compiles the broker binary into a temporary directory
+func buildBroker(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping broker integration test in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found, skipping broker integration test")
+	}
+	bin := filepath.Join(t.TempDir(), "broker")
+	out, err := exec.Command(gobin, "build", "-o", bin, ".").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return bin
+}
+
+// mkWorkspace creates the repository clone the broker reads OWNERS from
+func mkWorkspace(t *testing.T) string {
+	dir := t.TempDir()
+	for path, data := range map[string]string{
+		".git/config":  "\n",
+		"README.md":    "test",
+		"OWNERS":       "approvers:\n  - alice\nreviewers:\n  - alice\n  - bob\n",
+		"sub/OWNERS":   "approvers:\n  - carol\n",
+		"sub/file.txt": "test",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+	return dir
+}
+
+// runBroker executes the broker binary against the fake server
+func runBroker(t *testing.T, bin, apiURL, workspace string, vars map[string]string) {
+	runCommand(t, bin, apiURL, workspace, vars)
+}
+
+// runCommand executes a broker subcommand against the fake server and
+// returns its standard output
+func runCommand(t *testing.T, bin, apiURL, workspace string, vars map[string]string, args ...string) []byte {
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "MINIPROW_") && !strings.HasPrefix(e, "GITHUB_") {
+			env = append(env, e)
+		}
+	}
+	env = append(env,
+		"MINIPROW_REPO="+testRepo,
+		"MINIPROW_TOKEN=fake-token",
+		"MINIPROW_API_URL="+apiURL,
+		"GITHUB_WORKSPACE="+workspace,
+	)
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = env
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	require.NoError(t, err, stderr.String())
+	return out
+}
+
+func TestBrokerIntegration(t *testing.T) {
+	bin := buildBroker(t)
+	workspace := mkWorkspace(t)
+
+	server, err := githubtest.NewServerFromFixtures(filepath.Join("testdata", "fixtures.yaml"))
+	require.NoError(t, err)
+	defer server.Close()
+	fake := server.Fake
+
+	// A new PR from an approver gets labeled and the notifier is posted
+	runBroker(t, bin, server.URL, workspace, map[string]string{
+		"MINIPROW_EVENT": "NEWPR", "MINIPROW_PR": "1",
+	})
+	require.Equal(t, []string{"approved", "lgtm"}, fake.IssueLabels(testRepo, 1))
+	comments := fake.Comments(testRepo, 1)
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0].GetBody(), "[APPROVALNOTIFIER]")
+
+	// The notifier comment triggers the merge
+	runBroker(t, bin, server.URL, workspace, map[string]string{
+		"MINIPROW_EVENT":   "COMMENT",
+		"MINIPROW_PR":      "1",
+		"MINIPROW_COMMENT": strconv.FormatInt(comments[0].GetID(), 10),
+	})
+	require.True(t, fake.IsMerged(testRepo, 1))
+	require.Empty(t, fake.Comments(testRepo, 1))
+
+	// A reviewer's /lgtm applies the label but does not merge
+	comments = fake.Comments(testRepo, 2)
+	require.Len(t, comments, 1)
+	runBroker(t, bin, server.URL, workspace, map[string]string{
+		"MINIPROW_EVENT":   "COMMENT",
+		"MINIPROW_PR":      "2",
+		"MINIPROW_COMMENT": strconv.FormatInt(comments[0].GetID(), 10),
+	})
+	require.Equal(t, []string{"lgtm"}, fake.IssueLabels(testRepo, 2))
+	require.Len(t, fake.Comments(testRepo, 2), 2)
+	require.Equal(t, []int{1}, fake.Merges(testRepo))
+
+	// /retest re-runs the failed build and replies
+	comments = fake.Comments(testRepo, 3)
+	require.Len(t, comments, 1)
+	runBroker(t, bin, server.URL, workspace, map[string]string{
+		"MINIPROW_EVENT":   "COMMENT",
+		"MINIPROW_PR":      "3",
+		"MINIPROW_COMMENT": strconv.FormatInt(comments[0].GetID(), 10),
+	})
+	require.Len(t, fake.FailedJobReruns(testRepo), 1)
+	require.Contains(t, fake.Comments(testRepo, 3)[1].GetBody(), "@bob: re-triggered these workflows")
+}
+
+func TestBrokerCommands(t *testing.T) {
+	bin := buildBroker(t)
+	workspace := mkWorkspace(t)
+
+	server, err := githubtest.NewServerFromFixtures(filepath.Join("testdata", "fixtures.yaml"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	// version
+	info := map[string]any{}
+	out := runCommand(t, bin, server.URL, workspace, nil, "version", "-o", "json")
+	require.NoError(t, json.Unmarshal(out, &info))
+	require.Contains(t, info, "gitVersion")
+
+	// owners explain
+	explanation := owners.Explanation{}
+	out = runCommand(t, bin, server.URL, workspace, nil, "owners", "explain", "sub/file.txt", "-o", "json")
+	require.NoError(t, json.Unmarshal(out, &explanation))
+	require.Equal(t, []owners.Grant{
+		{User: "alice", File: "OWNERS", Line: 2},
+		{User: "carol", File: "sub/OWNERS", Line: 2},
+	}, explanation.Approvers)
+	require.Len(t, explanation.Files, 2)
+	require.Equal(t, "sub/OWNERS", explanation.Files[0].Path)
+
+	// owners index
+	ownerships := []owners.Ownership{}
+	out = runCommand(t, bin, server.URL, workspace, nil, "owners", "index", "alice", "-o", "json")
+	require.NoError(t, json.Unmarshal(out, &ownerships))
+	require.Equal(t, []owners.Ownership{
+		{User: "alice", Path: ".", Role: owners.RoleApprover, File: "OWNERS", Line: 2},
+		{User: "alice", Path: ".", Role: owners.RoleReviewer, File: "OWNERS", Line: 4},
+	}, ownerships)
+	out = runCommand(t, bin, server.URL, workspace, nil, "owners", "index")
+	require.Contains(t, string(out), "carol  sub   approver  sub/OWNERS:2")
+
+	// owners and config validate
+	require.Contains(
+		t, string(runCommand(t, bin, server.URL, workspace, nil, "owners", "validate")),
+		`OWNERS:2: warning: "alice" is not a defined alias nor a member of one`,
+	)
+	problems := []owners.Problem{}
+	out = runCommand(
+		t, bin, server.URL, workspace, nil,
+		"owners", "validate", "--check-collaborators", "--repo", testRepo, "-o", "json",
+	)
+	require.NoError(t, json.Unmarshal(out, &problems))
+	require.Empty(t, problems)
+	server.Fake.SetError("IsCollaborator", github.ErrForbidden)
+	out = runCommand(
+		t, bin, server.URL, workspace, nil,
+		"owners", "validate", "--check-collaborators", "--repo", testRepo, "-o", "json",
+	)
+	require.NoError(t, json.Unmarshal(out, &problems))
+	require.NotEmpty(t, problems)
+	require.Contains(t, problems[0].Message, "unable to check if")
+	server.Fake.SetError("IsCollaborator", nil)
+	require.Contains(t, string(runCommand(t, bin, server.URL, workspace, nil, "config", "validate")), "defaults")
+
+	// pr status
+	status := map[string]any{}
+	out = runCommand(t, bin, server.URL, workspace, nil, "pr", "status", testRepo+"#2", "-o", "json")
+	require.NoError(t, json.Unmarshal(out, &status))
+	require.Equal(t, []any{"approved", "lgtm"}, status["missingLabels"])
+	require.Equal(t, false, status["labelsReady"])
+
+	// simulate the /lgtm comment in PR 2: nothing changes in GitHub
+	comments := server.Fake.Comments(testRepo, 2)
+	require.Len(t, comments, 1)
+	eventFile := filepath.Join(t.TempDir(), "event.json")
+	require.NoError(t, os.WriteFile(eventFile, []byte(`{
+  "action": "created",
+  "comment": {"id": `+strconv.FormatInt(comments[0].GetID(), 10)+`},
+  "issue": {"number": 2, "pull_request": {}},
+  "repository": {"full_name": "`+testRepo+`"}
+}`), os.FileMode(0o644)))
+	sim := struct {
+		Event   string `json:"event"`
+		Actions []struct {
+			Type  string `json:"type"`
+			Label string `json:"label"`
+		} `json:"actions"`
+	}{}
+	out = runCommand(t, bin, server.URL, workspace, nil, "simulate", "--event", eventFile, "-o", "json")
+	require.NoError(t, json.Unmarshal(out, &sim))
+	require.Equal(t, "COMMENT", sim.Event)
+	require.Equal(t, "AddLabel", sim.Actions[0].Type)
+	require.Equal(t, "lgtm", sim.Actions[0].Label)
+	require.Empty(t, server.Fake.IssueLabels(testRepo, 2))
+	require.Len(t, server.Fake.Comments(testRepo, 2), 1)
+
+	// run --dry-run records the changes to PR 1 without making them
+	result := struct {
+		DryRun  bool                   `json:"dryRun"`
+		Planned []github.PlannedAction `json:"planned"`
+	}{}
+	out = runCommand(
+		t, bin, server.URL, workspace, map[string]string{"MINIPROW_EVENT": "NEWPR", "MINIPROW_PR": "1"},
+		"run", "--dry-run", "-o", "json",
+	)
+	require.NoError(t, json.Unmarshal(out, &result))
+	require.True(t, result.DryRun)
+	require.NotEmpty(t, result.Planned)
+	require.Empty(t, server.Fake.IssueLabels(testRepo, 1))
+	require.Empty(t, server.Fake.Comments(testRepo, 1))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/owners"
+)
+
+func newOwnersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "owners",
+		Short: "Inspect the OWNERS files of the repository",
+	}
+	cmd.AddCommand(newOwnersExplainCommand(), newOwnersValidateCommand(), newOwnersIndexCommand())
+	return cmd
+}
+
+func newOwnersExplainCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain <path>",
+		Short: "Show the approvers and reviewers of a path and the OWNERS files that define them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return explainOwners(cmd.OutOrStdout(), args[0])
+		},
+	}
+}
+
+func explainOwners(w io.Writer, path string) error {
+	exp, err := owners.NewReader().Explain(filepath.Join(rootOpts.repoRoot, path))
+	if err != nil {
+		return fmt.Errorf("reading owners of %s: %w", path, err)
+	}
+
+	// Print paths relative to the repository
+	exp.Path = path
+	if exp.CutOff != "" {
+		exp.CutOff = repoRelative(exp.CutOff)
+	}
+	for i := range exp.Files {
+		exp.Files[i].Path = repoRelative(exp.Files[i].Path)
+		relativeGrants(exp.Files[i].Approvers)
+		relativeGrants(exp.Files[i].Reviewers)
+	}
+	for i := range exp.Aliases {
+		exp.Aliases[i].File = repoRelative(exp.Aliases[i].File)
+	}
+	relativeGrants(exp.Approvers)
+	relativeGrants(exp.Reviewers)
+
+	return rootOpts.print(w, exp, func(w io.Writer) {
+		fmt.Fprintf(w, "Path: %s\n\n", exp.Path)
+		fmt.Fprintln(w, "OWNERS files consulted:")
+		for i, f := range exp.Files {
+			cutoff := ""
+			if f.NoParentOwners {
+				cutoff = " (no_parent_owners: parent files are not consulted)"
+			}
+			fmt.Fprintf(w, "  %d. %s%s\n", i+1, f.Path, cutoff)
+		}
+		if len(exp.Aliases) > 0 {
+			fmt.Fprintln(w, "\nAliases expanded:")
+			for _, a := range exp.Aliases {
+				fmt.Fprintf(w, "  %s:%d %s → %s\n", a.File, a.Line, a.Alias, strings.Join(a.Users, ", "))
+			}
+		}
+		fmt.Fprintln(w, "\nApprovers:")
+		for _, g := range exp.Approvers {
+			fmt.Fprintf(w, "  %s\n", g)
+		}
+		fmt.Fprintln(w, "\nReviewers:")
+		for _, g := range exp.Reviewers {
+			fmt.Fprintf(w, "  %s\n", g)
+		}
+	})
+}
+
+func newOwnersIndexCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "index [user...]",
+		Short: "List the directories each user can approve or review",
+		Long: "List the directories where users are approvers or reviewers, directly or\n" +
+			"through an alias. Rights extend to the subdirectories except the ones\n" +
+			"listed as exceptions, which set no_parent_owners. Without arguments, all\n" +
+			"the users in the OWNERS files are listed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return indexOwners(cmd.OutOrStdout(), args)
+		},
+	}
+}
+
+func indexOwners(w io.Writer, users []string) error {
+	idx, err := owners.NewReader().BuildIndex(rootOpts.repoRoot)
+	if err != nil {
+		return fmt.Errorf("indexing OWNERS files: %w", err)
+	}
+
+	ownerships := idx.Ownerships()
+	if len(users) > 0 {
+		ownerships = []owners.Ownership{}
+		for _, u := range users {
+			ownerships = append(ownerships, idx.UserOwnerships(u)...)
+		}
+	}
+
+	return rootOpts.print(w, ownerships, func(w io.Writer) {
+		if len(ownerships) == 0 {
+			fmt.Fprintln(w, "No directories found")
+			return
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tPATH\tROLE\tSOURCE\tEXCEPT")
+		for _, o := range ownerships {
+			source := fmt.Sprintf("%s:%d", o.File, o.Line)
+			if o.Alias != "" {
+				source += " via " + o.Alias
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.User, o.Path, o.Role, source, strings.Join(o.Except, ","))
+		}
+		tw.Flush()
+	})
+}
+
+// relativeGrants makes the file paths in the grants relative to the repository
+func relativeGrants(grants []owners.Grant) {
+	for i := range grants {
+		grants[i].File = repoRelative(grants[i].File)
+	}
+}
+
+type validateOwnersOptions struct {
+	checkCollaborators bool
+	repo               string
+}
+
+func newOwnersValidateCommand() *cobra.Command {
+	opts := &validateOwnersOptions{}
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Lint the OWNERS and OWNERS_ALIASES files in the repository",
+		Long: "Lint the OWNERS and OWNERS_ALIASES files in the repository. It reports\n" +
+			"syntax errors, unknown keys, empty approver sets, undefined, nested and\n" +
+			"unused aliases, duplicate entries and invalid filter regular expressions.\n" +
+			"Names that are not aliases nor alias members may be undefined aliases,\n" +
+			"they are reported unless --check-collaborators is set: then users are\n" +
+			"checked against the repository collaborators using MINIPROW_TOKEN or\n" +
+			"GITHUB_TOKEN.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return validateOwners(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+	cmd.Flags().BoolVar(
+		&opts.checkCollaborators, "check-collaborators", false, "check that users are collaborators of the repository",
+	)
+	cmd.Flags().StringVar(
+		&opts.repo, "repo", os.Getenv("GITHUB_REPOSITORY"), "org/repo slug used to check the collaborators",
+	)
+	return cmd
+}
+
+func validateOwners(ctx context.Context, w io.Writer, opts *validateOwnersOptions) error {
+	vopts := &owners.ValidateOptions{}
+	if opts.checkCollaborators {
+		if opts.repo == "" {
+			return errors.New("checking collaborators requires the repository slug (--repo)")
+		}
+		gh, err := newGitHub()
+		if err != nil {
+			return err
+		}
+		vopts.IsCollaborator = func(user string) (bool, error) {
+			return gh.IsCollaborator(ctx, opts.repo, user)
+		}
+	}
+
+	problems, err := owners.ValidateWithOptions(rootOpts.repoRoot, vopts)
+	if err != nil {
+		return fmt.Errorf("validating OWNERS files: %w", err)
+	}
+
+	errorCount := 0
+	for _, p := range problems {
+		if p.Severity == owners.SeverityError {
+			errorCount++
+		}
+	}
+
+	if err := rootOpts.print(w, problems, func(w io.Writer) {
+		if len(problems) == 0 {
+			fmt.Fprintln(w, "All OWNERS files are valid")
+		}
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+	}); err != nil {
+		return err
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("found %d errors in OWNERS files", errorCount)
+	}
+	return nil
+}
+
+// repoRelative returns a path relative to the repository root
+func repoRelative(path string) string {
+	root, err := filepath.Abs(rootOpts.repoRoot)
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// fileExists returns true if path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
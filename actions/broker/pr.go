@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/miniprow"
+)
+
+func newPRCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pr",
+		Short: "Inspect pull requests",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <org/repo#number>",
+		Short: "Show if a pull request has the labels and checks required to merge",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return prStatus(cmd.Context(), cmd.OutOrStdout(), args[0])
+		},
+	})
+	return cmd
+}
+
+// parsePRRef splits an org/repo#number reference
+func parsePRRef(ref string) (repo string, number int, err error) {
+	repo, num, ok := strings.Cut(ref, "#")
+	if !ok || strings.Count(repo, "/") != 1 {
+		return "", 0, fmt.Errorf("invalid pull request reference %q, expected org/repo#number", ref)
+	}
+	number, err = strconv.Atoi(num)
+	if err != nil || number <= 0 {
+		return "", 0, fmt.Errorf("invalid pull request number in %q", ref)
+	}
+	return repo, number, nil
+}
+
+func prStatus(ctx context.Context, w io.Writer, ref string) error {
+	repo, number, err := parsePRRef(ref)
+	if err != nil {
+		return err
+	}
+
+	data := miniprow.NewContextData()
+	data["event"] = miniprow.EventCheckMerge
+	data["repo"] = repo
+	data["pr"] = strconv.Itoa(number)
+	if data.GitHubToken() == "" {
+		data["token"] = os.Getenv("GITHUB_TOKEN")
+	}
+
+	broker, err := miniprow.NewBrokerWithData(ctx, data)
+	if err != nil {
+		return fmt.Errorf("creating MiniProw broker: %w", err)
+	}
+	status, err := broker.Status()
+	if err != nil {
+		return fmt.Errorf("getting pull request status: %w", err)
+	}
+
+	return rootOpts.print(w, status, func(w io.Writer) {
+		fmt.Fprintf(w, "%s#%d by %s\n", status.Repo, status.Number, status.Author)
+		fmt.Fprintf(w, "Labels:    %s\n", strings.Join(status.Labels, ", "))
+		if len(status.MissingLabels) > 0 {
+			fmt.Fprintf(w, "Missing:   %s\n", strings.Join(status.MissingLabels, ", "))
+		}
+		fmt.Fprintf(w, "Merged:    %t\n", status.Merged)
+		fmt.Fprintf(w, "Mergeable: %t\n", status.Mergeable)
+		fmt.Fprintln(w, "Checks:")
+		for _, c := range status.Checks {
+			ignored := ""
+			if c.Ignored {
+				ignored = " (ignored)"
+			}
+			fmt.Fprintf(w, "  %-30s %-7s %s%s\n", c.Name, c.Kind, c.State, ignored)
+		}
+		fmt.Fprintf(w, "Labels ready: %t, checks ready: %t\n", status.LabelsReady, status.ChecksReady)
+		if len(status.Actions) == 0 {
+			fmt.Fprintln(w, "MiniProw would not merge the pull request now")
+		}
+		for _, a := range status.Actions {
+			fmt.Fprintf(w, "MiniProw would run: %s\n", a)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/miniprow"
+)
+
+type runOptions struct {
+	dryRun bool
+}
+
+func (o *runOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "record the changes to GitHub instead of executing them")
+}
+
+func newRunCommand() *cobra.Command {
+	opts := &runOptions{}
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the broker on the event defined in the MINIPROW_* environment variables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return executeBroker(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
+
+// runResult is the output of a broker run
+type runResult struct {
+	Event   string                 `json:"event"`
+	Actions []miniprow.Action      `json:"actions"`
+	DryRun  bool                   `json:"dryRun"`
+	Planned []github.PlannedAction `json:"planned,omitempty"`
+	Sweep   []miniprow.SweepResult `json:"sweep,omitempty"`
+}
+
+// executeBroker executes the broker, bounded by the deadline set in the environment
+func executeBroker(ctx context.Context, w io.Writer, opts *runOptions) error {
+	data := miniprow.NewContextData()
+	if opts.dryRun {
+		data["dryrun"] = "true"
+	}
+	if timeout := data.Timeout(); timeout > 0 {
+		logrus.Infof("Broker run will time out after %s", timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	broker, err := miniprow.NewBrokerWithData(ctx, data)
+	if err != nil {
+		return fmt.Errorf("creating MiniProw broker: %w", err)
+	}
+	if err := broker.Run(); err != nil {
+		return fmt.Errorf("miniprow broker run returned error: %w", err)
+	}
+
+	// Text output is already in the log
+	if rootOpts.output != outputJSON {
+		return nil
+	}
+	return rootOpts.print(w, runResult{
+		Event:   data.Event(),
+		Actions: broker.Actions(),
+		DryRun:  broker.GitHub().DryRun(),
+		Planned: broker.GitHub().PlannedActions(),
+		Sweep:   broker.SweepResults(),
+	}, nil)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/miniprow"
+)
+
+type simulateOptions struct {
+	eventPath string
+	eventName string
+}
+
+func newSimulateCommand() *cobra.Command {
+	opts := &simulateOptions{}
+	cmd := &cobra.Command{
+		Use:   "simulate --event file.json",
+		Short: "Compute what the broker would do on a GitHub event without changing anything",
+		Long: "Compute what the broker would do on a GitHub event without changing anything.\n\n" +
+			"The event file is a webhook payload like the one GitHub actions store in\n" +
+			"GITHUB_EVENT_PATH. Supported events are issue_comment, pull_request and\n" +
+			"check_suite. Data is read from the GitHub API using MINIPROW_TOKEN or GITHUB_TOKEN.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return simulate(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.eventPath, "event", "", "path to the event payload")
+	cmd.Flags().StringVar(&opts.eventName, "event-name", "", "name of the event, guessed from the payload if not set")
+	_ = cmd.MarkFlagRequired("event")
+	return cmd
+}
+
+// simulation is the output of the simulate command
+type simulation struct {
+	Event   string            `json:"event"`
+	Repo    string            `json:"repo"`
+	Number  int               `json:"number"`
+	Actions []miniprow.Action `json:"actions"`
+}
+
+func simulate(ctx context.Context, w io.Writer, opts *simulateOptions) error {
+	payload, err := os.ReadFile(opts.eventPath)
+	if err != nil {
+		return fmt.Errorf("reading event file: %w", err)
+	}
+	data, err := miniprow.ContextDataFromEvent(opts.eventName, payload)
+	if err != nil {
+		return err
+	}
+	if data.GitHubToken() == "" {
+		data["token"] = os.Getenv("GITHUB_TOKEN")
+	}
+
+	broker, err := miniprow.NewBrokerWithData(ctx, data)
+	if err != nil {
+		return fmt.Errorf("creating MiniProw broker: %w", err)
+	}
+	actions, err := broker.Plan()
+	if err != nil {
+		return fmt.Errorf("planning event: %w", err)
+	}
+
+	number := data.PullRequest()
+	if number == 0 {
+		number = data.Issue()
+	}
+	res := simulation{Event: data.Event(), Repo: data.Repository(), Number: number, Actions: actions}
+	return rootOpts.print(w, res, func(w io.Writer) {
+		fmt.Fprintf(w, "%s event on %s#%d\n", res.Event, res.Repo, res.Number)
+		if len(res.Actions) == 0 {
+			fmt.Fprintln(w, "No actions would be taken")
+		}
+		for i, a := range res.Actions {
+			fmt.Fprintf(w, "%3d. %s\n", i+1, a)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/uservers/miniprow/pkg/miniprow"
+)
+
+func newSweepCommand() *cobra.Command {
+	opts := &runOptions{}
+	cmd := &cobra.Command{
+		Use:   "sweep <org/repo>",
+		Short: "Merge the open pull requests that are ready",
+		Long: "Merge the open pull requests that are ready.\n\n" +
+			"Pull requests can become ready without an event the broker reacts to. The\n" +
+			"sweep evaluates all the open pull requests, oldest first, and merges the\n" +
+			"ready ones up to sweep.maxMerges from the config. Run it from a scheduled\n" +
+			"workflow or set MINIPROW_EVENT=SWEEP when invoking the action.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sweep(cmd.Context(), cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
+
+func sweep(ctx context.Context, w io.Writer, repo string, opts *runOptions) error {
+	if strings.Count(repo, "/") != 1 {
+		return fmt.Errorf("invalid repository %q, expected org/repo", repo)
+	}
+
+	data := miniprow.NewContextData()
+	data["event"] = miniprow.EventSweep
+	data["repo"] = repo
+	data["pr"] = ""
+	data["issue"] = ""
+	data["comment"] = ""
+	if data.GitHubToken() == "" {
+		data["token"] = os.Getenv("GITHUB_TOKEN")
+	}
+	if opts.dryRun {
+		data["dryrun"] = "true"
+	}
+
+	broker, err := miniprow.NewBrokerWithData(ctx, data)
+	if err != nil {
+		return fmt.Errorf("creating MiniProw broker: %w", err)
+	}
+	runErr := broker.Run()
+	results := broker.SweepResults()
+	if runErr != nil && len(results) == 0 {
+		return fmt.Errorf("miniprow broker run returned error: %w", runErr)
+	}
+
+	if err := rootOpts.print(w, results, func(w io.Writer) {
+		if len(results) == 0 {
+			fmt.Fprintln(w, "No open pull requests")
+		}
+		for _, r := range results {
+			fmt.Fprintln(w, r)
+		}
+	}); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return fmt.Errorf("miniprow broker run returned error: %w", runErr)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/release-utils/version"
+)
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the broker",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			info := version.GetVersionInfo()
+			return rootOpts.print(cmd.OutOrStdout(), info, func(w io.Writer) {
+				fmt.Fprintln(w, info.String())
+			})
+		},
+	}
+}
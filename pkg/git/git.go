@@ -0,0 +1,158 @@
+// Package git runs the git operations of the merge pool on a local
+// clone of the repository
+package git
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/release-utils/command"
+)
+
+const (
+	// DefaultRemote is the remote pointing to the GitHub repository
+	DefaultRemote = "origin"
+
+	// Identity of the merge commits created by miniprow
+	committerName  = "MiniProw"
+	committerEmail = "miniprow@users.noreply.github.com"
+)
+
+// ErrConflict is returned when a merge cannot be done automatically
+var ErrConflict = errors.New("merge conflict")
+
+// Repo is a clone of a repository
+type Repo struct {
+	dir    string
+	remote string
+	// worktree is true when the repo was created with Worktree
+	worktree bool
+	parent   *Repo
+}
+
+// Open returns the clone at dir
+func Open(dir string) (*Repo, error) {
+	r := &Repo{dir: dir, remote: DefaultRemote}
+	if _, err := r.run("rev-parse", "--git-dir"); err != nil {
+		return nil, errors.Wrapf(err, "%s is not a git repository", dir)
+	}
+	return r, nil
+}
+
+// Dir returns the directory of the working tree
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// run executes a git command in the repository and returns its output
+func (r *Repo) run(args ...string) (string, error) {
+	args = append([]string{"-c", "user.name=" + committerName, "-c", "user.email=" + committerEmail}, args...)
+	out, err := command.NewWithWorkDir(r.dir, "git", args...).RunSilentSuccessOutput()
+	if err != nil {
+		return "", err
+	}
+	return out.OutputTrimNL(), nil
+}
+
+// RemoteHead returns the commit at the tip of a branch in the remote
+// without fetching it
+func (r *Repo) RemoteHead(branch string) (string, error) {
+	out, err := r.run("ls-remote", "--exit-code", r.remote, "refs/heads/"+branch)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading the head of %s", branch)
+	}
+	sha, _, _ := strings.Cut(out, "\t")
+	return sha, nil
+}
+
+// FetchBranch fetches a branch from the remote and returns its tip.
+// Shallow clones, like the default of actions/checkout, are converted
+// to full clones as merging needs the history.
+func (r *Repo) FetchBranch(branch string) (string, error) {
+	return r.fetch("refs/heads/"+branch, "refs/remotes/"+r.remote+"/"+branch)
+}
+
+// FetchPullRequest fetches the head of a pull request and returns it
+func (r *Repo) FetchPullRequest(number int) (string, error) {
+	return r.fetch(fmt.Sprintf("refs/pull/%d/head", number), fmt.Sprintf("refs/miniprow/pull/%d", number))
+}
+
+// fetch fetches a remote ref into a local ref and returns its commit
+func (r *Repo) fetch(remoteRef, localRef string) (string, error) {
+	args := []string{"fetch", "--quiet", "--no-tags"}
+	shallow, err := r.run("rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return "", errors.Wrap(err, "checking if the clone is shallow")
+	}
+	if shallow == "true" {
+		logrus.Info("Converting shallow clone to a full clone to merge branches")
+		args = append(args, "--unshallow")
+	}
+	if _, err := r.run(append(args, r.remote, "+"+remoteRef+":"+localRef)...); err != nil {
+		return "", errors.Wrapf(err, "fetching %s", remoteRef)
+	}
+	sha, err := r.run("rev-parse", localRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", localRef)
+	}
+	return sha, nil
+}
+
+// Worktree creates a temporary working tree of the clone with commit
+// checked out, detached. The checkout of the clone is not modified. It
+// has to be removed with Remove.
+func (r *Repo) Worktree(commit string) (*Repo, error) {
+	dir, err := os.MkdirTemp("", "miniprow-worktree-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating worktree directory")
+	}
+	if _, err := r.run("worktree", "add", "--quiet", "--force", "--detach", dir, commit); err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.Wrap(err, "adding worktree")
+	}
+	return &Repo{dir: dir, remote: r.remote, worktree: true, parent: r}, nil
+}
+
+// Remove deletes a working tree created with Worktree
+func (r *Repo) Remove() error {
+	if !r.worktree {
+		return errors.New("only worktrees can be removed")
+	}
+	if _, err := r.parent.run("worktree", "remove", "--force", r.dir); err != nil {
+		return errors.Wrap(err, "removing worktree")
+	}
+	return nil
+}
+
+// Reset points the checkout to commit, dropping any changes
+func (r *Repo) Reset(commit string) error {
+	_, err := r.run("reset", "--quiet", "--hard", commit)
+	return errors.Wrapf(err, "resetting to %s", commit)
+}
+
+// Merge merges a commit into the checkout with a merge commit. If the
+// merge has conflicts, it is aborted and ErrConflict is returned.
+func (r *Repo) Merge(commit, message string) error {
+	if _, err := r.run("merge", "--quiet", "--no-ff", "--no-edit", "-m", message, commit); err != nil {
+		if _, abortErr := r.run("merge", "--abort"); abortErr != nil {
+			return errors.Wrapf(abortErr, "aborting merge of %s after %v", commit, err)
+		}
+		return fmt.Errorf("merging %s: %w", commit, ErrConflict)
+	}
+	return nil
+}
+
+// Head returns the commit checked out
+func (r *Repo) Head() (string, error) {
+	sha, err := r.run("rev-parse", "HEAD")
+	return sha, errors.Wrap(err, "reading HEAD")
+}
+
+// ForcePush replaces a branch in the remote with the commit checked out
+func (r *Repo) ForcePush(branch string) error {
+	_, err := r.run("push", "--quiet", "--force", r.remote, "HEAD:refs/heads/"+branch)
+	return errors.Wrapf(err, "pushing %s", branch)
+}
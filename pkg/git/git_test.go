@@ -0,0 +1,95 @@
+package git_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/git"
+)
+
+// gitCmd runs git in dir and returns the output
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// commitFile writes a file in the checkout at dir and commits it
+func commitFile(t *testing.T, dir, path, content string) string {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), os.FileMode(0o644)))
+	gitCmd(t, dir, "add", path)
+	gitCmd(t, dir, "commit", "--quiet", "-m", "update "+path)
+	return gitCmd(t, dir, "rev-parse", "HEAD")
+}
+
+// mkRemote creates a bare repository with a main branch and a pull
+// request ref changing a file and returns it with a shallow clone
+func mkRemote(t *testing.T) (remote, clone string) {
+	tmp := t.TempDir()
+	remote = filepath.Join(tmp, "remote.git")
+	work := filepath.Join(tmp, "work")
+	gitCmd(t, tmp, "init", "--quiet", "--bare", "-b", "main", remote)
+	gitCmd(t, tmp, "clone", "--quiet", remote, work)
+	commitFile(t, work, "README.md", "one\n")
+	commitFile(t, work, "README.md", "two\n")
+	gitCmd(t, work, "push", "--quiet", "origin", "HEAD:refs/heads/main")
+	gitCmd(t, work, "checkout", "--quiet", "-b", "feature", "HEAD~1")
+	commitFile(t, work, "feature.txt", "feature\n")
+	gitCmd(t, work, "push", "--quiet", "origin", "HEAD:refs/pull/1/head")
+	gitCmd(t, work, "checkout", "--quiet", "-b", "conflict", "HEAD~1")
+	commitFile(t, work, "README.md", "three\n")
+	gitCmd(t, work, "push", "--quiet", "origin", "HEAD:refs/pull/2/head")
+
+	clone = filepath.Join(tmp, "clone")
+	gitCmd(t, tmp, "clone", "--quiet", "--depth", "1", "file://"+remote, clone)
+	return remote, clone
+}
+
+func TestMergeOnWorktree(t *testing.T) {
+	remote, clone := mkRemote(t)
+	repo, err := git.Open(clone)
+	require.NoError(t, err)
+
+	head, err := repo.RemoteHead("main")
+	require.NoError(t, err)
+	require.Equal(t, gitCmd(t, remote, "rev-parse", "main"), head)
+
+	// Fetching unshallows the clone, the merge base is needed
+	base, err := repo.FetchBranch("main")
+	require.NoError(t, err)
+	require.Equal(t, head, base)
+	require.Equal(t, "false", gitCmd(t, clone, "rev-parse", "--is-shallow-repository"))
+	pr1, err := repo.FetchPullRequest(1)
+	require.NoError(t, err)
+	pr2, err := repo.FetchPullRequest(2)
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree(base)
+	require.NoError(t, err)
+	require.NoError(t, wt.Merge(pr1, "merge #1"))
+	require.ErrorIs(t, wt.Merge(pr2, "merge #2"), git.ErrConflict)
+
+	// The conflict is aborted, the first merge is kept
+	staged, err := wt.Head()
+	require.NoError(t, err)
+	require.Equal(t, "merge #1", gitCmd(t, wt.Dir(), "log", "-1", "--format=%s"))
+	require.Empty(t, gitCmd(t, wt.Dir(), "status", "--porcelain"))
+
+	require.NoError(t, wt.ForcePush("staging"))
+	require.Equal(t, staged, gitCmd(t, remote, "rev-parse", "staging"))
+
+	require.NoError(t, wt.Reset(base))
+	require.NoError(t, wt.Remove())
+	require.NoDirExists(t, wt.Dir())
+	require.Equal(t, base, gitCmd(t, clone, "rev-parse", "HEAD"))
+}
+
+func TestOpenNotARepo(t *testing.T) {
+	_, err := git.Open(t.TempDir())
+	require.Error(t, err)
+}
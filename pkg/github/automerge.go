@@ -0,0 +1,146 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The auto-merge and merge queue mutations take the node ID of the pull
+// request, it is read first with pullRequestIDQuery
+const (
+	pullRequestIDQuery = `query($owner: String!, $repo: String!, $number: Int!) {
+  repository(owner: $owner, name: $repo) { pullRequest(number: $number) { id } }
+}`
+
+	enableAutoMergeMutation = `mutation($id: ID!, $headline: String) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, mergeMethod: MERGE, commitHeadline: $headline}) {
+    clientMutationId
+  }
+}`
+
+	disableAutoMergeMutation = `mutation($id: ID!) {
+  disablePullRequestAutoMerge(input: {pullRequestId: $id}) { clientMutationId }
+}`
+
+	enqueueMutation = `mutation($id: ID!) {
+  enqueuePullRequest(input: {pullRequestId: $id}) { clientMutationId }
+}`
+
+	dequeueMutation = `mutation($id: ID!) {
+  dequeuePullRequest(input: {id: $id}) { clientMutationId }
+}`
+)
+
+// EnableAutoMerge turns on auto-merge in a pull request, GitHub merges
+// it when the branch protection requirements are met. If the pull
+// request can be merged right away, the error wraps ErrCleanStatus.
+func (github *GitHub) EnableAutoMerge(ctx context.Context, slug string, number int) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.EnableAutoMerge(ctx, owner, repo, number),
+		"enabling auto-merge in #%d", number,
+	)
+}
+
+// DisableAutoMerge turns off auto-merge in a pull request
+func (github *GitHub) DisableAutoMerge(ctx context.Context, slug string, number int) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.DisableAutoMerge(ctx, owner, repo, number),
+		"disabling auto-merge in #%d", number,
+	)
+}
+
+// EnqueuePullRequest adds a pull request to the merge queue of its base branch
+func (github *GitHub) EnqueuePullRequest(ctx context.Context, slug string, number int) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.EnqueuePullRequest(ctx, owner, repo, number),
+		"adding #%d to the merge queue", number,
+	)
+}
+
+// DequeuePullRequest removes a pull request from the merge queue
+func (github *GitHub) DequeuePullRequest(ctx context.Context, slug string, number int) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.DequeuePullRequest(ctx, owner, repo, number),
+		"removing #%d from the merge queue", number,
+	)
+}
+
+// EnableAutoMerge runs the GraphQL mutation to enable auto-merge. The
+// merge commit gets the same title as the merges done with REST.
+func (g *githubClient) EnableAutoMerge(ctx context.Context, owner, repo string, number int) error {
+	err := g.mutatePullRequest(ctx, owner, repo, number, enableAutoMergeMutation, map[string]interface{}{
+		"headline": fmt.Sprintf("MiniProw: merge pull request #%d", number),
+	})
+	if errors.Is(err, ErrValidation) && strings.Contains(err.Error(), "clean status") {
+		return fmt.Errorf("%w: %w", ErrCleanStatus, err)
+	}
+	if err == nil {
+		logrus.Infof("Enabled auto-merge in pull request #%d", number)
+	}
+	return err
+}
+
+// DisableAutoMerge runs the GraphQL mutation to disable auto-merge
+func (g *githubClient) DisableAutoMerge(ctx context.Context, owner, repo string, number int) error {
+	return g.mutatePullRequest(ctx, owner, repo, number, disableAutoMergeMutation, nil)
+}
+
+// EnqueuePullRequest runs the GraphQL mutation to add a pull request
+// to the merge queue
+func (g *githubClient) EnqueuePullRequest(ctx context.Context, owner, repo string, number int) error {
+	return g.mutatePullRequest(ctx, owner, repo, number, enqueueMutation, nil)
+}
+
+// DequeuePullRequest runs the GraphQL mutation to remove a pull request
+// from the merge queue
+func (g *githubClient) DequeuePullRequest(ctx context.Context, owner, repo string, number int) error {
+	return g.mutatePullRequest(ctx, owner, repo, number, dequeueMutation, nil)
+}
+
+// mutatePullRequest runs a mutation that receives the node ID of a pull
+// request in the $id variable
+func (g *githubClient) mutatePullRequest(
+	ctx context.Context, owner, repo string, number int, mutation string, vars map[string]interface{},
+) error {
+	data := struct {
+		Repository *struct {
+			PullRequest *struct {
+				ID string `json:"id"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}{}
+	if err := g.graphQL(ctx, pullRequestIDQuery, map[string]interface{}{
+		"owner": owner, "repo": repo, "number": number,
+	}, &data); err != nil {
+		return errors.Wrap(err, "reading pull request ID")
+	}
+	if data.Repository == nil || data.Repository.PullRequest == nil {
+		return errors.Wrap(ErrNotFound, "pull request not found")
+	}
+
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	vars["id"] = data.Repository.PullRequest.ID
+	return g.graphQL(ctx, mutation, vars, &struct{}{})
+}
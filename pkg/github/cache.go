@@ -0,0 +1,395 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// CachingClient wraps a client memoizing the responses of read calls so
+// that data needed by several steps of a run is fetched only once and
+// all of them see the same version of it. Mutating calls go through to
+// the wrapped client and drop the cached data they modify.
+//
+// Successful responses and not found errors are cached. The cached
+// objects are shared, callers must not modify them.
+type CachingClient struct {
+	Client
+	mu     sync.Mutex
+	cache  map[string]cachedResponse
+	hits   int
+	misses int
+}
+
+// cachedResponse is the result of a read call
+type cachedResponse struct {
+	value interface{}
+	resp  *gogithub.Response
+	err   error
+}
+
+// NewCachingClient returns a caching client wrapping client
+func NewCachingClient(client Client) *CachingClient {
+	return &CachingClient{
+		Client: client,
+		cache:  map[string]cachedResponse{},
+	}
+}
+
+// Stats returns the number of calls answered from the cache and the
+// number of calls sent to the wrapped client
+func (c *CachingClient) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// get returns the cached response of a call or runs fetch to get it
+func (c *CachingClient) get(key string, fetch func() (interface{}, *gogithub.Response, error)) cachedResponse {
+	c.mu.Lock()
+	if r, ok := c.cache[key]; ok {
+		c.hits++
+		c.mu.Unlock()
+		logrus.Debugf("GitHub read cache hit: %s", key)
+		return r
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	value, resp, err := fetch()
+	r := cachedResponse{value: value, resp: resp, err: err}
+	if err == nil || errors.Is(err, ErrNotFound) {
+		c.mu.Lock()
+		c.cache[key] = r
+		c.mu.Unlock()
+	}
+	return r
+}
+
+// invalidate drops the cached responses of the keys
+func (c *CachingClient) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.cache, key)
+	}
+}
+
+// invalidatePrefix drops the cached responses with keys starting with prefix
+func (c *CachingClient) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.cache, key)
+		}
+	}
+}
+
+// cacheKey builds the key of a call. List options are not part of the
+// key as the client always returns all the pages.
+func cacheKey(method, owner, repo string, id interface{}) string {
+	return fmt.Sprintf("%s %s/%s %v", method, owner, repo, id)
+}
+
+// GetComment returns a comment, cached
+func (c *CachingClient) GetComment(
+	ctx context.Context, owner, repo string, number int64,
+) (*gogithub.IssueComment, *gogithub.Response, error) {
+	r := c.get(cacheKey("GetComment", owner, repo, number), func() (interface{}, *gogithub.Response, error) {
+		return c.Client.GetComment(ctx, owner, repo, number)
+	})
+	comment, _ := r.value.(*gogithub.IssueComment)
+	return comment, r.resp, r.err
+}
+
+// GetIssue returns an issue, cached
+func (c *CachingClient) GetIssue(
+	ctx context.Context, owner, repo string, number int,
+) (*gogithub.Issue, *gogithub.Response, error) {
+	r := c.get(cacheKey("GetIssue", owner, repo, number), func() (interface{}, *gogithub.Response, error) {
+		return c.Client.GetIssue(ctx, owner, repo, number)
+	})
+	issue, _ := r.value.(*gogithub.Issue)
+	return issue, r.resp, r.err
+}
+
+// GetPullRequest returns a pull request, cached
+func (c *CachingClient) GetPullRequest(
+	ctx context.Context, owner, repo string, number int,
+) (*gogithub.PullRequest, *gogithub.Response, error) {
+	r := c.get(cacheKey("GetPullRequest", owner, repo, number), func() (interface{}, *gogithub.Response, error) {
+		return c.Client.GetPullRequest(ctx, owner, repo, number)
+	})
+	pr, _ := r.value.(*gogithub.PullRequest)
+	return pr, r.resp, r.err
+}
+
+// ListLabels returns the labels of the repository, cached
+func (c *CachingClient) ListLabels(
+	ctx context.Context, owner, repo string, opts *Options,
+) ([]*gogithub.Label, error) {
+	r := c.get(cacheKey("ListLabels", owner, repo, ""), func() (interface{}, *gogithub.Response, error) {
+		labels, err := c.Client.ListLabels(ctx, owner, repo, opts)
+		return labels, nil, err
+	})
+	labels, _ := r.value.([]*gogithub.Label)
+	return labels, r.err
+}
+
+// ListPullRequestFiles returns the files changed in a pull request, cached
+func (c *CachingClient) ListPullRequestFiles(
+	ctx context.Context, owner, repo string, number int, opts *gogithub.ListOptions,
+) ([]*gogithub.CommitFile, error) {
+	r := c.get(cacheKey("ListPullRequestFiles", owner, repo, number), func() (interface{}, *gogithub.Response, error) {
+		files, err := c.Client.ListPullRequestFiles(ctx, owner, repo, number, opts)
+		return files, nil, err
+	})
+	files, _ := r.value.([]*gogithub.CommitFile)
+	return files, r.err
+}
+
+// ListCheckRunsForRef returns the check runs of a ref, cached
+func (c *CachingClient) ListCheckRunsForRef(
+	ctx context.Context, owner, repo, ref string, opts *gogithub.ListCheckRunsOptions,
+) (*gogithub.ListCheckRunsResults, error) {
+	r := c.get(cacheKey("ListCheckRunsForRef", owner, repo, ref), func() (interface{}, *gogithub.Response, error) {
+		runs, err := c.Client.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+		return runs, nil, err
+	})
+	runs, _ := r.value.(*gogithub.ListCheckRunsResults)
+	return runs, r.err
+}
+
+// GetCombinedStatus returns the combined status of a ref, cached
+func (c *CachingClient) GetCombinedStatus(
+	ctx context.Context, owner, repo, ref string, opts *gogithub.ListOptions,
+) (*gogithub.CombinedStatus, error) {
+	r := c.get(cacheKey("GetCombinedStatus", owner, repo, ref), func() (interface{}, *gogithub.Response, error) {
+		status, err := c.Client.GetCombinedStatus(ctx, owner, repo, ref, opts)
+		return status, nil, err
+	})
+	status, _ := r.value.(*gogithub.CombinedStatus)
+	return status, r.err
+}
+
+// GetIssueComments returns the comments of an issue, cached
+func (c *CachingClient) GetIssueComments(
+	ctx context.Context, owner, repo string, number int, opts *gogithub.IssueListCommentsOptions,
+) ([]*gogithub.IssueComment, error) {
+	r := c.get(cacheKey("GetIssueComments", owner, repo, number), func() (interface{}, *gogithub.Response, error) {
+		comments, err := c.Client.GetIssueComments(ctx, owner, repo, number, opts)
+		return comments, nil, err
+	})
+	comments, _ := r.value.([]*gogithub.IssueComment)
+	return comments, r.err
+}
+
+// GetAPIUser returns the authenticated user, cached
+func (c *CachingClient) GetAPIUser(ctx context.Context) (*gogithub.User, error) {
+	r := c.get("GetAPIUser", func() (interface{}, *gogithub.Response, error) {
+		user, err := c.Client.GetAPIUser(ctx)
+		return user, nil, err
+	})
+	user, _ := r.value.(*gogithub.User)
+	return user, r.err
+}
+
+// IsCollaborator checks if a user is a collaborator, cached
+func (c *CachingClient) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error) {
+	r := c.get(cacheKey("IsCollaborator", owner, repo, user), func() (interface{}, *gogithub.Response, error) {
+		isCollaborator, err := c.Client.IsCollaborator(ctx, owner, repo, user)
+		return isCollaborator, nil, err
+	})
+	isCollaborator, _ := r.value.(bool)
+	return isCollaborator, r.err
+}
+
+// GetFileContents returns the contents of a file at a ref, cached
+func (c *CachingClient) GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	r := c.get(cacheKey("GetFileContents", owner, repo, path+"@"+ref), func() (interface{}, *gogithub.Response, error) {
+		data, err := c.Client.GetFileContents(ctx, owner, repo, path, ref)
+		return data, nil, err
+	})
+	data, _ := r.value.([]byte)
+	return data, r.err
+}
+
+// ListWorkflows returns the workflows of a repository, cached
+func (c *CachingClient) ListWorkflows(
+	ctx context.Context, owner, repo string, opts *gogithub.ListOptions,
+) ([]*gogithub.Workflow, error) {
+	r := c.get(cacheKey("ListWorkflows", owner, repo, ""), func() (interface{}, *gogithub.Response, error) {
+		workflows, err := c.Client.ListWorkflows(ctx, owner, repo, opts)
+		return workflows, nil, err
+	})
+	workflows, _ := r.value.([]*gogithub.Workflow)
+	return workflows, r.err
+}
+
+// ListWorkflowRunsForSHA returns the workflow runs of a commit, cached
+func (c *CachingClient) ListWorkflowRunsForSHA(
+	ctx context.Context, owner, repo, sha string, opts *gogithub.ListOptions,
+) ([]*gogithub.WorkflowRun, error) {
+	r := c.get(cacheKey("ListWorkflowRunsForSHA", owner, repo, sha), func() (interface{}, *gogithub.Response, error) {
+		runs, err := c.Client.ListWorkflowRunsForSHA(ctx, owner, repo, sha, opts)
+		return runs, nil, err
+	})
+	runs, _ := r.value.([]*gogithub.WorkflowRun)
+	return runs, r.err
+}
+
+// AddLabel adds a label and drops the cached issue and pull request
+func (c *CachingClient) AddLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.AddLabel(ctx, owner, repo, number, label)
+}
+
+// RemoveLabel removes a label and drops the cached issue and pull request
+func (c *CachingClient) RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.RemoveLabel(ctx, owner, repo, number, label)
+}
+
+// MergePullRequest merges a pull request and drops the cached copy. The
+// base branch moves, so the mergeable flag of the other pull requests of
+// the repository is outdated too and they are also dropped.
+func (c *CachingClient) MergePullRequest(ctx context.Context, owner, repo string, number int, sha string) error {
+	defer c.invalidatePrefix(cacheKey("GetPullRequest", owner, repo, ""))
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.MergePullRequest(ctx, owner, repo, number, sha)
+}
+
+// EnableAutoMerge enables auto-merge and drops the cached pull request
+func (c *CachingClient) EnableAutoMerge(ctx context.Context, owner, repo string, number int) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.EnableAutoMerge(ctx, owner, repo, number)
+}
+
+// DisableAutoMerge disables auto-merge and drops the cached pull request
+func (c *CachingClient) DisableAutoMerge(ctx context.Context, owner, repo string, number int) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.DisableAutoMerge(ctx, owner, repo, number)
+}
+
+// EnqueuePullRequest adds a pull request to the merge queue and drops
+// the cached copy
+func (c *CachingClient) EnqueuePullRequest(ctx context.Context, owner, repo string, number int) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.EnqueuePullRequest(ctx, owner, repo, number)
+}
+
+// DequeuePullRequest removes a pull request from the merge queue and
+// drops the cached copy
+func (c *CachingClient) DequeuePullRequest(ctx context.Context, owner, repo string, number int) error {
+	defer c.invalidateIssue(owner, repo, number)
+	return c.Client.DequeuePullRequest(ctx, owner, repo, number)
+}
+
+// CreateComment posts a comment and drops the cached comment list
+func (c *CachingClient) CreateComment(
+	ctx context.Context, owner, repo string, number int, body string,
+) (*gogithub.IssueComment, error) {
+	defer c.invalidate(cacheKey("GetIssueComments", owner, repo, number))
+	return c.Client.CreateComment(ctx, owner, repo, number, body)
+}
+
+// DeleteComment deletes a comment and drops the cached comment lists of
+// the repository, the comment may be in any of them
+func (c *CachingClient) DeleteComment(ctx context.Context, owner, repo string, commentID int64) error {
+	defer c.invalidatePrefix(cacheKey("GetIssueComments", owner, repo, ""))
+	defer c.invalidate(cacheKey("GetComment", owner, repo, commentID))
+	return c.Client.DeleteComment(ctx, owner, repo, commentID)
+}
+
+// CreateCheckRun publishes a check run and drops the cached runs of its ref
+func (c *CachingClient) CreateCheckRun(
+	ctx context.Context, owner, repo string, opts gogithub.CreateCheckRunOptions,
+) (*gogithub.CheckRun, error) {
+	defer c.invalidate(cacheKey("ListCheckRunsForRef", owner, repo, opts.HeadSHA))
+	return c.Client.CreateCheckRun(ctx, owner, repo, opts)
+}
+
+// RerunFailedJobs re-runs the failed jobs of a workflow run and drops
+// the cached runs, the commit of the run is not known here
+func (c *CachingClient) RerunFailedJobs(ctx context.Context, owner, repo string, runID int64) error {
+	defer c.invalidatePrefix(cacheKey("ListWorkflowRunsForSHA", owner, repo, ""))
+	return c.Client.RerunFailedJobs(ctx, owner, repo, runID)
+}
+
+// RerunWorkflow re-runs a workflow run and drops the cached runs
+func (c *CachingClient) RerunWorkflow(ctx context.Context, owner, repo string, runID int64) error {
+	defer c.invalidatePrefix(cacheKey("ListWorkflowRunsForSHA", owner, repo, ""))
+	return c.Client.RerunWorkflow(ctx, owner, repo, runID)
+}
+
+// RerunJob re-runs a job and drops the cached workflow and check runs,
+// the job reports a check run
+func (c *CachingClient) RerunJob(ctx context.Context, owner, repo string, jobID int64) error {
+	defer c.invalidatePrefix(cacheKey("ListWorkflowRunsForSHA", owner, repo, ""))
+	defer c.invalidatePrefix(cacheKey("ListCheckRunsForRef", owner, repo, ""))
+	return c.Client.RerunJob(ctx, owner, repo, jobID)
+}
+
+// DispatchWorkflow starts a workflow run and drops the cached runs
+func (c *CachingClient) DispatchWorkflow(
+	ctx context.Context, owner, repo string, workflowID int64, ref string,
+) error {
+	defer c.invalidatePrefix(cacheKey("ListWorkflowRunsForSHA", owner, repo, ""))
+	return c.Client.DispatchWorkflow(ctx, owner, repo, workflowID, ref)
+}
+
+// invalidateIssue drops the cached copies of an issue or pull request
+func (c *CachingClient) invalidateIssue(owner, repo string, number int) {
+	c.invalidate(
+		cacheKey("GetIssue", owner, repo, number),
+		cacheKey("GetPullRequest", owner, repo, number),
+	)
+}
+
+// EnableReadCache makes the GitHub object memoize the responses of read
+// calls, see CachingClient. In dry-run mode the cache is placed below
+// the dry-run client, as the recorded mutations do not change the data.
+func (github *GitHub) EnableReadCache() {
+	if github.readCache() != nil {
+		return
+	}
+	if d, ok := github.client.(*DryRunClient); ok {
+		d.Client = NewCachingClient(d.Client)
+		return
+	}
+	github.client = NewCachingClient(github.client)
+}
+
+// ResetReadCache drops all the responses in the read cache. It is used
+// when polling data that changes during the run.
+func (github *GitHub) ResetReadCache() {
+	if c := github.readCache(); c != nil {
+		c.invalidatePrefix("")
+	}
+}
+
+// readCache returns the caching client, if the read cache is enabled
+func (github *GitHub) readCache() *CachingClient {
+	client := github.client
+	if d, ok := client.(*DryRunClient); ok {
+		client = d.Client
+	}
+	c, _ := client.(*CachingClient)
+	return c
+}
+
+// ReadCacheStats returns the number of calls answered from the read
+// cache and the calls sent to the API. Both are zero if the cache is
+// not enabled.
+func (github *GitHub) ReadCacheStats() (hits, misses int) {
+	if c := github.readCache(); c != nil {
+		return c.Stats()
+	}
+	return 0, 0
+}
@@ -0,0 +1,95 @@
+package github_test
+
+import (
+	"context"
+	"testing"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/github/githubfake"
+)
+
+// countingClient counts the read calls that reach the fake
+type countingClient struct {
+	*githubfake.Client
+	calls map[string]int
+}
+
+func (c *countingClient) GetIssueComments(
+	ctx context.Context, owner, repo string, number int, opts *gogithub.IssueListCommentsOptions,
+) ([]*gogithub.IssueComment, error) {
+	c.calls["GetIssueComments"]++
+	return c.Client.GetIssueComments(ctx, owner, repo, number, opts)
+}
+
+func (c *countingClient) GetPullRequest(
+	ctx context.Context, owner, repo string, number int,
+) (*gogithub.PullRequest, *gogithub.Response, error) {
+	c.calls["GetPullRequest"]++
+	return c.Client.GetPullRequest(ctx, owner, repo, number)
+}
+
+func (c *countingClient) GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	c.calls["GetFileContents"]++
+	return c.Client.GetFileContents(ctx, owner, repo, path, ref)
+}
+
+func TestReadCache(t *testing.T) {
+	ctx := context.Background()
+	fake := githubfake.New()
+	fake.AddRepo("uservers/test", "lgtm")
+	fake.AddPullRequest("uservers/test", 1, "alice", []string{"README.md"})
+	client := &countingClient{Client: fake, calls: map[string]int{}}
+	gh := github.NewWithClient(client)
+	gh.EnableReadCache()
+
+	// Reads are sent once
+	for i := 0; i < 2; i++ {
+		pr, err := gh.GetPullRequest(ctx, "uservers", "test", 1)
+		require.NoError(t, err)
+		require.Empty(t, pr.Labels)
+		_, err = gh.GetIssueComments(ctx, "uservers/test", 1)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, client.calls["GetPullRequest"])
+	require.Equal(t, 1, client.calls["GetIssueComments"])
+
+	// Mutations drop the data they change
+	require.NoError(t, gh.AddLabel(ctx, "uservers", "test", 1, "lgtm"))
+	pr, err := gh.GetPullRequest(ctx, "uservers", "test", 1)
+	require.NoError(t, err)
+	require.Len(t, pr.Labels, 1)
+	require.Equal(t, 2, client.calls["GetPullRequest"])
+
+	comment, err := gh.CreateComment(ctx, "uservers/test", 1, "hello")
+	require.NoError(t, err)
+	comments, err := gh.GetIssueComments(ctx, "uservers/test", 1)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	require.NoError(t, gh.DeleteComment(ctx, "uservers/test", comment.GetID()))
+	comments, err = gh.GetIssueComments(ctx, "uservers/test", 1)
+	require.NoError(t, err)
+	require.Empty(t, comments)
+	require.Equal(t, 3, client.calls["GetIssueComments"])
+
+	// Missing files are cached too
+	for i := 0; i < 2; i++ {
+		_, err = gh.GetFileContents(ctx, "uservers/test", "OWNERS", "main")
+		require.ErrorIs(t, err, github.ErrNotFound)
+	}
+	require.Equal(t, 1, client.calls["GetFileContents"])
+
+	hits, misses := gh.ReadCacheStats()
+	require.Equal(t, 3, hits)
+	require.Equal(t, 6, misses)
+
+	// Dry-run keeps the cache below it
+	gh.EnableDryRun()
+	require.True(t, gh.DryRun())
+	require.NoError(t, gh.AddLabel(ctx, "uservers", "test", 1, "approved"))
+	_, err = gh.GetPullRequest(ctx, "uservers", "test", 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, client.calls["GetPullRequest"])
+	require.Len(t, gh.PlannedActions(), 1)
+}
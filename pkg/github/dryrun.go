@@ -0,0 +1,213 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+)
+
+// PlannedAction is a mutating call that was not executed because the
+// client is running in dry-run mode
+type PlannedAction struct {
+	Action string `json:"action"` // Name of the client method
+	Repo   string `json:"repo"`   // org/repo slug
+	Number int    `json:"number,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// String returns a one line description of the action
+func (a PlannedAction) String() string {
+	s := a.Action + " " + a.Repo
+	if a.Number != 0 {
+		s += fmt.Sprintf("#%d", a.Number)
+	}
+	if a.Detail != "" {
+		s += ": " + a.Detail
+	}
+	return s
+}
+
+// DryRunClient wraps a client so that read calls are sent to the API but
+// mutating calls are recorded instead of executed
+type DryRunClient struct {
+	Client
+	mu      sync.Mutex
+	actions []PlannedAction
+}
+
+// NewDryRunClient returns a dry-run client wrapping client
+func NewDryRunClient(client Client) *DryRunClient {
+	return &DryRunClient{
+		Client:  client,
+		actions: []PlannedAction{},
+	}
+}
+
+// Actions returns the mutating calls recorded by the client, in order
+func (d *DryRunClient) Actions() []PlannedAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]PlannedAction{}, d.actions...)
+}
+
+func (d *DryRunClient) record(action PlannedAction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logrus.Infof("[dry-run] Not executing %s", action)
+	d.actions = append(d.actions, action)
+}
+
+// AddLabel records adding a label
+func (d *DryRunClient) AddLabel(_ context.Context, owner, repo string, number int, label string) error {
+	d.record(PlannedAction{Action: "AddLabel", Repo: owner + "/" + repo, Number: number, Detail: label})
+	return nil
+}
+
+// RemoveLabel records removing a label
+func (d *DryRunClient) RemoveLabel(_ context.Context, owner, repo string, number int, label string) error {
+	d.record(PlannedAction{Action: "RemoveLabel", Repo: owner + "/" + repo, Number: number, Detail: label})
+	return nil
+}
+
+// MergePullRequest records merging a pull request
+func (d *DryRunClient) MergePullRequest(_ context.Context, owner, repo string, number int, sha string) error {
+	d.record(PlannedAction{Action: "MergePullRequest", Repo: owner + "/" + repo, Number: number, Detail: sha})
+	return nil
+}
+
+// EnableAutoMerge records enabling auto-merge in a pull request
+func (d *DryRunClient) EnableAutoMerge(_ context.Context, owner, repo string, number int) error {
+	d.record(PlannedAction{Action: "EnableAutoMerge", Repo: owner + "/" + repo, Number: number})
+	return nil
+}
+
+// DisableAutoMerge records disabling auto-merge in a pull request
+func (d *DryRunClient) DisableAutoMerge(_ context.Context, owner, repo string, number int) error {
+	d.record(PlannedAction{Action: "DisableAutoMerge", Repo: owner + "/" + repo, Number: number})
+	return nil
+}
+
+// EnqueuePullRequest records adding a pull request to the merge queue
+func (d *DryRunClient) EnqueuePullRequest(_ context.Context, owner, repo string, number int) error {
+	d.record(PlannedAction{Action: "EnqueuePullRequest", Repo: owner + "/" + repo, Number: number})
+	return nil
+}
+
+// DequeuePullRequest records removing a pull request from the merge queue
+func (d *DryRunClient) DequeuePullRequest(_ context.Context, owner, repo string, number int) error {
+	d.record(PlannedAction{Action: "DequeuePullRequest", Repo: owner + "/" + repo, Number: number})
+	return nil
+}
+
+// RerunFailedJobs records re-running the failed jobs of a workflow run
+func (d *DryRunClient) RerunFailedJobs(_ context.Context, owner, repo string, runID int64) error {
+	d.record(PlannedAction{
+		Action: "RerunFailedJobs", Repo: owner + "/" + repo, Detail: fmt.Sprintf("run %d", runID),
+	})
+	return nil
+}
+
+// RerunWorkflow records re-running a workflow run
+func (d *DryRunClient) RerunWorkflow(_ context.Context, owner, repo string, runID int64) error {
+	d.record(PlannedAction{
+		Action: "RerunWorkflow", Repo: owner + "/" + repo, Detail: fmt.Sprintf("run %d", runID),
+	})
+	return nil
+}
+
+// RerunJob records re-running a job of a workflow run
+func (d *DryRunClient) RerunJob(_ context.Context, owner, repo string, jobID int64) error {
+	d.record(PlannedAction{
+		Action: "RerunJob", Repo: owner + "/" + repo, Detail: fmt.Sprintf("job %d", jobID),
+	})
+	return nil
+}
+
+// DispatchWorkflow records starting a workflow run
+func (d *DryRunClient) DispatchWorkflow(
+	_ context.Context, owner, repo string, workflowID int64, ref string,
+) error {
+	d.record(PlannedAction{
+		Action: "DispatchWorkflow", Repo: owner + "/" + repo,
+		Detail: fmt.Sprintf("workflow %d on %s", workflowID, ref),
+	})
+	return nil
+}
+
+// CreateComment records posting a comment. It returns a comment object
+// with the body and no ID.
+func (d *DryRunClient) CreateComment(
+	_ context.Context, owner, repo string, number int, body string,
+) (*gogithub.IssueComment, error) {
+	d.record(PlannedAction{
+		Action: "CreateComment", Repo: owner + "/" + repo, Number: number, Detail: summarize(body),
+	})
+	return &gogithub.IssueComment{Body: &body}, nil
+}
+
+// DeleteComment records deleting a comment
+func (d *DryRunClient) DeleteComment(_ context.Context, owner, repo string, commentID int64) error {
+	d.record(PlannedAction{
+		Action: "DeleteComment", Repo: owner + "/" + repo, Detail: fmt.Sprintf("comment %d", commentID),
+	})
+	return nil
+}
+
+// CreateCheckRun records publishing a check run. It returns the check
+// run that would have been created, without an ID.
+func (d *DryRunClient) CreateCheckRun(
+	_ context.Context, owner, repo string, opts gogithub.CreateCheckRunOptions,
+) (*gogithub.CheckRun, error) {
+	d.record(PlannedAction{
+		Action: "CreateCheckRun", Repo: owner + "/" + repo,
+		Detail: fmt.Sprintf("%s: %s", opts.Name, opts.GetConclusion()),
+	})
+	return &gogithub.CheckRun{
+		Name: &opts.Name, HeadSHA: &opts.HeadSHA, Status: opts.Status,
+		Conclusion: opts.Conclusion, Output: opts.Output,
+	}, nil
+}
+
+// summarize returns the first line of a text, truncated
+func summarize(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if len(line) > 80 {
+		line = line[:77] + "..."
+	}
+	return line
+}
+
+// EnableDryRun makes the GitHub object record all mutating calls
+// instead of sending them to the API
+func (github *GitHub) EnableDryRun() {
+	if github.DryRun() {
+		return
+	}
+	github.client = NewDryRunClient(github.client)
+}
+
+// DryRun returns true if the GitHub object is in dry-run mode
+func (github *GitHub) DryRun() bool {
+	_, ok := github.client.(*DryRunClient)
+	return ok
+}
+
+// PlanAction records a change made outside of the API, like a git push,
+// that the caller skipped because the GitHub object is in dry-run mode
+func (github *GitHub) PlanAction(action PlannedAction) {
+	if d, ok := github.client.(*DryRunClient); ok {
+		d.record(action)
+	}
+}
+
+// PlannedActions returns the mutating calls recorded in dry-run mode
+func (github *GitHub) PlannedActions() []PlannedAction {
+	if d, ok := github.client.(*DryRunClient); ok {
+		return d.Actions()
+	}
+	return nil
+}
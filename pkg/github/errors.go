@@ -0,0 +1,112 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	gogithub "github.com/google/go-github/v48/github"
+)
+
+// Sentinel errors returned by the GitHub object. They can be matched
+// using errors.Is on any error returned by the package.
+var (
+	// ErrNotFound is returned when the requested resource does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrUnauthorized is returned when the API rejects our credentials
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrForbidden is returned when the token lacks the required permissions
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrNotMergeable is returned when GitHub refuses to merge a pull request (405)
+	ErrNotMergeable = errors.New("pull request is not mergeable")
+
+	// ErrConflict is returned when the resource changed under us, for example
+	// when the head of a pull request moved while trying to merge it (409)
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is returned when GitHub rejects the data sent (422)
+	ErrValidation = errors.New("validation failed")
+
+	// ErrCleanStatus is returned when auto-merge cannot be enabled because
+	// the pull request can be merged right away
+	ErrCleanStatus = errors.New("pull request is in clean status")
+
+	// ErrRateLimited is returned when the API rate limit was exhausted
+	// even after retrying the call
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// APIError is an error returned by the GitHub API. It matches one of the
+// sentinel errors of the package and wraps the original error from the
+// go-github library.
+type APIError struct {
+	// StatusCode is the HTTP status of the failed response
+	StatusCode int
+
+	// Message is the error message returned by the API, if any
+	Message string
+
+	kind error
+	err  error
+}
+
+// Error returns the error string
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s (HTTP %d): %s", e.kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s (HTTP %d): %v", e.kind, e.StatusCode, e.err)
+}
+
+// Unwrap returns the sentinel error and the original error
+func (e *APIError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// apiError classifies an error returned from a call to the API. If the
+// error matches one of the sentinel errors, it is wrapped in an *APIError,
+// otherwise it is returned unchanged.
+func apiError(resp *gogithub.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var rerr *gogithub.RateLimitError
+	var aerr *gogithub.AbuseRateLimitError
+	if errors.As(err, &rerr) || errors.As(err, &aerr) {
+		return &APIError{StatusCode: http.StatusForbidden, kind: ErrRateLimited, err: err}
+	}
+
+	apierr := &APIError{err: err}
+	var eresp *gogithub.ErrorResponse
+	if errors.As(err, &eresp) {
+		apierr.Message = eresp.Message
+		if eresp.Response != nil {
+			apierr.StatusCode = eresp.Response.StatusCode
+		}
+	}
+	if apierr.StatusCode == 0 && resp != nil && resp.Response != nil {
+		apierr.StatusCode = resp.StatusCode
+	}
+
+	switch apierr.StatusCode {
+	case http.StatusNotFound:
+		apierr.kind = ErrNotFound
+	case http.StatusUnauthorized:
+		apierr.kind = ErrUnauthorized
+	case http.StatusForbidden:
+		apierr.kind = ErrForbidden
+	case http.StatusMethodNotAllowed:
+		apierr.kind = ErrNotMergeable
+	case http.StatusConflict:
+		apierr.kind = ErrConflict
+	case http.StatusUnprocessableEntity:
+		apierr.kind = ErrValidation
+	default:
+		return err
+	}
+	return apierr
+}
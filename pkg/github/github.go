@@ -3,8 +3,9 @@ package github
 import (
 	"context"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 
 	gogithub "github.com/google/go-github/v48/github"
@@ -27,10 +28,15 @@ const (
 type GitHub struct {
 	client  Client
 	options *Options
+
+	// rateLimits tracks the quota reported by the API, nil when the
+	// client was not created with NewWithToken
+	rateLimits *rateLimits
 }
 
 type githubClient struct {
 	*gogithub.Client
+	options *Options
 }
 
 type Client interface {
@@ -58,7 +64,16 @@ type Client interface {
 		context.Context, string, string, int, string,
 	) error
 
-	MergePullRequest(context.Context, string, string, int) error
+	MergePullRequest(context.Context, string, string, int, string) error
+
+	EnableAutoMerge(context.Context, string, string, int) error
+	DisableAutoMerge(context.Context, string, string, int) error
+	EnqueuePullRequest(context.Context, string, string, int) error
+	DequeuePullRequest(context.Context, string, string, int) error
+
+	ListPullRequests(
+		context.Context, string, string, *gogithub.PullRequestListOptions,
+	) ([]*gogithub.PullRequest, error)
 
 	ListPullRequestFiles(
 		context.Context, string, string, int, *gogithub.ListOptions,
@@ -68,6 +83,10 @@ type Client interface {
 		context.Context, string, string, string, *gogithub.ListCheckRunsOptions,
 	) (*gogithub.ListCheckRunsResults, error)
 
+	GetCombinedStatus(
+		context.Context, string, string, string, *gogithub.ListOptions,
+	) (*gogithub.CombinedStatus, error)
+
 	GetIssueComments(
 		context.Context, string, string, int, *gogithub.IssueListCommentsOptions,
 	) ([]*gogithub.IssueComment, error)
@@ -79,6 +98,27 @@ type Client interface {
 	) (*gogithub.IssueComment, error)
 
 	DeleteComment(context.Context, string, string, int64) (err error)
+
+	IsCollaborator(context.Context, string, string, string) (bool, error)
+
+	GetFileContents(context.Context, string, string, string, string) ([]byte, error)
+
+	CreateCheckRun(
+		context.Context, string, string, gogithub.CreateCheckRunOptions,
+	) (*gogithub.CheckRun, error)
+
+	GetPullRequestState(context.Context, string, string, int) (*PullRequestState, error)
+
+	ListWorkflows(context.Context, string, string, *gogithub.ListOptions) ([]*gogithub.Workflow, error)
+
+	ListWorkflowRunsForSHA(
+		context.Context, string, string, string, *gogithub.ListOptions,
+	) ([]*gogithub.WorkflowRun, error)
+
+	RerunFailedJobs(context.Context, string, string, int64) error
+	RerunWorkflow(context.Context, string, string, int64) error
+	RerunJob(context.Context, string, string, int64) error
+	DispatchWorkflow(context.Context, string, string, int64, string) error
 }
 
 // Options is a set of options to configure the behavior of the GitHub package
@@ -86,100 +126,193 @@ type Options struct {
 	// How many items to request in calls to the github API
 	// that require pagination.
 	ItemsPerPage int
+
+	// MaxRetries is the number of times a failed call to the API
+	// is retried when the error is transient
+	MaxRetries int
 }
 
 func (o *Options) GetItemsPerPage() int {
 	return o.ItemsPerPage
 }
 
+// GetMaxRetries returns the retry budget for each API call
+func (o *Options) GetMaxRetries() int {
+	return o.MaxRetries
+}
+
 // DefaultOptions return an options struct with commonly used settings
 func DefaultOptions() *Options {
 	return &Options{
 		ItemsPerPage: 50,
+		MaxRetries:   MaxGithubRetries,
 	}
 }
 
 func New() *GitHub {
 	token := env.Default(TokenEnvKey, "")
-	client, _ := NewWithToken(token) //nolint:errcheck
+	client, _ := NewWithToken(token, "") //nolint:errcheck
 	return client
 }
 
 // NewWithToken can be used to specify a GitHub token through parameters.
 // Empty string will result in unauthenticated client, which makes
-// unauthenticated requests.
-func NewWithToken(token string) (*GitHub, error) {
+// unauthenticated requests. If baseURL is not empty, the client will
+// talk to the REST API at that URL instead of api.github.com.
+func NewWithToken(token, baseURL string) (*GitHub, error) {
 	ctx := context.Background()
-	client := http.DefaultClient
+	transport, err := newTransport()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating API transport")
+	}
+	limits := newRateLimits(transport)
+	client := &http.Client{Transport: limits}
 	state := "unauthenticated"
 	if token != "" {
 		state = strings.TrimPrefix(state, "un")
-		client = oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		))
+		client = oauth2.NewClient(
+			context.WithValue(ctx, oauth2.HTTPClient, client),
+			oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+		)
 	}
 	logrus.Debugf("Using %s GitHub client", state)
+	gh := gogithub.NewClient(client)
+	if baseURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing API base URL")
+		}
+		gh.BaseURL = u
+		logrus.Debugf("Using GitHub API at %s", u)
+	}
+	options := DefaultOptions()
 	return &GitHub{
-		client:  &githubClient{gogithub.NewClient(client)},
-		options: DefaultOptions(),
+		client:     &githubClient{gh, options},
+		options:    options,
+		rateLimits: limits,
 	}, nil
 }
 
+// newTransport returns the transport used to talk to the API. When a
+// cassette is set in the environment, it is wrapped in a Recorder. When
+// an HTTP cache is set, requests are made conditional on top of that.
+func newTransport() (http.RoundTripper, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+	if path := os.Getenv(CassetteEnvKey); path != "" {
+		mode := CassetteMode(env.Default(CassetteModeEnvKey, string(CassetteRecord)))
+		rec, err := NewRecorder(path, mode, transport)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating API recorder")
+		}
+		logrus.Infof("Using API cassette %s in %s mode", path, mode)
+		transport = rec
+	}
+	if spec := os.Getenv(HTTPCacheEnvKey); spec != "" {
+		cache, err := NewHTTPCache(spec)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating HTTP cache")
+		}
+		logrus.Infof("Using HTTP cache %s for conditional API requests", spec)
+		transport = NewHTTPCacheTransport(cache, transport)
+	}
+	return transport, nil
+}
+
+// NewWithClient returns a GitHub object that talks to the API through
+// the passed client. It is mostly useful to inject fakes in tests.
+func NewWithClient(client Client) *GitHub {
+	return &GitHub{
+		client:  client,
+		options: DefaultOptions(),
+	}
+}
+
+// SetClient replaces the client used to talk to the API
+func (github *GitHub) SetClient(client Client) {
+	github.client = client
+}
+
+// Client returns the client used to talk to the API
+func (github *GitHub) Client() Client {
+	return github.client
+}
+
+// MaxGithubRetries is the default number of retries of failed API calls
 var MaxGithubRetries = 3
 
+// Options returns the options of the GitHub object. Changes to the
+// returned struct affect all subsequent calls.
+func (github *GitHub) Options() *Options {
+	return github.options
+}
+
+// errChecker returns a function that decides if failed calls are
+// retried. Calls are not retried once ctx is done, even while waiting.
+func (g *githubClient) errChecker(ctx context.Context) func(error) bool {
+	return internal.GithubErrChecker(g.options.GetMaxRetries(), internal.ContextSleeper(ctx))
+}
+
+// unsafeErrChecker returns the function that decides if calls that are
+// not idempotent are retried. Only rate limited calls are sent again.
+func (g *githubClient) unsafeErrChecker(ctx context.Context) func(error) bool {
+	return internal.RateLimitErrChecker(g.options.GetMaxRetries(), internal.ContextSleeper(ctx))
+}
+
 // Lists the labels in a given repository
-func (github *GitHub) ListLabels(owner, repo string) ([]*gogithub.Label, error) {
-	labels, err := github.client.ListLabels(context.Background(), owner, repo, github.options)
+func (github *GitHub) ListLabels(ctx context.Context, owner, repo string) ([]*gogithub.Label, error) {
+	labels, err := github.client.ListLabels(ctx, owner, repo, github.options)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting labels from repo")
 	}
 	return labels, nil
 }
 
-func (github *GitHub) AddLabel(owner, repo string, issue int, label string) error {
-	if err := github.client.AddLabel(context.Background(), owner, repo, issue, label); err != nil {
+func (github *GitHub) AddLabel(ctx context.Context, owner, repo string, issue int, label string) error {
+	if err := github.client.AddLabel(ctx, owner, repo, issue, label); err != nil {
 		return errors.Wrap(err, "added label "+label)
 	}
 	return nil
 }
 
-func (github *GitHub) RemoveLabel(owner, repo string, issue int, label string) error {
-	if err := github.client.RemoveLabel(context.Background(), owner, repo, issue, label); err != nil {
+func (github *GitHub) RemoveLabel(ctx context.Context, owner, repo string, issue int, label string) error {
+	if err := github.client.RemoveLabel(ctx, owner, repo, issue, label); err != nil {
 		return errors.Wrap(err, "removed label "+label)
 	}
 	return nil
 }
 
-func (github *GitHub) GetComment(owner, repo string, commentID int64) (comment *gogithub.IssueComment, err error) {
-	comment, _, err = github.client.GetComment(context.Background(), owner, repo, commentID)
+func (github *GitHub) GetComment(
+	ctx context.Context, owner, repo string, commentID int64,
+) (comment *gogithub.IssueComment, err error) {
+	comment, _, err = github.client.GetComment(ctx, owner, repo, commentID)
 	return comment, err
 }
 
-func (github *GitHub) MergePullRequest(ctx context.Context, owner, repo string, number int) error {
-	return github.client.MergePullRequest(ctx, owner, repo, number)
+// MergePullRequest merges a pull request. When sha is not empty, GitHub
+// only merges it if sha is still its head, otherwise ErrConflict is
+// returned.
+func (github *GitHub) MergePullRequest(ctx context.Context, owner, repo string, number int, sha string) error {
+	return github.client.MergePullRequest(ctx, owner, repo, number, sha)
 }
 
 func (g *githubClient) MergePullRequest(
-	ctx context.Context, owner string, repo string, number int,
+	ctx context.Context, owner string, repo string, number int, sha string,
 ) error {
 	// One default message for the merge commit
 	msg := fmt.Sprintf("MiniProw: merge pull request #%d", number)
 
 	// Call the GitHub API to merge the PR
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
-		_, r, err := g.Client.PullRequests.Merge(
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		_, resp, err := g.Client.PullRequests.Merge(
 			ctx, owner, repo, number, msg,
-			&gogithub.PullRequestOptions{CommitTitle: msg},
+			&gogithub.PullRequestOptions{CommitTitle: msg, SHA: sha},
 		)
 		if !shouldRetry(err) {
-			if err == nil {
-				logrus.Infof("Successfully merged commit %d", number)
-			}
-			m, xerr := io.ReadAll(r.Body)
-			if xerr != nil {
-				logrus.Error("Could not read failed merge body")
+			if err != nil {
+				return apiError(resp, err)
 			}
-			return errors.Wrap(err, string(m))
+			logrus.Infof("Successfully merged commit %d", number)
+			return nil
 		}
 	}
 }
@@ -195,10 +328,58 @@ func (github *GitHub) GetPullRequest(
 func (g *githubClient) GetPullRequest(
 	ctx context.Context, owner, repo string, number int,
 ) (*gogithub.PullRequest, *gogithub.Response, error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+	for shouldRetry := g.errChecker(ctx); ; {
 		pr, resp, err := g.Client.PullRequests.Get(ctx, owner, repo, number)
 		if !shouldRetry(err) {
-			return pr, resp, err
+			return pr, resp, apiError(resp, err)
+		}
+	}
+}
+
+// ListOpenPullRequests returns the open pull requests of a repository,
+// oldest first. Note that the list endpoint does not return the
+// mergeable flag of the pull requests.
+func (github *GitHub) ListOpenPullRequests(ctx context.Context, slug string) ([]*gogithub.PullRequest, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	opts := &gogithub.PullRequestListOptions{
+		State:     "open",
+		Sort:      "created",
+		Direction: "asc",
+		ListOptions: gogithub.ListOptions{
+			PerPage: github.options.GetItemsPerPage(),
+		},
+	}
+	prs, err := github.client.ListPullRequests(ctx, owner, repo, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing pull requests")
+	}
+	return prs, nil
+}
+
+// ListPullRequests queries the GH api to get the pull requests of a repository
+func (g *githubClient) ListPullRequests(
+	ctx context.Context, owner, repo string, opts *gogithub.PullRequestListOptions,
+) ([]*gogithub.PullRequest, error) {
+	allPRs := []*gogithub.PullRequest{}
+	for {
+		for shouldRetry := g.errChecker(ctx); ; {
+			prs, resp, err := g.Client.PullRequests.List(ctx, owner, repo, opts)
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "listing pull requests")
+			}
+			allPRs = append(allPRs, prs...)
+			if resp.NextPage == 0 {
+				logrus.Infof("GitHub: Found %d pull requests in %s/%s", len(allPRs), owner, repo)
+				return allPRs, nil
+			}
+			opts.Page = resp.NextPage
+			break
 		}
 	}
 }
@@ -231,7 +412,7 @@ func (g *githubClient) ListPullRequestFiles(
 	for {
 		files, resp, err := g.Client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
 		if err != nil {
-			return nil, errors.Wrap(err, "getting modified files")
+			return nil, errors.Wrap(apiError(resp, err), "getting modified files")
 		}
 		allFiles = append(allFiles, files...)
 		if resp.NextPage == 0 {
@@ -243,6 +424,8 @@ func (g *githubClient) ListPullRequestFiles(
 	return allFiles, nil
 }
 
+// ListCheckRunsForRef returns the check runs reported for a git ref. When
+// a check was re-run, only its latest run is returned.
 func (github *GitHub) ListCheckRunsForRef(
 	ctx context.Context, slug, ref string,
 ) (*gogithub.ListCheckRunsResults, error) {
@@ -256,16 +439,117 @@ func (github *GitHub) ListCheckRunsForRef(
 			PerPage: 100,
 		},
 	}
-	return github.client.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+	runs, err := github.client.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing check runs for %s", ref)
+	}
+	runs.CheckRuns = latestCheckRuns(runs.CheckRuns)
+	runs.Total = gogithub.Int(len(runs.CheckRuns))
+	return runs, nil
+}
+
+// latestCheckRuns filters a list of check runs, keeping only the most
+// recent run of each check name. Order of the list is preserved.
+func latestCheckRuns(runs []*gogithub.CheckRun) []*gogithub.CheckRun {
+	latest := map[string]*gogithub.CheckRun{}
+	for _, run := range runs {
+		prev, ok := latest[run.GetName()]
+		if !ok || isNewerCheckRun(run, prev) {
+			latest[run.GetName()] = run
+		}
+	}
+
+	filtered := []*gogithub.CheckRun{}
+	for _, run := range runs {
+		if latest[run.GetName()] == run {
+			filtered = append(filtered, run)
+		}
+	}
+	return filtered
+}
+
+// isNewerCheckRun returns true if run a was started after run b. Runs
+// without a start time are ordered by their ID.
+func isNewerCheckRun(a, b *gogithub.CheckRun) bool {
+	if a.StartedAt != nil && b.StartedAt != nil && !a.GetStartedAt().Equal(b.GetStartedAt()) {
+		return a.GetStartedAt().After(b.GetStartedAt().Time)
+	}
+	return a.GetID() > b.GetID()
 }
 
+// ListCheckRunsForRef queries the API for the check runs of a ref,
+// collecting the runs from all pages
 func (g *githubClient) ListCheckRunsForRef(
 	ctx context.Context, owner, repo, ref string, opts *gogithub.ListCheckRunsOptions,
 ) (*gogithub.ListCheckRunsResults, error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
-		runs, _, err := g.Client.Checks.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
-		if !shouldRetry(err) {
-			return runs, nil
+	results := &gogithub.ListCheckRunsResults{
+		CheckRuns: []*gogithub.CheckRun{},
+	}
+	for {
+		for shouldRetry := g.errChecker(ctx); ; {
+			runs, resp, err := g.Client.Checks.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "listing check runs")
+			}
+			results.CheckRuns = append(results.CheckRuns, runs.CheckRuns...)
+			if resp.NextPage == 0 {
+				results.Total = gogithub.Int(len(results.CheckRuns))
+				return results, nil
+			}
+			opts.Page = resp.NextPage
+			break
+		}
+	}
+}
+
+// GetCombinedStatus returns the combined commit status of a git ref,
+// including all the statuses reported through the legacy Status API
+func (github *GitHub) GetCombinedStatus(
+	ctx context.Context, slug, ref string,
+) (*gogithub.CombinedStatus, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	opts := &gogithub.ListOptions{
+		Page:    0,
+		PerPage: 100,
+	}
+	status, err := github.client.GetCombinedStatus(ctx, owner, repo, ref, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting combined status for %s", ref)
+	}
+	return status, nil
+}
+
+// GetCombinedStatus queries the API for the combined status of a ref,
+// collecting the statuses from all pages
+func (g *githubClient) GetCombinedStatus(
+	ctx context.Context, owner, repo, ref string, opts *gogithub.ListOptions,
+) (*gogithub.CombinedStatus, error) {
+	var combined *gogithub.CombinedStatus
+	for {
+		for shouldRetry := g.errChecker(ctx); ; {
+			status, resp, err := g.Client.Repositories.GetCombinedStatus(ctx, owner, repo, ref, opts)
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "getting combined status")
+			}
+			if combined == nil {
+				combined = status
+			} else {
+				combined.Statuses = append(combined.Statuses, status.Statuses...)
+			}
+			if resp.NextPage == 0 {
+				return combined, nil
+			}
+			opts.Page = resp.NextPage
+			break
 		}
 	}
 }
@@ -281,10 +565,10 @@ func (github *GitHub) GetIssue(
 func (g *githubClient) GetIssue(
 	ctx context.Context, owner, repo string, number int,
 ) (*gogithub.Issue, *gogithub.Response, error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+	for shouldRetry := g.errChecker(ctx); ; {
 		issue, resp, err := g.Client.Issues.Get(ctx, owner, repo, number)
 		if !shouldRetry(err) {
-			return issue, resp, err
+			return issue, resp, apiError(resp, err)
 		}
 	}
 }
@@ -314,15 +598,20 @@ func (g *githubClient) GetIssueComments(
 	comments = []*gogithub.IssueComment{}
 	// Loop the comment pages
 	for {
-		for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+		for shouldRetry := g.errChecker(ctx); ; {
 			cm, resp, err := g.Client.Issues.ListComments(ctx, owner, repo, number, opts)
-			if !shouldRetry(err) {
-				labels := append(comments, cm...) //nolint:gocritic
-				if resp.NextPage == 0 {
-					return labels, nil
-				}
-				opts.Page = resp.NextPage
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "listing comments")
+			}
+			comments = append(comments, cm...)
+			if resp.NextPage == 0 {
+				return comments, nil
 			}
+			opts.Page = resp.NextPage
+			break
 		}
 	}
 }
@@ -334,10 +623,10 @@ func (github *GitHub) GetAPIUser(ctx context.Context) (user *gogithub.User, err
 
 // GetAPIUser calls the github API to get the current user
 func (g *githubClient) GetAPIUser(ctx context.Context) (user *gogithub.User, err error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
-		user, _, err := g.Client.Users.Get(ctx, "")
+	for shouldRetry := g.errChecker(ctx); ; {
+		user, resp, err := g.Client.Users.Get(ctx, "")
 		if !shouldRetry(err) {
-			return user, err
+			return user, apiError(resp, err)
 		}
 	}
 }
@@ -345,10 +634,10 @@ func (g *githubClient) GetAPIUser(ctx context.Context) (user *gogithub.User, err
 func (g *githubClient) GetComment(
 	ctx context.Context, owner, repo string, number int64,
 ) (*gogithub.IssueComment, *gogithub.Response, error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+	for shouldRetry := g.errChecker(ctx); ; {
 		comment, resp, err := g.Client.Issues.GetComment(ctx, owner, repo, number)
 		if !shouldRetry(err) {
-			return comment, resp, err
+			return comment, resp, apiError(resp, err)
 		}
 	}
 }
@@ -363,15 +652,20 @@ func (g *githubClient) ListLabels(
 	}
 	labels := []*gogithub.Label{}
 	for {
-		for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+		for shouldRetry := g.errChecker(ctx); ; {
 			loopLabels, resp, err := g.Client.Issues.ListLabels(ctx, owner, repo, opts)
-			if !shouldRetry(err) {
-				labels := append(labels, loopLabels...)
-				if resp.NextPage == 0 {
-					return labels, nil
-				}
-				opts.Page = resp.NextPage
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, apiError(resp, err)
 			}
+			labels = append(labels, loopLabels...)
+			if resp.NextPage == 0 {
+				return labels, nil
+			}
+			opts.Page = resp.NextPage
+			break
 		}
 	}
 }
@@ -379,10 +673,10 @@ func (g *githubClient) ListLabels(
 func (g *githubClient) AddLabel(
 	ctx context.Context, owner, repo string, issue int, label string,
 ) error {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
-		_, _, err := g.Client.Issues.AddLabelsToIssue(ctx, owner, repo, issue, []string{label})
+	for shouldRetry := g.errChecker(ctx); ; {
+		_, resp, err := g.Client.Issues.AddLabelsToIssue(ctx, owner, repo, issue, []string{label})
 		if !shouldRetry(err) {
-			return err
+			return apiError(resp, err)
 		}
 	}
 }
@@ -390,16 +684,18 @@ func (g *githubClient) AddLabel(
 func (g *githubClient) RemoveLabel(
 	ctx context.Context, owner, repo string, issue int, label string,
 ) error {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+	for shouldRetry := g.errChecker(ctx); ; {
 		resp, err := g.Client.Issues.RemoveLabelForIssue(ctx, owner, repo, issue, label)
+		if shouldRetry(err) {
+			continue
+		}
+		err = apiError(resp, err)
 		// If we get an error, but it is 404 warn but do not err
-		if resp.StatusCode == 404 {
+		if errors.Is(err, ErrNotFound) {
 			logrus.Warnf("Issue %d does not have label %s, cannot remove (NOOP)", issue, label)
 			return nil
 		}
-		if !shouldRetry(err) {
-			return err
-		}
+		return err
 	}
 }
 
@@ -431,10 +727,10 @@ func (g *githubClient) CreateComment(
 		Body: &body,
 	}
 
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
-		cm, _, err := g.Client.Issues.CreateComment(ctx, owner, repo, number, comment)
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		cm, resp, err := g.Client.Issues.CreateComment(ctx, owner, repo, number, comment)
 		if !shouldRetry(err) {
-			return cm, err
+			return cm, apiError(resp, err)
 		}
 	}
 }
@@ -453,11 +749,11 @@ func (github *GitHub) DeleteComment(ctx context.Context, slug string, commentID
 func (g *githubClient) DeleteComment(
 	ctx context.Context, owner, repo string, commentID int64,
 ) (err error) {
-	for shouldRetry := internal.DefaultGithubErrChecker(); ; {
+	for shouldRetry := g.errChecker(ctx); ; {
 		resp, err := g.Client.Issues.DeleteComment(ctx, owner, repo, commentID)
 		if !shouldRetry(err) {
 			if err != nil {
-				return errors.Wrap(err, "deleting comment")
+				return errors.Wrap(apiError(resp, err), "deleting comment")
 			}
 			if resp.StatusCode != 204 {
 				return errors.New("got an http error response deleting comment")
@@ -466,3 +762,80 @@ func (g *githubClient) DeleteComment(
 		}
 	}
 }
+
+// IsCollaborator returns true if user is a collaborator of the repository
+func (github *GitHub) IsCollaborator(ctx context.Context, slug, user string) (bool, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return false, errors.New("invalid repo slug")
+	}
+	return github.client.IsCollaborator(ctx, owner, repo, user)
+}
+
+// IsCollaborator calls the API to check if a user is a collaborator
+func (g *githubClient) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error) {
+	for shouldRetry := g.errChecker(ctx); ; {
+		isCollaborator, resp, err := g.Client.Repositories.IsCollaborator(ctx, owner, repo, user)
+		if !shouldRetry(err) {
+			return isCollaborator, apiError(resp, err)
+		}
+	}
+}
+
+// GetFileContents returns the contents of a file in the repository at
+// a git ref. If the file does not exist, the error wraps ErrNotFound.
+func (github *GitHub) GetFileContents(ctx context.Context, slug, path, ref string) ([]byte, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	return github.client.GetFileContents(ctx, owner, repo, path, ref)
+}
+
+// GetFileContents calls the contents API to download a file
+func (g *githubClient) GetFileContents(
+	ctx context.Context, owner, repo, path, ref string,
+) ([]byte, error) {
+	for shouldRetry := g.errChecker(ctx); ; {
+		file, _, resp, err := g.Client.Repositories.GetContents(
+			ctx, owner, repo, path, &gogithub.RepositoryContentGetOptions{Ref: ref},
+		)
+		if shouldRetry(err) {
+			continue
+		}
+		if err != nil {
+			return nil, apiError(resp, err)
+		}
+		if file == nil {
+			return nil, errors.Errorf("%s is not a file", path)
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", path)
+		}
+		return []byte(content), nil
+	}
+}
+
+// CreateCheckRun publishes a check run in the repository
+func (github *GitHub) CreateCheckRun(
+	ctx context.Context, slug string, opts gogithub.CreateCheckRunOptions,
+) (*gogithub.CheckRun, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	return github.client.CreateCheckRun(ctx, owner, repo, opts)
+}
+
+// CreateCheckRun calls the checks API to create a check run
+func (g *githubClient) CreateCheckRun(
+	ctx context.Context, owner, repo string, opts gogithub.CreateCheckRunOptions,
+) (*gogithub.CheckRun, error) {
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		run, resp, err := g.Client.Checks.CreateCheckRun(ctx, owner, repo, opts)
+		if !shouldRetry(err) {
+			return run, apiError(resp, err)
+		}
+	}
+}
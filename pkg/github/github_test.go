@@ -0,0 +1,114 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestCheckRuns(t *testing.T) {
+	now := time.Now()
+	run := func(id int64, name string, started time.Time) *gogithub.CheckRun {
+		return &gogithub.CheckRun{
+			ID: &id, Name: &name, StartedAt: &gogithub.Timestamp{Time: started},
+		}
+	}
+	runs := []*gogithub.CheckRun{
+		run(3, "test", now.Add(-time.Hour)),
+		run(1, "build", now),
+		run(4, "test", now),
+		run(2, "lint", now),
+		run(5, "lint", now),
+	}
+	latest := latestCheckRuns(runs)
+	require.Len(t, latest, 3)
+	require.Equal(t, int64(1), latest[0].GetID())
+	require.Equal(t, int64(4), latest[1].GetID())
+	require.Equal(t, int64(5), latest[2].GetID())
+}
+
+func TestLatestWorkflowRuns(t *testing.T) {
+	run := func(id, workflow int64) *gogithub.WorkflowRun {
+		return &gogithub.WorkflowRun{ID: &id, WorkflowID: &workflow}
+	}
+	latest := latestWorkflowRuns([]*gogithub.WorkflowRun{run(7, 1), run(3, 2), run(5, 1)})
+	require.Len(t, latest, 2)
+	require.Equal(t, int64(7), latest[0].GetID())
+	require.Equal(t, int64(3), latest[1].GetID())
+}
+
+func TestAPIError(t *testing.T) {
+	response := func(code int) *gogithub.Response {
+		return &gogithub.Response{Response: &http.Response{StatusCode: code}}
+	}
+	for _, tc := range []struct {
+		code     int
+		expected error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusMethodNotAllowed, ErrNotMergeable},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusUnprocessableEntity, ErrValidation},
+	} {
+		resp := response(tc.code)
+		err := apiError(resp, &gogithub.ErrorResponse{Response: resp.Response, Message: "failed"})
+		require.ErrorIs(t, errors.Wrap(err, "calling api"), tc.expected)
+
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, tc.code, apiErr.StatusCode)
+		require.Equal(t, "failed", apiErr.Message)
+	}
+
+	// Errors without a response are not classified
+	err := errors.New("connection refused")
+	require.Equal(t, err, apiError(nil, err))
+	require.Nil(t, apiError(response(http.StatusOK), nil))
+
+	// Rate limits are flagged
+	require.ErrorIs(t, apiError(nil, &gogithub.RateLimitError{}), ErrRateLimited)
+}
+
+func TestCreateCommentIsNotRetried(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	// The comment may have been posted before the error, it is not sent again
+	gh, err := NewWithToken("token", server.URL)
+	require.NoError(t, err)
+	_, err = gh.CreateComment(context.Background(), "uservers/test", 1, "hello")
+	require.Error(t, err)
+	require.Equal(t, 1, requests)
+}
+
+func TestRetryWaitIsCanceled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	// The deadline ends while waiting to retry, the call returns then
+	gh, err := NewWithToken("token", server.URL)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = gh.ListLabels(ctx, "uservers", "test")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 30*time.Second)
+	require.Equal(t, 1, requests)
+}
@@ -0,0 +1,1012 @@
+// Package githubfake provides a stateful, in-memory implementation of the
+// github.Client interface. It keeps repositories, labels, issues, pull
+// requests, comments, check runs and statuses in memory so that code
+// using the github package can be tested without network access.
+package githubfake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/uservers/miniprow/pkg/github"
+)
+
+// DefaultBotUser is the login of the authenticated user of a new fake
+const DefaultBotUser = "miniprow-bot"
+
+// BaseSHA is the commit at the base branch of all the pull requests
+var BaseSHA = strings.Repeat("ba5e", 10)
+
+// Client is a fake GitHub client that keeps its state in memory
+type Client struct {
+	mu     sync.Mutex
+	user   *gogithub.User
+	repos  map[string]*Repo
+	errors map[string]error
+	nextID int64
+}
+
+// Repo holds the state of a fake repository
+type Repo struct {
+	Labels     []string
+	Issues     map[int]*gogithub.Issue
+	Pulls      map[int]*gogithub.PullRequest
+	Files      map[int][]*gogithub.CommitFile
+	Comments   map[int][]*gogithub.IssueComment
+	IssueLabel map[int][]string
+	CheckRuns  map[string][]*gogithub.CheckRun
+	Statuses   map[string][]*gogithub.RepoStatus
+	Merges     []int
+
+	// Queue has the pull requests in the merge queue, in order
+	Queue []int
+
+	// Workflows of the repository and their runs indexed by head SHA
+	Workflows    []*gogithub.Workflow
+	WorkflowRuns map[string][]*gogithub.WorkflowRun
+
+	// Reruns and FailedJobReruns have the IDs of the runs re-run, in
+	// order. Dispatches has the workflow_dispatch events as path@ref.
+	Reruns          []int64
+	FailedJobReruns []int64
+	Dispatches      []string
+
+	// JobReruns has the IDs of the jobs re-run. The check runs are the
+	// jobs, they share the ID.
+	JobReruns []int64
+
+	// Collaborators of the repository. The PR authors and the
+	// authenticated user are not added automatically.
+	Collaborators []string
+
+	// Contents has the files of the repository indexed by git ref
+	// and path
+	Contents map[string]map[string][]byte
+}
+
+// New returns a new fake client, authenticated as DefaultBotUser
+func New() *Client {
+	return &Client{
+		user:   &gogithub.User{Login: gogithub.String(DefaultBotUser)},
+		repos:  map[string]*Repo{},
+		errors: map[string]error{},
+		nextID: 1000,
+	}
+}
+
+// Ensure we implement the interface
+var _ github.Client = &Client{}
+
+// AddRepo creates a repository in the fake with the specified labels
+func (c *Client) AddRepo(slug string, labels ...string) *Repo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &Repo{
+		Labels:     labels,
+		Issues:     map[int]*gogithub.Issue{},
+		Pulls:      map[int]*gogithub.PullRequest{},
+		Files:      map[int][]*gogithub.CommitFile{},
+		Comments:   map[int][]*gogithub.IssueComment{},
+		IssueLabel: map[int][]string{},
+		CheckRuns:  map[string][]*gogithub.CheckRun{},
+		Statuses:   map[string][]*gogithub.RepoStatus{},
+		Merges:     []int{},
+		Queue:      []int{},
+
+		Workflows:       []*gogithub.Workflow{},
+		WorkflowRuns:    map[string][]*gogithub.WorkflowRun{},
+		Reruns:          []int64{},
+		FailedJobReruns: []int64{},
+		Dispatches:      []string{},
+		JobReruns:       []int64{},
+
+		Collaborators: []string{},
+		Contents:      map[string]map[string][]byte{},
+	}
+	c.repos[slug] = r
+	return r
+}
+
+// AddCollaborators adds users as collaborators of a repository
+func (c *Client) AddCollaborators(slug string, users ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	r.Collaborators = append(r.Collaborators, users...)
+}
+
+// AddFile stores a file in the repository at a git ref. Use BaseSHA
+// for the base branch or the head SHA of a pull request.
+func (c *Client) AddFile(slug, ref, path, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	if r.Contents[ref] == nil {
+		r.Contents[ref] = map[string][]byte{}
+	}
+	r.Contents[ref][path] = []byte(content)
+}
+
+// SetUser sets the login of the authenticated user
+func (c *Client) SetUser(login string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user = &gogithub.User{Login: gogithub.String(login)}
+}
+
+// SetError makes all calls to method return err. Passing a nil error
+// clears it.
+func (c *Client) SetError(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.errors, method)
+		return
+	}
+	c.errors[method] = err
+}
+
+// AddIssue creates an issue in a repository
+func (c *Client) AddIssue(slug string, number int, author string, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	r.Issues[number] = &gogithub.Issue{
+		Number: gogithub.Int(number),
+		User:   &gogithub.User{Login: gogithub.String(author)},
+		State:  gogithub.String("open"),
+	}
+	r.IssueLabel[number] = append([]string{}, labels...)
+}
+
+// AddPullRequest creates a pull request in a repository. The head SHA is
+// generated from the PR number and the head branch is pr-<number> in
+// the same repository. The PR is open and mergeable.
+func (c *Client) AddPullRequest(
+	slug string, number int, author string, files []string, labels ...string,
+) *gogithub.PullRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	pr := &gogithub.PullRequest{
+		Number:    gogithub.Int(number),
+		User:      &gogithub.User{Login: gogithub.String(author)},
+		State:     gogithub.String("open"),
+		Mergeable: gogithub.Bool(true),
+		Merged:    gogithub.Bool(false),
+		Head: &gogithub.PullRequestBranch{
+			Ref:  gogithub.String(fmt.Sprintf("pr-%d", number)),
+			SHA:  gogithub.String(fmt.Sprintf("%040d", number)),
+			Repo: &gogithub.Repository{FullName: gogithub.String(slug)},
+		},
+		Base: &gogithub.PullRequestBranch{
+			Ref:  gogithub.String("main"),
+			SHA:  gogithub.String(BaseSHA),
+			Repo: &gogithub.Repository{FullName: gogithub.String(slug)},
+		},
+	}
+	r.Pulls[number] = pr
+	r.IssueLabel[number] = append([]string{}, labels...)
+	r.Files[number] = []*gogithub.CommitFile{}
+	for _, f := range files {
+		r.Files[number] = append(r.Files[number], &gogithub.CommitFile{Filename: gogithub.String(f)})
+	}
+	return pr
+}
+
+// SetMergeable changes the mergeable flag of a pull request
+func (c *Client) SetMergeable(slug string, number int, mergeable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mustRepo(slug).Pulls[number].Mergeable = gogithub.Bool(mergeable)
+}
+
+// SetHead simulates a push to a pull request. The copies of the pull
+// request returned before keep the previous head.
+func (c *Client) SetHead(slug string, number int, sha string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pr := c.mustRepo(slug).Pulls[number]
+	head := *pr.Head
+	head.SHA = gogithub.String(sha)
+	pr.Head = &head
+}
+
+// AddComment posts a comment to an issue or pull request as user
+func (c *Client) AddComment(slug string, number int, user, body string) *gogithub.IssueComment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addComment(c.mustRepo(slug), number, user, body)
+}
+
+// AddCheckRun records a check run for a git ref
+func (c *Client) AddCheckRun(slug, ref, name, status, conclusion string) *gogithub.CheckRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	run := &gogithub.CheckRun{
+		ID:        gogithub.Int64(c.newID()),
+		Name:      gogithub.String(name),
+		HeadSHA:   gogithub.String(ref),
+		Status:    gogithub.String(status),
+		StartedAt: &gogithub.Timestamp{Time: time.Now()},
+		App:       &gogithub.App{Slug: gogithub.String(github.ActionsAppSlug)},
+	}
+	if conclusion != "" {
+		run.Conclusion = gogithub.String(conclusion)
+	}
+	r.CheckRuns[ref] = append(r.CheckRuns[ref], run)
+	return run
+}
+
+// AddWorkflow creates an active workflow in the repository. path is the
+// workflow file, eg .github/workflows/ci.yml.
+func (c *Client) AddWorkflow(slug, name, path string) *gogithub.Workflow {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	workflow := &gogithub.Workflow{
+		ID:    gogithub.Int64(c.newID()),
+		Name:  gogithub.String(name),
+		Path:  gogithub.String(path),
+		State: gogithub.String("active"),
+	}
+	r.Workflows = append(r.Workflows, workflow)
+	return workflow
+}
+
+// AddWorkflowRun records a run of the workflow with the given name for
+// a head SHA. The workflow has to be added first.
+func (c *Client) AddWorkflowRun(slug, sha, workflow, status, conclusion string) *gogithub.WorkflowRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	var w *gogithub.Workflow
+	for _, candidate := range r.Workflows {
+		if candidate.GetName() == workflow {
+			w = candidate
+		}
+	}
+	if w == nil {
+		panic("fake workflow " + workflow + " does not exist")
+	}
+	run := &gogithub.WorkflowRun{
+		ID:         gogithub.Int64(c.newID()),
+		Name:       w.Name,
+		WorkflowID: w.ID,
+		HeadSHA:    gogithub.String(sha),
+		Status:     gogithub.String(status),
+		RunAttempt: gogithub.Int(1),
+	}
+	if conclusion != "" {
+		run.Conclusion = gogithub.String(conclusion)
+	}
+	r.WorkflowRuns[sha] = append(r.WorkflowRuns[sha], run)
+	return run
+}
+
+// Reruns returns the IDs of the workflow runs re-run entirely
+func (c *Client) Reruns(slug string) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64{}, c.mustRepo(slug).Reruns...)
+}
+
+// FailedJobReruns returns the IDs of the workflow runs whose failed
+// jobs were re-run
+func (c *Client) FailedJobReruns(slug string) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64{}, c.mustRepo(slug).FailedJobReruns...)
+}
+
+// JobReruns returns the IDs of the jobs re-run
+func (c *Client) JobReruns(slug string) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64{}, c.mustRepo(slug).JobReruns...)
+}
+
+// Dispatches returns the workflow_dispatch events created, as path@ref
+func (c *Client) Dispatches(slug string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.mustRepo(slug).Dispatches...)
+}
+
+// AddStatus records a commit status for a git ref
+func (c *Client) AddStatus(slug, ref, context, state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.mustRepo(slug)
+	r.Statuses[ref] = append(r.Statuses[ref], &gogithub.RepoStatus{
+		ID:      gogithub.Int64(c.newID()),
+		Context: gogithub.String(context),
+		State:   gogithub.String(state),
+	})
+}
+
+// IssueLabels returns the labels applied to an issue or pull request
+func (c *Client) IssueLabels(slug string, number int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	labels := append([]string{}, c.mustRepo(slug).IssueLabel[number]...)
+	sort.Strings(labels)
+	return labels
+}
+
+// Comments returns the comments of an issue or pull request
+func (c *Client) Comments(slug string, number int) []*gogithub.IssueComment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*gogithub.IssueComment{}, c.mustRepo(slug).Comments[number]...)
+}
+
+// IsMerged returns true if a pull request was merged
+func (c *Client) IsMerged(slug string, number int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pr, ok := c.mustRepo(slug).Pulls[number]
+	return ok && pr.GetMerged()
+}
+
+// Merges returns the pull requests merged in a repo, in order
+func (c *Client) Merges(slug string) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int{}, c.mustRepo(slug).Merges...)
+}
+
+// GetComment returns a comment from its ID
+func (c *Client) GetComment(
+	_ context.Context, owner, repo string, id int64,
+) (*gogithub.IssueComment, *gogithub.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetComment", owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, comments := range r.Comments {
+		for _, comment := range comments {
+			if comment.GetID() == id {
+				return copyComment(comment), nil, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("comment %d: %w", id, github.ErrNotFound)
+}
+
+// GetIssue returns an issue. Pull requests are also returned as issues.
+func (c *Client) GetIssue(
+	_ context.Context, owner, repo string, number int,
+) (*gogithub.Issue, *gogithub.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetIssue", owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	var issue gogithub.Issue
+	if i, ok := r.Issues[number]; ok {
+		issue = *i
+	} else if pr, ok := r.Pulls[number]; ok {
+		issue = gogithub.Issue{
+			Number: pr.Number, User: pr.User, State: pr.State,
+			PullRequestLinks: &gogithub.PullRequestLinks{},
+		}
+	} else {
+		return nil, nil, fmt.Errorf("issue #%d: %w", number, github.ErrNotFound)
+	}
+	issue.Labels = labelObjects(r.IssueLabel[number])
+	return &issue, nil, nil
+}
+
+// GetPullRequest returns a pull request
+func (c *Client) GetPullRequest(
+	_ context.Context, owner, repo string, number int,
+) (*gogithub.PullRequest, *gogithub.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetPullRequest", owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, ok := r.Pulls[number]
+	if !ok {
+		return nil, nil, fmt.Errorf("pull request #%d: %w", number, github.ErrNotFound)
+	}
+	prCopy := *pr
+	prCopy.Labels = labelObjects(r.IssueLabel[number])
+	return &prCopy, nil, nil
+}
+
+// ListLabels returns the labels defined in a repository
+func (c *Client) ListLabels(
+	_ context.Context, owner, repo string, _ *github.Options,
+) ([]*gogithub.Label, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListLabels", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return labelObjects(r.Labels), nil
+}
+
+// AddLabel applies a label to an issue or pull request. As the real API,
+// if the label does not exist in the repository, it gets created.
+func (c *Client) AddLabel(_ context.Context, owner, repo string, number int, label string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("AddLabel", owner, repo)
+	if err != nil {
+		return err
+	}
+	if !contains(r.Labels, label) {
+		r.Labels = append(r.Labels, label)
+	}
+	if !contains(r.IssueLabel[number], label) {
+		r.IssueLabel[number] = append(r.IssueLabel[number], label)
+	}
+	return nil
+}
+
+// RemoveLabel removes a label from an issue or pull request. Removing a
+// label that is not applied is a noop.
+func (c *Client) RemoveLabel(_ context.Context, owner, repo string, number int, label string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("RemoveLabel", owner, repo)
+	if err != nil {
+		return err
+	}
+	labels := []string{}
+	for _, l := range r.IssueLabel[number] {
+		if l != label {
+			labels = append(labels, l)
+		}
+	}
+	r.IssueLabel[number] = labels
+	return nil
+}
+
+// MergePullRequest merges a pull request if it is open and mergeable,
+// and its head is sha when it is set
+func (c *Client) MergePullRequest(_ context.Context, owner, repo string, number int, sha string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("MergePullRequest", owner, repo)
+	if err != nil {
+		return err
+	}
+	pr, ok := r.Pulls[number]
+	if !ok {
+		return fmt.Errorf("pull request #%d: %w", number, github.ErrNotFound)
+	}
+	if pr.GetMerged() || !pr.GetMergeable() {
+		return fmt.Errorf("pull request #%d: %w", number, github.ErrNotMergeable)
+	}
+	if sha != "" && sha != pr.GetHead().GetSHA() {
+		return fmt.Errorf("head of pull request #%d was modified: %w", number, github.ErrConflict)
+	}
+	pr.Merged = gogithub.Bool(true)
+	pr.State = gogithub.String("closed")
+	pr.MergeCommitSHA = gogithub.String(fmt.Sprintf("%040x", c.newID()))
+	r.Merges = append(r.Merges, number)
+	return nil
+}
+
+// AutoMergeEnabled returns true if auto-merge is enabled in a pull request
+func (c *Client) AutoMergeEnabled(slug string, number int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pr, ok := c.mustRepo(slug).Pulls[number]
+	return ok && pr.AutoMerge != nil
+}
+
+// MergeQueue returns the pull requests in the merge queue of a repo
+func (c *Client) MergeQueue(slug string) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int{}, c.mustRepo(slug).Queue...)
+}
+
+// EnableAutoMerge enables auto-merge in an open pull request
+func (c *Client) EnableAutoMerge(_ context.Context, owner, repo string, number int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pr, err := c.openPullRequest("EnableAutoMerge", owner, repo, number)
+	if err != nil {
+		return err
+	}
+	pr.AutoMerge = &gogithub.PullRequestAutoMerge{MergeMethod: gogithub.String("merge")}
+	return nil
+}
+
+// DisableAutoMerge disables auto-merge in an open pull request
+func (c *Client) DisableAutoMerge(_ context.Context, owner, repo string, number int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pr, err := c.openPullRequest("DisableAutoMerge", owner, repo, number)
+	if err != nil {
+		return err
+	}
+	pr.AutoMerge = nil
+	return nil
+}
+
+// EnqueuePullRequest adds an open pull request to the merge queue. As
+// the real API, adding it twice is a noop.
+func (c *Client) EnqueuePullRequest(_ context.Context, owner, repo string, number int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.openPullRequest("EnqueuePullRequest", owner, repo, number); err != nil {
+		return err
+	}
+	r := c.repos[owner+"/"+repo]
+	for _, n := range r.Queue {
+		if n == number {
+			return nil
+		}
+	}
+	r.Queue = append(r.Queue, number)
+	return nil
+}
+
+// DequeuePullRequest removes a pull request from the merge queue
+func (c *Client) DequeuePullRequest(_ context.Context, owner, repo string, number int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.openPullRequest("DequeuePullRequest", owner, repo, number); err != nil {
+		return err
+	}
+	r := c.repos[owner+"/"+repo]
+	for i, n := range r.Queue {
+		if n == number {
+			r.Queue = append(r.Queue[:i:i], r.Queue[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("pull request #%d is not in the merge queue: %w", number, github.ErrValidation)
+}
+
+// ListWorkflows returns the workflows of the repository
+func (c *Client) ListWorkflows(
+	_ context.Context, owner, repo string, _ *gogithub.ListOptions,
+) ([]*gogithub.Workflow, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListWorkflows", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*gogithub.Workflow{}, r.Workflows...), nil
+}
+
+// ListWorkflowRunsForSHA returns all the workflow runs of a head SHA
+func (c *Client) ListWorkflowRunsForSHA(
+	_ context.Context, owner, repo, sha string, _ *gogithub.ListOptions,
+) ([]*gogithub.WorkflowRun, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListWorkflowRunsForSHA", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*gogithub.WorkflowRun{}, r.WorkflowRuns[sha]...), nil
+}
+
+// RerunFailedJobs queues a completed workflow run again and records it
+func (c *Client) RerunFailedJobs(_ context.Context, owner, repo string, runID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.rerun("RerunFailedJobs", owner, repo, runID)
+	if err != nil {
+		return err
+	}
+	r.FailedJobReruns = append(r.FailedJobReruns, runID)
+	return nil
+}
+
+// RerunWorkflow queues a completed workflow run again and records it
+func (c *Client) RerunWorkflow(_ context.Context, owner, repo string, runID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.rerun("RerunWorkflow", owner, repo, runID)
+	if err != nil {
+		return err
+	}
+	r.Reruns = append(r.Reruns, runID)
+	return nil
+}
+
+// RerunJob queues the check run with the job ID again and records it.
+// As the real API, jobs still in progress cannot be re-run.
+func (c *Client) RerunJob(_ context.Context, owner, repo string, jobID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("RerunJob", owner, repo)
+	if err != nil {
+		return err
+	}
+	for _, runs := range r.CheckRuns {
+		for _, run := range runs {
+			if run.GetID() != jobID || run.GetApp().GetSlug() != github.ActionsAppSlug {
+				continue
+			}
+			if run.GetStatus() != "completed" {
+				return fmt.Errorf("job %d is already running: %w", jobID, github.ErrForbidden)
+			}
+			run.Status = gogithub.String("queued")
+			run.Conclusion = nil
+			r.JobReruns = append(r.JobReruns, jobID)
+			return nil
+		}
+	}
+	return fmt.Errorf("job %d: %w", jobID, github.ErrNotFound)
+}
+
+// DispatchWorkflow records a workflow_dispatch event for a workflow
+func (c *Client) DispatchWorkflow(_ context.Context, owner, repo string, workflowID int64, ref string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("DispatchWorkflow", owner, repo)
+	if err != nil {
+		return err
+	}
+	for _, w := range r.Workflows {
+		if w.GetID() == workflowID {
+			r.Dispatches = append(r.Dispatches, w.GetPath()+"@"+ref)
+			return nil
+		}
+	}
+	return fmt.Errorf("workflow %d: %w", workflowID, github.ErrNotFound)
+}
+
+// ListPullRequests returns the pull requests of a repository sorted by
+// number. Only the state in the options is honored. As the real API,
+// the mergeable flag is not set in the listed pull requests.
+func (c *Client) ListPullRequests(
+	_ context.Context, owner, repo string, opts *gogithub.PullRequestListOptions,
+) ([]*gogithub.PullRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListPullRequests", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	state := "open"
+	if opts != nil && opts.State != "" {
+		state = opts.State
+	}
+	numbers := []int{}
+	for number, pr := range r.Pulls {
+		if state == "all" || pr.GetState() == state {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	prs := []*gogithub.PullRequest{}
+	for _, number := range numbers {
+		prCopy := *r.Pulls[number]
+		prCopy.Mergeable = nil
+		prCopy.Labels = labelObjects(r.IssueLabel[number])
+		prs = append(prs, &prCopy)
+	}
+	return prs, nil
+}
+
+// ListPullRequestFiles returns the files modified in a pull request
+func (c *Client) ListPullRequestFiles(
+	_ context.Context, owner, repo string, number int, _ *gogithub.ListOptions,
+) ([]*gogithub.CommitFile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListPullRequestFiles", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.Pulls[number]; !ok {
+		return nil, fmt.Errorf("pull request #%d: %w", number, github.ErrNotFound)
+	}
+	return append([]*gogithub.CommitFile{}, r.Files[number]...), nil
+}
+
+// ListCheckRunsForRef returns all the check runs recorded for a ref
+func (c *Client) ListCheckRunsForRef(
+	_ context.Context, owner, repo, ref string, _ *gogithub.ListCheckRunsOptions,
+) (*gogithub.ListCheckRunsResults, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("ListCheckRunsForRef", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	runs := append([]*gogithub.CheckRun{}, r.CheckRuns[ref]...)
+	return &gogithub.ListCheckRunsResults{
+		Total:     gogithub.Int(len(runs)),
+		CheckRuns: runs,
+	}, nil
+}
+
+// GetCombinedStatus returns the statuses recorded for a ref
+func (c *Client) GetCombinedStatus(
+	_ context.Context, owner, repo, ref string, _ *gogithub.ListOptions,
+) (*gogithub.CombinedStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetCombinedStatus", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	statuses := append([]*gogithub.RepoStatus{}, r.Statuses[ref]...)
+	state := "success"
+	for _, s := range statuses {
+		if s.GetState() != "success" {
+			state = s.GetState()
+		}
+	}
+	return &gogithub.CombinedStatus{
+		State:      gogithub.String(state),
+		SHA:        gogithub.String(ref),
+		TotalCount: gogithub.Int(len(statuses)),
+		Statuses:   statuses,
+	}, nil
+}
+
+// GetIssueComments returns the comments of an issue or pull request
+func (c *Client) GetIssueComments(
+	_ context.Context, owner, repo string, number int, _ *gogithub.IssueListCommentsOptions,
+) ([]*gogithub.IssueComment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetIssueComments", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	comments := []*gogithub.IssueComment{}
+	for _, comment := range r.Comments[number] {
+		comments = append(comments, copyComment(comment))
+	}
+	return comments, nil
+}
+
+// GetAPIUser returns the authenticated user
+func (c *Client) GetAPIUser(context.Context) (*gogithub.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errors["GetAPIUser"]; err != nil {
+		return nil, err
+	}
+	user := *c.user
+	return &user, nil
+}
+
+// IsCollaborator returns true if user was added as collaborator of the repo
+func (c *Client) IsCollaborator(_ context.Context, owner, repo, user string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("IsCollaborator", owner, repo)
+	if err != nil {
+		return false, err
+	}
+	for _, u := range r.Collaborators {
+		if strings.EqualFold(u, user) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetFileContents returns a file added with AddFile
+func (c *Client) GetFileContents(_ context.Context, owner, repo, path, ref string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetFileContents", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := r.Contents[ref][path]
+	if !ok {
+		return nil, fmt.Errorf("file %s at %s: %w", path, ref, github.ErrNotFound)
+	}
+	return append([]byte{}, data...), nil
+}
+
+// CreateCheckRun records a check run for the head SHA in the options,
+// keeping its output
+func (c *Client) CreateCheckRun(
+	_ context.Context, owner, repo string, opts gogithub.CreateCheckRunOptions,
+) (*gogithub.CheckRun, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("CreateCheckRun", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	status := opts.GetStatus()
+	if status == "" {
+		status = "queued"
+	}
+	run := &gogithub.CheckRun{
+		ID:         gogithub.Int64(c.newID()),
+		Name:       gogithub.String(opts.Name),
+		HeadSHA:    gogithub.String(opts.HeadSHA),
+		Status:     gogithub.String(status),
+		Conclusion: opts.Conclusion,
+		StartedAt:  &gogithub.Timestamp{Time: time.Now()},
+		Output:     opts.Output,
+	}
+	r.CheckRuns[opts.HeadSHA] = append(r.CheckRuns[opts.HeadSHA], run)
+	return run, nil
+}
+
+// GetPullRequestState returns a pull request with its labels, files,
+// comments and the check runs and statuses of its head, as one call
+func (c *Client) GetPullRequestState(
+	_ context.Context, owner, repo string, number int,
+) (*github.PullRequestState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("GetPullRequestState", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	pr, ok := r.Pulls[number]
+	if !ok {
+		return nil, fmt.Errorf("pull request #%d: %w", number, github.ErrNotFound)
+	}
+	prCopy := *pr
+	prCopy.Labels = labelObjects(r.IssueLabel[number])
+	state := &github.PullRequestState{
+		PullRequest: &prCopy,
+		Files:       append([]*gogithub.CommitFile{}, r.Files[number]...),
+		Comments:    []*gogithub.IssueComment{},
+		Reviews:     []*gogithub.PullRequestReview{},
+		CheckRuns:   append([]*gogithub.CheckRun{}, r.CheckRuns[pr.GetHead().GetSHA()]...),
+		Statuses:    append([]*gogithub.RepoStatus{}, r.Statuses[pr.GetHead().GetSHA()]...),
+	}
+	for _, comment := range r.Comments[number] {
+		state.Comments = append(state.Comments, copyComment(comment))
+	}
+	return state, nil
+}
+
+// CreateComment posts a comment as the authenticated user
+func (c *Client) CreateComment(
+	_ context.Context, owner, repo string, number int, body string,
+) (*gogithub.IssueComment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("CreateComment", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return copyComment(c.addComment(r, number, c.user.GetLogin(), body)), nil
+}
+
+// DeleteComment removes a comment
+func (c *Client) DeleteComment(_ context.Context, owner, repo string, id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.call("DeleteComment", owner, repo)
+	if err != nil {
+		return err
+	}
+	for number, comments := range r.Comments {
+		for i, comment := range comments {
+			if comment.GetID() == id {
+				r.Comments[number] = append(comments[:i:i], comments[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("comment %d: %w", id, github.ErrNotFound)
+}
+
+// call checks for injected errors and returns the repository
+func (c *Client) call(method, owner, repo string) (*Repo, error) {
+	if err := c.errors[method]; err != nil {
+		return nil, err
+	}
+	r, ok := c.repos[owner+"/"+repo]
+	if !ok {
+		return nil, fmt.Errorf("repository %s/%s: %w", owner, repo, github.ErrNotFound)
+	}
+	return r, nil
+}
+
+// openPullRequest checks for injected errors and returns an open pull
+// request. Changing closed pull requests is a validation error.
+func (c *Client) openPullRequest(method, owner, repo string, number int) (*gogithub.PullRequest, error) {
+	r, err := c.call(method, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	pr, ok := r.Pulls[number]
+	if !ok {
+		return nil, fmt.Errorf("pull request #%d: %w", number, github.ErrNotFound)
+	}
+	if pr.GetState() != "open" {
+		return nil, fmt.Errorf("pull request #%d is closed: %w", number, github.ErrValidation)
+	}
+	return pr, nil
+}
+
+// rerun checks for injected errors and queues a workflow run again,
+// starting a new attempt. As the real API, runs still in progress
+// cannot be re-run.
+func (c *Client) rerun(method, owner, repo string, runID int64) (*Repo, error) {
+	r, err := c.call(method, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, runs := range r.WorkflowRuns {
+		for _, run := range runs {
+			if run.GetID() != runID {
+				continue
+			}
+			if run.GetStatus() != "completed" {
+				return nil, fmt.Errorf("workflow run %d is already running: %w", runID, github.ErrForbidden)
+			}
+			run.Status = gogithub.String("queued")
+			run.Conclusion = nil
+			run.RunAttempt = gogithub.Int(run.GetRunAttempt() + 1)
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("workflow run %d: %w", runID, github.ErrNotFound)
+}
+
+func (c *Client) mustRepo(slug string) *Repo {
+	r, ok := c.repos[slug]
+	if !ok {
+		panic("fake repository " + slug + " does not exist")
+	}
+	return r
+}
+
+func (c *Client) newID() int64 {
+	c.nextID++
+	return c.nextID
+}
+
+func (c *Client) addComment(r *Repo, number int, user, body string) *gogithub.IssueComment {
+	now := time.Now()
+	comment := &gogithub.IssueComment{
+		ID:        gogithub.Int64(c.newID()),
+		Body:      gogithub.String(body),
+		User:      &gogithub.User{Login: gogithub.String(user)},
+		CreatedAt: &now,
+	}
+	r.Comments[number] = append(r.Comments[number], comment)
+	return comment
+}
+
+func copyComment(comment *gogithub.IssueComment) *gogithub.IssueComment {
+	c := *comment
+	return &c
+}
+
+func labelObjects(names []string) []*gogithub.Label {
+	labels := []*gogithub.Label{}
+	for _, name := range names {
+		labels = append(labels, &gogithub.Label{Name: gogithub.String(name)})
+	}
+	return labels
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package githubtest
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uservers/miniprow/pkg/github/githubfake"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures describes the initial state of the fake GitHub
+type Fixtures struct {
+	// User is the login of the authenticated user
+	User  string        `yaml:"user"`
+	Repos []RepoFixture `yaml:"repos"`
+}
+
+// RepoFixture is a repository in the fixtures file
+type RepoFixture struct {
+	Name          string               `yaml:"name"` // org/repo slug
+	Labels        []string             `yaml:"labels"`
+	Collaborators []string             `yaml:"collaborators"`
+	Contents      map[string]string    `yaml:"contents"` // Files in the base branch
+	Workflows     []WorkflowFixture    `yaml:"workflows"`
+	Issues        []IssueFixture       `yaml:"issues"`
+	Pulls         []PullRequestFixture `yaml:"pulls"`
+}
+
+// WorkflowFixture is a GitHub Actions workflow of the repository
+type WorkflowFixture struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"` // eg .github/workflows/ci.yml
+}
+
+// IssueFixture is an issue in the fixtures file
+type IssueFixture struct {
+	Number   int              `yaml:"number"`
+	Author   string           `yaml:"author"`
+	Labels   []string         `yaml:"labels"`
+	Comments []CommentFixture `yaml:"comments"`
+}
+
+// PullRequestFixture is a pull request in the fixtures file
+type PullRequestFixture struct {
+	IssueFixture `yaml:",inline"`
+	Files        []string          `yaml:"files"`
+	Mergeable    *bool             `yaml:"mergeable"`
+	CheckRuns    []CheckRunFixture `yaml:"checkRuns"`
+	Statuses     []StatusFixture   `yaml:"statuses"`
+	Contents     map[string]string `yaml:"contents"` // Files at the head of the PR
+
+	WorkflowRuns []WorkflowRunFixture `yaml:"workflowRuns"`
+}
+
+// CommentFixture is a comment in an issue or pull request
+type CommentFixture struct {
+	User string `yaml:"user"`
+	Body string `yaml:"body"`
+}
+
+// CheckRunFixture is a check run reported on the head of a pull request
+type CheckRunFixture struct {
+	Name       string `yaml:"name"`
+	Status     string `yaml:"status"`
+	Conclusion string `yaml:"conclusion"`
+}
+
+// WorkflowRunFixture is a run of a repository workflow on the head of a
+// pull request
+type WorkflowRunFixture struct {
+	Workflow   string `yaml:"workflow"` // Name of the workflow
+	Status     string `yaml:"status"`
+	Conclusion string `yaml:"conclusion"`
+}
+
+// StatusFixture is a commit status reported on the head of a pull request
+type StatusFixture struct {
+	Context string `yaml:"context"`
+	State   string `yaml:"state"`
+}
+
+// LoadFixtures reads a fixtures file
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixtures file: %w", err)
+	}
+	fixtures := &Fixtures{}
+	if err := yaml.Unmarshal(data, fixtures); err != nil {
+		return nil, fmt.Errorf("parsing fixtures file: %w", err)
+	}
+	return fixtures, nil
+}
+
+// Fake returns a new fake client with the fixtures applied
+func (f *Fixtures) Fake() *githubfake.Client {
+	fake := githubfake.New()
+	if f.User != "" {
+		fake.SetUser(f.User)
+	}
+	for _, r := range f.Repos {
+		fake.AddRepo(r.Name, r.Labels...)
+		fake.AddCollaborators(r.Name, r.Collaborators...)
+		for path, content := range r.Contents {
+			fake.AddFile(r.Name, githubfake.BaseSHA, path, content)
+		}
+		for _, w := range r.Workflows {
+			fake.AddWorkflow(r.Name, w.Name, w.Path)
+		}
+		for _, i := range r.Issues {
+			fake.AddIssue(r.Name, i.Number, i.Author, i.Labels...)
+			for _, c := range i.Comments {
+				fake.AddComment(r.Name, i.Number, c.User, c.Body)
+			}
+		}
+		for _, p := range r.Pulls {
+			pr := fake.AddPullRequest(r.Name, p.Number, p.Author, p.Files, p.Labels...)
+			if p.Mergeable != nil {
+				fake.SetMergeable(r.Name, p.Number, *p.Mergeable)
+			}
+			for _, c := range p.Comments {
+				fake.AddComment(r.Name, p.Number, c.User, c.Body)
+			}
+			for _, run := range p.CheckRuns {
+				fake.AddCheckRun(r.Name, pr.GetHead().GetSHA(), run.Name, run.Status, run.Conclusion)
+			}
+			for _, run := range p.WorkflowRuns {
+				fake.AddWorkflowRun(r.Name, pr.GetHead().GetSHA(), run.Workflow, run.Status, run.Conclusion)
+			}
+			for _, s := range p.Statuses {
+				fake.AddStatus(r.Name, pr.GetHead().GetSHA(), s.Context, s.State)
+			}
+			for path, content := range p.Contents {
+				fake.AddFile(r.Name, pr.GetHead().GetSHA(), path, content)
+			}
+		}
+	}
+	return fake
+}
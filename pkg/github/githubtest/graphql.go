@@ -0,0 +1,209 @@
+package githubtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/uservers/miniprow/pkg/github"
+)
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphQL answers the pull request query from the fake. All connections
+// are returned in one page. Other queries and the mutations are not
+// implemented, clients get a 404 as with unknown REST endpoints.
+func (s *Server) graphQL(w http.ResponseWriter, r *http.Request) {
+	req := graphQLRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !strings.HasPrefix(strings.TrimSpace(req.Query), "query") ||
+		!strings.Contains(req.Query, "pullRequest(number: $number)") {
+		writeError(w, http.StatusNotFound, errors.New("GraphQL query not implemented in fake server"))
+		return
+	}
+
+	owner, _ := req.Variables["owner"].(string)
+	repo, _ := req.Variables["repo"].(string)
+	number, _ := req.Variables["number"].(float64)
+	state, err := s.Fake.GetPullRequestState(r.Context(), owner, repo, int(number))
+	if err != nil {
+		reply(w, http.StatusOK, map[string]interface{}{
+			"data":   map[string]interface{}{"repository": nil},
+			"errors": []map[string]string{{"type": graphQLErrorType(err), "message": err.Error()}},
+		}, nil)
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"repository": map[string]interface{}{"pullRequest": graphQLPullRequest(state, req.Variables)},
+		},
+	}, nil)
+}
+
+// graphQLPullRequest renders the state of a pull request as the GraphQL
+// API returns it, including the connections requested in vars
+func graphQLPullRequest(state *github.PullRequestState, vars map[string]interface{}) map[string]interface{} {
+	pr := state.PullRequest
+	prState := "OPEN"
+	if pr.GetMerged() {
+		prState = "MERGED"
+	} else if pr.GetState() == "closed" {
+		prState = "CLOSED"
+	}
+	mergeable := "UNKNOWN"
+	if pr.Mergeable != nil {
+		mergeable = "CONFLICTING"
+		if pr.GetMergeable() {
+			mergeable = "MERGEABLE"
+		}
+	}
+	res := map[string]interface{}{
+		"number": pr.GetNumber(), "title": pr.GetTitle(), "body": pr.GetBody(),
+		"state": prState, "merged": pr.GetMerged(), "mergeable": mergeable, "isDraft": pr.GetDraft(),
+		"author":      graphQLActor(pr.GetUser()),
+		"headRefName": pr.GetHead().GetRef(), "headRefOid": pr.GetHead().GetSHA(),
+		"baseRefName": pr.GetBase().GetRef(), "baseRefOid": pr.GetBase().GetSHA(),
+		"headRepository": graphQLRepository(pr.GetHead().GetRepo()),
+		"baseRepository": graphQLRepository(pr.GetBase().GetRepo()),
+	}
+
+	if vars["withLabels"] == true {
+		nodes := []interface{}{}
+		for _, l := range pr.Labels {
+			nodes = append(nodes, map[string]interface{}{"name": l.GetName(), "color": l.GetColor()})
+		}
+		res["labels"] = graphQLPage(nodes)
+	}
+	if vars["withFiles"] == true {
+		nodes := []interface{}{}
+		for _, f := range state.Files {
+			nodes = append(nodes, map[string]interface{}{
+				"path": f.GetFilename(), "additions": f.GetAdditions(), "deletions": f.GetDeletions(),
+				"changeType": graphQLChangeType(f.GetStatus()),
+			})
+		}
+		res["files"] = graphQLPage(nodes)
+	}
+	if vars["withComments"] == true {
+		nodes := []interface{}{}
+		for _, c := range state.Comments {
+			nodes = append(nodes, map[string]interface{}{
+				"fullDatabaseId": strconv.FormatInt(c.GetID(), 10), "body": c.GetBody(),
+				"createdAt": c.GetCreatedAt(), "author": graphQLActor(c.GetUser()),
+			})
+		}
+		res["comments"] = graphQLPage(nodes)
+	}
+	if vars["withReviews"] == true {
+		nodes := []interface{}{}
+		for _, r := range state.Reviews {
+			nodes = append(nodes, map[string]interface{}{
+				"fullDatabaseId": strconv.FormatInt(r.GetID(), 10), "state": r.GetState(), "body": r.GetBody(),
+				"submittedAt": r.SubmittedAt, "commit": map[string]string{"oid": r.GetCommitID()},
+				"author": graphQLActor(r.GetUser()),
+			})
+		}
+		res["reviews"] = graphQLPage(nodes)
+	}
+	if vars["withContexts"] == true {
+		res["commits"] = map[string]interface{}{"nodes": []interface{}{map[string]interface{}{
+			"commit": map[string]interface{}{
+				"oid":               pr.GetHead().GetSHA(),
+				"statusCheckRollup": graphQLRollup(state.CheckRuns, state.Statuses),
+			},
+		}}}
+	}
+	return res
+}
+
+// graphQLRollup returns the status check rollup of a commit, which is
+// null when nothing has reported on it
+func graphQLRollup(runs []*gogithub.CheckRun, statuses []*gogithub.RepoStatus) interface{} {
+	if len(runs) == 0 && len(statuses) == 0 {
+		return nil
+	}
+	nodes := []interface{}{}
+	for _, run := range runs {
+		node := map[string]interface{}{
+			"__typename": "CheckRun", "databaseId": run.GetID(), "name": run.GetName(),
+			"status": strings.ToUpper(run.GetStatus()), "detailsUrl": run.GetDetailsURL(),
+			"startedAt": run.StartedAt, "completedAt": run.CompletedAt,
+		}
+		if run.Conclusion != nil {
+			node["conclusion"] = strings.ToUpper(run.GetConclusion())
+		}
+		if run.App != nil {
+			node["checkSuite"] = map[string]interface{}{"app": map[string]string{"slug": run.GetApp().GetSlug()}}
+		}
+		nodes = append(nodes, node)
+	}
+	for _, status := range statuses {
+		nodes = append(nodes, map[string]interface{}{
+			"__typename": "StatusContext", "context": status.GetContext(),
+			"state": strings.ToUpper(status.GetState()), "description": status.GetDescription(),
+			"targetUrl": status.GetTargetURL(),
+		})
+	}
+	return map[string]interface{}{"contexts": graphQLPage(nodes)}
+}
+
+// graphQLPage returns a connection with all its nodes in one page
+func graphQLPage(nodes []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"pageInfo": map[string]interface{}{"hasNextPage": false, "endCursor": ""},
+		"nodes":    nodes,
+	}
+}
+
+// graphQLActor returns a user as a GraphQL actor. Apps have the [bot]
+// suffix only in REST.
+func graphQLActor(user *gogithub.User) interface{} {
+	if user == nil {
+		return nil
+	}
+	if login := user.GetLogin(); strings.HasSuffix(login, "[bot]") {
+		return map[string]string{"__typename": "Bot", "login": strings.TrimSuffix(login, "[bot]")}
+	}
+	return map[string]string{"__typename": "User", "login": user.GetLogin()}
+}
+
+// graphQLRepository returns the name of a repository, or null
+func graphQLRepository(repo *gogithub.Repository) interface{} {
+	if repo == nil {
+		return nil
+	}
+	return map[string]string{"nameWithOwner": repo.GetFullName()}
+}
+
+// graphQLChangeType converts a REST file status to a GraphQL change type
+func graphQLChangeType(status string) string {
+	if status == "removed" {
+		return "DELETED"
+	}
+	return strings.ToUpper(status)
+}
+
+// graphQLErrorType returns the GraphQL error type matching an error
+// from the fake
+func graphQLErrorType(err error) string {
+	switch {
+	case errors.Is(err, github.ErrNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, github.ErrForbidden):
+		return "FORBIDDEN"
+	case errors.Is(err, github.ErrValidation):
+		return "UNPROCESSABLE"
+	default:
+		return "INTERNAL"
+	}
+}
@@ -0,0 +1,423 @@
+// Package githubtest provides an httptest server that implements the
+// subset of the GitHub REST API used by miniprow, and the GraphQL query
+// that loads pull requests. The server keeps its state in a
+// githubfake.Client so tests can seed it from YAML fixtures and assert
+// on the resulting repository state.
+package githubtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/github/githubfake"
+)
+
+// Server is a fake GitHub REST API server
+type Server struct {
+	*httptest.Server
+
+	// Fake holds the state of the server
+	Fake *githubfake.Client
+}
+
+// NewServer starts a new server backed by the passed fake. The caller
+// has to call Close when done.
+func NewServer(fake *githubfake.Client) *Server {
+	s := &Server{Fake: fake}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewServerFromFixtures starts a new server with its state loaded from
+// a YAML fixtures file
+func NewServerFromFixtures(path string) (*Server, error) {
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(fixtures.Fake()), nil
+}
+
+// handler builds the mux with all the supported endpoints
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	repo := "/repos/{owner}/{repo}"
+	mux.HandleFunc("GET /user", s.getUser)
+	mux.HandleFunc("POST /graphql", s.graphQL)
+	mux.HandleFunc("GET "+repo+"/labels", s.listLabels)
+	mux.HandleFunc("GET "+repo+"/collaborators/{user}", s.isCollaborator)
+	mux.HandleFunc("GET "+repo+"/issues/{number}", s.getIssue)
+	mux.HandleFunc("GET "+repo+"/issues/{number}/{sub}", s.getIssueSub)
+	mux.HandleFunc("POST "+repo+"/issues/{number}/{sub}", s.postIssueSub)
+	mux.HandleFunc("DELETE "+repo+"/issues/{number}/{sub}", s.deleteComment)
+	mux.HandleFunc("DELETE "+repo+"/issues/{number}/labels/{label}", s.removeLabel)
+	mux.HandleFunc("GET "+repo+"/pulls", s.listPullRequests)
+	mux.HandleFunc("GET "+repo+"/pulls/{number}", s.getPullRequest)
+	mux.HandleFunc("GET "+repo+"/pulls/{number}/files", s.listFiles)
+	mux.HandleFunc("PUT "+repo+"/pulls/{number}/merge", s.merge)
+	mux.HandleFunc("GET "+repo+"/commits/{ref}/check-runs", s.listCheckRuns)
+	mux.HandleFunc("GET "+repo+"/commits/{ref}/status", s.getCombinedStatus)
+	mux.HandleFunc("GET "+repo+"/contents/{path...}", s.getContents)
+	mux.HandleFunc("POST "+repo+"/check-runs", s.createCheckRun)
+	mux.HandleFunc("GET "+repo+"/actions/workflows", s.listWorkflows)
+	mux.HandleFunc("POST "+repo+"/actions/workflows/{id}/dispatches", s.dispatchWorkflow)
+	mux.HandleFunc("GET "+repo+"/actions/runs", s.listWorkflowRuns)
+	mux.HandleFunc("POST "+repo+"/actions/runs/{id}/{rerun}", s.rerunWorkflow)
+	mux.HandleFunc("POST "+repo+"/actions/jobs/{id}/rerun", s.rerunJob)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		logrus.Warnf("githubtest: unsupported endpoint %s %s", r.Method, r.URL.Path)
+		writeError(w, http.StatusNotFound, errors.New("endpoint not implemented in fake server"))
+	})
+	return mux
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	user, err := s.Fake.GetAPIUser(r.Context())
+	reply(w, http.StatusOK, user, err)
+}
+
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request) {
+	labels, err := s.Fake.ListLabels(r.Context(), r.PathValue("owner"), r.PathValue("repo"), nil)
+	reply(w, http.StatusOK, labels, err)
+}
+
+func (s *Server) isCollaborator(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.Fake.IsCollaborator(r.Context(), r.PathValue("owner"), r.PathValue("repo"), r.PathValue("user"))
+	if err == nil && !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	reply(w, http.StatusNoContent, nil, err)
+}
+
+func (s *Server) getIssue(w http.ResponseWriter, r *http.Request) {
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	issue, _, err := s.Fake.GetIssue(r.Context(), r.PathValue("owner"), r.PathValue("repo"), number)
+	reply(w, http.StatusOK, issue, err)
+}
+
+// getIssueSub handles both /issues/comments/{id} and /issues/{number}/comments
+// as the two patterns overlap in the mux
+func (s *Server) getIssueSub(w http.ResponseWriter, r *http.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	if r.PathValue("number") == "comments" {
+		id, ok := int64Value(w, r, "sub")
+		if !ok {
+			return
+		}
+		comment, _, err := s.Fake.GetComment(r.Context(), owner, repo, id)
+		reply(w, http.StatusOK, comment, err)
+		return
+	}
+
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	if r.PathValue("sub") != "comments" {
+		writeError(w, http.StatusNotFound, errors.New("endpoint not implemented in fake server"))
+		return
+	}
+	comments, err := s.Fake.GetIssueComments(r.Context(), owner, repo, number, nil)
+	reply(w, http.StatusOK, comments, err)
+}
+
+// postIssueSub handles creating comments and adding labels
+func (s *Server) postIssueSub(w http.ResponseWriter, r *http.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	switch r.PathValue("sub") {
+	case "comments":
+		comment := &gogithub.IssueComment{}
+		if err := json.NewDecoder(r.Body).Decode(comment); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		created, err := s.Fake.CreateComment(r.Context(), owner, repo, number, comment.GetBody())
+		reply(w, http.StatusCreated, created, err)
+	case "labels":
+		labels := []string{}
+		if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, l := range labels {
+			if err := s.Fake.AddLabel(r.Context(), owner, repo, number, l); err != nil {
+				reply(w, http.StatusOK, nil, err)
+				return
+			}
+		}
+		issue, _, err := s.Fake.GetIssue(r.Context(), owner, repo, number)
+		if err != nil {
+			reply(w, http.StatusOK, nil, err)
+			return
+		}
+		reply(w, http.StatusOK, issue.Labels, nil)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("endpoint not implemented in fake server"))
+	}
+}
+
+func (s *Server) deleteComment(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("number") != "comments" {
+		writeError(w, http.StatusNotFound, errors.New("endpoint not implemented in fake server"))
+		return
+	}
+	id, ok := int64Value(w, r, "sub")
+	if !ok {
+		return
+	}
+	err := s.Fake.DeleteComment(r.Context(), r.PathValue("owner"), r.PathValue("repo"), id)
+	reply(w, http.StatusNoContent, nil, err)
+}
+
+func (s *Server) removeLabel(w http.ResponseWriter, r *http.Request) {
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	issue, _, err := s.Fake.GetIssue(r.Context(), owner, repo, number)
+	if err != nil {
+		reply(w, http.StatusOK, nil, err)
+		return
+	}
+	found := false
+	for _, l := range issue.Labels {
+		found = found || l.GetName() == r.PathValue("label")
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errors.New("Label does not exist"))
+		return
+	}
+	err = s.Fake.RemoveLabel(r.Context(), owner, repo, number, r.PathValue("label"))
+	reply(w, http.StatusOK, []*gogithub.Label{}, err)
+}
+
+func (s *Server) listPullRequests(w http.ResponseWriter, r *http.Request) {
+	prs, err := s.Fake.ListPullRequests(
+		r.Context(), r.PathValue("owner"), r.PathValue("repo"),
+		&gogithub.PullRequestListOptions{State: r.URL.Query().Get("state")},
+	)
+	reply(w, http.StatusOK, prs, err)
+}
+
+func (s *Server) getPullRequest(w http.ResponseWriter, r *http.Request) {
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	pr, _, err := s.Fake.GetPullRequest(r.Context(), r.PathValue("owner"), r.PathValue("repo"), number)
+	reply(w, http.StatusOK, pr, err)
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	files, err := s.Fake.ListPullRequestFiles(r.Context(), r.PathValue("owner"), r.PathValue("repo"), number, nil)
+	reply(w, http.StatusOK, files, err)
+}
+
+func (s *Server) merge(w http.ResponseWriter, r *http.Request) {
+	number, ok := intValue(w, r, "number")
+	if !ok {
+		return
+	}
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	opts := struct {
+		SHA string `json:"sha"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.Fake.MergePullRequest(r.Context(), owner, repo, number, opts.SHA); err != nil {
+		reply(w, http.StatusOK, nil, err)
+		return
+	}
+	pr, _, err := s.Fake.GetPullRequest(r.Context(), owner, repo, number)
+	reply(w, http.StatusOK, &gogithub.PullRequestMergeResult{
+		SHA:     pr.MergeCommitSHA,
+		Merged:  gogithub.Bool(true),
+		Message: gogithub.String("Pull Request successfully merged"),
+	}, err)
+}
+
+func (s *Server) listCheckRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.Fake.ListCheckRunsForRef(
+		r.Context(), r.PathValue("owner"), r.PathValue("repo"), r.PathValue("ref"), nil,
+	)
+	reply(w, http.StatusOK, runs, err)
+}
+
+func (s *Server) getCombinedStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Fake.GetCombinedStatus(
+		r.Context(), r.PathValue("owner"), r.PathValue("repo"), r.PathValue("ref"), nil,
+	)
+	reply(w, http.StatusOK, status, err)
+}
+
+func (s *Server) getContents(w http.ResponseWriter, r *http.Request) {
+	data, err := s.Fake.GetFileContents(
+		r.Context(), r.PathValue("owner"), r.PathValue("repo"), r.PathValue("path"), r.URL.Query().Get("ref"),
+	)
+	if err != nil {
+		reply(w, http.StatusOK, nil, err)
+		return
+	}
+	reply(w, http.StatusOK, &gogithub.RepositoryContent{
+		Type:     gogithub.String("file"),
+		Name:     gogithub.String(path.Base(r.PathValue("path"))),
+		Path:     gogithub.String(r.PathValue("path")),
+		Encoding: gogithub.String("base64"),
+		Content:  gogithub.String(base64.StdEncoding.EncodeToString(data)),
+	}, nil)
+}
+
+func (s *Server) createCheckRun(w http.ResponseWriter, r *http.Request) {
+	opts := gogithub.CreateCheckRunOptions{}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	run, err := s.Fake.CreateCheckRun(r.Context(), r.PathValue("owner"), r.PathValue("repo"), opts)
+	reply(w, http.StatusCreated, run, err)
+}
+
+func (s *Server) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	workflows, err := s.Fake.ListWorkflows(r.Context(), r.PathValue("owner"), r.PathValue("repo"), nil)
+	reply(w, http.StatusOK, &gogithub.Workflows{
+		TotalCount: gogithub.Int(len(workflows)), Workflows: workflows,
+	}, err)
+}
+
+func (s *Server) dispatchWorkflow(w http.ResponseWriter, r *http.Request) {
+	id, ok := int64Value(w, r, "id")
+	if !ok {
+		return
+	}
+	event := gogithub.CreateWorkflowDispatchEventRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err := s.Fake.DispatchWorkflow(r.Context(), r.PathValue("owner"), r.PathValue("repo"), id, event.Ref)
+	reply(w, http.StatusNoContent, nil, err)
+}
+
+// listWorkflowRuns only supports listing the runs of a head SHA
+func (s *Server) listWorkflowRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.Fake.ListWorkflowRunsForSHA(
+		r.Context(), r.PathValue("owner"), r.PathValue("repo"), r.URL.Query().Get("head_sha"), nil,
+	)
+	reply(w, http.StatusOK, &gogithub.WorkflowRuns{
+		TotalCount: gogithub.Int(len(runs)), WorkflowRuns: runs,
+	}, err)
+}
+
+// rerunWorkflow handles re-running a whole run and its failed jobs
+func (s *Server) rerunWorkflow(w http.ResponseWriter, r *http.Request) {
+	id, ok := int64Value(w, r, "id")
+	if !ok {
+		return
+	}
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	switch r.PathValue("rerun") {
+	case "rerun":
+		reply(w, http.StatusCreated, struct{}{}, s.Fake.RerunWorkflow(r.Context(), owner, repo, id))
+	case "rerun-failed-jobs":
+		reply(w, http.StatusCreated, struct{}{}, s.Fake.RerunFailedJobs(r.Context(), owner, repo, id))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("endpoint not implemented in fake server"))
+	}
+}
+
+func (s *Server) rerunJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := int64Value(w, r, "id")
+	if !ok {
+		return
+	}
+	err := s.Fake.RerunJob(r.Context(), r.PathValue("owner"), r.PathValue("repo"), id)
+	reply(w, http.StatusCreated, struct{}{}, err)
+}
+
+// reply writes the API response. If err is not nil, its mapped to
+// the HTTP code the real API would return.
+func reply(w http.ResponseWriter, code int, data any, err error) {
+	if err != nil {
+		writeError(w, errorCode(err), err)
+		return
+	}
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logrus.Errorf("githubtest: encoding response: %v", err)
+	}
+}
+
+// errorCode returns the HTTP status matching an error from the fake
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, github.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, github.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, github.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, github.ErrNotMergeable):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, github.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, github.ErrValidation):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); err != nil {
+		logrus.Errorf("githubtest: encoding error response: %v", err)
+	}
+}
+
+func intValue(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	i, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid %s: %w", name, err))
+		return 0, false
+	}
+	return i, true
+}
+
+func int64Value(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	i, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid %s: %w", name, err))
+		return 0, false
+	}
+	return i, true
+}
@@ -0,0 +1,506 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PullRequestState is the data of a pull request needed to decide on
+// it, loaded in one GraphQL query. The objects use the same types and
+// values as the REST API.
+type PullRequestState struct {
+	PullRequest *gogithub.PullRequest
+	Comments    []*gogithub.IssueComment
+	Reviews     []*gogithub.PullRequestReview
+
+	// Files changed in the pull request. The GraphQL API does not return
+	// the previous name of renamed files, so Files is nil when the pull
+	// request renames any file and the list has to be read with REST.
+	Files []*gogithub.CommitFile
+
+	// CheckRuns and Statuses are the checks reported on the head commit,
+	// the latest run of each check
+	CheckRuns []*gogithub.CheckRun
+	Statuses  []*gogithub.RepoStatus
+}
+
+// pullRequestQuery fetches a pull request with all its connections. It
+// is run once per page, connections that have no more pages are skipped.
+const pullRequestQuery = `query(
+  $owner: String!, $repo: String!, $number: Int!,
+  $labels: String, $withLabels: Boolean!,
+  $files: String, $withFiles: Boolean!,
+  $comments: String, $withComments: Boolean!,
+  $reviews: String, $withReviews: Boolean!,
+  $contexts: String, $withContexts: Boolean!
+) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      number title body state merged mergeable isDraft
+      author { __typename login }
+      headRefName headRefOid baseRefName baseRefOid
+      headRepository { nameWithOwner } baseRepository { nameWithOwner }
+      labels(first: 100, after: $labels) @include(if: $withLabels) {
+        pageInfo { hasNextPage endCursor }
+        nodes { name color }
+      }
+      files(first: 100, after: $files) @include(if: $withFiles) {
+        pageInfo { hasNextPage endCursor }
+        nodes { path additions deletions changeType }
+      }
+      comments(first: 100, after: $comments) @include(if: $withComments) {
+        pageInfo { hasNextPage endCursor }
+        nodes { fullDatabaseId body createdAt author { __typename login } }
+      }
+      reviews(first: 100, after: $reviews) @include(if: $withReviews) {
+        pageInfo { hasNextPage endCursor }
+        nodes { fullDatabaseId state body submittedAt commit { oid } author { __typename login } }
+      }
+      commits(last: 1) @include(if: $withContexts) {
+        nodes { commit { oid statusCheckRollup {
+          contexts(first: 100, after: $contexts) {
+            pageInfo { hasNextPage endCursor }
+            nodes {
+              __typename
+              ... on CheckRun {
+                databaseId name status conclusion startedAt completedAt detailsUrl checkSuite { app { slug } }
+              }
+              ... on StatusContext { context state description targetUrl }
+            }
+          }
+        } } }
+      }
+    }
+  }
+}`
+
+type graphQLPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphQLActor struct {
+	Typename string `json:"__typename"`
+	Login    string `json:"login"`
+}
+
+// user returns the actor as a REST user. Apps are reported without the
+// [bot] suffix the REST API uses in their logins.
+func (a *graphQLActor) user() *gogithub.User {
+	if a == nil {
+		return nil
+	}
+	login := a.Login
+	if a.Typename == "Bot" && !strings.HasSuffix(login, "[bot]") {
+		login += "[bot]"
+	}
+	return &gogithub.User{Login: gogithub.String(login)}
+}
+
+type graphQLRepository struct {
+	NameWithOwner string `json:"nameWithOwner"`
+}
+
+// repository returns the repository as a REST object
+func (r *graphQLRepository) repository() *gogithub.Repository {
+	if r == nil {
+		return nil
+	}
+	return &gogithub.Repository{FullName: gogithub.String(r.NameWithOwner)}
+}
+
+type graphQLPullRequest struct {
+	Number      int           `json:"number"`
+	Title       string        `json:"title"`
+	Body        string        `json:"body"`
+	State       string        `json:"state"`
+	Merged      bool          `json:"merged"`
+	Mergeable   string        `json:"mergeable"`
+	IsDraft     bool          `json:"isDraft"`
+	Author      *graphQLActor `json:"author"`
+	HeadRefName string        `json:"headRefName"`
+	HeadRefOid  string        `json:"headRefOid"`
+	BaseRefName string        `json:"baseRefName"`
+	BaseRefOid  string        `json:"baseRefOid"`
+
+	// The head repository is null when the fork was deleted
+	HeadRepository *graphQLRepository `json:"headRepository"`
+	BaseRepository *graphQLRepository `json:"baseRepository"`
+
+	Labels *struct {
+		PageInfo graphQLPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Files *struct {
+		PageInfo graphQLPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Path       string `json:"path"`
+			Additions  int    `json:"additions"`
+			Deletions  int    `json:"deletions"`
+			ChangeType string `json:"changeType"`
+		} `json:"nodes"`
+	} `json:"files"`
+	Comments *struct {
+		PageInfo graphQLPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			FullDatabaseID string        `json:"fullDatabaseId"`
+			Body           string        `json:"body"`
+			CreatedAt      time.Time     `json:"createdAt"`
+			Author         *graphQLActor `json:"author"`
+		} `json:"nodes"`
+	} `json:"comments"`
+	Reviews *struct {
+		PageInfo graphQLPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			FullDatabaseID string        `json:"fullDatabaseId"`
+			State          string        `json:"state"`
+			Body           string        `json:"body"`
+			SubmittedAt    *time.Time    `json:"submittedAt"`
+			Author         *graphQLActor `json:"author"`
+			Commit         *struct {
+				Oid string `json:"oid"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"reviews"`
+	Commits *struct {
+		Nodes []struct {
+			Commit struct {
+				Oid               string `json:"oid"`
+				StatusCheckRollup *struct {
+					Contexts struct {
+						PageInfo graphQLPageInfo `json:"pageInfo"`
+						Nodes    []struct {
+							Typename    string     `json:"__typename"`
+							DatabaseID  int64      `json:"databaseId"`
+							Name        string     `json:"name"`
+							Status      string     `json:"status"`
+							Conclusion  string     `json:"conclusion"`
+							StartedAt   *time.Time `json:"startedAt"`
+							CompletedAt *time.Time `json:"completedAt"`
+							DetailsURL  string     `json:"detailsUrl"`
+							Context     string     `json:"context"`
+							State       string     `json:"state"`
+							Description string     `json:"description"`
+							TargetURL   string     `json:"targetUrl"`
+							CheckSuite  *struct {
+								App *struct {
+									Slug string `json:"slug"`
+								} `json:"app"`
+							} `json:"checkSuite"`
+						} `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"commits"`
+}
+
+// graphQLResponse is the envelope of all GraphQL responses
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// GetPullRequestState loads a pull request with its labels, files,
+// comments, reviews and the checks of its head commit using the GraphQL
+// API, in as few requests as the pagination allows
+func (github *GitHub) GetPullRequestState(
+	ctx context.Context, slug string, number int,
+) (*PullRequestState, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	state, err := github.client.GetPullRequestState(ctx, owner, repo, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading pull request #%d", number)
+	}
+	state.CheckRuns = latestCheckRuns(state.CheckRuns)
+	return state, nil
+}
+
+// GetPullRequestState runs the pull request query until all the
+// connections have been read
+func (g *githubClient) GetPullRequestState(
+	ctx context.Context, owner, repo string, number int,
+) (*PullRequestState, error) {
+	vars := map[string]interface{}{
+		"owner": owner, "repo": repo, "number": number,
+		"withLabels": true, "withFiles": true, "withComments": true,
+		"withReviews": true, "withContexts": true,
+	}
+	state := &PullRequestState{
+		Files:     []*gogithub.CommitFile{},
+		Comments:  []*gogithub.IssueComment{},
+		Reviews:   []*gogithub.PullRequestReview{},
+		CheckRuns: []*gogithub.CheckRun{},
+		Statuses:  []*gogithub.RepoStatus{},
+	}
+	renamed := false
+	for page := 1; ; page++ {
+		pr, err := g.queryPullRequest(ctx, vars)
+		if err != nil {
+			return nil, err
+		}
+		if state.PullRequest == nil {
+			state.PullRequest = pr.pullRequest()
+		}
+		if pr.Labels != nil {
+			for _, l := range pr.Labels.Nodes {
+				state.PullRequest.Labels = append(state.PullRequest.Labels, &gogithub.Label{
+					Name: gogithub.String(l.Name), Color: gogithub.String(l.Color),
+				})
+			}
+			nextPage(vars, "labels", pr.Labels.PageInfo)
+		}
+		if pr.Files != nil {
+			for _, f := range pr.Files.Nodes {
+				renamed = renamed || f.ChangeType == "RENAMED"
+				state.Files = append(state.Files, &gogithub.CommitFile{
+					Filename:  gogithub.String(f.Path),
+					Additions: gogithub.Int(f.Additions),
+					Deletions: gogithub.Int(f.Deletions),
+					Changes:   gogithub.Int(f.Additions + f.Deletions),
+					Status:    gogithub.String(fileStatus(f.ChangeType)),
+				})
+			}
+			nextPage(vars, "files", pr.Files.PageInfo)
+		}
+		if pr.Comments != nil {
+			for _, c := range pr.Comments.Nodes {
+				id, err := strconv.ParseInt(c.FullDatabaseID, 10, 64)
+				if err != nil {
+					return nil, errors.Wrapf(err, "parsing comment ID %q", c.FullDatabaseID)
+				}
+				state.Comments = append(state.Comments, &gogithub.IssueComment{
+					ID:        gogithub.Int64(id),
+					Body:      gogithub.String(c.Body),
+					User:      c.Author.user(),
+					CreatedAt: &c.CreatedAt,
+				})
+			}
+			nextPage(vars, "comments", pr.Comments.PageInfo)
+		}
+		if pr.Reviews != nil {
+			for _, r := range pr.Reviews.Nodes {
+				id, err := strconv.ParseInt(r.FullDatabaseID, 10, 64)
+				if err != nil {
+					return nil, errors.Wrapf(err, "parsing review ID %q", r.FullDatabaseID)
+				}
+				review := &gogithub.PullRequestReview{
+					ID:          gogithub.Int64(id),
+					State:       gogithub.String(r.State),
+					Body:        gogithub.String(r.Body),
+					User:        r.Author.user(),
+					SubmittedAt: r.SubmittedAt,
+				}
+				if r.Commit != nil {
+					review.CommitID = gogithub.String(r.Commit.Oid)
+				}
+				state.Reviews = append(state.Reviews, review)
+			}
+			nextPage(vars, "reviews", pr.Reviews.PageInfo)
+		}
+		if pr.Commits != nil {
+			vars["withContexts"] = false
+			for _, node := range pr.Commits.Nodes {
+				rollup := node.Commit.StatusCheckRollup
+				if node.Commit.Oid != pr.HeadRefOid || rollup == nil {
+					continue
+				}
+				for _, c := range rollup.Contexts.Nodes {
+					switch c.Typename {
+					case "CheckRun":
+						run := &gogithub.CheckRun{
+							ID:         gogithub.Int64(c.DatabaseID),
+							Name:       gogithub.String(c.Name),
+							HeadSHA:    gogithub.String(pr.HeadRefOid),
+							Status:     gogithub.String(strings.ToLower(c.Status)),
+							DetailsURL: gogithub.String(c.DetailsURL),
+						}
+						if c.Conclusion != "" {
+							run.Conclusion = gogithub.String(strings.ToLower(c.Conclusion))
+						}
+						if c.CheckSuite != nil && c.CheckSuite.App != nil {
+							run.App = &gogithub.App{Slug: gogithub.String(c.CheckSuite.App.Slug)}
+						}
+						if c.StartedAt != nil {
+							run.StartedAt = &gogithub.Timestamp{Time: *c.StartedAt}
+						}
+						if c.CompletedAt != nil {
+							run.CompletedAt = &gogithub.Timestamp{Time: *c.CompletedAt}
+						}
+						state.CheckRuns = append(state.CheckRuns, run)
+					case "StatusContext":
+						state.Statuses = append(state.Statuses, &gogithub.RepoStatus{
+							Context:     gogithub.String(c.Context),
+							State:       gogithub.String(statusState(c.State)),
+							Description: gogithub.String(c.Description),
+							TargetURL:   gogithub.String(c.TargetURL),
+						})
+					}
+				}
+				nextPage(vars, "contexts", rollup.Contexts.PageInfo)
+			}
+		}
+
+		if !morePages(vars) {
+			logrus.Debugf("GitHub: loaded pull request #%d in %d GraphQL queries", number, page)
+			break
+		}
+	}
+	if renamed {
+		state.Files = nil
+	}
+	return state, nil
+}
+
+// queryPullRequest sends one page of the pull request query
+func (g *githubClient) queryPullRequest(
+	ctx context.Context, vars map[string]interface{},
+) (*graphQLPullRequest, error) {
+	data := struct {
+		Repository *struct {
+			PullRequest *graphQLPullRequest `json:"pullRequest"`
+		} `json:"repository"`
+	}{}
+	if err := g.graphQL(ctx, pullRequestQuery, vars, &data); err != nil {
+		return nil, errors.Wrap(err, "querying pull request")
+	}
+	if data.Repository == nil || data.Repository.PullRequest == nil {
+		return nil, errors.Wrap(ErrNotFound, "pull request not found")
+	}
+	return data.Repository.PullRequest, nil
+}
+
+// graphQL sends a query or mutation and decodes the data of the
+// response into out. The errors reported by GitHub are matched to the
+// sentinel errors of the package when possible. Mutations are only
+// retried when rate limited.
+func (g *githubClient) graphQL(
+	ctx context.Context, query string, vars map[string]interface{}, out interface{},
+) error {
+	// GitHub Enterprise serves GraphQL at /api/graphql, next to /api/v3
+	endpoint := "graphql"
+	if strings.HasSuffix(g.Client.BaseURL.Path, "/api/v3/") {
+		endpoint = "../graphql"
+	}
+	shouldRetry := g.errChecker(ctx)
+	if strings.HasPrefix(strings.TrimSpace(query), "mutation") {
+		shouldRetry = g.unsafeErrChecker(ctx)
+	}
+	for {
+		req, err := g.Client.NewRequest("POST", endpoint, map[string]interface{}{
+			"query": query, "variables": vars,
+		})
+		if err != nil {
+			return errors.Wrap(err, "building GraphQL request")
+		}
+		res := &graphQLResponse{}
+		resp, err := g.Client.Do(ctx, req, res)
+		if shouldRetry(err) {
+			continue
+		}
+		if err != nil {
+			return apiError(resp, err)
+		}
+		if len(res.Errors) > 0 {
+			switch res.Errors[0].Type {
+			case "NOT_FOUND":
+				return errors.Wrap(ErrNotFound, res.Errors[0].Message)
+			case "FORBIDDEN":
+				return errors.Wrap(ErrForbidden, res.Errors[0].Message)
+			case "UNPROCESSABLE":
+				return errors.Wrap(ErrValidation, res.Errors[0].Message)
+			}
+			return errors.Errorf("GraphQL request failed: %s", res.Errors[0].Message)
+		}
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			return errors.Wrap(err, "decoding GraphQL response")
+		}
+		return nil
+	}
+}
+
+// nextPage sets the variables to read the next page of a connection or
+// to skip it if it has been read completely
+func nextPage(vars map[string]interface{}, name string, info graphQLPageInfo) {
+	key := "with" + strings.ToUpper(name[:1]) + name[1:]
+	vars[key] = info.HasNextPage
+	if info.HasNextPage {
+		vars[name] = info.EndCursor
+	}
+}
+
+// morePages returns true if any connection has pages left to read
+func morePages(vars map[string]interface{}) bool {
+	for _, key := range []string{"withLabels", "withFiles", "withComments", "withReviews", "withContexts"} {
+		if more, _ := vars[key].(bool); more {
+			return true
+		}
+	}
+	return false
+}
+
+// pullRequest returns the pull request fields as a REST object
+func (pr *graphQLPullRequest) pullRequest() *gogithub.PullRequest {
+	res := &gogithub.PullRequest{
+		Number: gogithub.Int(pr.Number),
+		Title:  gogithub.String(pr.Title),
+		Body:   gogithub.String(pr.Body),
+		State:  gogithub.String("open"),
+		Merged: gogithub.Bool(pr.Merged),
+		Draft:  gogithub.Bool(pr.IsDraft),
+		User:   pr.Author.user(),
+		Head: &gogithub.PullRequestBranch{
+			Ref: gogithub.String(pr.HeadRefName), SHA: gogithub.String(pr.HeadRefOid),
+			Repo: pr.HeadRepository.repository(),
+		},
+		Base: &gogithub.PullRequestBranch{
+			Ref: gogithub.String(pr.BaseRefName), SHA: gogithub.String(pr.BaseRefOid),
+			Repo: pr.BaseRepository.repository(),
+		},
+		Labels: []*gogithub.Label{},
+	}
+	if pr.State != "OPEN" {
+		res.State = gogithub.String("closed")
+	}
+	// UNKNOWN means GitHub is still computing it, as a null in REST
+	switch pr.Mergeable {
+	case "MERGEABLE":
+		res.Mergeable = gogithub.Bool(true)
+	case "CONFLICTING":
+		res.Mergeable = gogithub.Bool(false)
+	}
+	return res
+}
+
+// fileStatus converts a GraphQL change type to the REST file status
+func fileStatus(changeType string) string {
+	if changeType == "DELETED" {
+		return "removed"
+	}
+	return strings.ToLower(changeType)
+}
+
+// statusState converts a GraphQL status state to the REST value.
+// Expected statuses have not been reported yet, they are pending.
+func statusState(state string) string {
+	if state == "EXPECTED" {
+		return "pending"
+	}
+	return strings.ToLower(state)
+}
@@ -0,0 +1,165 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPullRequestState(t *testing.T) {
+	head := "0123456789012345678901234567890123456789"
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/graphql", r.URL.Path)
+		req := struct {
+			Variables map[string]interface{} `json:"variables"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		queries++
+
+		pr := map[string]interface{}{
+			"number": 1, "title": "Fix", "state": "OPEN", "merged": false, "mergeable": "UNKNOWN",
+			"author":     map[string]string{"__typename": "User", "login": "alice"},
+			"headRefOid": head, "baseRefName": "main", "baseRefOid": "base",
+			"headRepository": nil, "baseRepository": map[string]string{"nameWithOwner": "uservers/test"},
+		}
+		page := func(more bool, cursor string, nodes ...interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"pageInfo": map[string]interface{}{"hasNextPage": more, "endCursor": cursor},
+				"nodes":    nodes,
+			}
+		}
+		if req.Variables["withLabels"] == true {
+			pr["labels"] = page(false, "l1", map[string]string{"name": "lgtm"})
+		}
+		if req.Variables["withFiles"] == true {
+			pr["files"] = page(false, "f1", map[string]interface{}{"path": "a.go", "changeType": "MODIFIED"})
+		}
+		if req.Variables["withReviews"] == true {
+			pr["reviews"] = page(false, "")
+		}
+		// Comments have two pages
+		if req.Variables["withComments"] == true {
+			if req.Variables["comments"] == nil {
+				pr["comments"] = page(true, "c1", map[string]interface{}{
+					"fullDatabaseId": "3000000000", "body": "/lgtm", "createdAt": "2022-01-01T00:00:00Z",
+					"author": map[string]string{"__typename": "User", "login": "bob"},
+				})
+			} else {
+				require.Equal(t, "c1", req.Variables["comments"])
+				pr["comments"] = page(false, "c2", map[string]interface{}{
+					"fullDatabaseId": "3000000001", "body": "[APPROVALNOTIFIER]", "createdAt": "2022-01-01T00:00:00Z",
+					"author": map[string]string{"__typename": "Bot", "login": "github-actions"},
+				})
+			}
+		}
+		if req.Variables["withContexts"] == true {
+			pr["commits"] = map[string]interface{}{"nodes": []interface{}{map[string]interface{}{
+				"commit": map[string]interface{}{"oid": head, "statusCheckRollup": map[string]interface{}{
+					"contexts": page(false, "x1",
+						map[string]interface{}{
+							"__typename": "CheckRun", "databaseId": 4000000000, "name": "test", "status": "COMPLETED", "conclusion": "SUCCESS",
+							"checkSuite": map[string]interface{}{"app": map[string]string{"slug": "github-actions"}},
+						},
+						map[string]interface{}{
+							"__typename": "CheckRun", "databaseId": 3999999999, "name": "test", "status": "COMPLETED", "conclusion": "FAILURE",
+						},
+						map[string]interface{}{"__typename": "StatusContext", "context": "ci/legacy", "state": "EXPECTED"},
+					),
+				}},
+			}}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"repository": map[string]interface{}{"pullRequest": pr}},
+		}))
+	}))
+	defer server.Close()
+
+	gh, err := NewWithToken("token", server.URL+"/api/v3")
+	require.NoError(t, err)
+	state, err := gh.GetPullRequestState(context.Background(), "uservers/test", 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, queries)
+
+	require.Equal(t, "alice", state.PullRequest.GetUser().GetLogin())
+	require.Equal(t, "open", state.PullRequest.GetState())
+	require.Nil(t, state.PullRequest.Mergeable)
+	require.Equal(t, head, state.PullRequest.GetHead().GetSHA())
+	require.Nil(t, state.PullRequest.GetHead().Repo)
+	require.Equal(t, "uservers/test", state.PullRequest.GetBase().GetRepo().GetFullName())
+	require.Len(t, state.PullRequest.Labels, 1)
+	require.Len(t, state.Files, 1)
+	require.Equal(t, "modified", state.Files[0].GetStatus())
+
+	require.Len(t, state.Comments, 2)
+	require.Equal(t, int64(3000000000), state.Comments[0].GetID())
+	require.Equal(t, "github-actions[bot]", state.Comments[1].GetUser().GetLogin())
+
+	require.Len(t, state.CheckRuns, 1)
+	require.Equal(t, int64(4000000000), state.CheckRuns[0].GetID())
+	require.Equal(t, ActionsAppSlug, state.CheckRuns[0].GetApp().GetSlug())
+	require.Equal(t, "completed", state.CheckRuns[0].GetStatus())
+	require.Equal(t, "success", state.CheckRuns[0].GetConclusion())
+	require.Len(t, state.Statuses, 1)
+	require.Equal(t, "pending", state.Statuses[0].GetState())
+}
+
+func TestAutoMergeMutations(t *testing.T) {
+	mutations := []string{}
+	clean := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/json")
+
+		// The node ID is read before each mutation
+		if strings.HasPrefix(req.Query, "query") {
+			require.EqualValues(t, 7, req.Variables["number"])
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"repository": map[string]interface{}{
+					"pullRequest": map[string]string{"id": "PR_7"},
+				}},
+			}))
+			return
+		}
+		require.Equal(t, "PR_7", req.Variables["id"])
+		name, _, _ := strings.Cut(strings.TrimSpace(strings.SplitN(req.Query, "{", 3)[1]), "(")
+		mutations = append(mutations, name)
+		if clean {
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []map[string]string{{
+					"type": "UNPROCESSABLE", "message": "Pull request Pull request is in clean status",
+				}},
+			}))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{}}))
+	}))
+	defer server.Close()
+
+	gh, err := NewWithToken("token", server.URL+"/api/v3")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, gh.EnableAutoMerge(ctx, "uservers/test", 7))
+	require.NoError(t, gh.DisableAutoMerge(ctx, "uservers/test", 7))
+	require.NoError(t, gh.EnqueuePullRequest(ctx, "uservers/test", 7))
+	require.NoError(t, gh.DequeuePullRequest(ctx, "uservers/test", 7))
+	require.Equal(t, []string{
+		"enablePullRequestAutoMerge", "disablePullRequestAutoMerge", "enqueuePullRequest", "dequeuePullRequest",
+	}, mutations)
+
+	// Pull requests that can merge now do not accept auto-merge
+	clean = true
+	err = gh.EnableAutoMerge(ctx, "uservers/test", 7)
+	require.ErrorIs(t, err, ErrCleanStatus)
+	require.ErrorIs(t, err, ErrValidation)
+}
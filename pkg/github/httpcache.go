@@ -0,0 +1,228 @@
+package github
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPCacheEnvKey is the environment variable that enables conditional
+// requests. Its value is "memory" or the directory where responses are
+// stored, which can be persisted across jobs with actions/cache. Entries
+// are not keyed by token, use a directory for each repository or bot
+// identity so that responses are never shared between identities.
+const HTTPCacheEnvKey = "MINIPROW_HTTP_CACHE"
+
+// HTTPCache stores the responses used to build conditional requests
+type HTTPCache interface {
+	// Get returns the data stored under key
+	Get(key string) ([]byte, bool)
+
+	// Set stores data under key
+	Set(key string, data []byte) error
+}
+
+// NewHTTPCache returns the cache described by spec: "memory" for an
+// in-memory cache or a directory path for a disk cache
+func NewHTTPCache(spec string) (HTTPCache, error) {
+	if spec == "memory" {
+		return NewMemoryCache(), nil
+	}
+	return NewDiskCache(spec)
+}
+
+// MemoryCache is an HTTPCache that keeps the responses in memory
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+// NewMemoryCache returns an empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string][]byte{}}
+}
+
+// Get returns the data stored under key
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+// Set stores data under key
+func (c *MemoryCache) Set(key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = data
+	return nil
+}
+
+// DiskCache is an HTTPCache that writes each response to a file in a
+// directory. Note that the files contain the API responses in clear.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a cache that stores the responses in dir,
+// creating it if needed
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0o700)); err != nil {
+		return nil, errors.Wrap(err, "creating cache directory")
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// Get returns the data stored under key
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set stores data under key. The file is replaced atomically so that
+// concurrent readers never see a partial response.
+func (c *DiskCache) Set(key string, data []byte) error {
+	f, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating cache file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing cache file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing cache file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), filepath.Join(c.dir, key)), "storing cache file")
+}
+
+// cachedHTTPResponse is a response stored in the cache
+type cachedHTTPResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// HTTPCacheTransport is an http.RoundTripper that stores the responses
+// with an ETag or Last-Modified header and revalidates them sending
+// If-None-Match and If-Modified-Since. When the API replies 304 Not
+// Modified, which does not count against the rate limit, the stored
+// response is returned. Only GET requests are cached.
+type HTTPCacheTransport struct {
+	cache HTTPCache
+	base  http.RoundTripper
+}
+
+// NewHTTPCacheTransport returns a transport that sends the requests
+// through base and stores the responses in cache
+func NewHTTPCacheTransport(cache HTTPCache, base http.RoundTripper) *HTTPCacheTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &HTTPCacheTransport{cache: cache, base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *HTTPCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := httpCacheKey(req)
+	var cached *cachedHTTPResponse
+	if data, ok := t.cache.Get(key); ok {
+		cached = &cachedHTTPResponse{}
+		if err := json.Unmarshal(data, cached); err != nil {
+			logrus.Warnf("Ignoring invalid cached response for %s: %v", req.URL, err)
+			cached = nil
+		}
+	}
+
+	// Requests must not be modified, send a copy with the validators
+	if cached != nil {
+		creq := req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			creq.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			creq.Header.Set("If-Modified-Since", modified)
+		}
+		req = creq
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		logrus.Debugf("GitHub API: %s not modified, using cached response", req.URL)
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+		resp.Body.Close()
+
+		// The 304 has the current rate limit, keep its headers
+		header := cached.Header.Clone()
+		for k, v := range resp.Header {
+			header[k] = v
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+			StatusCode:    cached.StatusCode,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK ||
+		(resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	data, err := json.Marshal(&cachedHTTPResponse{
+		StatusCode: resp.StatusCode, Header: resp.Header, Body: body,
+	})
+	if err == nil {
+		err = t.cache.Set(key, data)
+	}
+	if err != nil {
+		logrus.Warnf("Unable to cache response for %s: %v", req.URL, err)
+	}
+	return resp, nil
+}
+
+// httpCacheKey returns the key of a request. Responses depend on the
+// media type, it is part of the key. The token is left out: actions
+// get a new one for every job and the cache would never hit across
+// runs. The API still checks the token of every conditional request.
+func httpCacheKey(req *http.Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s", req.Method, req.URL.String(), req.Header.Get("Accept"))
+	return hex.EncodeToString(h.Sum(nil))
+}
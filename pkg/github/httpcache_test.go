@@ -0,0 +1,58 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPCacheTransport(t *testing.T) {
+	ctx := context.Background()
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotEmpty(t, r.Header.Get("Authorization"))
+		requests++
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"login":"miniprow-bot"}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, spec := range []string{"memory", dir} {
+		requests, notModified = 0, 0
+		t.Setenv(HTTPCacheEnvKey, spec)
+		gh, err := NewWithToken("token", server.URL)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			user, err := gh.GetAPIUser(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "miniprow-bot", user.GetLogin())
+		}
+		require.Equal(t, 3, requests, spec)
+		require.Equal(t, 2, notModified, spec)
+	}
+
+	// The disk cache is reused by new clients, even with a new token
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	requests, notModified = 0, 0
+	gh, err := NewWithToken("new-token", server.URL)
+	require.NoError(t, err)
+	_, err = gh.GetAPIUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, notModified)
+}
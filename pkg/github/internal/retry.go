@@ -17,6 +17,14 @@ limitations under the License.
 package internal
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/v48/github"
@@ -33,41 +41,91 @@ const (
 	// GitHub calls in case we cannot extract that information from the error
 	// itself.
 	defaultGithubSleep = time.Minute
+
+	// maxGithubSleep is the longest we are willing to wait before retrying
+	// a call. If GitHub asks us to wait longer than this, we give up.
+	maxGithubSleep = 10 * time.Minute
+
+	// baseBackoff is the wait before the first retry of errors that do not
+	// tell us how long to wait. It doubles on each try.
+	baseBackoff = 2 * time.Second
 )
 
 // DefaultGithubErrChecker is a GithubErrChecker set up with a default amount
 // of retries and the default sleep function.
 func DefaultGithubErrChecker() func(error) bool {
-	return GithubErrChecker(MaxGithubRetries, time.Sleep)
+	return GithubErrChecker(MaxGithubRetries, ContextSleeper(context.Background()))
+}
+
+// ContextSleeper returns a sleep function for the error checkers that
+// stops waiting when ctx is done. It returns false if the wait was cut
+// short.
+func ContextSleeper(ctx context.Context) func(time.Duration) bool {
+	return func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
 }
 
 // GithubErrChecker returns a function that checks errors from GitHub and
 // decides if they can / should be retried.
 // It needs to be called with `maxTries`, a number of retries a single call
 // should be retried at max, and `sleeper`, a function which implements the
-// sleeping.
+// sleeping and returns false if the wait was interrupted. Interrupted
+// calls are not retried.
+//
+// The following errors are flagged as retryable:
+//
+//   - `AbuseRateLimitError` (secondary rate limits): we sleep for the amount
+//     of time the error told us to wait.
+//   - `RateLimitError` (primary rate limit): we sleep until the limit resets.
+//   - Responses with a `Retry-After` or exhausted `X-RateLimit-Remaining`
+//     header: we sleep for the time signaled by the headers.
+//   - 502, 503 and 504 responses and network errors such as connection
+//     resets: we sleep using exponential backoff with jitter.
 //
-// Currently the only special error that is flagged as retryable is the
-// `AbuseRateLimitError`. If such an error occurs, we sleep for a while (the
-// amount of time the error told us to wait) and then report back that we can
-// retry.
-// Other special errors should be easy to implement too.
+// Context cancellations are never retried. Calls that are not idempotent
+// have to use RateLimitErrChecker instead.
 //
 // It can be used like this:
 //
-//	for shouldRetry := GithubErrChecker(10, time.Sleep); ; {
+//	for shouldRetry := GithubErrChecker(10, ContextSleeper(ctx)); ; {
 //	  commit, res, err := github_client.GetCommit(...)
 //	  if !shouldRetry(err) {
 //	    return commit, res, err
 //	  }
 //	}
-func GithubErrChecker(maxTries int, sleeper func(time.Duration)) func(error) bool {
+func GithubErrChecker(maxTries int, sleeper func(time.Duration) bool) func(error) bool {
+	return errChecker(maxTries, sleeper, true)
+}
+
+// RateLimitErrChecker works like GithubErrChecker but it only retries
+// the rate limit errors, when GitHub did not process the request. It is
+// meant for calls that are not idempotent, like creating a comment or
+// merging: after a server or a network error the request may have been
+// applied and sending it again could repeat its effect.
+func RateLimitErrChecker(maxTries int, sleeper func(time.Duration) bool) func(error) bool {
+	return errChecker(maxTries, sleeper, false)
+}
+
+// errChecker builds the error checkers. Server and network errors are
+// only retried if the call is idempotent.
+func errChecker(maxTries int, sleeper func(time.Duration) bool, idempotent bool) func(error) bool {
 	try := 0
 
 	return func(err error) bool {
 		if err == nil {
 			return false
 		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
 		if try >= maxTries {
 			logrus.Errorf("Max retries (%d) reached, not retrying anymore: %v", maxTries, err)
 			return false
@@ -75,18 +133,111 @@ func GithubErrChecker(maxTries int, sleeper func(time.Duration)) func(error) boo
 
 		try++
 
-		if aerr, ok := err.(*github.AbuseRateLimitError); ok {
-			waitDuration := defaultGithubSleep
-			if d := aerr.RetryAfter; d != nil {
-				waitDuration = *d
-			}
+		waitDuration, reason, ok := retryWait(err, try, idempotent)
+		if !ok {
+			return false
+		}
+		if waitDuration > maxGithubSleep {
 			logrus.
-				WithField("err", aerr).
-				Infof("Hit the abuse rate limit on try %d, sleeping for %s", try, waitDuration)
-			sleeper(waitDuration)
-			return true
+				WithField("err", err).
+				Errorf("%s on try %d but wait time of %s is too long, not retrying", reason, try, waitDuration)
+			return false
+		}
+		logrus.
+			WithField("err", err).
+			Infof("%s on try %d, sleeping for %s", reason, try, waitDuration)
+		if !sleeper(waitDuration) {
+			logrus.Infof("Not retrying, the call was canceled while waiting")
+			return false
 		}
+		return true
+	}
+}
+
+// retryWait classifies an error and returns how long to wait before
+// retrying the call and a reason for logging. If the error cannot be
+// retried, ok is false. Server and network errors are only retried for
+// idempotent calls.
+func retryWait(err error, try int, idempotent bool) (wait time.Duration, reason string, ok bool) {
+	var aerr *github.AbuseRateLimitError
+	if errors.As(err, &aerr) {
+		wait = defaultGithubSleep
+		if d := aerr.RetryAfter; d != nil {
+			wait = *d
+		}
+		return wait, "Hit the abuse rate limit", true
+	}
+
+	var rerr *github.RateLimitError
+	if errors.As(err, &rerr) {
+		return untilReset(rerr.Rate.Reset.Time), "Hit the rate limit", true
+	}
+
+	var eerr *github.ErrorResponse
+	if errors.As(err, &eerr) {
+		if eerr.Response == nil {
+			return 0, "", false
+		}
+		if d, ok := headerWait(eerr.Response.Header); ok {
+			return d, "Got a throttling response", true
+		}
+		switch eerr.Response.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			if idempotent {
+				return backoff(try), "Got HTTP error " + strconv.Itoa(eerr.Response.StatusCode), true
+			}
+		}
+		return 0, "", false
+	}
+
+	if idempotent && isNetworkError(err) {
+		return backoff(try), "Got a network error", true
+	}
+	return 0, "", false
+}
+
+// headerWait reads the throttling headers from a response and returns
+// how long GitHub told us to wait
+func headerWait(header http.Header) (time.Duration, bool) {
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if v := header.Get("X-RateLimit-Reset"); v != "" {
+			if epoch, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return untilReset(time.Unix(epoch, 0)), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// untilReset returns the time to wait until a rate limit reset, plus
+// a second to give the API some slack
+func untilReset(reset time.Time) time.Duration {
+	d := time.Until(reset) + time.Second
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// backoff computes an exponential backoff duration for the try with
+// up to 50% of random jitter added to it
+func backoff(try int) time.Duration {
+	d := baseBackoff << (try - 1)
+	return d + time.Duration(rand.Int64N(int64(d/2)+1))
+}
 
-		return false
+// isNetworkError returns true if the error is a transient failure
+// talking to the API, before we got a response
+func isNetworkError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
 	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
 }
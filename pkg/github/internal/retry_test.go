@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/stretchr/testify/require"
+)
+
+func errResponse(code int, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &github.ErrorResponse{
+		Response: &http.Response{StatusCode: code, Header: header},
+	}
+}
+
+// recorder returns a sleeper that records the waits instead of sleeping
+func recorder(slept *[]time.Duration) func(time.Duration) bool {
+	return func(d time.Duration) bool {
+		*slept = append(*slept, d)
+		return true
+	}
+}
+
+func TestGithubErrChecker(t *testing.T) {
+	retryAfter := 3 * time.Second
+	for _, tc := range []struct {
+		name    string
+		err     error
+		retry   bool
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{name: "nil error", err: nil},
+		{name: "not found", err: errResponse(http.StatusNotFound, nil)},
+		{name: "canceled", err: fmt.Errorf("calling api: %w", context.Canceled)},
+		{
+			name: "abuse rate limit", err: &github.AbuseRateLimitError{RetryAfter: &retryAfter},
+			retry: true, minWait: retryAfter, maxWait: retryAfter,
+		},
+		{
+			name: "primary rate limit",
+			err: &github.RateLimitError{Rate: github.Rate{
+				Reset: github.Timestamp{Time: time.Now().Add(30 * time.Second)},
+			}},
+			retry: true, minWait: 29 * time.Second, maxWait: 32 * time.Second,
+		},
+		{
+			name: "primary rate limit too far away",
+			err: &github.RateLimitError{Rate: github.Rate{
+				Reset: github.Timestamp{Time: time.Now().Add(time.Hour)},
+			}},
+		},
+		{
+			name:  "retry-after header",
+			err:   errResponse(http.StatusForbidden, http.Header{"Retry-After": []string{"7"}}),
+			retry: true, minWait: 7 * time.Second, maxWait: 7 * time.Second,
+		},
+		{
+			name: "rate limit reset header",
+			err: errResponse(http.StatusForbidden, http.Header{
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(time.Now().Add(20*time.Second).Unix(), 10)},
+			}),
+			retry: true, minWait: 18 * time.Second, maxWait: 22 * time.Second,
+		},
+		{
+			name: "bad gateway", err: errResponse(http.StatusBadGateway, nil),
+			retry: true, minWait: baseBackoff, maxWait: baseBackoff * 3 / 2,
+		},
+		{
+			name: "gateway timeout", err: errResponse(http.StatusGatewayTimeout, nil),
+			retry: true, minWait: baseBackoff, maxWait: baseBackoff * 3 / 2,
+		},
+		{
+			name:  "connection reset",
+			err:   &url.Error{Op: "Get", URL: "https://api.github.com/", Err: syscall.ECONNRESET},
+			retry: true, minWait: baseBackoff, maxWait: baseBackoff * 3 / 2,
+		},
+	} {
+		var slept []time.Duration
+		shouldRetry := GithubErrChecker(3, recorder(&slept))
+		require.Equal(t, tc.retry, shouldRetry(tc.err), tc.name)
+		if !tc.retry {
+			require.Empty(t, slept, tc.name)
+			continue
+		}
+		require.Len(t, slept, 1, tc.name)
+		require.GreaterOrEqual(t, slept[0], tc.minWait, tc.name)
+		require.LessOrEqual(t, slept[0], tc.maxWait, tc.name)
+	}
+}
+
+func TestGithubErrCheckerBudget(t *testing.T) {
+	var slept []time.Duration
+	shouldRetry := GithubErrChecker(3, recorder(&slept))
+	err := errResponse(http.StatusServiceUnavailable, nil)
+	for range 3 {
+		require.True(t, shouldRetry(err))
+	}
+	require.False(t, shouldRetry(err))
+	require.Len(t, slept, 3)
+
+	// Backoff grows exponentially
+	require.GreaterOrEqual(t, slept[1], 2*baseBackoff)
+	require.GreaterOrEqual(t, slept[2], 4*baseBackoff)
+	require.LessOrEqual(t, slept[2], 6*baseBackoff)
+}
+
+func TestRateLimitErrChecker(t *testing.T) {
+	retryAfter := 3 * time.Second
+	for _, tc := range []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{name: "abuse rate limit", err: &github.AbuseRateLimitError{RetryAfter: &retryAfter}, retry: true},
+		{
+			name:  "retry-after header",
+			err:   errResponse(http.StatusForbidden, http.Header{"Retry-After": []string{"7"}}),
+			retry: true,
+		},
+		{name: "bad gateway", err: errResponse(http.StatusBadGateway, nil)},
+		{name: "service unavailable", err: errResponse(http.StatusServiceUnavailable, nil)},
+		{
+			name: "connection reset",
+			err:  &url.Error{Op: "Post", URL: "https://api.github.com/", Err: syscall.ECONNRESET},
+		},
+	} {
+		var slept []time.Duration
+		shouldRetry := RateLimitErrChecker(3, recorder(&slept))
+		require.Equal(t, tc.retry, shouldRetry(tc.err), tc.name)
+		require.Equal(t, tc.retry, len(slept) == 1, tc.name)
+	}
+}
+
+func TestContextSleeper(t *testing.T) {
+	require.True(t, ContextSleeper(context.Background())(time.Millisecond))
+
+	// Canceling the context during a Retry-After wait stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	shouldRetry := GithubErrChecker(3, ContextSleeper(ctx))
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	require.False(t, shouldRetry(errResponse(http.StatusForbidden, http.Header{"Retry-After": []string{"60"}})))
+	require.Less(t, time.Since(start), 30*time.Second)
+}
@@ -0,0 +1,126 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is the API quota of a resource as reported in the
+// X-RateLimit headers of the last response
+type RateLimit struct {
+	Resource  string    `json:"resource"` // core, graphql, search...
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Used      int       `json:"used"`
+	Reset     time.Time `json:"reset"`
+}
+
+// String returns a one line summary of the rate limit
+func (r RateLimit) String() string {
+	return fmt.Sprintf(
+		"%s: %d/%d requests remaining, resets at %s",
+		r.Resource, r.Remaining, r.Limit, r.Reset.Format(time.RFC3339),
+	)
+}
+
+// rateLimits is an http.RoundTripper that records the rate limit
+// headers of every response, per resource
+type rateLimits struct {
+	base   http.RoundTripper
+	mu     sync.Mutex
+	limits map[string]RateLimit
+}
+
+func newRateLimits(base http.RoundTripper) *rateLimits {
+	return &rateLimits{base: base, limits: map[string]RateLimit{}}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *rateLimits) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	limit, ok := parseRateLimit(resp.Header)
+	if ok {
+		r.mu.Lock()
+		r.limits[limit.Resource] = limit
+		r.mu.Unlock()
+	}
+	return resp, err
+}
+
+// get returns the rate limit of a resource
+func (r *rateLimits) get(resource string) (RateLimit, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limit, ok := r.limits[resource]
+	return limit, ok
+}
+
+// all returns the rate limits of all the resources, sorted
+func (r *rateLimits) all() []RateLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []RateLimit{}
+	for _, limit := range r.limits {
+		res = append(res, limit)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Resource < res[j].Resource })
+	return res
+}
+
+// parseRateLimit reads the rate limit headers of a response
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	if header.Get("X-RateLimit-Limit") == "" {
+		return RateLimit{}, false
+	}
+	number := func(key string) int {
+		n, _ := strconv.Atoi(header.Get(key)) //nolint:errcheck
+		return n
+	}
+	limit := RateLimit{
+		Resource:  header.Get("X-RateLimit-Resource"),
+		Limit:     number("X-RateLimit-Limit"),
+		Remaining: number("X-RateLimit-Remaining"),
+		Used:      number("X-RateLimit-Used"),
+		Reset:     time.Unix(int64(number("X-RateLimit-Reset")), 0),
+	}
+	if limit.Resource == "" {
+		limit.Resource = "core"
+	}
+	return limit, true
+}
+
+// RateLimit returns the quota of the REST API (the core resource) seen
+// in the last response. It returns false if no response had the rate
+// limit headers, as when using a client that does not talk HTTP.
+func (github *GitHub) RateLimit() (RateLimit, bool) {
+	if github.rateLimits == nil {
+		return RateLimit{}, false
+	}
+	return github.rateLimits.get("core")
+}
+
+// RateLimits returns the quota of all the API resources used so far
+func (github *GitHub) RateLimits() []RateLimit {
+	if github.rateLimits == nil {
+		return []RateLimit{}
+	}
+	return github.rateLimits.all()
+}
+
+// BudgetBelow returns true if the remaining requests of any of the
+// resources used so far is under floor
+func (github *GitHub) BudgetBelow(floor int) bool {
+	for _, limit := range github.RateLimits() {
+		if limit.Remaining < floor {
+			return true
+		}
+	}
+	return false
+}
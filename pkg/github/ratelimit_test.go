@@ -0,0 +1,50 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	remaining := 60
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining--
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Used", strconv.Itoa(5000-remaining))
+		w.Header().Set("X-RateLimit-Reset", "1700000000")
+		w.Header().Set("X-RateLimit-Resource", "core")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"login":"miniprow-bot"}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	gh, err := NewWithToken("token", server.URL)
+	require.NoError(t, err)
+	_, ok := gh.RateLimit()
+	require.False(t, ok)
+	require.False(t, gh.BudgetBelow(100))
+
+	for i := 0; i < 2; i++ {
+		_, err := gh.GetAPIUser(context.Background())
+		require.NoError(t, err)
+	}
+	limit, ok := gh.RateLimit()
+	require.True(t, ok)
+	require.Equal(t, 5000, limit.Limit)
+	require.Equal(t, 58, limit.Remaining)
+	require.Equal(t, 4942, limit.Used)
+	require.Equal(t, int64(1700000000), limit.Reset.Unix())
+	require.Len(t, gh.RateLimits(), 1)
+	require.True(t, gh.BudgetBelow(100))
+	require.False(t, gh.BudgetBelow(50))
+
+	// Clients that do not talk HTTP have no rate limit
+	_, ok = NewWithClient(gh.Client()).RateLimit()
+	require.False(t, ok)
+}
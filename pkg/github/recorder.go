@@ -0,0 +1,241 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CassetteEnvKey is the environment variable that enables recording or
+	// replaying the API interactions to/from a cassette file
+	CassetteEnvKey = "MINIPROW_CASSETTE"
+
+	// CassetteModeEnvKey sets the cassette mode: record (default) or replay
+	CassetteModeEnvKey = "MINIPROW_CASSETTE_MODE"
+
+	// redactedValue replaces the sensitive headers in the cassette
+	redactedValue = "REDACTED"
+)
+
+// CassetteMode controls if the recorder writes or reads the cassette
+type CassetteMode string
+
+const (
+	// CassetteRecord sends requests to the API and records them
+	CassetteRecord CassetteMode = "record"
+
+	// CassetteReplay serves the responses from the cassette without
+	// talking to the API
+	CassetteReplay CassetteMode = "replay"
+)
+
+// redactedHeaders are headers which are never written to cassettes
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// Cassette is a list of recorded HTTP interactions
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response the API sent back
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+	replayed bool
+}
+
+// RecordedRequest is the data captured from a request
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is the data captured from a response
+type RecordedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cassette")
+	}
+	cassette := &Cassette{}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, errors.Wrap(err, "parsing cassette")
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to a file
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling cassette")
+	}
+	if err := os.WriteFile(path, data, os.FileMode(0o600)); err != nil {
+		return errors.Wrap(err, "writing cassette")
+	}
+	return nil
+}
+
+// Recorder is an http.RoundTripper that records the API interactions to
+// a cassette or replays them from it. The tokens in the requests are
+// redacted before writing them to disk.
+type Recorder struct {
+	mu       sync.Mutex
+	mode     CassetteMode
+	path     string
+	base     http.RoundTripper
+	cassette *Cassette
+}
+
+// NewRecorder returns a new recorder. In record mode, requests are sent
+// through the base transport and the cassette is written to path after
+// every interaction. In replay mode the cassette is loaded from path.
+func NewRecorder(path string, mode CassetteMode, base http.RoundTripper) (*Recorder, error) {
+	r := &Recorder{
+		mode:     mode,
+		path:     path,
+		base:     base,
+		cassette: &Cassette{Interactions: []*Interaction{}},
+	}
+	switch mode {
+	case CassetteRecord:
+		if r.base == nil {
+			r.base = http.DefaultTransport
+		}
+	case CassetteReplay:
+		cassette, err := LoadCassette(path)
+		if err != nil {
+			return nil, err
+		}
+		r.cassette = cassette
+	default:
+		return nil, fmt.Errorf("invalid cassette mode %q", mode)
+	}
+	return r, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == CassetteReplay {
+		return r.replay(req)
+	}
+	return r.record(req)
+}
+
+// record sends the request and saves the interaction to the cassette
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request body")
+	}
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Header: redact(req.Header),
+			Body:   reqBody,
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     redact(resp.Header),
+			Body:       respBody,
+		},
+	})
+	if err := r.cassette.Save(r.path); err != nil {
+		logrus.Errorf("Unable to save API cassette: %v", err)
+	}
+	return resp, nil
+}
+
+// replay returns the first unused recorded response matching the request
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request body")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range r.cassette.Interactions {
+		if i.replayed || i.Request.Method != req.Method || i.Request.URL != req.URL.RequestURI() {
+			continue
+		}
+		if req.Method != http.MethodGet && i.Request.Body != reqBody {
+			continue
+		}
+		i.replayed = true
+		header := i.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewBufferString(i.Response.Body)),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL.RequestURI())
+}
+
+// readBody consumes a body and replaces it with a new reader with the
+// same contents so it can still be read
+func readBody(body *io.ReadCloser) (string, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", nil
+	}
+	data, err := io.ReadAll(*body)
+	if err != nil {
+		return "", err
+	}
+	if err := (*body).Close(); err != nil {
+		return "", err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
+
+// redact returns a copy of the headers with the sensitive values removed
+func redact(header http.Header) http.Header {
+	h := header.Clone()
+	for _, key := range redactedHeaders {
+		if h.Get(key) != "" {
+			h.Set(key, redactedValue)
+		}
+	}
+	return h
+}
@@ -0,0 +1,72 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	token := "super-secret-token"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer "+token, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/repos/uservers/test/pulls/1":
+			_, _ = w.Write([]byte(`{"number": 1, "title": "Recorded PR"}`))
+		case "/user":
+			_, _ = w.Write([]byte(`{"login": "miniprow-bot"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+
+	// Record a session against the server
+	t.Setenv(CassetteEnvKey, cassette)
+	t.Setenv(CassetteModeEnvKey, string(CassetteRecord))
+	gh, err := NewWithToken(token, server.URL)
+	require.NoError(t, err)
+	pr, err := gh.GetPullRequest(ctx, "uservers", "test", 1)
+	require.NoError(t, err)
+	require.Equal(t, "Recorded PR", pr.GetTitle())
+	_, err = gh.GetPullRequest(ctx, "uservers", "test", 2)
+	require.ErrorIs(t, err, ErrNotFound)
+	user, err := gh.GetAPIUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "miniprow-bot", user.GetLogin())
+	server.Close()
+
+	// The token must not be written to disk
+	data, err := os.ReadFile(cassette)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), token)
+	recorded, err := LoadCassette(cassette)
+	require.NoError(t, err)
+	require.Len(t, recorded.Interactions, 3)
+
+	// Replay the session, the server is gone now
+	t.Setenv(CassetteModeEnvKey, string(CassetteReplay))
+	gh, err = NewWithToken(token, server.URL)
+	require.NoError(t, err)
+	user, err = gh.GetAPIUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "miniprow-bot", user.GetLogin())
+	pr, err = gh.GetPullRequest(ctx, "uservers", "test", 1)
+	require.NoError(t, err)
+	require.Equal(t, "Recorded PR", pr.GetTitle())
+	_, err = gh.GetPullRequest(ctx, "uservers", "test", 2)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Interactions are only replayed once
+	_, err = gh.GetPullRequest(ctx, "uservers", "test", 1)
+	require.Error(t, err)
+}
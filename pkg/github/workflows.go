@@ -0,0 +1,229 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/pkg/errors"
+)
+
+// ListWorkflows returns the GitHub Actions workflows of a repository
+func (github *GitHub) ListWorkflows(ctx context.Context, slug string) ([]*gogithub.Workflow, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	opts := &gogithub.ListOptions{
+		Page:    0,
+		PerPage: 100,
+	}
+	workflows, err := github.client.ListWorkflows(ctx, owner, repo, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing workflows of %s", slug)
+	}
+	return workflows, nil
+}
+
+// ListWorkflowRunsForSHA returns the workflow runs triggered for a
+// commit. When a workflow ran more than once, only its latest run is
+// returned.
+func (github *GitHub) ListWorkflowRunsForSHA(
+	ctx context.Context, slug, sha string,
+) ([]*gogithub.WorkflowRun, error) {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return nil, errors.New("invalid repo slug")
+	}
+	opts := &gogithub.ListOptions{
+		Page:    0,
+		PerPage: 100,
+	}
+	runs, err := github.client.ListWorkflowRunsForSHA(ctx, owner, repo, sha, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing workflow runs for %s", sha)
+	}
+	return latestWorkflowRuns(runs), nil
+}
+
+// latestWorkflowRuns filters a list of workflow runs, keeping the run
+// with the highest ID of each workflow. Order of the list is preserved.
+func latestWorkflowRuns(runs []*gogithub.WorkflowRun) []*gogithub.WorkflowRun {
+	latest := map[int64]*gogithub.WorkflowRun{}
+	for _, run := range runs {
+		prev, ok := latest[run.GetWorkflowID()]
+		if !ok || run.GetID() > prev.GetID() {
+			latest[run.GetWorkflowID()] = run
+		}
+	}
+
+	filtered := []*gogithub.WorkflowRun{}
+	for _, run := range runs {
+		if latest[run.GetWorkflowID()] == run {
+			filtered = append(filtered, run)
+		}
+	}
+	return filtered
+}
+
+// RerunFailedJobs re-runs the failed jobs of a workflow run and the
+// jobs that depend on them
+func (github *GitHub) RerunFailedJobs(ctx context.Context, slug string, runID int64) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.RerunFailedJobs(ctx, owner, repo, runID),
+		"re-running failed jobs of run %d", runID,
+	)
+}
+
+// RerunWorkflow re-runs all the jobs of a workflow run
+func (github *GitHub) RerunWorkflow(ctx context.Context, slug string, runID int64) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.RerunWorkflow(ctx, owner, repo, runID),
+		"re-running workflow run %d", runID,
+	)
+}
+
+// ActionsAppSlug is the app of the check runs reported by the jobs of
+// GitHub Actions workflows
+const ActionsAppSlug = "github-actions"
+
+// RerunJob re-runs a job of a workflow run. The jobs of GitHub Actions
+// report a check run with the same ID, it can be passed as jobID. Check
+// runs of other apps are not jobs.
+func (github *GitHub) RerunJob(ctx context.Context, slug string, jobID int64) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.RerunJob(ctx, owner, repo, jobID),
+		"re-running job %d", jobID,
+	)
+}
+
+// DispatchWorkflow starts a run of a workflow on a branch. The workflow
+// has to be triggered by workflow_dispatch events.
+func (github *GitHub) DispatchWorkflow(ctx context.Context, slug string, workflowID int64, ref string) error {
+	owner, repo := ParseSlug(slug)
+	if owner == "" || repo == "" {
+		return errors.New("invalid repo slug")
+	}
+	return errors.Wrapf(
+		github.client.DispatchWorkflow(ctx, owner, repo, workflowID, ref),
+		"dispatching workflow %d on %s", workflowID, ref,
+	)
+}
+
+// ListWorkflows queries the actions API for the workflows of a
+// repository, collecting them from all pages
+func (g *githubClient) ListWorkflows(
+	ctx context.Context, owner, repo string, opts *gogithub.ListOptions,
+) ([]*gogithub.Workflow, error) {
+	workflows := []*gogithub.Workflow{}
+	for {
+		for shouldRetry := g.errChecker(ctx); ; {
+			page, resp, err := g.Client.Actions.ListWorkflows(ctx, owner, repo, opts)
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "listing workflows")
+			}
+			workflows = append(workflows, page.Workflows...)
+			if resp.NextPage == 0 {
+				return workflows, nil
+			}
+			opts.Page = resp.NextPage
+			break
+		}
+	}
+}
+
+// ListWorkflowRunsForSHA queries the actions API for the workflow runs
+// of a commit. The client library cannot filter the runs by head SHA,
+// the request is built by hand.
+func (g *githubClient) ListWorkflowRunsForSHA(
+	ctx context.Context, owner, repo, sha string, opts *gogithub.ListOptions,
+) ([]*gogithub.WorkflowRun, error) {
+	runs := []*gogithub.WorkflowRun{}
+	for {
+		u := fmt.Sprintf(
+			"repos/%s/%s/actions/runs?head_sha=%s&per_page=%d&page=%d",
+			owner, repo, url.QueryEscape(sha), opts.PerPage, opts.Page,
+		)
+		for shouldRetry := g.errChecker(ctx); ; {
+			req, err := g.Client.NewRequest(http.MethodGet, u, nil)
+			if err != nil {
+				return nil, errors.Wrap(err, "building workflow runs request")
+			}
+			page := &gogithub.WorkflowRuns{}
+			resp, err := g.Client.Do(ctx, req, page)
+			if shouldRetry(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(apiError(resp, err), "listing workflow runs")
+			}
+			runs = append(runs, page.WorkflowRuns...)
+			if resp.NextPage == 0 {
+				return runs, nil
+			}
+			opts.Page = resp.NextPage
+			break
+		}
+	}
+}
+
+// RerunFailedJobs calls the actions API to re-run the failed jobs of a run
+func (g *githubClient) RerunFailedJobs(ctx context.Context, owner, repo string, runID int64) error {
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		resp, err := g.Client.Actions.RerunFailedJobsByID(ctx, owner, repo, runID)
+		if !shouldRetry(err) {
+			return apiError(resp, err)
+		}
+	}
+}
+
+// RerunWorkflow calls the actions API to re-run a whole workflow run
+func (g *githubClient) RerunWorkflow(ctx context.Context, owner, repo string, runID int64) error {
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		resp, err := g.Client.Actions.RerunWorkflowByID(ctx, owner, repo, runID)
+		if !shouldRetry(err) {
+			return apiError(resp, err)
+		}
+	}
+}
+
+// RerunJob calls the actions API to re-run a job
+func (g *githubClient) RerunJob(ctx context.Context, owner, repo string, jobID int64) error {
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		resp, err := g.Client.Actions.RerunJobByID(ctx, owner, repo, jobID)
+		if !shouldRetry(err) {
+			return apiError(resp, err)
+		}
+	}
+}
+
+// DispatchWorkflow calls the actions API to create a workflow_dispatch event
+func (g *githubClient) DispatchWorkflow(
+	ctx context.Context, owner, repo string, workflowID int64, ref string,
+) error {
+	for shouldRetry := g.unsafeErrChecker(ctx); ; {
+		resp, err := g.Client.Actions.CreateWorkflowDispatchEventByID(
+			ctx, owner, repo, workflowID, gogithub.CreateWorkflowDispatchEventRequest{Ref: ref},
+		)
+		if !shouldRetry(err) {
+			return apiError(resp, err)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	gogithub "github.com/google/go-github/v48/github"
 	"github.com/sirupsen/logrus"
@@ -20,126 +22,489 @@ const (
 	MiniProwConf         = "config.yaml"
 	approvalNotifierFlag = "APPROVALNOTIFIER"
 	TestsDoneCommand     = "tests-done"
+	RetestCommand        = "retest"
+	TestCommand          = "test"
+
+	// maxCheckAnnotations is the number of annotations GitHub accepts
+	// when creating a check run
+	maxCheckAnnotations = 50
+
+	notMergeableMessage = "MiniProw tried to merge this pull request but GitHub reported " +
+		"it cannot be merged. If there is a merge conflict, please rebase."
 )
 
 type Broker struct {
-	ctx    context.Context
-	impl   brokerImplementation
-	github *github.GitHub
-	config Config
-	State  *State
+	ctx     context.Context
+	impl    brokerImplementation
+	github  *github.GitHub
+	config  Config
+	actions []Action
+	sweep   []SweepResult
+	State   *State
 }
 
 type State struct {
 	PullRequest *gogithub.PullRequest
 	Issue       *gogithub.Issue
-}
 
-var DefaultConfig = Config{
-	requiredLabels: []string{"approved", "lgtm"},
-	options: &Options{
-		AutoMerge: true, // AutoMerge merges a PR if the author is an approver + reviewer
-	},
+	// Data of the pull request loaded in one GraphQL query. They are
+	// nil when the pull request was read with the REST API, the broker
+	// then lists them with REST calls when needed.
+	Files     []*gogithub.CommitFile
+	Comments  []*gogithub.IssueComment
+	Reviews   []*gogithub.PullRequestReview
+	CheckRuns []*gogithub.CheckRun
+	Statuses  []*gogithub.RepoStatus
 }
 
-type Options struct {
-	AutoMerge bool
-}
+// NewBroker creates a new broker. All calls to the GitHub API made by
+// the broker are bound to the passed context.
+func NewBroker(ctx context.Context) (*Broker, error) {
+	broker := &Broker{
+		impl:   &defaultBrokerImplementation{},
+		config: DefaultConfig,
+	}
 
-type Config struct {
-	requiredLabels []string
-	options        *Options
-}
+	// Load the context data from the environment
+	broker.ReadContext(ctx)
 
-// RequiredLabels returns a list of required labels
-func (c *Config) RequiredLabels() []string {
-	return c.requiredLabels
+	if err := broker.init(); err != nil {
+		return nil, err
+	}
+	return broker, nil
 }
 
-func NewBroker() (*Broker, error) {
+// NewBrokerWithData creates a new broker that runs with the passed
+// context data instead of reading it from the environment
+func NewBrokerWithData(ctx context.Context, data ContextData) (*Broker, error) {
 	broker := &Broker{
+		ctx:    context.WithValue(ctx, ckey, data),
 		impl:   &defaultBrokerImplementation{},
 		config: DefaultConfig,
 	}
+	if err := broker.init(); err != nil {
+		return nil, err
+	}
+	return broker, nil
+}
 
-	// Load the context data from the environment
-	broker.ReadContext()
-
+// init loads the configuration and the state of the broker
+func (b *Broker) init() error {
 	// Load configuration file
-	if err := broker.LoadConfigFile(); err != nil {
-		return nil, fmt.Errorf("loading config file: %w", err)
+	if err := b.LoadConfigFile(); err != nil {
+		return fmt.Errorf("loading config file: %w", err)
 	}
 
 	// Load the state
-	if err := broker.InitState(); err != nil {
-		return nil, fmt.Errorf("initilizing state: %w", err)
+	if err := b.InitState(); err != nil {
+		return fmt.Errorf("initilizing state: %w", err)
 	}
-
-	return broker, nil
+	return nil
 }
 
-// Run starts the processing
+// Run starts the processing: it computes the actions to take on
+// the pull request and applies them
 func (b *Broker) Run() (err error) {
 	logrus.WithField("step", "Run").Info("🚀 MiniProw broker running!")
-	switch b.ctx.Value(ckey).(ContextData).Event() {
-	case "COMMENT":
-		if err := b.HandleComment(); err != nil {
+	defer b.ReportDryRun()
+	defer b.reportAPIUsage()
+
+	if b.ctx.Value(ckey).(ContextData).Event() == EventSweep {
+		b.sweep, err = b.Sweep()
+		if err != nil {
 			logrus.WithField("step", "Run").Error(err)
-			return fmt.Errorf("running comment handler: %w", err)
+			return fmt.Errorf("sweeping open pull requests: %w", err)
 		}
-	case "NEWPR":
-		if err := b.HandleNewPR(); err != nil {
-			logrus.WithField("step", "Run").Error(err)
-			return fmt.Errorf("running new PR handler: %w", err)
+		return nil
+	}
+
+	actions, err := b.Plan()
+	if err != nil {
+		logrus.WithField("step", "Run").Error(err)
+		return fmt.Errorf("planning %s event: %w", b.ctx.Value(ckey).(ContextData).Event(), err)
+	}
+
+	b.actions = actions
+	logrus.WithField("step", "Run").Infof("Broker decided %d actions", len(actions))
+	for i, a := range actions {
+		logrus.WithField("step", "Run").Infof("%3d. %s", i+1, a)
+	}
+
+	if err := b.Apply(actions); err != nil {
+		logrus.WithField("step", "Run").Error(err)
+		return fmt.Errorf("applying actions: %w", err)
+	}
+	return nil
+}
+
+// Actions returns the actions decided in the last run
+func (b *Broker) Actions() []Action {
+	return b.actions
+}
+
+// SweepResults returns what the last sweep did with each open pull request
+func (b *Broker) SweepResults() []SweepResult {
+	return b.sweep
+}
+
+// Config returns the configuration used by the broker
+func (b *Broker) Config() *Config {
+	return &b.config
+}
+
+// Plan reads the event and the pull request state and returns the
+// actions the broker has to take. It does not modify anything.
+func (b *Broker) Plan() ([]Action, error) {
+	event, err := b.ReadEvent()
+	if err != nil {
+		return nil, fmt.Errorf("reading event: %w", err)
+	}
+	if event.Type == EventSweep {
+		return nil, errors.New("sweeps are not planned for a single pull request")
+	}
+	state, err := b.ReadPRState(event)
+	if err != nil {
+		return nil, fmt.Errorf("reading pull request state: %w", err)
+	}
+	return Decide(state, event, &b.config)
+}
+
+// ReadEvent builds the event that triggered the run from the context
+func (b *Broker) ReadEvent() (Event, error) {
+	event := Event{Type: b.ctx.Value(ckey).(ContextData).Event()}
+	if event.Type != EventComment {
+		return event, nil
+	}
+	logrus.Infof(
+		"💬 Comment handler running for comment ID#%d",
+		b.ctx.Value(ckey).(ContextData).CommentID(),
+	)
+	comment, err := b.impl.GetComment(
+		b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(),
+		b.ctx.Value(ckey).(ContextData).CommentID(),
+	)
+	if err != nil {
+		return event, fmt.Errorf("getting comment from github: %w", err)
+	}
+	logrus.Infof(" > Comment body: %s", strings.TrimSpace(comment.GetBody()))
+	event.Comment = comment
+	return event, nil
+}
+
+// ReadPRState collects the data needed to decide on an event
+func (b *Broker) ReadPRState(event Event) (*PRState, error) {
+	if b.State == nil || b.State.PullRequest == nil {
+		return nil, errors.New("pull request not found in state")
+	}
+	pr := b.State.PullRequest
+	s := &PRState{
+		Number:    pr.GetNumber(),
+		Author:    b.Author(),
+		HeadSHA:   pr.GetHead().GetSHA(),
+		Labels:    []string{},
+		Merged:    pr.GetMerged(),
+		Mergeable: pr.GetMergeable(),
+	}
+	for _, l := range pr.Labels {
+		s.Labels = append(s.Labels, l.GetName())
+	}
+
+	if event.Type == EventTestsDone {
+		return s, nil
+	}
+
+	s.RepoRoot = b.RepoRoot()
+	if s.RepoRoot == "" {
+		return nil, errors.New("could not get repo root")
+	}
+
+	// Changes to the OWNERS files are reviewed first, the permissions
+	// are then computed from their version in the base branch
+	review, overlay, err := b.ReadOwnersReview(s.RepoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("reviewing OWNERS changes: %w", err)
+	}
+	s.OwnersReview = review
+
+	if event.Type == EventCheckMerge || event.Type == EventComment {
+		checkruns, err := b.impl.GetPRCheckRuns(b.ctx, b.GitHub(), b.State)
+		if err != nil {
+			return nil, fmt.Errorf("getting check runs for pull request: %w", err)
 		}
-	case "CHECKMERGE":
-		if err := b.CheckMerge(); err != nil {
-			logrus.WithField("step", "Run").Error(err)
-			return fmt.Errorf("running merge check: %w", err)
+		s.CheckRuns = checkruns.CheckRuns
+
+		statuses, err := b.impl.GetPRStatuses(b.ctx, b.GitHub(), b.State)
+		if err != nil {
+			return nil, fmt.Errorf("getting commit statuses for pull request: %w", err)
 		}
-	case "TESTSDONE":
-		if err := b.CreateTestsDoneComment(); err != nil {
-			logrus.WithField("step", "Run").Error(err)
-			return fmt.Errorf("running merge check: %w", err)
+		s.Statuses = statuses.Statuses
+	}
+
+	// Merging a PR that grants approval rights requires a root approver
+	if review.NeedsRootApproval() {
+		s.Approvers, _, err = b.impl.GetApprovers(b.ctx, b.GitHub(), b.State)
+		if err != nil {
+			return nil, fmt.Errorf("while getting current PR approvers: %w", err)
 		}
-	default:
-		logrus.WithField("step", "Run").Error("MiniProw event not found or wrong key")
-		return errors.New("unkown MiniProw event")
 	}
 
-	// TODO(puerco): Check if pr is merged before continuing
-	//nolint:gocritic
-	/*
-		// Checking if PR can be merged
-		ready, err := b.LabelsReadyToMerge()
+	// Direct merges only need the merge data. The other strategies and
+	// the flaky check retries keep their state in the notifier, it is
+	// read to update it.
+	stateless := b.config.MergeStrategy() == MergeStrategyDirect && len(b.config.FlakyChecks()) == 0
+	if event.Type == EventCheckMerge && stateless {
+		return s, nil
+	}
+
+	botuser, err := b.impl.GetBotUser(b.ctx, b.GitHub())
+	if err != nil {
+		return nil, fmt.Errorf("getting bot user: %w", err)
+	}
+	s.BotUser = botuser.GetLogin()
+
+	notifierEvent := event.Type == EventComment && s.IsApprovalNotifier(event.Comment)
+	if notifierEvent && stateless {
+		return s, nil
+	}
+
+	if event.Type == EventComment && !notifierEvent {
+		s.RepoLabels, err = b.impl.GetRepoLabels(b.ctx, b.GitHub())
 		if err != nil {
-			return fmt.Errorf( "checking if PR is ready: %w", err)
+			return nil, fmt.Errorf("listing repository labels: %w", err)
+		}
+		if runsWorkflows(event.Comment) {
+			if err := b.readWorkflowState(s, event.Comment, overlay); err != nil {
+				return nil, fmt.Errorf("reading workflow runs: %w", err)
+			}
 		}
+	}
 
-		if !ready {
-			logrus.WithField("step", "Run").Info("PR cannot be merged yet")
-			return nil
+	if event.Type == EventNewPR {
+		userPerms, err := b.impl.GetUserPerms(b.ctx, s.Author, overlay)
+		if err != nil {
+			return nil, fmt.Errorf("getting the PR author's permissions: %w", err)
 		}
+		s.AuthorIsApprover = userPerms["approver"]
+		s.AuthorIsReviewer = userPerms["reviewer"]
+	}
+
+	s.NeededApprovers, err = b.impl.GetNeededApprovers(b.ctx, b.GitHub(), b.State, overlay)
+	if err != nil {
+		return nil, fmt.Errorf("getting current PR approvers: %w", err)
+	}
 
-		// Verify the test suite has been successful
-		checksPassed, err := b.VerifyChecks()
+	if s.Approvers == nil {
+		s.Approvers, _, err = b.impl.GetApprovers(b.ctx, b.GitHub(), b.State)
 		if err != nil {
-			return fmt.Errorf( "verifying check suite results from the PR: %w", err)
+			return nil, fmt.Errorf("while getting current PR approvers: %w", err)
 		}
+	}
 
-		if !checksPassed {
-			logrus.WithField("step", "Run").Info("⏳ PR checks are failing or have not yet completed")
-			return nil
+	// With direct merges the notifier is not needed to label or merge,
+	// save the requests to find and refresh it when the quota is running out
+	if b.GitHub().BudgetBelow(b.config.RateLimitFloor()) {
+		logrus.Warnf("Less than %d API requests left, postponing non-essential work", b.config.RateLimitFloor())
+		s.LowAPIBudget = true
+		if stateless {
+			return s, nil
+		}
+	}
+
+	s.Notifier, err = b.impl.GetApprovalNotifierComment(b.ctx, b.GitHub(), b.State)
+	if err != nil {
+		return nil, fmt.Errorf("while lookig for the approve notifier comment: %w", err)
+	}
+	if s.Notifier != nil {
+		// A broken state is not fatal, it is replaced in the next refresh
+		s.NotifierState, err = parseNotifierState(s.Notifier.GetBody())
+		if err != nil {
+			logrus.Warnf("Ignoring the state in the approval notifier: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Apply executes the actions decided by the broker. Actions are
+// idempotent: labels already in the desired state, merged pull requests
+// and comments already deleted are skipped. The actions that follow a
+// merge are skipped if GitHub did not merge the pull request.
+func (b *Broker) Apply(actions []Action) error {
+	labels := map[string]struct{}{}
+	if b.State != nil && b.State.PullRequest != nil {
+		for _, l := range b.State.PullRequest.Labels {
+			labels[l.GetName()] = struct{}{}
 		}
+	}
 
-		// Merge PR
-		if err := b.MergePullRequest(); err != nil {
-			return fmt.Errorf("merging pull request: %w", err)
+	merged := true
+	for _, a := range actions {
+		if !merged && a.Reason == reasonMerged {
+			logrus.Infof("Pull request was not merged, skipping %s", a)
+			continue
 		}
-	*/
+		switch a.Type {
+		case ActionAddLabel:
+			if _, ok := labels[a.Label]; ok {
+				logrus.Infof("Label %s is already set (NOOP)", a.Label)
+				continue
+			}
+			if err := b.impl.AddLabel(b.ctx, b.GitHub(), a.Label); err != nil {
+				return fmt.Errorf("adding label %s: %w", a.Label, err)
+			}
+			labels[a.Label] = struct{}{}
+		case ActionRemoveLabel:
+			if _, ok := labels[a.Label]; !ok {
+				logrus.Infof("Label %s is not set (NOOP)", a.Label)
+				continue
+			}
+			if err := b.impl.RemoveLabel(b.ctx, b.GitHub(), a.Label); err != nil {
+				return fmt.Errorf("removing label %s: %w", a.Label, err)
+			}
+			delete(labels, a.Label)
+		case ActionMerge:
+			var err error
+			merged, err = b.MergePullRequest()
+			if err != nil {
+				return fmt.Errorf("merging pull request: %w", err)
+			}
+		case ActionEnableAutoMerge:
+			if err := b.EnableAutoMerge(); err != nil {
+				return fmt.Errorf("enabling auto-merge: %w", err)
+			}
+		case ActionDisableAutoMerge:
+			if err := b.impl.DisableAutoMerge(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(),
+				b.ctx.Value(ckey).(ContextData).PullRequest(),
+			); err != nil {
+				return fmt.Errorf("disabling auto-merge: %w", err)
+			}
+		case ActionEnqueue:
+			if err := b.impl.EnqueuePullRequest(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(),
+				b.ctx.Value(ckey).(ContextData).PullRequest(),
+			); err != nil {
+				return fmt.Errorf("adding pull request to the merge queue: %w", err)
+			}
+		case ActionDequeue:
+			if err := b.impl.DequeuePullRequest(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(),
+				b.ctx.Value(ckey).(ContextData).PullRequest(),
+			); err != nil {
+				return fmt.Errorf("removing pull request from the merge queue: %w", err)
+			}
+		case ActionRerunFailedJobs:
+			if err := b.impl.RerunFailedJobs(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), a.RunID,
+			); err != nil {
+				return fmt.Errorf("re-running failed jobs of run %d: %w", a.RunID, err)
+			}
+		case ActionRerunWorkflow:
+			if err := b.impl.RerunWorkflow(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), a.RunID,
+			); err != nil {
+				return fmt.Errorf("re-running workflow run %d: %w", a.RunID, err)
+			}
+		case ActionRerunCheck:
+			// Retries are best effort, checks that cannot be re-run are skipped
+			err := b.impl.RerunJob(b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), a.RunID)
+			if errors.Is(err, github.ErrNotFound) || errors.Is(err, github.ErrForbidden) {
+				logrus.Warnf("Unable to re-run check run %d, skipping it: %v", a.RunID, err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("re-running check run %d: %w", a.RunID, err)
+			}
+		case ActionDispatchWorkflow:
+			if err := b.impl.DispatchWorkflow(
+				b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), a.WorkflowID, a.Ref,
+			); err != nil {
+				return fmt.Errorf("dispatching workflow %d: %w", a.WorkflowID, err)
+			}
+		case ActionCreateComment:
+			if _, err := b.impl.CreatePRComment(b.ctx, b.GitHub(), b.State, a.Body); err != nil {
+				return fmt.Errorf("creating comment: %w", err)
+			}
+		case ActionCreateCheckRun:
+			if a.Check == nil {
+				return errors.New("check run action without check data")
+			}
+			if err := b.impl.CreateCheckRun(b.ctx, b.GitHub(), b.State, a.Check); err != nil {
+				return fmt.Errorf("publishing check run %s: %w", a.Check.Name, err)
+			}
+		case ActionDeleteComment:
+			err := b.impl.DeletePRComment(b.ctx, b.GitHub(), a.CommentID)
+			if errors.Is(err, github.ErrNotFound) {
+				logrus.Infof("Comment %d was already deleted (NOOP)", a.CommentID)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("deleting comment %d: %w", a.CommentID, err)
+			}
+		default:
+			return fmt.Errorf("unknown action type %q", a.Type)
+		}
+	}
 	return nil
 }
 
+// reportAPIUsage logs how many API reads were saved by the read cache
+// and the quota left at the end of the run
+func (b *Broker) reportAPIUsage() {
+	if b.github == nil {
+		return
+	}
+	hits, misses := b.github.ReadCacheStats()
+	if hits+misses > 0 {
+		logrus.Infof("GitHub reads: %d API calls, %d answered from cache", misses, hits)
+	}
+	for _, limit := range b.github.RateLimits() {
+		logrus.Infof("GitHub rate limit %s", limit)
+	}
+}
+
+// ReportDryRun prints the actions the broker would have executed when
+// running in dry-run mode and appends them to the job step summary
+func (b *Broker) ReportDryRun() {
+	if b.github == nil || !b.github.DryRun() {
+		return
+	}
+	actions := b.github.PlannedActions()
+	logrus.Infof("Dry run: %d planned actions", len(actions))
+	for i, a := range actions {
+		logrus.Infof("%3d. %s", i+1, a)
+	}
+
+	summaryPath := os.Getenv("GITHUB_STEP_SUMMARY")
+	if summaryPath == "" {
+		return
+	}
+	summary := "## MiniProw dry run\n\n"
+	if len(actions) == 0 {
+		summary += "No changes would be made to GitHub.\n"
+	} else {
+		summary += "| # | Action | Target | Details |\n|---|---|---|---|\n"
+		for i, a := range actions {
+			target := a.Repo
+			if a.Number != 0 {
+				target += fmt.Sprintf("#%d", a.Number)
+			}
+			summary += fmt.Sprintf(
+				"| %d | %s | %s | %s |\n", i+1, a.Action, target,
+				strings.ReplaceAll(a.Detail, "|", "\\|"),
+			)
+		}
+	}
+	f, err := os.OpenFile(summaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.FileMode(0o644))
+	if err != nil {
+		logrus.Errorf("Unable to open step summary: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(summary); err != nil {
+		logrus.Errorf("Unable to write step summary: %v", err)
+	}
+}
+
 // GitHub returns a github object
 func (b *Broker) GitHub() *github.GitHub {
 	if b.github == nil {
@@ -160,53 +525,148 @@ func (b *Broker) RepoRoot() string {
 
 // LoadConfigFile reads the borker configuration from a file
 func (b *Broker) LoadConfigFile() error {
-	return b.impl.LoadConfigFile(b.ctx)
+	conf, err := b.impl.LoadConfigFile(b.ctx)
+	if err != nil {
+		return err
+	}
+	if conf != nil {
+		b.config = *conf
+	}
+	return nil
 }
 
 //counterfeiter:generate . brokerImplementation
 type brokerImplementation interface {
-	ReadContext() context.Context
-	ReadState(ctx context.Context) (*State, error)
+	ReadContext(context.Context) context.Context
+	ReadState(context.Context, *github.GitHub) (*State, error)
 	GetGitHub(context.Context) (*github.GitHub, error)
-	GetComment(*github.GitHub, string, int64) (*gogithub.IssueComment, error)
-	GetPullRequest(*github.GitHub, string, int) (*gogithub.PullRequest, error)
-	GetIssue(*github.GitHub, string, int) (*gogithub.Issue, error)
-	MergePullRequest(context.Context, *github.GitHub, string, int) error
-	GetRepoOwners(context.Context) (*owners.List, error)
+	GetComment(context.Context, *github.GitHub, string, int64) (*gogithub.IssueComment, error)
+	GetPullRequest(context.Context, *github.GitHub, string, int) (*gogithub.PullRequest, error)
+	GetPullRequestState(context.Context, *github.GitHub, string, int) (*github.PullRequestState, error)
+	GetIssue(context.Context, *github.GitHub, string, int) (*gogithub.Issue, error)
+	MergePullRequest(context.Context, *github.GitHub, string, int, string) error
+	EnableAutoMerge(context.Context, *github.GitHub, string, int) error
+	DisableAutoMerge(context.Context, *github.GitHub, string, int) error
+	EnqueuePullRequest(context.Context, *github.GitHub, string, int) error
+	DequeuePullRequest(context.Context, *github.GitHub, string, int) error
+	ListWorkflows(context.Context, *github.GitHub, string) ([]*gogithub.Workflow, error)
+	ListWorkflowRuns(context.Context, *github.GitHub, string, string) ([]*gogithub.WorkflowRun, error)
+	RerunFailedJobs(context.Context, *github.GitHub, string, int64) error
+	RerunWorkflow(context.Context, *github.GitHub, string, int64) error
+	RerunJob(context.Context, *github.GitHub, string, int64) error
+	DispatchWorkflow(context.Context, *github.GitHub, string, int64, string) error
+	IsCollaborator(context.Context, *github.GitHub, string, string) (bool, error)
+	ListOpenPullRequests(context.Context, *github.GitHub, string) ([]*gogithub.PullRequest, error)
+	GetRepoOwners(context.Context, map[string][]byte) (*owners.List, error)
 	AddLabel(context.Context, *github.GitHub, string) error
-	GetChangedFiles(context.Context, *github.GitHub) ([]*gogithub.CommitFile, error)
+	RemoveLabel(context.Context, *github.GitHub, string) error
+	GetRepoLabels(context.Context, *github.GitHub) ([]string, error)
+	GetChangedFiles(context.Context, *github.GitHub, *State) ([]*gogithub.CommitFile, error)
 	RepoRoot(context.Context) string
-	LoadConfigFile(context.Context) error
-	GetApprovers(context.Context, *github.GitHub) ([]string, []string, error)
-	GetMissingApprovers(context.Context, *github.GitHub, []string) ([]*fileApprovers, error)
-	GetNeededApprovers(context.Context, *github.GitHub) (*owners.List, error)
-	GetUserPerms(context.Context, string) (map[string]bool, error)
+	LoadConfigFile(context.Context) (*Config, error)
+	GetApprovers(context.Context, *github.GitHub, *State) ([]string, []string, error)
+	GetMissingApprovers(
+		context.Context, *github.GitHub, *State, []string, map[string][]byte,
+	) ([]*fileApprovers, error)
+	GetNeededApprovers(context.Context, *github.GitHub, *State, map[string][]byte) (*owners.List, error)
+	GetUserPerms(context.Context, string, map[string][]byte) (map[string]bool, error)
 	GetAuthor(s *State) string
 	GetPRCheckRuns(context.Context, *github.GitHub, *State) (*gogithub.ListCheckRunsResults, error)
+	GetPRStatuses(context.Context, *github.GitHub, *State) (*gogithub.CombinedStatus, error)
+	WaitForChecks(context.Context, *github.GitHub, *Config, string) (bool, error)
 	GetApprovalNotifierComment(context.Context, *github.GitHub, *State) (*gogithub.IssueComment, error)
 	GetBotUser(context.Context, *github.GitHub) (*gogithub.User, error)
 	IsApprovalNotifier(*gogithub.IssueComment, string) bool
 	CreatePRComment(context.Context, *github.GitHub, *State, string) (*gogithub.IssueComment, error)
 	DeletePRComment(context.Context, *github.GitHub, int64) error
+	GetFileContents(context.Context, *github.GitHub, string, string) ([]byte, error)
+	CreateCheckRun(context.Context, *github.GitHub, *State, *CheckResult) error
+}
+
+type defaultBrokerImplementation struct {
+	// indexes memoize the OWNERS files for the whole run. They are built
+	// on first use, one per pull request as the overlay depends on the
+	// OWNERS files it changes.
+	mu      sync.Mutex
+	indexes map[int]*owners.Index
 }
 
-type defaultBrokerImplementation struct{}
+// ownersIndex returns the index of the OWNERS files in the repository
+// as seen by a pull request
+func (bi *defaultBrokerImplementation) ownersIndex(
+	number int, repoRoot string, overlay map[string][]byte,
+) (*owners.Index, error) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	if index, ok := bi.indexes[number]; ok {
+		return index, nil
+	}
+	index, err := owners.NewReaderWithOptions(&owners.Options{Overlay: overlay}).BuildIndex(repoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("indexing OWNERS files: %w", err)
+	}
+	if bi.indexes == nil {
+		bi.indexes = map[int]*owners.Index{}
+	}
+	bi.indexes[number] = index
+	return index, nil
+}
+
+// changedFilesOwners returns the owners of each file changed in the PR
+func (bi *defaultBrokerImplementation) changedFilesOwners(
+	ctx context.Context, gh *github.GitHub, s *State, overlay map[string][]byte,
+) ([]*gogithub.CommitFile, []*owners.List, error) {
+	// Check the repository root before proceeding
+	repoRoot := bi.RepoRoot(ctx)
+	if repoRoot == "" {
+		return nil, nil, errors.New("unable to load missing approvers, reporoot not found")
+	}
+	files, err := bi.GetChangedFiles(ctx, gh, s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing pull request files: %w", err)
+	}
+	index, err := bi.ownersIndex(s.PullRequest.GetNumber(), repoRoot, overlay)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, filepath.Join(repoRoot, f.GetFilename()))
+	}
+	lists, err := index.GetPathsOwners(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, lists, nil
+}
 
 // GetGitHub gets a comment
 func (bi *defaultBrokerImplementation) GetGitHub(ctx context.Context) (*github.GitHub, error) {
 	if ctx.Value(ckey).(ContextData).GitHubToken() == "" {
 		return nil, errors.New("unable to get github client, token not found")
 	}
-	gh, err := github.NewWithToken(ctx.Value(ckey).(ContextData).GitHubToken())
+	gh, err := github.NewWithToken(
+		ctx.Value(ckey).(ContextData).GitHubToken(),
+		ctx.Value(ckey).(ContextData).APIURL(),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("creating github object: %w", err)
 	}
+	if retries := ctx.Value(ckey).(ContextData).GitHubRetries(); retries >= 0 {
+		gh.Options().MaxRetries = retries
+	}
+	if ctx.Value(ckey).(ContextData).DryRun() {
+		logrus.Info("Running in dry-run mode, no changes will be made to GitHub")
+		gh.EnableDryRun()
+	}
+	// A run reads the same data in several steps, fetch it only once
+	gh.EnableReadCache()
 	return gh, nil
 }
 
 // GetComment return a comment object from its id
 func (bi *defaultBrokerImplementation) GetComment(
-	gh *github.GitHub, slug string, commentID int64,
+	ctx context.Context, gh *github.GitHub, slug string, commentID int64,
 ) (comment *gogithub.IssueComment, err error) {
 	// Parse the slug
 	org, repo := github.ParseSlug(slug)
@@ -214,7 +674,7 @@ func (bi *defaultBrokerImplementation) GetComment(
 		return nil, errors.New("unable to get comment, repo slug not valid")
 	}
 	// Call the github api to get the comment
-	comment, err = gh.GetComment(org, repo, commentID)
+	comment, err = gh.GetComment(ctx, org, repo, commentID)
 	if err != nil {
 		return comment, fmt.Errorf("getting comment from github: %w", err)
 	}
@@ -223,7 +683,7 @@ func (bi *defaultBrokerImplementation) GetComment(
 
 // GetComment return a comment object from its id
 func (bi *defaultBrokerImplementation) GetPullRequest(
-	gh *github.GitHub, slug string, prID int,
+	ctx context.Context, gh *github.GitHub, slug string, prID int,
 ) (pr *gogithub.PullRequest, err error) {
 	// Parse the slug
 	org, repo := github.ParseSlug(slug)
@@ -231,16 +691,24 @@ func (bi *defaultBrokerImplementation) GetPullRequest(
 		return nil, errors.New("unable to get comment, repo slug not valid")
 	}
 	// Call the github api to get the comment
-	pr, err = gh.GetPullRequest(context.Background(), org, repo, prID)
+	pr, err = gh.GetPullRequest(ctx, org, repo, prID)
 	if err != nil {
 		return pr, fmt.Errorf("getting comment from github: %w", err)
 	}
 	return pr, nil
 }
 
+// GetPullRequestState loads a pull request with all the data needed to
+// decide on it in one GraphQL query
+func (bi *defaultBrokerImplementation) GetPullRequestState(
+	ctx context.Context, gh *github.GitHub, slug string, prID int,
+) (*github.PullRequestState, error) {
+	return gh.GetPullRequestState(ctx, slug, prID)
+}
+
 // GetComment return a comment object from its id
 func (bi *defaultBrokerImplementation) GetIssue(
-	gh *github.GitHub, slug string, issueID int,
+	ctx context.Context, gh *github.GitHub, slug string, issueID int,
 ) (pr *gogithub.Issue, err error) {
 	// Parse the slug
 	org, repo := github.ParseSlug(slug)
@@ -248,7 +716,7 @@ func (bi *defaultBrokerImplementation) GetIssue(
 		return nil, errors.New("unable to get comment, repo slug not valid")
 	}
 	// Call the github api to get the comment
-	pr, err = gh.GetIssue(context.Background(), org, repo, issueID)
+	pr, err = gh.GetIssue(ctx, org, repo, issueID)
 	if err != nil {
 		return pr, fmt.Errorf("getting comment from github: %w", err)
 	}
@@ -256,22 +724,21 @@ func (bi *defaultBrokerImplementation) GetIssue(
 }
 
 // ReadContext builds the context from the environment data
-func (bi *defaultBrokerImplementation) ReadContext() context.Context {
+func (bi *defaultBrokerImplementation) ReadContext(ctx context.Context) context.Context {
 	// Build the context we will use
-	return context.WithValue(context.Background(), ckey, NewContextData())
+	return context.WithValue(ctx, ckey, NewContextData())
 }
 
 // ReadState reads the state and buids the object
-func (bi *defaultBrokerImplementation) ReadState(ctx context.Context) (s *State, err error) {
+func (bi *defaultBrokerImplementation) ReadState(ctx context.Context, gh *github.GitHub) (s *State, err error) {
 	s = &State{}
-	gh, err := bi.GetGitHub(ctx)
-	if err != nil {
-		return s, fmt.Errorf("creating github client: %w", err)
+	if gh == nil {
+		return s, errors.New("unable to read state without a github client")
 	}
 
 	// Check if we are dealing with an issue and assign to state
 	if issueID := ctx.Value(ckey).(ContextData).Issue(); issueID != 0 {
-		issue, err := bi.GetIssue(gh, ctx.Value(ckey).(ContextData).Repository(), issueID)
+		issue, err := bi.GetIssue(ctx, gh, ctx.Value(ckey).(ContextData).Repository(), issueID)
 		if err != nil {
 			return nil, fmt.Errorf("reading issue to assign in state: %w", err)
 		}
@@ -281,16 +748,29 @@ func (bi *defaultBrokerImplementation) ReadState(ctx context.Context) (s *State,
 		s.Issue = issue
 	}
 
-	// or if we are dealing with a PR
+	// or if we are dealing with a PR. All its data is loaded at once
+	// with GraphQL, if that fails, it is read with REST calls as needed.
 	if prID := ctx.Value(ckey).(ContextData).PullRequest(); prID != 0 {
-		pr, err := bi.GetPullRequest(gh, ctx.Value(ckey).(ContextData).Repository(), prID)
-		if err != nil {
-			return nil, fmt.Errorf("fetching PR #%d: %w", prID, err)
+		prState, err := bi.GetPullRequestState(ctx, gh, ctx.Value(ckey).(ContextData).Repository(), prID)
+		if err == nil {
+			s.PullRequest = prState.PullRequest
+			s.Files = prState.Files
+			s.Comments = prState.Comments
+			s.Reviews = prState.Reviews
+			s.CheckRuns = prState.CheckRuns
+			s.Statuses = prState.Statuses
+		} else {
+			logrus.WithContext(ctx).WithField("step", "ReadState").Warnf(
+				"Unable to load PR #%d with GraphQL, using REST: %v", prID, err,
+			)
+			s.PullRequest, err = bi.GetPullRequest(ctx, gh, ctx.Value(ckey).(ContextData).Repository(), prID)
+			if err != nil {
+				return nil, fmt.Errorf("fetching PR #%d: %w", prID, err)
+			}
 		}
 		logrus.WithContext(ctx).WithField("step", "ReadState").Infof(
-			"Got Pull Request #%d from context", pr.GetNumber(),
+			"Got Pull Request #%d from context", s.PullRequest.GetNumber(),
 		)
-		s.PullRequest = pr
 	}
 
 	return s, nil
@@ -301,351 +781,122 @@ func (b *Broker) Author() string {
 	return b.impl.GetAuthor(b.State)
 }
 
-// HandleNewPR runs whena new PR is created
-func (b *Broker) HandleNewPR() error {
-	if b.ctx.Value(ckey).(ContextData).PullRequest() == 0 {
-		return errors.New("cannot start new PR handler, PR number not set")
-	}
-	logrus.Infof(
-		"New Pull Request handler running for PR ID#%d",
-		b.ctx.Value(ckey).(ContextData).PullRequest(),
-	)
-
-	// Get the PR author login
-	author := b.Author()
-	if author == "" {
-		return errors.New("unable to handle pr, could not get PR author handle")
-	}
-
-	// Get the current user top-level permissions
-	userPerms, err := b.impl.GetUserPerms(b.ctx, author)
-	if err != nil {
-		return fmt.Errorf("getting the PR author's permissions: %w", err)
-	}
-
-	// list of approvals needed:
-	neededApproves := owners.NewList()
-
-	// If the user is a top-level approver, we skip the
-	// file permission check
-	if userPerms["approver"] {
-		logrus.Info("Not checking individual files as user is a top level approver")
-	} else {
-		// Otherwise, we get the missing approvers. We need to check each file
-
-		// First, get the list of current approvers
-		neededApproves, err = b.impl.GetNeededApprovers(b.ctx, b.GitHub())
-		if err != nil {
-			return fmt.Errorf("getting current PR approvers: %w", err)
-		}
-	}
-
-	// if the user is an approver, we always add the label
-	// TODO(puerco): Mal, implementa
-	if userPerms["approver"] || len(neededApproves.Files) == 0 {
-		logrus.Infof("→ %s is an aprrover", author)
-		if err := b.impl.AddLabel(b.ctx, b.GitHub(), "approved"); err != nil {
-			return fmt.Errorf("adding approved label: %w", err)
-		}
-	}
-
-	// Now, if the user is a reviewer...
-	if userPerms["reviewer"] {
-		logrus.Infof("→ %s is a reviewer", author)
-		// ... and also an approver *and* we have automerge on
-		if userPerms["approver"] && b.config.options.AutoMerge {
-			if err := b.impl.AddLabel(b.ctx, b.GitHub(), "lgtm"); err != nil {
-				return fmt.Errorf("adding approved lgtm: %w", err)
-			}
-		}
-	}
-
-	if !userPerms["reviewer"] && !userPerms["approver"] {
-		logrus.Infof("User %s is not an approver nor a reviewer", author)
-	}
-
-	if err := b.CreateApprovalNotifierComment(); err != nil {
-		return fmt.Errorf("creating approval notifier: %w", err)
-	}
-	return nil
-}
-
-func (b *Broker) CheckMerge() error {
-	// 1, Check the comment and the check runs to se if we need to
-	// add the comment again
-	checksReady, err := b.ChecksReadyToMerge()
-	if err != nil {
-		return fmt.Errorf("looking at pull request check runs: %w", err)
-	}
-
-	// 2. Check the labels are ready
-	labelsReady, err := b.LabelsReadyToMerge()
-	if err != nil {
-		return fmt.Errorf("looking at pull request labels: %w", err)
-	}
-
-	// We need the labels and the checks to merge
-	if !labelsReady && !checksReady {
-		missing := []string{}
-		if !labelsReady {
-			missing = append(missing, "labels")
-		}
-		if !checksReady {
-			missing = append(missing, "checks")
-		}
-		logrus.Infof("Pull request not yet ready to merge. Has missing: %s", strings.Join(missing, ","))
-	}
-
-	// Merge PR → → → →
-	if err := b.MergePullRequest(); err != nil {
-		return fmt.Errorf("merging pull request: %w", err)
-	}
-	return nil
-}
-
-// Handles a new comment posted
-func (b *Broker) HandleComment() error {
-	logrus.Infof(
-		"💬 Comment handler running for comment ID#%d",
-		b.ctx.Value(ckey).(ContextData).CommentID(),
-	)
-	gh, err := b.impl.GetGitHub(b.ctx)
-	if err != nil {
-		return fmt.Errorf("creating github object: %w", err)
-	}
-
-	// Get the comment
-	comment, err := b.impl.GetComment(
-		gh, b.ctx.Value(ckey).(ContextData).Repository(),
-		b.ctx.Value(ckey).(ContextData).CommentID(),
-	)
-	if err != nil {
-		return fmt.Errorf("getting comment from github: %w", err)
-	}
-
-	// The comment is the APPROVALNOTIFIER comment, handle it now
-	// as we know this comment does not have any flags.
-	//
-	// To determine if its the real deal, we look for the flag *and*
-	// check it is comming from the bot account:
-	botuser, err := b.impl.GetBotUser(b.ctx, b.GitHub())
-	if err != nil {
-		return fmt.Errorf("getting bot user: %w", err)
-	}
-	if b.impl.IsApprovalNotifier(comment, botuser.GetLogin()) {
-		logrus.Info(" > Event triggered by Approval Notifier Comment")
-		return b.HandleApprovalNotifierComment(comment)
-	}
-
-	logrus.Info(" > Performing comment analysis")
-
-	// Extract the slash commands from the comment body
-	logrus.WithField("handler", "comment handler").Infof(
-		" > Comment body: %s", strings.TrimSpace(comment.GetBody()),
-	)
-	commands, err := ParseSlashCommands(strings.TrimSpace(comment.GetBody()))
-	if err != nil {
-		return fmt.Errorf("parsing commands: %w", err)
-	}
-
-	logrus.WithField("handler", "comment handler").Infof(
-		"Found %d slash commands in the comment data", len(commands),
-	)
-	var combinedError error
-
-	// Cycle all commands found and run them
-	for _, cmd := range commands {
-		if err := cmd.Run(b); err != nil {
-			if combinedError == nil {
-				combinedError = errors.New("errors while running handlers")
-			}
-			combinedError = fmt.Errorf(combinedError.Error(), err)
+// checksVerdict computes if a set of check runs and commit statuses
+// allow the PR to merge according to the required and ignored checks
+func (c *Config) checksVerdict(runs []*gogithub.CheckRun, statuses []*gogithub.RepoStatus) bool {
+	seen := map[string]struct{}{}
+	failedJobs := []string{}
+	for _, run := range runs {
+		if c.IsCheckIgnored(run.GetName()) {
+			logrus.Infof(" > ignoring check %s", run.GetName())
+			continue
 		}
-	}
-
-	if combinedError != nil {
-		return combinedError
-	}
-
-	// Check if we can merge after the slash commands
-	if err := b.CreateApprovalNotifierComment(); err != nil {
-		return fmt.Errorf("creating ANC after slash commands: %w", err)
-	}
-	return nil
-}
+		seen[run.GetName()] = struct{}{}
 
-// ChecksReadyToMerge returns true if the checks in a PR are ready to merge
-func (b *Broker) ChecksReadyToMerge() (ready bool, err error) {
-	logrus.Info("🔍 Verifying checks have completed and are successful")
-	// Get the latest runs in the pull request
-	checkruns, err := b.impl.GetPRCheckRuns(b.ctx, b.GitHub(), b.State)
-	if err != nil {
-		return ready, fmt.Errorf("getting check runs for pull request: %w", err)
-	}
-
-	failedJobs := []*gogithub.CheckRun{}
-	for _, run := range checkruns.CheckRuns {
 		// If we have at least one check still running, we do not
 		// continue checking as we are sure we cannot merge
 		if run.GetStatus() != "completed" {
 			logrus.Infof(" > check %s has not yet completed", run.GetName())
-			return false, nil
+			return false
 		}
 
 		// Count the jobs that are failing
 		if run.GetConclusion() != "success" {
 			logrus.Infof(" > last run of %s failed", run.GetName())
-			failedJobs = append(failedJobs, run)
+			failedJobs = append(failedJobs, run.GetName())
+		}
+	}
+
+	for _, status := range statuses {
+		if c.IsCheckIgnored(status.GetContext()) {
+			logrus.Infof(" > ignoring status %s", status.GetContext())
+			continue
+		}
+		seen[status.GetContext()] = struct{}{}
+
+		switch status.GetState() {
+		case "success":
+		case "pending":
+			logrus.Infof(" > status %s is still pending", status.GetContext())
+			return false
+		default:
+			logrus.Infof(" > status %s reported %s", status.GetContext(), status.GetState())
+			failedJobs = append(failedJobs, status.GetContext())
+		}
+	}
+
+	// Required checks have to be present, if not, they may not have started
+	for _, name := range c.RequiredChecks() {
+		if _, ok := seen[name]; !ok {
+			logrus.Infof(" > required check %s has not reported yet", name)
+			return false
 		}
 	}
 
 	// If we have failed jobs, we cannot merge just now
 	if len(failedJobs) > 0 {
 		logrus.Infof("❌ %d jobs are failing. Cannot merge just now.", len(failedJobs))
-		return false, nil
+		return false
 	}
 
 	logrus.Info(" ✅ CI Tests are green")
-	return true, nil
-}
-
-// CreateTestsDoneComment creates a comment to notify that tests are done
-func (b *Broker) CreateTestsDoneComment() error {
-	if _, err := b.impl.CreatePRComment(b.ctx, b.GitHub(), b.State, "/"+TestsDoneCommand); err != nil {
-		return fmt.Errorf("creating approval notifier comment: %w", err)
-	}
-	return nil
+	return true
 }
 
-// CreateApprovalNotifierComment posts a new comment in the PullRequest
-// witht he special flag to handle the merging of the PRs
-func (b *Broker) CreateApprovalNotifierComment() error {
-	// First, check if the comment exists already and delete it
-	comment, err := b.impl.GetApprovalNotifierComment(b.ctx, b.GitHub(), b.State)
-	if err != nil {
-		return fmt.Errorf("while lookig for the approve notifier comment: %w", err)
-	}
-
-	repoRoot := b.RepoRoot()
-	if repoRoot == "" {
-		return fmt.Errorf("could not get repo root: %w", err)
-	}
-
-	// Get the list of needed approvers
-	neededApprovers, err := b.impl.GetNeededApprovers(b.ctx, b.GitHub())
-	if err != nil {
-		return fmt.Errorf("getting current PR approvers: %w", err)
-	}
-
-	if len(neededApprovers.Approvers) == 0 {
-		return errors.New("no approvers were found. Missing OWNERS file(s)?")
-	}
-
-	// Get the approvers and reviewers
-	approvers, _, err := b.impl.GetApprovers(b.ctx, b.GitHub())
-	if err != nil {
-		return fmt.Errorf("while getting current PR approvers: %w", err)
-	}
-	approvers = append(approvers, b.impl.GetAuthor(b.State))
-
-	// TODO(puerco): Implement suggestions here
-	suggestedAssignees := []string{}
-
-	// If the comment exists already delete it
-	if comment != nil {
-		if err := b.impl.DeletePRComment(b.ctx, b.GitHub(), comment.GetID()); err != nil {
-			return fmt.Errorf("deleting previous approval notifier: %w", err)
-		}
+// flakyFailures returns the failed check runs that are retried when the
+// only failures are flaky checks and the rest of the checks passed.
+// Commit statuses cannot be re-run, a failed status is never retried.
+func (c *Config) flakyFailures(runs []*gogithub.CheckRun, statuses []*gogithub.RepoStatus) []*gogithub.CheckRun {
+	if len(c.flakyChecks) == 0 || c.checksPending(runs, statuses) {
+		return nil
 	}
-
-	commentBody := "[" + approvalNotifierFlag + "] This PR is __NOT APPROVED__\n\n\n"
-	commentBody += "This pull-request has been approved by: *" + strings.Join(approvers, ", ") + "*\n"
-	commentBody += "To complete the pull request process, please assign " + strings.Join(suggestedAssignees, ",")
-	commentBody += " after the PR has been reviewed.\n"
-	commentBody += "You can assign the PR to them by writing `/assign "
-	commentBody += strings.Join(suggestedAssignees, ",") + "` in a comment when ready.\n\n"
-
-	commentBody += "The full list of commands accepted by this bot can be found [here](http://undercons.com/).\n\n"
-	// TODO: Check if all are approved and do not open details
-	commentBody += "<details open>\n"
-	commentBody += "Needs approval from an approver in each of these files:\n\n"
-	for _, ofile := range neededApprovers.Files {
-		fileApprovers := ofile.WhoCanApprove(approvers)
-		mkup := "**"
-		if len(fileApprovers) > 0 {
-			mkup = "~~"
+	failed := []*gogithub.CheckRun{}
+	for _, run := range runs {
+		if c.IsCheckIgnored(run.GetName()) || run.GetConclusion() == "success" {
+			continue
 		}
-
-		commentBody += fmt.Sprintf(
-			"- %s[%s](%s)%s", mkup,
-			strings.TrimPrefix(ofile.Path, repoRoot),
-			"http://github.com/", mkup,
-		)
-
-		if len(fileApprovers) > 0 {
-			commentBody += " [" + strings.Join(fileApprovers, ",") + "]"
+		if !c.IsCheckFlaky(run.GetName()) {
+			logrus.Infof(" > check %s failed and it is not flaky, not retrying", run.GetName())
+			return nil
 		}
-
-		commentBody += "\n"
+		failed = append(failed, run)
 	}
-
-	commentBody += "\n"
-
-	commentBody += "Approvers can indicate their approval by writing /approve in a comment\n\n"
-	commentBody += "Approvers can cancel approval by writing /approve cancel in a comment\n"
-	commentBody += "</details>"
-	// Post the new comment to the pull request
-	_, err = b.impl.CreatePRComment(b.ctx, b.GitHub(), b.State, commentBody)
-	if err != nil {
-		return fmt.Errorf("creating approval notifier comment: %w", err)
+	for _, status := range statuses {
+		if !c.IsCheckIgnored(status.GetContext()) && status.GetState() != "success" {
+			return nil
+		}
 	}
-
-	return nil
+	return failed
 }
 
-// HandleApprovalNotifierComment reacts on the approval comment
-// ti check if tests are ready and flags are in place
-func (b *Broker) HandleApprovalNotifierComment(comment *gogithub.IssueComment) error {
-	// 1, Check the comment and the check runs to se if we need to
-	// add the comment again
-	checksReady, err := b.ChecksReadyToMerge()
-	if err != nil {
-		return fmt.Errorf("looking at pull request check runs: %w", err)
-	}
-
-	// 2. Check the labels are ready
-	labelsReady, err := b.LabelsReadyToMerge()
-	if err != nil {
-		return fmt.Errorf("looking at pull request labels: %w", err)
-	}
-
-	// We need the labels and the checks to merge
-	if !labelsReady || !checksReady {
-		missing := []string{}
-		if !labelsReady {
-			missing = append(missing, "labels")
+// checksPending returns true if the checks have not finished: there are
+// no checks yet, some are running or required checks have not reported
+func (c *Config) checksPending(runs []*gogithub.CheckRun, statuses []*gogithub.RepoStatus) bool {
+	seen := map[string]struct{}{}
+	for _, run := range runs {
+		if c.IsCheckIgnored(run.GetName()) {
+			continue
 		}
-		if !checksReady {
-			missing = append(missing, "checks")
+		seen[run.GetName()] = struct{}{}
+		if run.GetStatus() != "completed" {
+			return true
 		}
-		logrus.Infof(
-			"⏳ Not merging as pull request is not yet ready. Has missing: %s",
-			strings.Join(missing, ","),
-		)
-		return nil
 	}
-
-	// Merge PR → → → →
-	if err := b.MergePullRequest(); err != nil {
-		return fmt.Errorf("merging pull request: %w", err)
+	for _, status := range statuses {
+		if c.IsCheckIgnored(status.GetContext()) {
+			continue
+		}
+		seen[status.GetContext()] = struct{}{}
+		if status.GetState() == "pending" {
+			return true
+		}
 	}
-
-	// If PR has merged, delete the approval notifier
-	if err := b.impl.DeletePRComment(b.ctx, b.GitHub(), comment.GetID()); err != nil {
-		logrus.Error("error deleting the approval notifier comment")
+	for _, name := range c.RequiredChecks() {
+		if _, ok := seen[name]; !ok {
+			return true
+		}
 	}
-
-	return nil
+	return len(seen) == 0
 }
 
 // VerifyChecks checks if all tests are green
@@ -684,6 +935,11 @@ func (bi *defaultBrokerImplementation) GetPRCheckRuns(
 	if state.PullRequest == nil {
 		return nil, errors.New("no pr found in state")
 	}
+	if state.CheckRuns != nil {
+		return &gogithub.ListCheckRunsResults{
+			Total: gogithub.Int(len(state.CheckRuns)), CheckRuns: state.CheckRuns,
+		}, nil
+	}
 	runs, err := gh.ListCheckRunsForRef(
 		ctx, ctx.Value(ckey).(ContextData).Repository(),
 		state.PullRequest.GetHead().GetSHA(),
@@ -697,63 +953,72 @@ func (bi *defaultBrokerImplementation) GetPRCheckRuns(
 	return runs, nil
 }
 
-// LabelsReadyToMerge checks if the PR is ready to merge
-func (b *Broker) LabelsReadyToMerge() (ready bool, err error) {
-	logrus.Infof("🔍 checking if PR %d can merge", b.ctx.Value(ckey).(ContextData).PullRequest())
-	// Fetch the pull request from GitHub
-	pr, err := b.impl.GetPullRequest(
-		b.GitHub(),
-		b.ctx.Value(ckey).(ContextData).Repository(),
-		b.ctx.Value(ckey).(ContextData).PullRequest(),
+// GetPRStatuses returns the combined commit status of the PR head
+func (bi *defaultBrokerImplementation) GetPRStatuses(
+	ctx context.Context, gh *github.GitHub, state *State,
+) (*gogithub.CombinedStatus, error) {
+	if state.PullRequest == nil {
+		return nil, errors.New("no pr found in state")
+	}
+	if state.Statuses != nil {
+		return &gogithub.CombinedStatus{
+			SHA:        state.PullRequest.GetHead().SHA,
+			TotalCount: gogithub.Int(len(state.Statuses)),
+			Statuses:   state.Statuses,
+		}, nil
+	}
+	status, err := gh.GetCombinedStatus(
+		ctx, ctx.Value(ckey).(ContextData).Repository(),
+		state.PullRequest.GetHead().GetSHA(),
 	)
 	if err != nil {
-		return ready, fmt.Errorf("fetching PR from GitHub: %w", err)
+		return nil, fmt.Errorf(
+			"getting commit statuses for PR %d: %w",
+			state.PullRequest.GetNumber(), err,
+		)
 	}
+	return status, nil
+}
 
-	// Check that the required labels are set
-	missingLabels := []string{}
-	logrus.Infof("PR requires the following labels: %s", strings.Join(b.config.RequiredLabels(), ", "))
-
-RequiredLoop:
-	for _, expected := range b.config.requiredLabels {
-		for _, l := range pr.Labels {
-			if expected == l.GetName() {
-				logrus.Infof(" > found label %s", l.GetName())
-				continue RequiredLoop
-			}
+// WaitForChecks polls the checks of a commit until all of them finish
+// and returns if they passed. It fails if they do not finish before the
+// merge pool timeout in the config.
+func (bi *defaultBrokerImplementation) WaitForChecks(
+	ctx context.Context, gh *github.GitHub, conf *Config, sha string,
+) (bool, error) {
+	slug := ctx.Value(ckey).(ContextData).Repository()
+	ctx, cancel := context.WithTimeout(ctx, conf.MergePool().CheckTimeout)
+	defer cancel()
+	for {
+		// The checks change while waiting, they cannot be cached
+		gh.ResetReadCache()
+		runs, err := gh.ListCheckRunsForRef(ctx, slug, sha)
+		if err != nil {
+			return false, fmt.Errorf("getting check runs of %s: %w", sha, err)
+		}
+		status, err := gh.GetCombinedStatus(ctx, slug, sha)
+		if err != nil {
+			return false, fmt.Errorf("getting commit statuses of %s: %w", sha, err)
+		}
+		if !conf.checksPending(runs.CheckRuns, status.Statuses) {
+			return conf.checksVerdict(runs.CheckRuns, status.Statuses), nil
+		}
+		logrus.Infof("Waiting for the checks of %s", sha)
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("waiting for the checks of %s: %w", sha, ctx.Err())
+		case <-time.After(checksPollInterval):
 		}
-		missingLabels = append(missingLabels, expected)
-	}
-	if len(missingLabels) > 0 {
-		errMsg := fmt.Sprintf("cannot merge PR #%d, has missing labels: %s",
-			pr.GetNumber(), strings.Join(missingLabels, ", "))
-		logrus.Info("❌ " + errMsg)
-		return false, errors.New(errMsg)
-	}
-
-	if pr.GetMerged() {
-		errMsg := fmt.Sprintf("PR #%d, is already merged", pr.GetNumber())
-		logrus.Info("❌ " + errMsg)
-		return false, errors.New(errMsg)
-	}
-
-	if !pr.GetMergeable() {
-		errMsg := fmt.Sprintf("github reports that PR #%d, cannot merge yet", pr.GetNumber())
-		logrus.Info("❌ " + errMsg)
-		return false, errors.New(errMsg)
 	}
-
-	logrus.Infof("✅ Pull Request #%d has all labels required to merge", pr.GetNumber())
-	return true, nil
 }
 
 // ReadContext reads the environment and assigns the data to the context
-func (b *Broker) ReadContext() {
-	b.ctx = b.impl.ReadContext()
+func (b *Broker) ReadContext(ctx context.Context) {
+	b.ctx = b.impl.ReadContext(ctx)
 }
 
 func (b *Broker) InitState() error {
-	s, err := b.impl.ReadState(b.ctx)
+	s, err := b.impl.ReadState(b.ctx, b.GitHub())
 	if err != nil {
 		return fmt.Errorf("initializing state: %w", err)
 	}
@@ -761,53 +1026,194 @@ func (b *Broker) InitState() error {
 	return nil
 }
 
-// MergePullRequest merges the pull request
-func (b *Broker) MergePullRequest() error {
+// MergePullRequest merges the pull request and returns true if GitHub
+// merged it
+func (b *Broker) MergePullRequest() (bool, error) {
+	return b.mergePullRequest(true)
+}
+
+// mergePullRequest merges the pull request and returns true if GitHub
+// merged it. Pull requests GitHub refuses to merge are not an error.
+// When the merge pool is on and checkBase is true, pull requests whose
+// base branch moved since they were tested are left to the pool.
+func (b *Broker) mergePullRequest(checkBase bool) (bool, error) {
 	logrus.Infof("🏁 merging Pull Request #%d", b.ctx.Value(ckey).(ContextData).PullRequest())
 	// Fetch the pull request from GitHub
 	pr, err := b.impl.GetPullRequest(
-		b.GitHub(),
+		b.ctx, b.GitHub(),
 		b.ctx.Value(ckey).(ContextData).Repository(),
 		b.ctx.Value(ckey).(ContextData).PullRequest(),
 	)
 	if err != nil {
-		return fmt.Errorf("fetching PR from GitHub: %w", err)
+		return false, fmt.Errorf("fetching PR from GitHub: %w", err)
 	}
 
 	if pr.GetMerged() {
 		logrus.Warnf("PR %d is already merged! (NOOP)", pr.GetNumber())
-		return nil
+		return false, nil
 	}
 
-	if err := b.impl.MergePullRequest(
+	if checkBase && b.config.MergePool().Mode != MergePoolOff {
+		current, err := b.testedOnCurrentBase(pr)
+		if err != nil {
+			return false, fmt.Errorf("checking the base branch: %w", err)
+		}
+		if !current {
+			logrus.Infof(
+				"⏳ Base branch %s moved since PR #%d was tested, the merge pool will test it again",
+				pr.GetBase().GetRef(), pr.GetNumber(),
+			)
+			return false, nil
+		}
+	}
+
+	// Only the head that was evaluated, and tested by the pool, is merged
+	err = b.impl.MergePullRequest(
 		b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), pr.GetNumber(),
-	); err != nil {
-		return fmt.Errorf("merging pull request: %w", err)
+		b.State.PullRequest.GetHead().GetSHA(),
+	)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, github.ErrConflict):
+		// The head moved while merging, the new commits will trigger a new run
+		logrus.Warnf("PR #%d head was modified while merging, not merging: %v", pr.GetNumber(), err)
+		return false, nil
+	case errors.Is(err, github.ErrNotMergeable):
+		logrus.Warnf("GitHub refused to merge PR #%d: %v", pr.GetNumber(), err)
+		if _, err := b.impl.CreatePRComment(b.ctx, b.GitHub(), b.State, notMergeableMessage); err != nil {
+			return false, fmt.Errorf("notifying PR is not mergeable: %w", err)
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("merging pull request: %w", err)
 	}
-	return nil
 }
 
-// MergePullRequest calls the GH API to merge the PR
+// EnableAutoMerge enables auto-merge in the pull request. GitHub does
+// not accept it when the pull request can be merged right away, then it
+// is merged directly.
+func (b *Broker) EnableAutoMerge() error {
+	number := b.ctx.Value(ckey).(ContextData).PullRequest()
+	err := b.impl.EnableAutoMerge(b.ctx, b.GitHub(), b.ctx.Value(ckey).(ContextData).Repository(), number)
+	if errors.Is(err, github.ErrCleanStatus) {
+		logrus.Infof("PR #%d can be merged now, merging it instead of enabling auto-merge", number)
+		_, err := b.MergePullRequest()
+		return err
+	}
+	return err
+}
+
+// MergePullRequest calls the GH API to merge the PR if its head is sha
 func (bi *defaultBrokerImplementation) MergePullRequest(
-	ctx context.Context, gh *github.GitHub, repoSlug string, prID int,
+	ctx context.Context, gh *github.GitHub, repoSlug string, prID int, sha string,
 ) error {
 	org, repo := github.ParseSlug(repoSlug)
 	if org == "" || repo == "" {
 		return errors.New("unable to get comment, repo slug not valid")
 	}
-	return gh.MergePullRequest(ctx, org, repo, prID)
+	return gh.MergePullRequest(ctx, org, repo, prID, sha)
 }
 
-// GetRepoOwners gets the owners from the top OWNERS file
+// EnableAutoMerge enables the GitHub auto-merge in the pull request
+func (bi *defaultBrokerImplementation) EnableAutoMerge(
+	ctx context.Context, gh *github.GitHub, repoSlug string, prID int,
+) error {
+	return gh.EnableAutoMerge(ctx, repoSlug, prID)
+}
+
+// DisableAutoMerge disables the GitHub auto-merge in the pull request
+func (bi *defaultBrokerImplementation) DisableAutoMerge(
+	ctx context.Context, gh *github.GitHub, repoSlug string, prID int,
+) error {
+	return gh.DisableAutoMerge(ctx, repoSlug, prID)
+}
+
+// EnqueuePullRequest adds the pull request to the merge queue
+func (bi *defaultBrokerImplementation) EnqueuePullRequest(
+	ctx context.Context, gh *github.GitHub, repoSlug string, prID int,
+) error {
+	return gh.EnqueuePullRequest(ctx, repoSlug, prID)
+}
+
+// DequeuePullRequest removes the pull request from the merge queue
+func (bi *defaultBrokerImplementation) DequeuePullRequest(
+	ctx context.Context, gh *github.GitHub, repoSlug string, prID int,
+) error {
+	return gh.DequeuePullRequest(ctx, repoSlug, prID)
+}
+
+// ListWorkflows returns the GitHub Actions workflows of the repository
+func (bi *defaultBrokerImplementation) ListWorkflows(
+	ctx context.Context, gh *github.GitHub, repoSlug string,
+) ([]*gogithub.Workflow, error) {
+	return gh.ListWorkflows(ctx, repoSlug)
+}
+
+// ListWorkflowRuns returns the latest run of each workflow for a commit
+func (bi *defaultBrokerImplementation) ListWorkflowRuns(
+	ctx context.Context, gh *github.GitHub, repoSlug, sha string,
+) ([]*gogithub.WorkflowRun, error) {
+	return gh.ListWorkflowRunsForSHA(ctx, repoSlug, sha)
+}
+
+// RerunFailedJobs re-runs the failed jobs of a workflow run
+func (bi *defaultBrokerImplementation) RerunFailedJobs(
+	ctx context.Context, gh *github.GitHub, repoSlug string, runID int64,
+) error {
+	return gh.RerunFailedJobs(ctx, repoSlug, runID)
+}
+
+// RerunWorkflow re-runs all the jobs of a workflow run
+func (bi *defaultBrokerImplementation) RerunWorkflow(
+	ctx context.Context, gh *github.GitHub, repoSlug string, runID int64,
+) error {
+	return gh.RerunWorkflow(ctx, repoSlug, runID)
+}
+
+// RerunJob re-runs a job of a workflow run, the ID of its check run
+func (bi *defaultBrokerImplementation) RerunJob(
+	ctx context.Context, gh *github.GitHub, repoSlug string, jobID int64,
+) error {
+	return gh.RerunJob(ctx, repoSlug, jobID)
+}
+
+// DispatchWorkflow starts a workflow run on a branch
+func (bi *defaultBrokerImplementation) DispatchWorkflow(
+	ctx context.Context, gh *github.GitHub, repoSlug string, workflowID int64, ref string,
+) error {
+	return gh.DispatchWorkflow(ctx, repoSlug, workflowID, ref)
+}
+
+// IsCollaborator returns true if user is a collaborator of the repository
+func (bi *defaultBrokerImplementation) IsCollaborator(
+	ctx context.Context, gh *github.GitHub, repoSlug, user string,
+) (bool, error) {
+	return gh.IsCollaborator(ctx, repoSlug, user)
+}
+
+// ListOpenPullRequests returns the open pull requests of a repository
+func (bi *defaultBrokerImplementation) ListOpenPullRequests(
+	ctx context.Context, gh *github.GitHub, repoSlug string,
+) ([]*gogithub.PullRequest, error) {
+	prs, err := gh.ListOpenPullRequests(ctx, repoSlug)
+	if err != nil {
+		return nil, fmt.Errorf("listing open pull requests: %w", err)
+	}
+	return prs, nil
+}
+
+// GetRepoOwners gets the owners from the top OWNERS file. The overlay
+// replaces the files in the checkout, see owners.Options.
 func (bi *defaultBrokerImplementation) GetRepoOwners(
-	ctx context.Context,
+	ctx context.Context, overlay map[string][]byte,
 ) (list *owners.List, err error) {
 	repoRoot := bi.RepoRoot(ctx)
 	if repoRoot == "" {
 		return list, errors.New("unable to load config, reporoot not found")
 	}
 	// There must be a better way to find the cloned repo
-	reader := owners.NewReader()
+	reader := owners.NewReaderWithOptions(&owners.Options{Overlay: overlay})
 	list, err = reader.GetDirectoryOwners(repoRoot)
 	if err != nil {
 		return list, fmt.Errorf("reading top repository OWNERS file: %w", err)
@@ -825,21 +1231,65 @@ func (bi *defaultBrokerImplementation) AddLabel(
 	}
 	issueID := ctx.Value(ckey).(ContextData).PullRequest()
 	if issueID == 0 {
-		ctx.Value(ckey).(ContextData).Issue()
+		issueID = ctx.Value(ckey).(ContextData).Issue()
 	}
 	if issueID == 0 {
 		return errors.New("unable to add label, cannot find issue or pr number in context")
 	}
 	logrus.Infof("Adding label %s to issue #%d", labelName, issueID)
-	if err := gh.AddLabel(org, repo, issueID, labelName); err != nil {
+	if err := gh.AddLabel(ctx, org, repo, issueID, labelName); err != nil {
 		return fmt.Errorf("adding label to #%d: %w", issueID, err)
 	}
 	return nil
 }
 
+// RemoveLabel removes a label from the current issue/PR
+func (bi *defaultBrokerImplementation) RemoveLabel(
+	ctx context.Context, gh *github.GitHub, labelName string,
+) error {
+	org, repo := github.ParseSlug(ctx.Value(ckey).(ContextData).Repository())
+	if org == "" || repo == "" {
+		return errors.New("unable to remove label, repo slug not valid")
+	}
+	issueID := ctx.Value(ckey).(ContextData).PullRequest()
+	if issueID == 0 {
+		issueID = ctx.Value(ckey).(ContextData).Issue()
+	}
+	if issueID == 0 {
+		return errors.New("unable to remove label, cannot find issue or pr number in context")
+	}
+	logrus.Infof("Removing label %s from issue #%d", labelName, issueID)
+	if err := gh.RemoveLabel(ctx, org, repo, issueID, labelName); err != nil {
+		return fmt.Errorf("removing label from #%d: %w", issueID, err)
+	}
+	return nil
+}
+
+// GetRepoLabels returns the names of the labels defined in the repository
+func (bi *defaultBrokerImplementation) GetRepoLabels(
+	ctx context.Context, gh *github.GitHub,
+) ([]string, error) {
+	org, repo := github.ParseSlug(ctx.Value(ckey).(ContextData).Repository())
+	if org == "" || repo == "" {
+		return nil, errors.New("unable to list labels, repo slug not valid")
+	}
+	labels, err := gh.ListLabels(ctx, org, repo)
+	if err != nil {
+		return nil, fmt.Errorf("listing labels: %w", err)
+	}
+	names := []string{}
+	for _, l := range labels {
+		names = append(names, l.GetName())
+	}
+	return names, nil
+}
+
 // GetChangedFiles returns a list of the changed files in the current PR
-func (bi *defaultBrokerImplementation) GetChangedFiles(ctx context.Context, gh *github.GitHub,
+func (bi *defaultBrokerImplementation) GetChangedFiles(ctx context.Context, gh *github.GitHub, s *State,
 ) (files []*gogithub.CommitFile, err error) {
+	if s != nil && s.Files != nil {
+		return s.Files, nil
+	}
 	// Get the modified files
 	files, err = gh.ListPullRequestFiles(ctx,
 		ctx.Value(ckey).(ContextData).Repository(),
@@ -871,20 +1321,24 @@ func (bi *defaultBrokerImplementation) RepoRoot(ctx context.Context) string {
 	return root
 }
 
-// LoadConfigFile loads a conf file from the miniprow directory
-func (bi *defaultBrokerImplementation) LoadConfigFile(ctx context.Context) error {
+// LoadConfigFile loads a conf file from the miniprow directory. If
+// the repository has no config file, it returns nil.
+func (bi *defaultBrokerImplementation) LoadConfigFile(ctx context.Context) (*Config, error) {
 	repoRoot := bi.RepoRoot(ctx)
 	if repoRoot == "" {
-		return errors.New("unable to load config, repo root not found")
+		return nil, errors.New("unable to load config, repo root not found")
 	}
-	confpath := filepath.Join(repoRoot, MiniProwDir, MiniProwDir)
-	logrus.Warn("Config file load not yet implemented")
-	if util.Exists(confpath) {
-		logrus.Info("Loading configuration file from " + confpath)
-	} else {
+	confpath := filepath.Join(repoRoot, MiniProwDir, MiniProwConf)
+	if !util.Exists(confpath) {
 		logrus.Warn("No configuration file found. Using default values")
+		return nil, nil
 	}
-	return nil
+	logrus.Info("Loading configuration file from " + confpath)
+	conf, err := ParseConfigFile(confpath)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", confpath, err)
+	}
+	return conf, nil
 }
 
 // fileApprovers is a type that binds a filename and its owners
@@ -894,12 +1348,12 @@ type fileApprovers struct {
 }
 
 func (bi *defaultBrokerImplementation) GetMissingApprovers(
-	ctx context.Context, gh *github.GitHub, currentApprovers []string,
+	ctx context.Context, gh *github.GitHub, s *State, currentApprovers []string, overlay map[string][]byte,
 ) (approvals []*fileApprovers, err error) {
 	// Check the files modified by the PR
-	files, err := bi.GetChangedFiles(ctx, gh)
+	files, lists, err := bi.changedFilesOwners(ctx, gh, s, overlay)
 	if err != nil {
-		return approvals, fmt.Errorf("listing pull request files: %w", err)
+		return nil, err
 	}
 
 	// Build a revers lookup map
@@ -909,29 +1363,12 @@ func (bi *defaultBrokerImplementation) GetMissingApprovers(
 	}
 
 	approvals = []*fileApprovers{}
-	repoRoot := bi.RepoRoot(ctx)
-	if repoRoot == "" {
-		return nil, errors.New("unable to load missing approvers, reporoot not found")
-	}
-
-	reader := owners.NewReader()
-
-	// Range the files in the PR and get the owners
-	for _, f := range files {
-		logrus.Infof(" > Checking File: %s", f.GetFilename())
-
-		ownerList, err := reader.GetPathOwners(
-			filepath.Join(repoRoot, f.GetFilename()),
-		)
-		if err != nil {
-			return approvals, fmt.Errorf("getting owners from %s: %w", f, err)
-		}
-
+	for i, f := range files {
 		// Check the approvers to see if we have one
 		approved := false
-		for _, user := range ownerList.Approvers {
+		for _, user := range lists[i].Approvers {
 			if _, ok := revkey[string(user)]; ok {
-				approved = false
+				approved = true
 				break
 			}
 		}
@@ -940,7 +1377,7 @@ func (bi *defaultBrokerImplementation) GetMissingApprovers(
 		if !approved {
 			approvals = append(approvals, &fileApprovers{
 				Filename: f.GetFilename(),
-				Owners:   *ownerList,
+				Owners:   *lists[i],
 			})
 		}
 	}
@@ -949,34 +1386,14 @@ func (bi *defaultBrokerImplementation) GetMissingApprovers(
 
 // GetNeededApprovers  returns the approvals needed to merge the PR
 func (bi *defaultBrokerImplementation) GetNeededApprovers(
-	ctx context.Context, gh *github.GitHub,
+	ctx context.Context, gh *github.GitHub, s *State, overlay map[string][]byte,
 ) (list *owners.List, err error) {
-	// Check the repository root before proceeding
-	repoRoot := bi.RepoRoot(ctx)
-	if repoRoot == "" {
-		return nil, fmt.Errorf("unable to load missing approvers, reporoot not found")
-	}
-	// Get the owners list for every file and append them
-	files, err := bi.GetChangedFiles(ctx, gh)
+	_, lists, err := bi.changedFilesOwners(ctx, gh, s, overlay)
 	if err != nil {
-		return nil, fmt.Errorf("listing pull request files: %w", err)
-	}
-
-	// Build the pnwers reader
-	reader := owners.NewReader()
-	list = owners.NewList()
-	for _, file := range files {
-		logrus.Infof("📂 Getting approvers for %s", file.GetFilename())
-		loopList, err := reader.GetPathOwners(
-			filepath.Join(repoRoot, file.GetFilename()),
-		)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"getting owners for path: %s: %w", file.GetFilename(), err,
-			)
-		}
-		list.Append(loopList)
+		return nil, err
 	}
+	list = owners.MergeLists(lists...)
+	logrus.Infof("📂 Found %d OWNERS files for the PR changes", len(list.Files))
 	return list, nil
 }
 
@@ -986,7 +1403,7 @@ func (bi *defaultBrokerImplementation) GetAuthor(s *State) string {
 
 // GetAuthorPerms returs the top-level authorizations of the PR author
 func (bi *defaultBrokerImplementation) GetUserPerms(
-	ctx context.Context, userName string,
+	ctx context.Context, userName string, overlay map[string][]byte,
 ) (userPerms map[string]bool, err error) {
 	// Add the automatic labels according to the collaborator types
 	userPerms = map[string]bool{
@@ -995,7 +1412,7 @@ func (bi *defaultBrokerImplementation) GetUserPerms(
 	}
 
 	// Get the top level owners
-	ownerList, err := bi.GetRepoOwners(ctx)
+	ownerList, err := bi.GetRepoOwners(ctx, overlay)
 	if err != nil {
 		return userPerms, fmt.Errorf("getting repository owners: %w", err)
 	}
@@ -1042,9 +1459,7 @@ func (bi *defaultBrokerImplementation) GetApprovalNotifierComment(
 	}
 
 	// Get all the comments from the PR
-	comments, err := gh.GetIssueComments(
-		ctx, ctx.Value(ckey).(ContextData).Repository(), prid,
-	)
+	comments, err := bi.prComments(ctx, gh, s, prid)
 	if err != nil {
 		return nil, fmt.Errorf("listing comments to find approval notifier: %w", err)
 	}
@@ -1106,17 +1521,67 @@ func (bi *defaultBrokerImplementation) DeletePRComment(
 	return gh.DeleteComment(ctx, ctx.Value(ckey).(ContextData).Repository(), commentID)
 }
 
+// GetFileContents downloads a file of the repository at a git ref
+func (bi *defaultBrokerImplementation) GetFileContents(
+	ctx context.Context, gh *github.GitHub, path, ref string,
+) ([]byte, error) {
+	return gh.GetFileContents(ctx, ctx.Value(ckey).(ContextData).Repository(), path, ref)
+}
+
+// CreateCheckRun publishes a completed check run on the head of the
+// pull request. Problems are sent as line annotations.
+func (bi *defaultBrokerImplementation) CreateCheckRun(
+	ctx context.Context, gh *github.GitHub, s *State, check *CheckResult,
+) error {
+	if s.PullRequest == nil {
+		return errors.New("pull request not found in state")
+	}
+	annotations := []*gogithub.CheckRunAnnotation{}
+	for _, p := range check.Problems {
+		// The API accepts up to 50 annotations per request
+		if len(annotations) == maxCheckAnnotations {
+			logrus.Warnf("Check run %s has too many annotations, not sending all", check.Name)
+			break
+		}
+		level := "warning"
+		if p.Severity == owners.SeverityError {
+			level = "failure"
+		}
+		line := p.Line
+		if line < 1 {
+			line = 1
+		}
+		annotations = append(annotations, &gogithub.CheckRunAnnotation{
+			Path:            gogithub.String(p.File),
+			StartLine:       gogithub.Int(line),
+			EndLine:         gogithub.Int(line),
+			AnnotationLevel: gogithub.String(level),
+			Message:         gogithub.String(p.Message),
+		})
+	}
+
+	_, err := gh.CreateCheckRun(ctx, ctx.Value(ckey).(ContextData).Repository(), gogithub.CreateCheckRunOptions{
+		Name:       check.Name,
+		HeadSHA:    s.PullRequest.GetHead().GetSHA(),
+		Status:     gogithub.String("completed"),
+		Conclusion: gogithub.String(check.Conclusion),
+		Output: &gogithub.CheckRunOutput{
+			Title:       gogithub.String(check.Title),
+			Summary:     gogithub.String(check.Summary),
+			Annotations: annotations,
+		},
+	})
+	return err
+}
+
 // GetApprovers returns a list of users that have approved this PR
 // note the PR author IS NOT INCLUDED IN THIS LIST.
 func (bi *defaultBrokerImplementation) GetApprovers(
-	ctx context.Context, gh *github.GitHub,
+	ctx context.Context, gh *github.GitHub, s *State,
 ) (approvers, reviewers []string, err error) {
 	logrus.Info("🤓 Looking for approvers and reviewers in PR comments")
 	// Get all the PR comments
-	comments, err := gh.GetIssueComments(
-		ctx, ctx.Value(ckey).(ContextData).Repository(),
-		ctx.Value(ckey).(ContextData).PullRequest(),
-	)
+	comments, err := bi.prComments(ctx, gh, s, ctx.Value(ckey).(ContextData).PullRequest())
 	if err != nil {
 		return approvers, reviewers, fmt.Errorf("listing PR comments: %w", err)
 	}
@@ -1148,3 +1613,14 @@ func (bi *defaultBrokerImplementation) GetApprovers(
 	logrus.Infof("> Found %d PR reviewers: %s", len(reviewers), strings.Join(reviewers, ", "))
 	return approvers, reviewers, nil
 }
+
+// prComments returns the comments of the pull request, from the state
+// if they were loaded with it
+func (bi *defaultBrokerImplementation) prComments(
+	ctx context.Context, gh *github.GitHub, s *State, number int,
+) ([]*gogithub.IssueComment, error) {
+	if s != nil && s.Comments != nil {
+		return s.Comments, nil
+	}
+	return gh.GetIssueComments(ctx, ctx.Value(ckey).(ContextData).Repository(), number)
+}
@@ -0,0 +1,622 @@
+package miniprow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/github/githubfake"
+	"github.com/uservers/miniprow/pkg/github/githubtest"
+	"github.com/uservers/miniprow/pkg/owners"
+)
+
+const testRepo = "uservers/test"
+
+// mkTestWorkspace creates a repository clone with OWNERS files and
+// points GITHUB_WORKSPACE to it. The root is owned by alice (approver)
+// and bob (reviewer), the sub directory by carol.
+func mkTestWorkspace(t *testing.T) string {
+	dir := t.TempDir()
+	for path, data := range map[string]string{
+		".git/config":  "\n",
+		"README.md":    "test",
+		"OWNERS":       "approvers:\n  - alice\nreviewers:\n  - alice\n  - bob\n",
+		"sub/OWNERS":   "approvers:\n  - carol\nreviewers:\n  - carol\n",
+		"sub/file.txt": "test",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+	t.Setenv("GITHUB_WORKSPACE", dir)
+	return dir
+}
+
+// newTestFake returns a fake with the test repository and the
+// labels used by the broker
+func newTestFake() *githubfake.Client {
+	fake := githubfake.New()
+	fake.AddRepo(testRepo, "approved", "lgtm")
+	return fake
+}
+
+// newTestBroker builds a broker that talks to the fake client
+func newTestBroker(t *testing.T, fake *githubfake.Client, event string, pr int, commentID int64) *Broker {
+	return newTestBrokerWithGitHub(t, github.NewWithClient(fake), event, pr, commentID)
+}
+
+// newTestBrokerWithGitHub builds a broker that talks to GitHub through gh
+func newTestBrokerWithGitHub(t *testing.T, gh *github.GitHub, event string, pr int, commentID int64) *Broker {
+	data := ContextData{
+		"event":   event,
+		"repo":    testRepo,
+		"pr":      strconv.Itoa(pr),
+		"comment": strconv.FormatInt(commentID, 10),
+		"token":   "fake-token",
+	}
+	b := &Broker{
+		ctx:    context.WithValue(context.Background(), ckey, data),
+		impl:   &defaultBrokerImplementation{},
+		config: DefaultConfig,
+		github: gh,
+	}
+	b.github.EnableReadCache()
+	require.NoError(t, b.LoadConfigFile())
+	require.NoError(t, b.InitState())
+	return b
+}
+
+// notifierComments returns the approval notifier comments in the PR
+func notifierComments(fake *githubfake.Client, pr int) []*gogithub.IssueComment {
+	res := []*gogithub.IssueComment{}
+	for _, c := range fake.Comments(testRepo, pr) {
+		if strings.Contains(c.GetBody(), "["+approvalNotifierFlag+"]") {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func TestHandleNewPRFromApprover(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 1, "alice", []string{"README.md"})
+
+	b := newTestBroker(t, fake, "NEWPR", 1, 0)
+	require.NoError(t, b.Run())
+
+	require.Equal(t, []string{"approved", "lgtm"}, fake.IssueLabels(testRepo, 1))
+	notifiers := notifierComments(fake, 1)
+	require.Len(t, notifiers, 1)
+	require.Equal(t, githubfake.DefaultBotUser, notifiers[0].GetUser().GetLogin())
+}
+
+func TestHandleNewPRFromAliasedApprover(t *testing.T) {
+	dir := mkTestWorkspace(t)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "OWNERS"), []byte("approvers:\n  - leads\nreviewers:\n  - leads\n"), os.FileMode(0o644),
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "OWNERS_ALIASES"), []byte("aliases:\n  leads:\n    - dave\n"), os.FileMode(0o644),
+	))
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 1, "dave", []string{"README.md"})
+
+	// dave is a root approver through the alias
+	require.NoError(t, newTestBroker(t, fake, "NEWPR", 1, 0).Run())
+	require.Equal(t, []string{"approved", "lgtm"}, fake.IssueLabels(testRepo, 1))
+}
+
+func TestReadStateFallsBackToREST(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 12, "eve", []string{"sub/file.txt"})
+	fake.AddComment(testRepo, 12, "carol", "/approve")
+
+	// With GraphQL the files and comments are loaded with the PR
+	b := newTestBroker(t, fake, "CHECKMERGE", 12, 0)
+	require.NotNil(t, b.State.Files)
+	require.Len(t, b.State.Comments, 1)
+
+	fake.SetError("GetPullRequestState", fmt.Errorf("GraphQL not available"))
+	b = newTestBroker(t, fake, "CHECKMERGE", 12, 0)
+	require.Equal(t, 12, b.State.PullRequest.GetNumber())
+	require.Nil(t, b.State.Files)
+	require.Nil(t, b.State.Comments)
+
+	state, err := b.ReadPRState(Event{Type: EventNewPR})
+	require.NoError(t, err)
+	require.Equal(t, []string{"carol"}, state.Approvers)
+	require.Contains(t, state.NeededApprovers.Approvers, owners.User("carol"))
+}
+
+func TestHandleNewPRFromContributor(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 2, "eve", []string{"sub/file.txt"})
+
+	b := newTestBroker(t, fake, "NEWPR", 2, 0)
+	require.NoError(t, b.Run())
+
+	require.Empty(t, fake.IssueLabels(testRepo, 2))
+	notifiers := notifierComments(fake, 2)
+	require.Len(t, notifiers, 1)
+	require.Contains(t, notifiers[0].GetBody(), "**[/sub/OWNERS]")
+}
+
+func TestHandleCommentLabels(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 3, "eve", []string{"sub/file.txt"})
+
+	// Add the label
+	comment := fake.AddComment(testRepo, 3, "bob", "Looks good\n/lgtm")
+	b := newTestBroker(t, fake, "COMMENT", 3, comment.GetID())
+	require.NoError(t, b.Run())
+	require.Equal(t, []string{"lgtm"}, fake.IssueLabels(testRepo, 3))
+	require.Len(t, notifierComments(fake, 3), 1)
+
+	// Comments and the bot user are read once per run
+	hits, _ := b.GitHub().ReadCacheStats()
+	require.Positive(t, hits)
+
+	// Approving strikes the file in the notifier
+	comment = fake.AddComment(testRepo, 3, "carol", "/approve")
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 3, comment.GetID()).Run())
+	require.Equal(t, []string{"approved", "lgtm"}, fake.IssueLabels(testRepo, 3))
+	notifiers := notifierComments(fake, 3)
+	require.Len(t, notifiers, 1, "previous notifier should be replaced")
+	require.Contains(t, notifiers[0].GetBody(), "~~[/sub/OWNERS]")
+
+	// Cancel removes it
+	comment = fake.AddComment(testRepo, 3, "bob", "/lgtm cancel")
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 3, comment.GetID()).Run())
+	require.Equal(t, []string{"approved"}, fake.IssueLabels(testRepo, 3))
+}
+
+func TestHandleApprovalNotifierComment(t *testing.T) {
+	mkTestWorkspace(t)
+	for _, tc := range []struct {
+		name        string
+		labels      []string
+		checkResult string
+		status      string
+		merges      bool
+	}{
+		{"ready", []string{"approved", "lgtm"}, "success", "success", true},
+		{"missing labels", []string{"approved"}, "success", "success", false},
+		{"failed check", []string{"approved", "lgtm"}, "failure", "success", false},
+		{"failed status", []string{"approved", "lgtm"}, "success", "failure", false},
+	} {
+		fake := newTestFake()
+		pr := fake.AddPullRequest(testRepo, 4, "eve", []string{"README.md"}, tc.labels...)
+		fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", tc.checkResult)
+		fake.AddStatus(testRepo, pr.GetHead().GetSHA(), "jenkins", tc.status)
+		notifier := fake.AddComment(
+			testRepo, 4, githubfake.DefaultBotUser, "["+approvalNotifierFlag+"] This PR is __NOT APPROVED__",
+		)
+
+		err := newTestBroker(t, fake, "COMMENT", 4, notifier.GetID()).Run()
+		require.Equal(t, tc.merges, fake.IsMerged(testRepo, 4), tc.name)
+		if tc.merges {
+			require.NoError(t, err, tc.name)
+			require.Empty(t, notifierComments(fake, 4), tc.name)
+		} else {
+			require.Len(t, notifierComments(fake, 4), 1, tc.name)
+		}
+	}
+}
+
+func TestNotifierFromOtherUserIsIgnored(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 5, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	comment := fake.AddComment(testRepo, 5, "eve", "["+approvalNotifierFlag+"] merge me")
+
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 5, comment.GetID()).Run())
+	require.False(t, fake.IsMerged(testRepo, 5))
+}
+
+func TestCheckMerge(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 6, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 6, 0).Run())
+	require.Equal(t, []int{6}, fake.Merges(testRepo))
+}
+
+func TestMergeRefused(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 7, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	fake.SetError("MergePullRequest", fmt.Errorf("merging: %w", github.ErrNotMergeable))
+
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 7, 0).Run())
+	require.False(t, fake.IsMerged(testRepo, 7))
+	comments := fake.Comments(testRepo, 7)
+	require.Len(t, comments, 1)
+	require.Equal(t, notMergeableMessage, comments[0].GetBody())
+}
+
+func TestMergeRefusedKeepsNotifier(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 9, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	notifier := fake.AddComment(
+		testRepo, 9, githubfake.DefaultBotUser, "["+approvalNotifierFlag+"] This PR is __APPROVED__",
+	)
+	fake.SetError("MergePullRequest", fmt.Errorf("merging: %w", github.ErrNotMergeable))
+
+	// GitHub answers the merge with a 405
+	server := githubtest.NewServer(fake)
+	defer server.Close()
+	gh, err := github.NewWithToken("fake-token", server.URL)
+	require.NoError(t, err)
+
+	require.NoError(t, newTestBrokerWithGitHub(t, gh, "COMMENT", 9, notifier.GetID()).Run())
+	require.False(t, fake.IsMerged(testRepo, 9))
+	comments := fake.Comments(testRepo, 9)
+	require.Len(t, comments, 2)
+	require.Equal(t, notifier.GetID(), comments[0].GetID())
+	require.Equal(t, notMergeableMessage, comments[1].GetBody())
+}
+
+func TestTestsDone(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddPullRequest(testRepo, 8, "eve", []string{"README.md"})
+
+	require.NoError(t, newTestBroker(t, fake, "TESTSDONE", 8, 0).Run())
+	comments := fake.Comments(testRepo, 8)
+	require.Len(t, comments, 1)
+	require.Equal(t, "/"+TestsDoneCommand, comments[0].GetBody())
+}
+
+func TestDryRun(t *testing.T) {
+	mkTestWorkspace(t)
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 9, "alice", []string{"README.md"})
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+
+	b := newTestBroker(t, fake, "NEWPR", 9, 0)
+	b.GitHub().EnableDryRun()
+	require.NoError(t, b.Run())
+
+	// Nothing changed in the repo
+	require.Empty(t, fake.IssueLabels(testRepo, 9))
+	require.Empty(t, fake.Comments(testRepo, 9))
+
+	// ... but the plan has the actions in order
+	actions := b.GitHub().PlannedActions()
+	require.Len(t, actions, 3)
+	require.Equal(t, "AddLabel", actions[0].Action)
+	require.Equal(t, "approved", actions[0].Detail)
+	require.Equal(t, "AddLabel", actions[1].Action)
+	require.Equal(t, "lgtm", actions[1].Detail)
+	require.Equal(t, "CreateComment", actions[2].Action)
+	require.Equal(t, 9, actions[2].Number)
+
+	data, err := os.ReadFile(summary)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "| 2 | AddLabel | "+testRepo+"#9 | lgtm |")
+}
+
+func TestCheckMergeNotReady(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 10, "eve", []string{"README.md"})
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "failure")
+
+	// Neither labels nor checks are ready, the PR must not merge
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 10, 0).Run())
+	require.Empty(t, fake.Merges(testRepo))
+}
+
+func TestSweep(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	for _, n := range []int{14, 11, 15} {
+		pr := fake.AddPullRequest(testRepo, n, "eve", []string{"README.md"}, "approved", "lgtm")
+		fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	}
+	fake.AddPullRequest(testRepo, 12, "eve", []string{"README.md"}, "approved")
+	fake.AddPullRequest(testRepo, 13, "eve", []string{"README.md"}, "approved", "lgtm").Draft = gogithub.Bool(true)
+	fake.SetMergeable(testRepo, 11, false)
+
+	b := newTestBroker(t, fake, EventSweep, 0, 0)
+	b.config.sweepMaxMerges = 1
+	require.NoError(t, b.Run())
+
+	// The oldest ready PR is merged, then the limit is reached
+	require.Equal(t, []int{14}, fake.Merges(testRepo))
+	require.Equal(t, []SweepResult{
+		{Number: 11, Reason: "not ready to merge"},
+		{Number: 12, Reason: "missing labels: lgtm"},
+		{Number: 13, Reason: "draft"},
+		{Number: 14, Merged: true, Reason: "labels and checks are ready"},
+		{Number: 15, Reason: "merge limit reached"},
+	}, b.SweepResults())
+
+	// The next sweep picks up where this one stopped
+	b = newTestBroker(t, fake, EventSweep, 0, 0)
+	require.NoError(t, b.Run())
+	require.Equal(t, []int{14, 15}, fake.Merges(testRepo))
+}
+
+func TestOwnersChanges(t *testing.T) {
+	dir := mkTestWorkspace(t)
+	fake := newTestFake()
+
+	// eve adds herself to the root OWNERS in the PR checkout
+	head := "approvers:\n  - alice\n  - eve\nreviewers:\n  - bob\n  - team/sig\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "OWNERS"), []byte(head), os.FileMode(0o644)))
+	pr := fake.AddPullRequest(testRepo, 11, "eve", []string{"OWNERS"})
+	fake.AddFile(testRepo, githubfake.BaseSHA, "OWNERS", "approvers:\n  - alice\nreviewers:\n  - alice\n  - bob\n")
+	fake.AddFile(testRepo, pr.GetHead().GetSHA(), "OWNERS", head)
+
+	// Permissions come from the base branch, eve is not an approver
+	require.NoError(t, newTestBroker(t, fake, "NEWPR", 11, 0).Run())
+	require.Empty(t, fake.IssueLabels(testRepo, 11))
+	notifiers := notifierComments(fake, 11)
+	require.Len(t, notifiers, 1)
+	require.Contains(t, notifiers[0].GetBody(), "**This PR grants approval rights to eve")
+
+	// The linter results are published with annotations
+	runs, err := fake.ListCheckRunsForRef(context.Background(), "uservers", "test", pr.GetHead().GetSHA(), nil)
+	require.NoError(t, err)
+	require.Len(t, runs.CheckRuns, 1)
+	run := runs.CheckRuns[0]
+	require.Equal(t, OwnersCheckName, run.GetName())
+	require.Equal(t, "failure", run.GetConclusion())
+	require.Contains(t, run.GetOutput().GetSummary(), "grants approval rights to: eve")
+	// Users that are not alias members are warnings, team/sig is an error
+	annotations := run.GetOutput().Annotations
+	require.Len(t, annotations, 4)
+	require.Equal(t, "OWNERS", annotations[3].GetPath())
+	require.Equal(t, 6, annotations[3].GetStartLine())
+	require.Equal(t, "failure", annotations[3].GetAnnotationLevel())
+
+	// Fix the file: labels and checks are not enough without a root approver
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), OwnersCheckName, "completed", "success")
+	fake.AddComment(testRepo, 11, "bob", "/lgtm")
+	fake.AddComment(testRepo, 11, "eve", "/approve")
+	require.NoError(t, fake.AddLabel(context.Background(), "uservers", "test", 11, "lgtm"))
+	require.NoError(t, fake.AddLabel(context.Background(), "uservers", "test", 11, "approved"))
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 11, 0).Run())
+	require.Empty(t, fake.Merges(testRepo))
+
+	fake.AddComment(testRepo, 11, "alice", "/approve")
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 11, 0).Run())
+	require.Equal(t, []int{11}, fake.Merges(testRepo))
+}
+
+func TestBrokenOwnersChangesNeedRootApproval(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+
+	// carol breaks sub/OWNERS, the grants cannot be computed
+	pr := fake.AddPullRequest(testRepo, 12, "carol", []string{"sub/OWNERS"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	fake.AddFile(testRepo, githubfake.BaseSHA, "sub/OWNERS", "approvers:\n  - carol\n")
+	fake.AddFile(testRepo, pr.GetHead().GetSHA(), "sub/OWNERS", "approvers: eve\n")
+
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 12, 0).Run())
+	require.Empty(t, fake.Merges(testRepo))
+
+	fake.AddComment(testRepo, 12, "alice", "/approve")
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 12, 0).Run())
+	require.Equal(t, []int{12}, fake.Merges(testRepo))
+}
+
+func TestOwnersRemovingNoParentOwnersNeedsRootApproval(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+
+	// Removing sub/OWNERS makes the root approvers owners of sub
+	pr := fake.AddPullRequest(testRepo, 13, "carol", []string{"sub/OWNERS"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	root := "approvers:\n  - alice\nreviewers:\n  - alice\n  - bob\n"
+	fake.AddFile(testRepo, githubfake.BaseSHA, "OWNERS", root)
+	fake.AddFile(testRepo, pr.GetHead().GetSHA(), "OWNERS", root)
+	fake.AddFile(testRepo, githubfake.BaseSHA, "sub/OWNERS", "options:\n  no_parent_owners: true\napprovers:\n  - carol\n")
+
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 13, 0).Run())
+	require.Empty(t, fake.Merges(testRepo))
+
+	fake.AddComment(testRepo, 13, "alice", "/approve")
+	require.NoError(t, newTestBroker(t, fake, "CHECKMERGE", 13, 0).Run())
+	require.Equal(t, []int{13}, fake.Merges(testRepo))
+}
+
+func TestAutoMergeStrategy(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := githubfake.New()
+	fake.AddRepo(testRepo, "approved", "lgtm", "do-not-merge/hold")
+	pr := fake.AddPullRequest(testRepo, 16, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "in_progress", "")
+
+	run := func() {
+		b := newTestBroker(t, fake, "CHECKMERGE", 16, 0)
+		b.config.mergeStrategy = MergeStrategyAutoMerge
+		b.config.blockingLabels = []string{"do-not-merge/hold"}
+		require.NoError(t, b.Run())
+	}
+
+	// Auto-merge is enabled before the checks finish
+	run()
+	require.True(t, fake.AutoMergeEnabled(testRepo, 16))
+	require.False(t, fake.IsMerged(testRepo, 16))
+	notifiers := notifierComments(fake, 16)
+	require.Len(t, notifiers, 1)
+	state, err := parseNotifierState(notifiers[0].GetBody())
+	require.NoError(t, err)
+	require.Equal(t, NotifierState{AutoMerge: true}, state)
+
+	// A blocking label turns it off again
+	require.NoError(t, fake.AddLabel(context.Background(), "uservers", "test", 16, "do-not-merge/hold"))
+	run()
+	require.False(t, fake.AutoMergeEnabled(testRepo, 16))
+	notifiers = notifierComments(fake, 16)
+	require.Len(t, notifiers, 1)
+	state, err = parseNotifierState(notifiers[0].GetBody())
+	require.NoError(t, err)
+	require.Equal(t, NotifierState{}, state)
+	require.NotContains(t, notifiers[0].GetBody(), "Auto-merge is enabled")
+}
+
+func TestAutoMergeCleanStatus(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 17, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	fake.SetError("EnableAutoMerge", fmt.Errorf("enabling auto-merge: %w", github.ErrCleanStatus))
+
+	// GitHub refuses auto-merge on mergeable PRs, the broker merges it
+	b := newTestBroker(t, fake, "CHECKMERGE", 17, 0)
+	b.config.mergeStrategy = MergeStrategyAutoMerge
+	require.NoError(t, b.Run())
+	require.Equal(t, []int{17}, fake.Merges(testRepo))
+}
+
+func TestMergeQueueStrategy(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 18, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+
+	b := newTestBroker(t, fake, "CHECKMERGE", 18, 0)
+	b.config.mergeStrategy = MergeStrategyMergeQueue
+	require.NoError(t, b.Run())
+	require.Equal(t, []int{18}, fake.MergeQueue(testRepo))
+	require.Empty(t, fake.Merges(testRepo))
+	notifiers := notifierComments(fake, 18)
+	require.Len(t, notifiers, 1)
+	require.Contains(t, notifiers[0].GetBody(), "in the merge queue")
+}
+
+func TestRetestCommands(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	fake.AddCollaborators(testRepo, "dave")
+	pr := fake.AddPullRequest(testRepo, 19, "eve", []string{"README.md"})
+	fake.AddWorkflow(testRepo, "Build", ".github/workflows/build.yml")
+	fake.AddWorkflow(testRepo, "E2E", ".github/workflows/e2e.yml")
+	failed := fake.AddWorkflowRun(testRepo, pr.GetHead().GetSHA(), "Build", "completed", "failure")
+
+	replies := func() []string {
+		res := []string{}
+		for _, c := range fake.Comments(testRepo, 19) {
+			if !strings.Contains(c.GetBody(), "["+approvalNotifierFlag+"]") {
+				res = append(res, c.GetBody())
+			}
+		}
+		return res
+	}
+
+	// eve is not trusted, nothing is re-run
+	comment := fake.AddComment(testRepo, 19, "eve", "/retest")
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 19, comment.GetID()).Run())
+	require.Empty(t, fake.FailedJobReruns(testRepo))
+	require.Contains(t, replies()[1], "@eve: /retest can only be used by collaborators")
+
+	// bob is a reviewer in OWNERS
+	comment = fake.AddComment(testRepo, 19, "bob", "/retest")
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 19, comment.GetID()).Run())
+	require.Equal(t, []int64{failed.GetID()}, fake.FailedJobReruns(testRepo))
+	require.Equal(t, "queued", failed.GetStatus())
+	require.Contains(t, replies()[3], "- `Build`: re-running the failed jobs")
+
+	// dave is a collaborator. The build is running again, E2E did not run.
+	comment = fake.AddComment(testRepo, 19, "dave", "/test build e2e")
+	require.NoError(t, newTestBroker(t, fake, "COMMENT", 19, comment.GetID()).Run())
+	require.Empty(t, fake.Reruns(testRepo))
+	require.Equal(t, []string{".github/workflows/e2e.yml@pr-19"}, fake.Dispatches(testRepo))
+	require.Contains(t, replies()[5], "- `Build`: the workflow is already running\n- `E2E`: started the workflow on pr-19")
+}
+
+func TestFlakyCheckRetries(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 20, "eve", []string{"README.md"}, "approved", "lgtm")
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "build", "completed", "success")
+	e2e := fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "e2e-linux", "completed", "failure")
+
+	// The state is loaded with GraphQL, as in the actions
+	server := githubtest.NewServer(fake)
+	defer server.Close()
+	gh, err := github.NewWithToken("fake-token", server.URL)
+	require.NoError(t, err)
+
+	run := func() {
+		b := newTestBrokerWithGitHub(t, gh, "CHECKMERGE", 20, 0)
+		b.config.flakyChecks = []string{"e2e-*"}
+		b.config.flakyRetries = 2
+		require.NoError(t, b.Run())
+	}
+	retries := func() int {
+		notifiers := notifierComments(fake, 20)
+		require.Len(t, notifiers, 1)
+		state, err := parseNotifierState(notifiers[0].GetBody())
+		require.NoError(t, err)
+		require.Equal(t, pr.GetHead().GetSHA(), state.RetrySHA)
+		return state.FlakyRetries
+	}
+
+	// The failed flaky check is re-run and the retry saved in the notifier
+	run()
+	require.Equal(t, []int64{e2e.GetID()}, fake.JobReruns(testRepo))
+	require.Equal(t, "queued", e2e.GetStatus())
+	require.Equal(t, 1, retries())
+
+	// It fails again, the second retry exhausts the budget
+	e2e.Status, e2e.Conclusion = gogithub.String("completed"), gogithub.String("failure")
+	run()
+	require.Len(t, fake.JobReruns(testRepo), 2)
+	require.Equal(t, 2, retries())
+
+	e2e.Status, e2e.Conclusion = gogithub.String("completed"), gogithub.String("failure")
+	run()
+	require.Len(t, fake.JobReruns(testRepo), 2)
+	require.Empty(t, fake.Merges(testRepo))
+
+	// Passing on a retry lets the pull request merge
+	e2e.Conclusion = gogithub.String("success")
+	run()
+	require.Equal(t, []int{20}, fake.Merges(testRepo))
+}
+
+func TestFlakyCheckRetriesSkipChecksThatCannotRerun(t *testing.T) {
+	mkTestWorkspace(t)
+	fake := newTestFake()
+	pr := fake.AddPullRequest(testRepo, 21, "eve", []string{"README.md"}, "approved", "lgtm")
+	external := fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "e2e-external", "completed", "failure")
+	external.App = &gogithub.App{Slug: gogithub.String("external-ci")}
+	fake.AddCheckRun(testRepo, pr.GetHead().GetSHA(), "e2e-linux", "completed", "failure")
+	fake.SetError("RerunJob", fmt.Errorf("job: %w", github.ErrNotFound))
+
+	// The check of another app is not retried, the job that is gone is skipped
+	b := newTestBroker(t, fake, "CHECKMERGE", 21, 0)
+	b.config.flakyChecks = []string{"e2e-*"}
+	require.NoError(t, b.Run())
+	require.Empty(t, fake.JobReruns(testRepo))
+	reruns := 0
+	for _, a := range b.Actions() {
+		if a.Type == ActionRerunCheck {
+			reruns++
+		}
+	}
+	require.Equal(t, 1, reruns)
+}
@@ -0,0 +1,329 @@
+package miniprow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge pool modes. When the pool is on, pull requests tested against
+// an old version of the base branch are tested again before merging.
+const (
+	// MergePoolOff merges pull requests as soon as they are ready
+	MergePoolOff = "off"
+
+	// MergePoolRetest tests each outdated pull request on top of the
+	// current base before merging it
+	MergePoolRetest = "retest"
+
+	// MergePoolBatch tests the ready pull requests together and merges
+	// all of them if the tests pass. If they fail, the batch is bisected.
+	MergePoolBatch = "batch"
+)
+
+// Merge strategies, how pull requests are merged once they are ready
+const (
+	// MergeStrategyDirect merges pull requests with the REST API when
+	// their labels and checks are ready
+	MergeStrategyDirect = "direct"
+
+	// MergeStrategyAutoMerge enables the GitHub auto-merge of pull
+	// requests once their labels are ready. GitHub merges them when the
+	// branch protection requirements are met.
+	MergeStrategyAutoMerge = "auto-merge"
+
+	// MergeStrategyMergeQueue adds the ready pull requests to the merge
+	// queue of the base branch, for repositories that require it
+	MergeStrategyMergeQueue = "merge-queue"
+)
+
+var DefaultConfig = Config{
+	requiredLabels: []string{"approved", "lgtm"},
+	blockingLabels: []string{},
+	requiredChecks: []string{},
+	ignoredChecks:  []string{},
+	flakyChecks:    []string{},
+	flakyRetries:   2,
+	rateLimitFloor: 100,
+	sweepMaxMerges: 5,
+	mergeStrategy:  MergeStrategyDirect,
+	mergePool: MergePool{
+		Mode:         MergePoolOff,
+		Branch:       "miniprow-pool",
+		MaxBatchSize: 5,
+		CheckTimeout: time.Hour,
+	},
+	options: &Options{
+		AutoMerge: true, // AutoMerge merges a PR if the author is an approver + reviewer
+	},
+}
+
+type Options struct {
+	AutoMerge bool
+}
+
+type Config struct {
+	requiredLabels []string
+	blockingLabels []string // Labels that keep a pull request from merging
+	requiredChecks []string // Checks that must report success before merging
+	ignoredChecks  []string // Checks that never block a merge
+	flakyChecks    []string // Names or patterns of the checks re-run when they fail
+	flakyRetries   int      // Times the flaky checks are re-run on each head commit
+	rateLimitFloor int      // API requests kept in reserve for essential work
+	sweepMaxMerges int      // Pull requests merged at most in a sweep
+	mergeStrategy  string
+	mergePool      MergePool
+	options        *Options
+}
+
+// MergePool configures how pull requests are tested against the
+// latest base branch before merging
+type MergePool struct {
+	Mode         string        // off, retest or batch
+	Branch       string        // Staging branch where the merge commits are tested
+	MaxBatchSize int           // Pull requests tested together in batch mode
+	CheckTimeout time.Duration // Time to wait for the checks of the staging branch
+}
+
+// configFile is the YAML representation of the configuration
+// file stored in the .miniprow directory of the repository
+type configFile struct {
+	RequiredLabels []string `yaml:"requiredLabels"`
+	BlockingLabels []string `yaml:"blockingLabels"`
+	Checks         struct {
+		Required     []string `yaml:"required"`
+		Ignored      []string `yaml:"ignored"`
+		Flaky        []string `yaml:"flaky"`
+		FlakyRetries *int     `yaml:"flakyRetries"`
+	} `yaml:"checks"`
+	AutoMerge      *bool `yaml:"autoMerge"`
+	RateLimitFloor *int  `yaml:"rateLimitFloor"`
+	Sweep          struct {
+		MaxMerges *int `yaml:"maxMerges"`
+	} `yaml:"sweep"`
+	MergeStrategy string `yaml:"mergeStrategy"`
+	MergePool     struct {
+		Mode         string `yaml:"mode"`
+		Branch       string `yaml:"branch"`
+		MaxBatchSize *int   `yaml:"maxBatchSize"`
+		CheckTimeout string `yaml:"checkTimeout"`
+	} `yaml:"mergePool"`
+}
+
+// RequiredLabels returns a list of required labels
+func (c *Config) RequiredLabels() []string {
+	return c.requiredLabels
+}
+
+// BlockingLabels returns the labels that keep a pull request from
+// merging even if it has all the required labels
+func (c *Config) BlockingLabels() []string {
+	return c.blockingLabels
+}
+
+// RequiredChecks returns the names of the checks that have to be
+// present and successful before merging
+func (c *Config) RequiredChecks() []string {
+	return c.requiredChecks
+}
+
+// IgnoredChecks returns the names of the checks that are not
+// considered when computing the merge verdict
+func (c *Config) IgnoredChecks() []string {
+	return c.ignoredChecks
+}
+
+// IsCheckIgnored returns true if a check or status is ignored by the config
+func (c *Config) IsCheckIgnored(name string) bool {
+	for _, n := range c.ignoredChecks {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FlakyChecks returns the names of the checks that are re-run when
+// they fail. The names can be glob patterns as in path.Match.
+func (c *Config) FlakyChecks() []string {
+	return c.flakyChecks
+}
+
+// FlakyRetries returns how many times the flaky checks are re-run on
+// each head commit of a pull request
+func (c *Config) FlakyRetries() int {
+	return c.flakyRetries
+}
+
+// IsCheckFlaky returns true if a check matches the flaky checks in the config
+func (c *Config) IsCheckFlaky(name string) bool {
+	for _, pattern := range c.flakyChecks {
+		// Patterns are validated when parsing the config
+		if ok, _ := path.Match(pattern, name); ok { //nolint:errcheck
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitFloor returns the number of API requests under which the
+// broker postpones the work that is not needed to label and merge
+func (c *Config) RateLimitFloor() int {
+	return c.rateLimitFloor
+}
+
+// SweepMaxMerges returns the maximum number of pull requests merged in
+// a sweep of the repository
+func (c *Config) SweepMaxMerges() int {
+	return c.sweepMaxMerges
+}
+
+// MergeStrategy returns how pull requests are merged, one of the
+// MergeStrategy constants
+func (c *Config) MergeStrategy() string {
+	return c.mergeStrategy
+}
+
+// MergePool returns the merge pool settings
+func (c *Config) MergePool() MergePool {
+	return c.mergePool
+}
+
+// ParseConfigFile reads a configuration file and returns a config
+// with its values applied on top of the defaults
+func ParseConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	cf := configFile{}
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	conf := DefaultConfig
+	conf.options = &Options{
+		AutoMerge: DefaultConfig.options.AutoMerge,
+	}
+	if cf.RequiredLabels != nil {
+		conf.requiredLabels = cf.RequiredLabels
+	}
+	if cf.BlockingLabels != nil {
+		conf.blockingLabels = cf.BlockingLabels
+	}
+	if cf.Checks.Required != nil {
+		conf.requiredChecks = cf.Checks.Required
+	}
+	if cf.Checks.Ignored != nil {
+		conf.ignoredChecks = cf.Checks.Ignored
+	}
+	if cf.Checks.Flaky != nil {
+		if err := validatePatterns(cf.Checks.Flaky); err != nil {
+			return nil, fmt.Errorf("invalid flaky checks: %w", err)
+		}
+		conf.flakyChecks = cf.Checks.Flaky
+	}
+	if cf.Checks.FlakyRetries != nil {
+		if *cf.Checks.FlakyRetries < 0 {
+			return nil, errors.New("checks.flakyRetries cannot be negative")
+		}
+		conf.flakyRetries = *cf.Checks.FlakyRetries
+	}
+	if cf.AutoMerge != nil {
+		conf.options.AutoMerge = *cf.AutoMerge
+	}
+	if cf.RateLimitFloor != nil {
+		if *cf.RateLimitFloor < 0 {
+			return nil, errors.New("rateLimitFloor cannot be negative")
+		}
+		conf.rateLimitFloor = *cf.RateLimitFloor
+	}
+	if cf.Sweep.MaxMerges != nil {
+		if *cf.Sweep.MaxMerges < 1 {
+			return nil, errors.New("sweep.maxMerges must be at least 1")
+		}
+		conf.sweepMaxMerges = *cf.Sweep.MaxMerges
+	}
+	switch cf.MergeStrategy {
+	case "":
+	case MergeStrategyDirect, MergeStrategyAutoMerge, MergeStrategyMergeQueue:
+		conf.mergeStrategy = cf.MergeStrategy
+	default:
+		return nil, fmt.Errorf("unknown merge strategy %q", cf.MergeStrategy)
+	}
+	if err := cf.applyMergePool(&conf.mergePool); err != nil {
+		return nil, err
+	}
+	// The pool merges the pull requests it tested, GitHub cannot do it
+	if conf.mergePool.Mode != MergePoolOff && conf.mergeStrategy != MergeStrategyDirect {
+		return nil, fmt.Errorf("the merge pool cannot be used with the %s merge strategy", conf.mergeStrategy)
+	}
+	return &conf, nil
+}
+
+// validatePatterns checks the syntax of a list of glob patterns
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// applyMergePool sets the merge pool values of the file in pool
+func (cf *configFile) applyMergePool(pool *MergePool) error {
+	switch cf.MergePool.Mode {
+	case "":
+	case MergePoolOff, MergePoolRetest, MergePoolBatch:
+		pool.Mode = cf.MergePool.Mode
+	default:
+		return fmt.Errorf("unknown merge pool mode %q", cf.MergePool.Mode)
+	}
+	if cf.MergePool.Branch != "" {
+		pool.Branch = cf.MergePool.Branch
+	}
+	if cf.MergePool.MaxBatchSize != nil {
+		if *cf.MergePool.MaxBatchSize < 1 {
+			return errors.New("mergePool.maxBatchSize must be at least 1")
+		}
+		pool.MaxBatchSize = *cf.MergePool.MaxBatchSize
+	}
+	if cf.MergePool.CheckTimeout != "" {
+		timeout, err := time.ParseDuration(cf.MergePool.CheckTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid mergePool.checkTimeout %q", cf.MergePool.CheckTimeout)
+		}
+		pool.CheckTimeout = timeout
+	}
+	return nil
+}
+
+// Options returns the broker options set in the config
+func (c *Config) Options() *Options {
+	return c.options
+}
+
+// ValidateConfigFile checks a configuration file for syntax errors
+// and keys not known to miniprow
+func ValidateConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	cf := configFile{}
+	if err := decoder.Decode(&cf); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file: %w", err)
+	}
+	return nil
+}
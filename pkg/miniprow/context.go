@@ -3,6 +3,9 @@ package miniprow
 import (
 	"os"
 	"strconv"
+	"time"
+
+	"sigs.k8s.io/release-utils/env"
 )
 
 type (
@@ -20,6 +23,10 @@ func NewContextData() ContextData {
 		"issue":   os.Getenv("MINIPROW_ISSUE"),
 		"pr":      os.Getenv("MINIPROW_PR"),
 		"token":   os.Getenv("MINIPROW_TOKEN"),
+		"retries": os.Getenv("MINIPROW_GITHUB_RETRIES"),
+		"timeout": os.Getenv("MINIPROW_TIMEOUT"),
+		"apiurl":  env.Default("MINIPROW_API_URL", os.Getenv("GITHUB_API_URL")),
+		"dryrun":  os.Getenv("MINIPROW_DRY_RUN"),
 	}
 }
 
@@ -77,3 +84,41 @@ func (d ContextData) Repository() string {
 func (d ContextData) Event() string {
 	return d.getStringVal("event")
 }
+
+// GitHubRetries returns the number of times failed GitHub API calls
+// are retried. It returns -1 if the value is not set.
+func (d ContextData) GitHubRetries() int {
+	if d.getStringVal("retries") == "" {
+		return -1
+	}
+	retries, err := strconv.Atoi(d["retries"])
+	if err != nil || retries < 0 {
+		return -1
+	}
+	return retries
+}
+
+// Timeout returns the maximum duration of a broker run. It
+// returns 0 if no deadline is set.
+func (d ContextData) Timeout() time.Duration {
+	if d.getStringVal("timeout") == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(d["timeout"])
+	if err != nil || timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// APIURL returns the base URL of the GitHub REST API. An empty
+// string means the public GitHub API.
+func (d ContextData) APIURL() string {
+	return d.getStringVal("apiurl")
+}
+
+// DryRun returns true if the broker should not modify anything in GitHub
+func (d ContextData) DryRun() bool {
+	dryRun, err := strconv.ParseBool(d.getStringVal("dryrun"))
+	return err == nil && dryRun
+}
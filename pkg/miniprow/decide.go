@@ -0,0 +1,643 @@
+package miniprow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/owners"
+)
+
+// Events the broker knows how to handle
+const (
+	EventComment    = "COMMENT"
+	EventNewPR      = "NEWPR"
+	EventCheckMerge = "CHECKMERGE"
+	EventTestsDone  = "TESTSDONE"
+
+	// EventSweep is not about a single pull request, the broker looks
+	// for open pull requests ready to merge, see Broker.Sweep
+	EventSweep = "SWEEP"
+)
+
+// ActionType is the kind of change an action makes in GitHub
+type ActionType string
+
+const (
+	ActionAddLabel       ActionType = "AddLabel"
+	ActionRemoveLabel    ActionType = "RemoveLabel"
+	ActionMerge          ActionType = "Merge"
+	ActionCreateComment  ActionType = "CreateComment"
+	ActionDeleteComment  ActionType = "DeleteComment"
+	ActionCreateCheckRun ActionType = "CreateCheckRun"
+
+	// Actions of the auto-merge and merge queue strategies, GitHub
+	// merges the pull request
+	ActionEnableAutoMerge  ActionType = "EnableAutoMerge"
+	ActionDisableAutoMerge ActionType = "DisableAutoMerge"
+	ActionEnqueue          ActionType = "Enqueue"
+	ActionDequeue          ActionType = "Dequeue"
+
+	// Actions of the /retest and /test commands
+	ActionRerunFailedJobs  ActionType = "RerunFailedJobs"
+	ActionRerunWorkflow    ActionType = "RerunWorkflow"
+	ActionDispatchWorkflow ActionType = "DispatchWorkflow"
+
+	// ActionRerunCheck re-runs a failed flaky check
+	ActionRerunCheck ActionType = "RerunCheck"
+)
+
+// reasonMerged is the reason of the actions that only run once the
+// pull request is merged
+const reasonMerged = "pull request merged"
+
+// Action is a change to the pull request decided by the broker
+type Action struct {
+	Type      ActionType   `json:"type"`
+	Label     string       `json:"label,omitempty"`
+	Body      string       `json:"body,omitempty"`
+	CommentID int64        `json:"commentID,omitempty"`
+	Check     *CheckResult `json:"check,omitempty"`
+	Reason    string       `json:"reason,omitempty"` // Why the action was decided
+
+	// Workflow or check run to re-run, or workflow to dispatch on Ref
+	RunID      int64  `json:"runID,omitempty"`
+	WorkflowID int64  `json:"workflowID,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+}
+
+// CheckResult is a check run published by the broker on the head of
+// the pull request
+type CheckResult struct {
+	Name       string `json:"name"`
+	Conclusion string `json:"conclusion"`
+	Title      string `json:"title"`
+	Summary    string `json:"summary"`
+
+	// Problems are reported as line annotations
+	Problems []owners.Problem `json:"problems,omitempty"`
+}
+
+// String returns a one line description of the action
+func (a Action) String() string {
+	s := string(a.Type)
+	switch a.Type {
+	case ActionAddLabel, ActionRemoveLabel:
+		s += " " + a.Label
+	case ActionCreateComment:
+		s += " " + summarizeBody(a.Body)
+	case ActionDeleteComment:
+		s += fmt.Sprintf(" %d", a.CommentID)
+	case ActionCreateCheckRun:
+		if a.Check != nil {
+			s += fmt.Sprintf(" %s: %s", a.Check.Name, a.Check.Conclusion)
+		}
+	case ActionRerunFailedJobs, ActionRerunWorkflow, ActionRerunCheck:
+		s += fmt.Sprintf(" run %d", a.RunID)
+	case ActionDispatchWorkflow:
+		s += fmt.Sprintf(" workflow %d on %s", a.WorkflowID, a.Ref)
+	}
+	if a.Reason != "" {
+		s += " (" + a.Reason + ")"
+	}
+	return s
+}
+
+// Event is the trigger of a broker run
+type Event struct {
+	Type    string
+	Comment *gogithub.IssueComment // Comment that triggered the run, if any
+}
+
+// PRState is everything the broker knows about the pull request when
+// deciding what to do. It is plain data, the broker fills it from the
+// API and the repository before calling Decide.
+type PRState struct {
+	Number    int
+	Author    string
+	HeadSHA   string
+	Labels    []string // Labels in the pull request
+	Merged    bool
+	Mergeable bool
+
+	// Top level permissions of the author
+	AuthorIsApprover bool
+	AuthorIsReviewer bool
+
+	RepoRoot        string
+	RepoLabels      []string     // Labels defined in the repository
+	NeededApprovers *owners.List // Owners of the files changed in the PR
+	Approvers       []string     // Users who commented /approve
+	BotUser         string       // Login of the account running miniprow
+	Notifier        *gogithub.IssueComment
+
+	// NotifierState is the data of previous runs kept in the notifier
+	NotifierState NotifierState
+
+	CheckRuns []*gogithub.CheckRun
+	Statuses  []*gogithub.RepoStatus
+
+	// OwnersReview has the result of checking the OWNERS files changed
+	// in the PR, nil if it does not change any
+	OwnersReview *OwnersReview
+
+	// LowAPIBudget is set when the API quota left is under the floor in
+	// the config. The approval notifier is not refreshed to save it.
+	LowAPIBudget bool
+
+	// Data of the /retest and /test commands, only read when a comment
+	// has them. The workflows are not read if the commenter is not trusted.
+	Commenter        string // Login of the author of the comment
+	CommenterTrusted bool   // Collaborator or user in the root OWNERS
+	HeadBranch       string // Branch of the head, empty if it is in a fork
+	Workflows        []*gogithub.Workflow
+	WorkflowRuns     []*gogithub.WorkflowRun // Latest run of each workflow on the head
+}
+
+// HasLabel returns true if the pull request has a label
+func (s *PRState) HasLabel(label string) bool {
+	for _, l := range s.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// RootApproved returns true if the author or one of the users that
+// commented /approve is a root approver. Only pull requests that grant
+// new approval rights need it, for the rest it is always true.
+func (s *PRState) RootApproved() bool {
+	if !s.OwnersReview.NeedsRootApproval() {
+		return true
+	}
+	for _, user := range append([]string{s.Author}, s.Approvers...) {
+		if s.OwnersReview.IsRootApprover(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsApprovalNotifier returns true if the comment is the approval
+// notifier posted by the bot
+func (s *PRState) IsApprovalNotifier(comment *gogithub.IssueComment) bool {
+	return comment != nil &&
+		strings.Contains(comment.GetBody(), "["+approvalNotifierFlag+"]") &&
+		comment.GetUser().GetLogin() == s.BotUser
+}
+
+// Decide computes the actions to take on a pull request when an event
+// occurs. It does not talk to GitHub, all the data it needs is in the
+// state, so it can be unit tested with plain structs.
+func Decide(state *PRState, event Event, config *Config) ([]Action, error) {
+	if state == nil {
+		return nil, errors.New("unable to decide without pull request state")
+	}
+	// Work on a copy of the state to track the effect of the actions
+	s := *state
+	s.Labels = append([]string{}, state.Labels...)
+	d := &decision{state: &s, config: config, actions: []Action{}}
+
+	switch event.Type {
+	case EventNewPR:
+		if err := d.newPR(); err != nil {
+			return nil, err
+		}
+	case EventComment:
+		if err := d.comment(event.Comment); err != nil {
+			return nil, err
+		}
+	case EventCheckMerge:
+		d.merge()
+		if d.stateChanged {
+			if err := d.notifier(); err != nil {
+				return nil, err
+			}
+		}
+	case EventTestsDone:
+		d.add(Action{Type: ActionCreateComment, Body: "/" + TestsDoneCommand, Reason: "tests finished"})
+	default:
+		return nil, fmt.Errorf("unknown MiniProw event %q", event.Type)
+	}
+	return d.actions, nil
+}
+
+// decision accumulates the actions while deciding
+type decision struct {
+	state   *PRState
+	config  *Config
+	actions []Action
+	merging bool
+
+	// stateChanged is set when the notifier state was modified and the
+	// notifier has to be refreshed to save it
+	stateChanged bool
+}
+
+func (d *decision) add(a Action) {
+	switch a.Type {
+	case ActionAddLabel:
+		if d.state.HasLabel(a.Label) {
+			return
+		}
+		d.state.Labels = append(d.state.Labels, a.Label)
+	case ActionRemoveLabel:
+		if !d.state.HasLabel(a.Label) {
+			return
+		}
+		labels := []string{}
+		for _, l := range d.state.Labels {
+			if l != a.Label {
+				labels = append(labels, l)
+			}
+		}
+		d.state.Labels = labels
+	case ActionMerge:
+		d.merging = true
+	case ActionEnableAutoMerge, ActionDisableAutoMerge:
+		enable := a.Type == ActionEnableAutoMerge
+		if d.state.NotifierState.AutoMerge == enable {
+			return
+		}
+		d.state.NotifierState.AutoMerge = enable
+		d.stateChanged = true
+	case ActionEnqueue, ActionDequeue:
+		enqueue := a.Type == ActionEnqueue
+		if d.state.NotifierState.Queued == enqueue {
+			return
+		}
+		d.state.NotifierState.Queued = enqueue
+		d.stateChanged = true
+	}
+	d.actions = append(d.actions, a)
+}
+
+// newPR labels the PR according to the author permissions and posts
+// the approval notifier
+func (d *decision) newPR() error {
+	if d.state.Author == "" {
+		return errors.New("unable to handle pr, could not get PR author handle")
+	}
+
+	// If the author is a top-level approver or owns all files, the PR is approved
+	if d.state.AuthorIsApprover || d.state.NeededApprovers == nil || len(d.state.NeededApprovers.Files) == 0 {
+		logrus.Infof("→ %s is an approver", d.state.Author)
+		d.add(Action{Type: ActionAddLabel, Label: "approved", Reason: "author is an approver"})
+	}
+
+	// Approvers that are also reviewers get lgtm when automerge is on
+	if d.state.AuthorIsReviewer && d.state.AuthorIsApprover && d.config.options.AutoMerge {
+		d.add(Action{Type: ActionAddLabel, Label: "lgtm", Reason: "author is an approver and reviewer"})
+	}
+
+	if !d.state.AuthorIsReviewer && !d.state.AuthorIsApprover {
+		logrus.Infof("User %s is not an approver nor a reviewer", d.state.Author)
+	}
+
+	if d.state.OwnersReview != nil {
+		d.add(Action{
+			Type: ActionCreateCheckRun, Check: d.state.OwnersReview.checkResult(), Reason: "OWNERS files changed",
+		})
+	}
+	d.hold()
+	return d.notifier()
+}
+
+// comment reacts to a new comment: the approval notifier triggers a
+// merge, otherwise the slash commands in the comment are run
+func (d *decision) comment(comment *gogithub.IssueComment) error {
+	if comment == nil {
+		return errors.New("comment event without comment data")
+	}
+
+	// The approval notifier does not have any slash commands
+	if d.state.IsApprovalNotifier(comment) {
+		logrus.Info(" > Event triggered by Approval Notifier Comment")
+		if d.merge() {
+			d.add(Action{
+				Type: ActionDeleteComment, CommentID: comment.GetID(), Reason: reasonMerged,
+			})
+		}
+		if d.stateChanged {
+			return d.notifier()
+		}
+		return nil
+	}
+
+	commands, err := ParseSlashCommands(strings.TrimSpace(comment.GetBody()))
+	if err != nil {
+		return fmt.Errorf("parsing commands: %w", err)
+	}
+	logrus.Infof("Found %d slash commands in the comment data", len(commands))
+
+	errs := []error{}
+	for _, cmd := range commands {
+		logrus.Infof("Running /%s slash command handler", cmd.Command)
+		if err := cmd.Handler.Decide(d, cmd.Command, cmd.Arguments); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors while running handlers: %w", errors.Join(errs...))
+	}
+
+	d.hold()
+	return d.notifier()
+}
+
+// merge adds a merge action if the pull request is ready. Returns
+// true if the merge was planned. With the auto-merge and merge queue
+// strategies GitHub merges the pull request, they never return true.
+func (d *decision) merge() bool {
+	switch d.config.MergeStrategy() {
+	case MergeStrategyAutoMerge:
+		d.autoMerge()
+		return false
+	case MergeStrategyMergeQueue:
+		d.enqueue()
+		return false
+	}
+
+	missing := []string{}
+	if !d.config.labelsVerdict(d.state) {
+		missing = append(missing, "labels")
+	}
+	if !d.config.checksVerdict(d.state.CheckRuns, d.state.Statuses) {
+		missing = append(missing, "checks")
+	}
+	if !d.state.RootApproved() {
+		logrus.Infof("❌ PR #%d grants approval rights and needs a root approver", d.state.Number)
+		missing = append(missing, "root approval")
+	}
+	if len(missing) > 0 {
+		logrus.Infof(
+			"⏳ Not merging as pull request is not yet ready. Has missing: %s",
+			strings.Join(missing, ","),
+		)
+		if len(missing) == 1 && missing[0] == "checks" {
+			d.retryFlakyChecks()
+		}
+		return false
+	}
+	d.add(Action{Type: ActionMerge, Reason: "labels and checks are ready"})
+	return true
+}
+
+// autoMerge enables auto-merge once the labels are ready, GitHub waits
+// for the checks required by the branch protection
+func (d *decision) autoMerge() {
+	if reason := d.holdReason(); reason != "" {
+		logrus.Infof("⏳ Not enabling auto-merge in PR #%d: %s", d.state.Number, reason)
+		d.hold()
+		return
+	}
+	d.add(Action{Type: ActionEnableAutoMerge, Reason: "labels are ready"})
+
+	// GitHub does not merge the pull request if a flaky check failed
+	d.retryFlakyChecks()
+}
+
+// enqueue adds the pull request to the merge queue when it is ready to
+// merge. The queue only accepts pull requests with passing checks.
+func (d *decision) enqueue() {
+	if reason := d.holdReason(); reason != "" {
+		logrus.Infof("⏳ Not adding PR #%d to the merge queue: %s", d.state.Number, reason)
+		d.hold()
+		return
+	}
+	if !d.config.labelsVerdict(d.state) {
+		logrus.Infof("⏳ Not adding PR #%d to the merge queue, it is not ready yet", d.state.Number)
+		return
+	}
+	if !d.config.checksVerdict(d.state.CheckRuns, d.state.Statuses) {
+		logrus.Infof("⏳ Not adding PR #%d to the merge queue, its checks are not ready", d.state.Number)
+		d.retryFlakyChecks()
+		return
+	}
+	d.add(Action{Type: ActionEnqueue, Reason: "labels and checks are ready"})
+}
+
+// retryFlakyChecks re-runs the failed flaky checks of a pull request
+// that is otherwise ready, up to the retries in the config for each head
+// commit. The retries are counted in the notifier state.
+func (d *decision) retryFlakyChecks() {
+	failed := d.config.flakyFailures(d.state.CheckRuns, d.state.Statuses)
+	if len(failed) == 0 {
+		return
+	}
+	retries := 0
+	if d.state.NotifierState.RetrySHA == d.state.HeadSHA {
+		retries = d.state.NotifierState.FlakyRetries
+	}
+	if retries >= d.config.FlakyRetries() {
+		logrus.Infof("❌ Flaky checks of PR #%d were re-run %d times, not retrying them again", d.state.Number, retries)
+		return
+	}
+
+	retries++
+	rerun := false
+	for _, run := range failed {
+		// Checks without an ID cannot be re-run through the API
+		if run.GetID() == 0 {
+			logrus.Warnf("Unable to retry flaky check %s of PR #%d, its ID is unknown", run.GetName(), d.state.Number)
+			continue
+		}
+		// Only the checks of GitHub Actions jobs are re-run
+		if run.GetApp().GetSlug() != github.ActionsAppSlug {
+			logrus.Warnf(
+				"Unable to retry flaky check %s of PR #%d, it is not a GitHub Actions job", run.GetName(), d.state.Number,
+			)
+			continue
+		}
+		d.add(Action{
+			Type: ActionRerunCheck, RunID: run.GetID(),
+			Reason: fmt.Sprintf("flaky check %s failed, retry %d of %d", run.GetName(), retries, d.config.FlakyRetries()),
+		})
+		rerun = true
+	}
+	if !rerun {
+		return
+	}
+	d.state.NotifierState.RetrySHA = d.state.HeadSHA
+	d.state.NotifierState.FlakyRetries = retries
+	d.stateChanged = true
+}
+
+// hold disables auto-merge and removes the pull request from the merge
+// queue when its labels or approvals stop allowing it to merge
+func (d *decision) hold() {
+	reason := d.holdReason()
+	if reason == "" {
+		return
+	}
+	d.add(Action{Type: ActionDisableAutoMerge, Reason: reason})
+	d.add(Action{Type: ActionDequeue, Reason: reason})
+}
+
+// holdReason returns why the labels or the approvals of the pull
+// request do not allow it to merge, or an empty string
+func (d *decision) holdReason() string {
+	if blocking := d.config.blockingLabelsIn(d.state.Labels); len(blocking) > 0 {
+		return "blocked by " + strings.Join(blocking, ", ")
+	}
+	if missing := d.config.missingLabels(d.state.Labels); len(missing) > 0 {
+		return "missing labels: " + strings.Join(missing, ", ")
+	}
+	if !d.state.RootApproved() {
+		return "needs a root approver"
+	}
+	return ""
+}
+
+// notifier replaces the approval notifier comment with an updated one.
+// If the pull request is going to merge, the notifier is only removed.
+func (d *decision) notifier() error {
+	// The notifier state has to be saved even when the budget is low
+	if d.state.LowAPIBudget && !d.stateChanged {
+		logrus.Warn("API budget is low, postponing the approval notifier refresh")
+		return nil
+	}
+	if d.state.Notifier != nil {
+		d.add(Action{
+			Type: ActionDeleteComment, CommentID: d.state.Notifier.GetID(), Reason: "replacing approval notifier",
+		})
+	}
+	if d.merging {
+		return nil
+	}
+	body, err := approvalNotifierBody(d.state)
+	if err != nil {
+		return err
+	}
+	d.add(Action{Type: ActionCreateComment, Body: body, Reason: "approval notifier"})
+	return nil
+}
+
+// labelsVerdict returns true if the PR has the required labels and
+// GitHub reports it can be merged
+func (c *Config) labelsVerdict(s *PRState) bool {
+	if missingLabels := c.missingLabels(s.Labels); len(missingLabels) > 0 {
+		logrus.Infof("❌ PR #%d has missing labels: %s", s.Number, strings.Join(missingLabels, ", "))
+		return false
+	}
+	if blocking := c.blockingLabelsIn(s.Labels); len(blocking) > 0 {
+		logrus.Infof("❌ PR #%d has blocking labels: %s", s.Number, strings.Join(blocking, ", "))
+		return false
+	}
+	if s.Merged {
+		logrus.Infof("❌ PR #%d is already merged", s.Number)
+		return false
+	}
+	if !s.Mergeable {
+		logrus.Infof("❌ github reports that PR #%d cannot merge yet", s.Number)
+		return false
+	}
+	logrus.Infof("✅ Pull Request #%d has all labels required to merge", s.Number)
+	return true
+}
+
+// missingLabels returns the required labels not found in labels
+func (c *Config) missingLabels(labels []string) []string {
+	missing := []string{}
+	for _, expected := range c.RequiredLabels() {
+		found := false
+		for _, l := range labels {
+			if l == expected {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, expected)
+		}
+	}
+	return missing
+}
+
+// blockingLabelsIn returns the blocking labels found in labels
+func (c *Config) blockingLabelsIn(labels []string) []string {
+	blocking := []string{}
+	for _, l := range labels {
+		for _, b := range c.BlockingLabels() {
+			if l == b {
+				blocking = append(blocking, l)
+			}
+		}
+	}
+	return blocking
+}
+
+// approvalNotifierBody renders the approval notifier comment
+func approvalNotifierBody(s *PRState) (string, error) {
+	if s.NeededApprovers == nil || len(s.NeededApprovers.Approvers) == 0 {
+		return "", errors.New("no approvers were found. Missing OWNERS file(s)?")
+	}
+
+	approvers := append(append([]string{}, s.Approvers...), s.Author)
+
+	// TODO(puerco): Implement suggestions here
+	suggestedAssignees := []string{}
+
+	commentBody := "[" + approvalNotifierFlag + "] This PR is __NOT APPROVED__\n\n\n"
+	commentBody += "This pull-request has been approved by: *" + strings.Join(approvers, ", ") + "*\n"
+	commentBody += "To complete the pull request process, please assign " + strings.Join(suggestedAssignees, ",")
+	commentBody += " after the PR has been reviewed.\n"
+	commentBody += "You can assign the PR to them by writing `/assign "
+	commentBody += strings.Join(suggestedAssignees, ",") + "` in a comment when ready.\n\n"
+
+	commentBody += "The full list of commands accepted by this bot can be found [here](http://undercons.com/).\n\n"
+	// TODO: Check if all are approved and do not open details
+	commentBody += "<details open>\n"
+	commentBody += "Needs approval from an approver in each of these files:\n\n"
+	for _, ofile := range s.NeededApprovers.Files {
+		fileApprovers := ofile.WhoCanApprove(approvers)
+		mkup := "**"
+		if len(fileApprovers) > 0 {
+			mkup = "~~"
+		}
+
+		commentBody += fmt.Sprintf(
+			"- %s[%s](%s)%s", mkup,
+			strings.TrimPrefix(ofile.Path, s.RepoRoot),
+			"http://github.com/", mkup,
+		)
+
+		if len(fileApprovers) > 0 {
+			commentBody += " [" + strings.Join(fileApprovers, ",") + "]"
+		}
+
+		commentBody += "\n"
+	}
+
+	commentBody += "\n"
+
+	if s.OwnersReview.NeedsRootApproval() {
+		mkup := "**"
+		if s.RootApproved() {
+			mkup = "~~"
+		}
+		commentBody += fmt.Sprintf(
+			"%sThis PR grants approval rights to %s and needs approval from a root approver%s [%s]\n\n",
+			mkup, s.OwnersReview.grantees(), mkup,
+			strings.Join(s.OwnersReview.RootApprovers, ","),
+		)
+	}
+
+	commentBody += "Approvers can indicate their approval by writing /approve in a comment\n\n"
+	commentBody += "Approvers can cancel approval by writing /approve cancel in a comment\n"
+	commentBody += "</details>"
+	if summary := s.NotifierState.summary(); summary != "" {
+		commentBody += "\n\n" + summary
+	}
+	commentBody += "\n" + s.NotifierState.render()
+	return commentBody, nil
+}
+
+// summarizeBody returns the first line of a comment body
+func summarizeBody(body string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	return line
+}
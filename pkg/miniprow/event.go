@@ -0,0 +1,91 @@
+package miniprow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// eventPayload has the fields miniprow reads from the GitHub webhook
+// payloads delivered to actions
+type eventPayload struct {
+	Action  string `json:"action"`
+	Comment *struct {
+		ID int64 `json:"id"`
+	} `json:"comment"`
+	Issue *struct {
+		Number      int       `json:"number"`
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	PullRequest *struct {
+		Number int `json:"number"`
+	} `json:"pull_request"`
+	CheckSuite *struct {
+		PullRequests []struct {
+			Number int `json:"number"`
+		} `json:"pull_requests"`
+	} `json:"check_suite"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// ContextDataFromEvent builds the broker context data from a GitHub
+// event payload such as the one found in GITHUB_EVENT_PATH. If name is
+// empty, the event type is guessed from the payload. Values not found in
+// the payload (token, API URL, etc) are read from the environment.
+func ContextDataFromEvent(name string, payload []byte) (ContextData, error) {
+	p := eventPayload{}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("parsing event payload: %w", err)
+	}
+
+	if name == "" {
+		switch {
+		case p.Comment != nil && p.Issue != nil:
+			name = "issue_comment"
+		case p.PullRequest != nil:
+			name = "pull_request"
+		case p.CheckSuite != nil:
+			name = "check_suite"
+		default:
+			return nil, errors.New("unable to determine the event type from the payload")
+		}
+	}
+
+	data := NewContextData()
+	data["repo"] = p.Repository.FullName
+	data["comment"] = ""
+	data["issue"] = ""
+	data["pr"] = ""
+
+	switch name {
+	case "issue_comment":
+		if p.Comment == nil || p.Issue == nil {
+			return nil, errors.New("issue_comment payload has no comment or issue")
+		}
+		data["event"] = EventComment
+		data["comment"] = strconv.FormatInt(p.Comment.ID, 10)
+		if p.Issue.PullRequest != nil {
+			data["pr"] = strconv.Itoa(p.Issue.Number)
+		} else {
+			data["issue"] = strconv.Itoa(p.Issue.Number)
+		}
+	case "pull_request", "pull_request_target":
+		if p.PullRequest == nil {
+			return nil, errors.New("pull_request payload has no pull request")
+		}
+		data["event"] = EventNewPR
+		data["pr"] = strconv.Itoa(p.PullRequest.Number)
+	case "check_suite":
+		if p.CheckSuite == nil || len(p.CheckSuite.PullRequests) == 0 {
+			return nil, errors.New("check_suite payload is not associated with a pull request")
+		}
+		data["event"] = EventCheckMerge
+		data["pr"] = strconv.Itoa(p.CheckSuite.PullRequests[0].Number)
+	default:
+		return nil, fmt.Errorf("unsupported event %q", name)
+	}
+	return data, nil
+}
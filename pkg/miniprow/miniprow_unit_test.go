@@ -1,10 +1,17 @@
 package miniprow
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	gogithub "github.com/google/go-github/v48/github"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/owners"
 )
 
 func TestParseSlashCommands(t *testing.T) {
@@ -21,3 +28,664 @@ func TestParseSlashCommands(t *testing.T) {
 
 	require.Equal(t, 4, len(commands))
 }
+
+func TestChecksVerdict(t *testing.T) {
+	run := func(name, status, conclusion string) *gogithub.CheckRun {
+		return &gogithub.CheckRun{Name: &name, Status: &status, Conclusion: &conclusion}
+	}
+	status := func(context, state string) *gogithub.RepoStatus {
+		return &gogithub.RepoStatus{Context: &context, State: &state}
+	}
+	for _, tc := range []struct {
+		name     string
+		required []string
+		ignored  []string
+		runs     []*gogithub.CheckRun
+		statuses []*gogithub.RepoStatus
+		expected bool
+	}{
+		{"no checks", nil, nil, nil, nil, true},
+		{
+			"runs and statuses green", nil, nil,
+			[]*gogithub.CheckRun{run("build", "completed", "success")},
+			[]*gogithub.RepoStatus{status("jenkins", "success")}, true,
+		},
+		{
+			"failed status", nil, nil,
+			[]*gogithub.CheckRun{run("build", "completed", "success")},
+			[]*gogithub.RepoStatus{status("jenkins", "failure")}, false,
+		},
+		{
+			"pending status", nil, nil, nil,
+			[]*gogithub.RepoStatus{status("buildkite", "pending")}, false,
+		},
+		{
+			"ignored failed status", nil, []string{"jenkins"}, nil,
+			[]*gogithub.RepoStatus{status("jenkins", "error")}, true,
+		},
+		{
+			"ignored running check", nil, []string{"slow"},
+			[]*gogithub.CheckRun{run("slow", "in_progress", "")}, nil, true,
+		},
+		{
+			"required status missing", []string{"jenkins"}, nil,
+			[]*gogithub.CheckRun{run("build", "completed", "success")}, nil, false,
+		},
+		{
+			"required status present", []string{"jenkins"}, nil, nil,
+			[]*gogithub.RepoStatus{status("jenkins", "success")}, true,
+		},
+	} {
+		conf := DefaultConfig
+		conf.requiredChecks = tc.required
+		conf.ignoredChecks = tc.ignored
+		require.Equal(t, tc.expected, conf.checksVerdict(tc.runs, tc.statuses), tc.name)
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), MiniProwConf)
+	require.NoError(t, os.WriteFile(path, []byte(`checks:
+  required: [jenkins]
+  ignored: [codecov/patch]
+  flaky: [e2e-*]
+  flakyRetries: 1
+autoMerge: false
+rateLimitFloor: 500
+sweep:
+  maxMerges: 3
+blockingLabels: [do-not-merge/hold]
+mergePool:
+  mode: batch
+  maxBatchSize: 3
+  checkTimeout: 30m
+`), os.FileMode(0o644)))
+
+	conf, err := ParseConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"jenkins"}, conf.RequiredChecks())
+	require.True(t, conf.IsCheckIgnored("codecov/patch"))
+	require.True(t, conf.IsCheckFlaky("e2e-linux"))
+	require.False(t, conf.IsCheckFlaky("jenkins"))
+	require.Equal(t, 1, conf.FlakyRetries())
+	require.Equal(t, 2, DefaultConfig.FlakyRetries())
+	require.Equal(t, DefaultConfig.RequiredLabels(), conf.RequiredLabels())
+	require.False(t, conf.options.AutoMerge)
+	require.True(t, DefaultConfig.options.AutoMerge)
+	require.Equal(t, 500, conf.RateLimitFloor())
+	require.Equal(t, 100, DefaultConfig.RateLimitFloor())
+	require.Equal(t, 3, conf.SweepMaxMerges())
+	require.Equal(t, MergePool{
+		Mode: MergePoolBatch, Branch: "miniprow-pool", MaxBatchSize: 3, CheckTimeout: 30 * time.Minute,
+	}, conf.MergePool())
+	require.Equal(t, MergePoolOff, DefaultConfig.MergePool().Mode)
+	require.Equal(t, []string{"do-not-merge/hold"}, conf.BlockingLabels())
+	require.Equal(t, MergeStrategyDirect, conf.MergeStrategy())
+
+	// GitHub cannot merge the pull requests tested by the pool
+	require.NoError(t, os.WriteFile(path, []byte("mergeStrategy: auto-merge\nmergePool:\n  mode: retest\n"), os.FileMode(0o644)))
+	_, err = ParseConfigFile(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("mergeStrategy: merge-queue\n"), os.FileMode(0o644)))
+	conf, err = ParseConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, MergeStrategyMergeQueue, conf.MergeStrategy())
+
+	require.NoError(t, os.WriteFile(path, []byte("mergePool:\n  mode: later\n"), os.FileMode(0o644)))
+	_, err = ParseConfigFile(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("checks:\n  flaky: [\"e2e-[\"]\n"), os.FileMode(0o644)))
+	_, err = ParseConfigFile(path)
+	require.Error(t, err)
+}
+
+func TestDecide(t *testing.T) {
+	str := func(s string) *string { return &s }
+	success := []*gogithub.CheckRun{{Name: str("build"), Status: str("completed"), Conclusion: str("success")}}
+	failure := []*gogithub.CheckRun{{Name: str("build"), Status: str("completed"), Conclusion: str("failure")}}
+	needed := &owners.List{
+		Files:     []owners.File{{Path: "/repo/OWNERS", Approvers: []owners.User{"alice"}}},
+		Approvers: []owners.User{"alice"},
+	}
+	comment := func(id int64, user, body string) *gogithub.IssueComment {
+		return &gogithub.IssueComment{ID: &id, Body: &body, User: &gogithub.User{Login: &user}}
+	}
+	grants := &OwnersReview{
+		Files:         []string{"sub/OWNERS"},
+		NewApprovers:  []owners.Grant{{User: "eve", File: "sub/OWNERS", Line: 2}},
+		RootApprovers: []string{"alice"},
+	}
+	notifier := comment(10, "bot", "["+approvalNotifierFlag+"] This PR is __NOT APPROVED__")
+	types := func(actions []Action) []ActionType {
+		res := []ActionType{}
+		for _, a := range actions {
+			res = append(res, a.Type)
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name     string
+		state    PRState
+		event    Event
+		expected []ActionType
+	}{
+		{
+			"new PR from approver", PRState{
+				Author: "alice", AuthorIsApprover: true, AuthorIsReviewer: true, NeededApprovers: needed,
+			},
+			Event{Type: EventNewPR},
+			[]ActionType{ActionAddLabel, ActionAddLabel, ActionCreateComment},
+		},
+		{
+			"new PR from contributor replaces notifier", PRState{
+				Author: "eve", NeededApprovers: needed, Notifier: notifier,
+			},
+			Event{Type: EventNewPR},
+			[]ActionType{ActionDeleteComment, ActionCreateComment},
+		},
+		{
+			"check merge ready", PRState{
+				Labels: []string{"approved", "lgtm"}, Mergeable: true, CheckRuns: success,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{ActionMerge},
+		},
+		{
+			"check merge missing labels and failing checks", PRState{
+				Mergeable: true, CheckRuns: failure,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"check merge missing labels", PRState{
+				Labels: []string{"approved"}, Mergeable: true, CheckRuns: success,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"check merge failing checks", PRState{
+				Labels: []string{"approved", "lgtm"}, Mergeable: true, CheckRuns: failure,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"check merge already merged", PRState{
+				Labels: []string{"approved", "lgtm"}, Mergeable: true, Merged: true, CheckRuns: success,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"notifier merges and is deleted", PRState{
+				Labels: []string{"approved", "lgtm"}, Mergeable: true, CheckRuns: success, BotUser: "bot",
+			},
+			Event{Type: EventComment, Comment: notifier},
+			[]ActionType{ActionMerge, ActionDeleteComment},
+		},
+		{
+			"lgtm already set is skipped", PRState{
+				Author: "eve", Labels: []string{"lgtm"}, RepoLabels: []string{"lgtm"}, NeededApprovers: needed,
+			},
+			Event{Type: EventComment, Comment: comment(11, "bob", "/lgtm")},
+			[]ActionType{ActionCreateComment},
+		},
+		{
+			"lgtm and tests-done merge in one comment", PRState{
+				Author: "eve", Labels: []string{"approved"}, RepoLabels: []string{"lgtm"}, Mergeable: true,
+				CheckRuns: success, NeededApprovers: needed, Notifier: notifier, BotUser: "bot",
+			},
+			Event{Type: EventComment, Comment: comment(12, "bob", "/lgtm\n/tests-done")},
+			[]ActionType{ActionAddLabel, ActionMerge, ActionDeleteComment},
+		},
+		{
+			"lgtm cancel", PRState{
+				Author: "eve", Labels: []string{"lgtm"}, NeededApprovers: needed,
+			},
+			Event{Type: EventComment, Comment: comment(13, "bob", "/lgtm cancel")},
+			[]ActionType{ActionRemoveLabel, ActionCreateComment},
+		},
+		{
+			"new PR changing OWNERS publishes check", PRState{
+				Author: "eve", NeededApprovers: needed, OwnersReview: &OwnersReview{Files: []string{"OWNERS"}},
+			},
+			Event{Type: EventNewPR},
+			[]ActionType{ActionCreateCheckRun, ActionCreateComment},
+		},
+		{
+			"check merge granting approval without root approver", PRState{
+				Author: "eve", Labels: []string{"approved", "lgtm"}, Mergeable: true, CheckRuns: success,
+				Approvers: []string{"carol"}, OwnersReview: grants,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"check merge granting approval with root approver", PRState{
+				Author: "eve", Labels: []string{"approved", "lgtm"}, Mergeable: true, CheckRuns: success,
+				Approvers: []string{"carol", "Alice"}, OwnersReview: grants,
+			},
+			Event{Type: EventCheckMerge},
+			[]ActionType{ActionMerge},
+		},
+		{
+			"low API budget postpones the notifier", PRState{
+				Author: "eve", RepoLabels: []string{"lgtm"}, NeededApprovers: needed, Notifier: notifier,
+				LowAPIBudget: true,
+			},
+			Event{Type: EventComment, Comment: comment(14, "bob", "/lgtm")},
+			[]ActionType{ActionAddLabel},
+		},
+		{
+			"tests done", PRState{},
+			Event{Type: EventTestsDone},
+			[]ActionType{ActionCreateComment},
+		},
+	} {
+		state := tc.state
+		actions, err := Decide(&state, tc.event, &DefaultConfig)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.expected, types(actions), tc.name)
+		require.Equal(t, tc.state.Labels, state.Labels, "%s: state must not be modified", tc.name)
+	}
+
+	// Labels missing in the repository cannot be applied
+	_, err := Decide(&PRState{Author: "eve", NeededApprovers: needed}, Event{
+		Type: EventComment, Comment: comment(14, "bob", "/lgtm"),
+	}, &DefaultConfig)
+	require.Error(t, err)
+
+	// Unknown events
+	_, err = Decide(&PRState{}, Event{Type: "PUSH"}, &DefaultConfig)
+	require.Error(t, err)
+}
+
+func TestDecideMergeStrategies(t *testing.T) {
+	str := func(s string) *string { return &s }
+	success := []*gogithub.CheckRun{{Name: str("build"), Status: str("completed"), Conclusion: str("success")}}
+	running := []*gogithub.CheckRun{{Name: str("build"), Status: str("in_progress")}}
+	needed := &owners.List{
+		Files:     []owners.File{{Path: "/repo/OWNERS", Approvers: []owners.User{"alice"}}},
+		Approvers: []owners.User{"alice"},
+	}
+	notifier := &gogithub.IssueComment{
+		ID: gogithub.Int64(10), Body: str("[" + approvalNotifierFlag + "]"), User: &gogithub.User{Login: str("bot")},
+	}
+	ready := []string{"approved", "lgtm"}
+	hold := []string{"approved", "lgtm", "do-not-merge/hold"}
+	enabled := NotifierState{AutoMerge: true}
+	queued := NotifierState{Queued: true}
+
+	for _, tc := range []struct {
+		name     string
+		strategy string
+		state    PRState
+		event    Event
+		expected []ActionType
+	}{
+		{
+			"auto-merge does not wait for checks", MergeStrategyAutoMerge,
+			PRState{Labels: ready, Mergeable: true, CheckRuns: running, NeededApprovers: needed, Notifier: notifier},
+			Event{Type: EventCheckMerge},
+			[]ActionType{ActionEnableAutoMerge, ActionDeleteComment, ActionCreateComment},
+		},
+		{
+			"auto-merge already enabled", MergeStrategyAutoMerge,
+			PRState{Labels: ready, CheckRuns: running, NeededApprovers: needed, NotifierState: enabled},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"auto-merge from the notifier keeps it", MergeStrategyAutoMerge,
+			PRState{Labels: ready, NeededApprovers: needed, Notifier: notifier, BotUser: "bot"},
+			Event{Type: EventComment, Comment: notifier},
+			[]ActionType{ActionEnableAutoMerge, ActionDeleteComment, ActionCreateComment},
+		},
+		{
+			"blocking label disables auto-merge", MergeStrategyAutoMerge,
+			PRState{Labels: hold, Author: "eve", NeededApprovers: needed, NotifierState: enabled},
+			Event{Type: EventNewPR},
+			[]ActionType{ActionDisableAutoMerge, ActionCreateComment},
+		},
+		{
+			"lgtm cancel disables auto-merge", MergeStrategyAutoMerge,
+			PRState{Labels: ready, Author: "eve", NeededApprovers: needed, NotifierState: enabled},
+			Event{Type: EventComment, Comment: &gogithub.IssueComment{Body: str("/lgtm cancel")}},
+			[]ActionType{ActionRemoveLabel, ActionDisableAutoMerge, ActionCreateComment},
+		},
+		{
+			"merge queue waits for checks", MergeStrategyMergeQueue,
+			PRState{Labels: ready, Mergeable: true, CheckRuns: running, NeededApprovers: needed},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+		{
+			"merge queue enqueues", MergeStrategyMergeQueue,
+			PRState{Labels: ready, Mergeable: true, CheckRuns: success, NeededApprovers: needed},
+			Event{Type: EventCheckMerge},
+			[]ActionType{ActionEnqueue, ActionCreateComment},
+		},
+		{
+			"blocking label dequeues", MergeStrategyMergeQueue,
+			PRState{Labels: hold, Mergeable: true, CheckRuns: success, NeededApprovers: needed, NotifierState: queued},
+			Event{Type: EventCheckMerge},
+			[]ActionType{ActionDequeue, ActionCreateComment},
+		},
+		{
+			"blocking label stops direct merges", MergeStrategyDirect,
+			PRState{Labels: hold, Mergeable: true, CheckRuns: success},
+			Event{Type: EventCheckMerge},
+			[]ActionType{},
+		},
+	} {
+		conf := DefaultConfig
+		conf.mergeStrategy = tc.strategy
+		conf.blockingLabels = []string{"do-not-merge/hold"}
+		state := tc.state
+		actions, err := Decide(&state, tc.event, &conf)
+		require.NoError(t, err, tc.name)
+		types := []ActionType{}
+		for _, a := range actions {
+			types = append(types, a.Type)
+		}
+		require.Equal(t, tc.expected, types, tc.name)
+
+		// The refreshed notifier saves the new state
+		for _, a := range actions {
+			if a.Type != ActionCreateComment {
+				continue
+			}
+			saved, err := parseNotifierState(a.Body)
+			require.NoError(t, err, tc.name)
+			require.Equal(t, tc.state.NotifierState.AutoMerge != saved.AutoMerge, hasAction(types, ActionEnableAutoMerge, ActionDisableAutoMerge), tc.name)
+			require.Equal(t, tc.state.NotifierState.Queued != saved.Queued, hasAction(types, ActionEnqueue, ActionDequeue), tc.name)
+		}
+	}
+}
+
+func TestDecideFlakyRetries(t *testing.T) {
+	str := func(s string) *string { return &s }
+	run := func(id int64, name, conclusion string) *gogithub.CheckRun {
+		return &gogithub.CheckRun{
+			ID: &id, Name: str(name), Status: str("completed"), Conclusion: str(conclusion),
+			App: &gogithub.App{Slug: str(github.ActionsAppSlug)},
+		}
+	}
+	external := run(2, "e2e-linux", "failure")
+	external.App.Slug = str("external-ci")
+	flaky := []*gogithub.CheckRun{run(1, "build", "success"), run(2, "e2e-linux", "failure")}
+	broken := []*gogithub.CheckRun{run(1, "build", "failure"), run(2, "e2e-linux", "failure")}
+	notifier := &gogithub.IssueComment{
+		ID: gogithub.Int64(10), Body: str("[" + approvalNotifierFlag + "]"), User: &gogithub.User{Login: str("bot")},
+	}
+	needed := &owners.List{
+		Files:     []owners.File{{Path: "/repo/OWNERS", Approvers: []owners.User{"alice"}}},
+		Approvers: []owners.User{"alice"},
+	}
+	ready := []string{"approved", "lgtm"}
+	sha := fmt.Sprintf("%040d", 1)
+
+	for _, tc := range []struct {
+		name     string
+		state    PRState
+		expected []ActionType
+		retries  int
+	}{
+		{
+			"failed flaky check is re-run",
+			PRState{Labels: ready, Mergeable: true, CheckRuns: flaky, HeadSHA: sha, Notifier: notifier, NeededApprovers: needed},
+			[]ActionType{ActionRerunCheck, ActionDeleteComment, ActionCreateComment}, 1,
+		},
+		{
+			"retries count per commit",
+			PRState{
+				Labels: ready, Mergeable: true, CheckRuns: flaky, HeadSHA: sha, Notifier: notifier, NeededApprovers: needed,
+				NotifierState: NotifierState{RetrySHA: fmt.Sprintf("%040d", 2), FlakyRetries: 2},
+			},
+			[]ActionType{ActionRerunCheck, ActionDeleteComment, ActionCreateComment}, 1,
+		},
+		{
+			"budget is exhausted",
+			PRState{
+				Labels: ready, Mergeable: true, CheckRuns: flaky, HeadSHA: sha, Notifier: notifier, NeededApprovers: needed,
+				NotifierState: NotifierState{RetrySHA: sha, FlakyRetries: 2},
+			},
+			[]ActionType{}, 2,
+		},
+		{
+			"checks without an ID are not retried",
+			PRState{
+				Labels: ready, Mergeable: true, CheckRuns: []*gogithub.CheckRun{run(0, "e2e-linux", "failure")},
+				HeadSHA: sha, Notifier: notifier, NeededApprovers: needed,
+			},
+			[]ActionType{}, 0,
+		},
+		{
+			"checks of other apps are not retried",
+			PRState{
+				Labels: ready, Mergeable: true, CheckRuns: []*gogithub.CheckRun{external},
+				HeadSHA: sha, Notifier: notifier, NeededApprovers: needed,
+			},
+			[]ActionType{}, 0,
+		},
+		{
+			"other failed checks are not retried",
+			PRState{Labels: ready, Mergeable: true, CheckRuns: broken, HeadSHA: sha, Notifier: notifier, NeededApprovers: needed},
+			[]ActionType{}, 0,
+		},
+		{
+			"pull requests that are not ready are not retried",
+			PRState{Labels: []string{"approved"}, Mergeable: true, CheckRuns: flaky, HeadSHA: sha, Notifier: notifier, NeededApprovers: needed},
+			[]ActionType{}, 0,
+		},
+	} {
+		conf := DefaultConfig
+		conf.flakyChecks = []string{"e2e-*"}
+		state := tc.state
+		actions, err := Decide(&state, Event{Type: EventCheckMerge}, &conf)
+		require.NoError(t, err, tc.name)
+		types := []ActionType{}
+		for _, a := range actions {
+			types = append(types, a.Type)
+			switch a.Type {
+			case ActionRerunCheck:
+				require.Equal(t, int64(2), a.RunID, tc.name)
+			case ActionCreateComment:
+				saved, err := parseNotifierState(a.Body)
+				require.NoError(t, err, tc.name)
+				require.Equal(t, NotifierState{RetrySHA: sha, FlakyRetries: tc.retries}, saved, tc.name)
+			}
+		}
+		require.Equal(t, tc.expected, types, tc.name)
+	}
+}
+
+func TestNotifierState(t *testing.T) {
+	state, err := parseNotifierState("[APPROVALNOTIFIER] old notifier")
+	require.NoError(t, err)
+	require.Equal(t, NotifierState{}, state)
+
+	body := "notifier\n" + NotifierState{AutoMerge: true}.render()
+	state, err = parseNotifierState(body)
+	require.NoError(t, err)
+	require.Equal(t, NotifierState{AutoMerge: true}, state)
+
+	_, err = parseNotifierState("notifier " + notifierStateMarker + "{broken")
+	require.Error(t, err)
+	_, err = parseNotifierState("notifier " + notifierStateMarker + "{broken -->")
+	require.Error(t, err)
+}
+
+func TestWorkflowCommands(t *testing.T) {
+	workflow := func(id int64, name, file, state string) *gogithub.Workflow {
+		return &gogithub.Workflow{
+			ID: gogithub.Int64(id), Name: gogithub.String(name), Path: gogithub.String(".github/workflows/" + file),
+			State: gogithub.String(state),
+		}
+	}
+	workflows := []*gogithub.Workflow{
+		workflow(1, "Build", "build.yml", "active"),
+		workflow(2, "Lint", "lint.yaml", "active"),
+		workflow(3, "E2E", "e2e.yml", "active"),
+		workflow(4, "Old", "old.yml", "disabled_manually"),
+	}
+	run := func(id, workflow int64, name, status, conclusion string) *gogithub.WorkflowRun {
+		r := &gogithub.WorkflowRun{
+			ID: gogithub.Int64(id), WorkflowID: gogithub.Int64(workflow), Name: gogithub.String(name),
+			Status: gogithub.String(status),
+		}
+		if conclusion != "" {
+			r.Conclusion = gogithub.String(conclusion)
+		}
+		return r
+	}
+	runs := []*gogithub.WorkflowRun{
+		run(100, 1, "Build", "completed", "failure"),
+		run(101, 2, "Lint", "in_progress", ""),
+		run(102, 3, "E2E", "completed", "success"),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		comment  string
+		state    PRState
+		expected []Action
+		reply    string
+	}{
+		{
+			"retest failed runs", "/retest",
+			PRState{CommenterTrusted: true, WorkflowRuns: runs},
+			[]Action{{Type: ActionRerunFailedJobs, RunID: 100, Reason: "/retest"}},
+			"@bob: re-triggered these workflows:\n\n- `Build`: re-running the failed jobs",
+		},
+		{
+			"retest without failures", "/retest",
+			PRState{CommenterTrusted: true, WorkflowRuns: runs[1:]},
+			[]Action{},
+			"@bob: there are no failed workflow runs to re-run.",
+		},
+		{
+			"retest from untrusted user", "/retest",
+			PRState{WorkflowRuns: runs},
+			[]Action{},
+			"@bob: /retest can only be used by collaborators",
+		},
+		{
+			"test by name and file", "/test e2e lint build.yml",
+			PRState{CommenterTrusted: true, Workflows: workflows, WorkflowRuns: runs},
+			[]Action{
+				{Type: ActionRerunWorkflow, RunID: 102, Reason: "/test e2e"},
+				{Type: ActionRerunWorkflow, RunID: 100, Reason: "/test build.yml"},
+			},
+			"@bob:\n\n- `E2E`: re-running the workflow\n- `Lint`: the workflow is already running\n- `Build`: re-running",
+		},
+		{
+			"test dispatches workflows without runs", "/test E2E",
+			PRState{CommenterTrusted: true, Workflows: workflows, HeadBranch: "fix"},
+			[]Action{{Type: ActionDispatchWorkflow, WorkflowID: 3, Ref: "fix", Reason: "/test E2E"}},
+			"- `E2E`: started the workflow on fix",
+		},
+		{
+			"test in a fork", "/test e2e",
+			PRState{CommenterTrusted: true, Workflows: workflows},
+			[]Action{},
+			"cannot be started in pull requests from forks",
+		},
+		{
+			"test unknown and disabled workflows", "/test deploy old",
+			PRState{CommenterTrusted: true, Workflows: workflows, HeadBranch: "fix"},
+			[]Action{},
+			"- `deploy`: there is no workflow with this name\n- `Old`: the workflow is disabled",
+		},
+		{
+			"test without arguments", "/test",
+			PRState{CommenterTrusted: true, Workflows: workflows},
+			[]Action{},
+			"The workflows of the repository are: `Build`, `Lint`, `E2E`, `Old`",
+		},
+	} {
+		state := tc.state
+		state.Commenter = "bob"
+		d := &decision{state: &state, config: &DefaultConfig}
+		commands, err := ParseSlashCommands(tc.comment)
+		require.NoError(t, err, tc.name)
+		for _, cmd := range commands {
+			require.NoError(t, cmd.Handler.Decide(d, cmd.Command, cmd.Arguments), tc.name)
+		}
+
+		// The reply is the last action
+		require.NotEmpty(t, d.actions, tc.name)
+		reply := d.actions[len(d.actions)-1]
+		require.Equal(t, ActionCreateComment, reply.Type, tc.name)
+		require.Contains(t, reply.Body, tc.reply, tc.name)
+		require.Equal(t, tc.expected, d.actions[:len(d.actions)-1], tc.name)
+	}
+
+	require.True(t, runsWorkflows(&gogithub.IssueComment{Body: gogithub.String("Flaky\n/retest")}))
+	require.False(t, runsWorkflows(&gogithub.IssueComment{Body: gogithub.String("/lgtm")}))
+}
+
+// hasAction returns true if any of the action types is in the list
+func hasAction(list []ActionType, types ...ActionType) bool {
+	for _, a := range list {
+		for _, t := range types {
+			if a == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestContextDataFromEvent(t *testing.T) {
+	t.Setenv("MINIPROW_TOKEN", "token")
+	for _, tc := range []struct {
+		name      string
+		eventName string
+		payload   string
+		event     string
+		pr        int
+		issue     int
+		comment   int64
+		shouldErr bool
+	}{
+		{
+			"pr comment", "", `{"comment":{"id":5},"issue":{"number":3,"pull_request":{}},"repository":{"full_name":"a/b"}}`,
+			EventComment, 3, 0, 5, false,
+		},
+		{
+			"issue comment", "issue_comment", `{"comment":{"id":5},"issue":{"number":3},"repository":{"full_name":"a/b"}}`,
+			EventComment, 0, 3, 5, false,
+		},
+		{
+			"new pr", "", `{"action":"opened","pull_request":{"number":7},"repository":{"full_name":"a/b"}}`,
+			EventNewPR, 7, 0, 0, false,
+		},
+		{
+			"check suite", "", `{"check_suite":{"pull_requests":[{"number":9}]},"repository":{"full_name":"a/b"}}`,
+			EventCheckMerge, 9, 0, 0, false,
+		},
+		{"check suite without pr", "check_suite", `{"check_suite":{}}`, "", 0, 0, 0, true},
+		{"unknown payload", "", `{"ref":"main"}`, "", 0, 0, 0, true},
+		{"unsupported event", "push", `{}`, "", 0, 0, 0, true},
+		{"invalid json", "", `{`, "", 0, 0, 0, true},
+	} {
+		data, err := ContextDataFromEvent(tc.eventName, []byte(tc.payload))
+		if tc.shouldErr {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.event, data.Event(), tc.name)
+		require.Equal(t, "a/b", data.Repository(), tc.name)
+		require.Equal(t, tc.pr, data.PullRequest(), tc.name)
+		require.Equal(t, tc.issue, data.Issue(), tc.name)
+		require.Equal(t, tc.comment, data.CommentID(), tc.name)
+		require.Equal(t, "token", data.GitHubToken(), tc.name)
+	}
+}
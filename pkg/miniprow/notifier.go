@@ -0,0 +1,70 @@
+package miniprow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// notifierStateMarker opens the HTML comment where the broker keeps its
+// state in the approval notifier. GitHub does not render it.
+const notifierStateMarker = "<!-- miniprow-state: "
+
+// NotifierState is the data the broker keeps between runs. It is
+// embedded in the approval notifier as JSON and read back from it the
+// next time the broker runs on the pull request.
+type NotifierState struct {
+	AutoMerge bool `json:"autoMerge,omitempty"` // Auto-merge was enabled by miniprow
+	Queued    bool `json:"queued,omitempty"`    // Pull request added to the merge queue
+
+	// FlakyRetries counts the times the flaky checks were re-run on
+	// the head commit in RetrySHA. It starts again on new commits.
+	RetrySHA     string `json:"retrySHA,omitempty"`
+	FlakyRetries int    `json:"flakyRetries,omitempty"`
+}
+
+// parseNotifierState reads the state embedded in the body of a
+// notifier. Bodies without state return the zero state.
+func parseNotifierState(body string) (NotifierState, error) {
+	state := NotifierState{}
+	_, rest, found := strings.Cut(body, notifierStateMarker)
+	if !found {
+		return state, nil
+	}
+	data, _, found := strings.Cut(rest, " -->")
+	if !found {
+		return state, errors.New("miniprow state in the notifier is not terminated")
+	}
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return NotifierState{}, fmt.Errorf("parsing miniprow state in the notifier: %w", err)
+	}
+	return state, nil
+}
+
+// render returns the state as an HTML comment. The JSON encoder escapes
+// <, > and &, so the values cannot close the comment.
+func (s NotifierState) render() string {
+	data, _ := json.Marshal(s) // Plain fields, it does not fail
+	return notifierStateMarker + string(data) + " -->"
+}
+
+// summary returns the lines telling how the pull request will be
+// merged and the flaky check retries, empty if there is nothing to tell
+func (s NotifierState) summary() string {
+	lines := []string{}
+	switch {
+	case s.Queued:
+		lines = append(lines, "This pull request is in the merge queue, GitHub will merge it when its checks pass.")
+	case s.AutoMerge:
+		lines = append(lines, "Auto-merge is enabled, GitHub will merge this pull request when its checks pass.")
+	}
+	if s.FlakyRetries > 0 {
+		sha := s.RetrySHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		lines = append(lines, fmt.Sprintf("Flaky check retries on commit %s: %d", sha, s.FlakyRetries))
+	}
+	return strings.Join(lines, "\n\n")
+}
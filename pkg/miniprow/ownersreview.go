@@ -0,0 +1,256 @@
+package miniprow
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/owners"
+)
+
+// OwnersCheckName is the name of the check run that reports the
+// result of linting the OWNERS files modified in a pull request
+const OwnersCheckName = "miniprow/owners"
+
+// OwnersReview is the result of checking the OWNERS and OWNERS_ALIASES
+// files modified by a pull request
+type OwnersReview struct {
+	// Files are the OWNERS and OWNERS_ALIASES files changed in the PR
+	Files []string `json:"files"`
+
+	// Problems found by the linter in the PR version of the files
+	Problems []owners.Problem `json:"problems"`
+
+	// NewApprovers are the approval rights granted by the PR. If there
+	// are any, the PR needs the approval of a root approver.
+	NewApprovers []owners.Grant `json:"newApprovers"`
+
+	// RootApprovers are the approvers of the repository root in the
+	// base branch
+	RootApprovers []string `json:"rootApprovers"`
+
+	// CompareError is set when the base and PR versions of the files
+	// could not be compared. The PR may grant approval rights, so it
+	// needs the approval of a root approver.
+	CompareError string `json:"compareError,omitempty"`
+}
+
+// NeedsRootApproval returns true if the PR grants new approval rights
+// or it is unknown if it does
+func (r *OwnersReview) NeedsRootApproval() bool {
+	return r != nil && (len(r.NewApprovers) > 0 || r.CompareError != "")
+}
+
+// IsRootApprover returns true if user is a root approver
+func (r *OwnersReview) IsRootApprover(user string) bool {
+	for _, u := range r.RootApprovers {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// newUsers returns the users that gain approval rights
+func (r *OwnersReview) newUsers() []string {
+	seen := map[string]struct{}{}
+	users := []string{}
+	for _, g := range r.NewApprovers {
+		if _, ok := seen[g.User]; ok {
+			continue
+		}
+		seen[g.User] = struct{}{}
+		users = append(users, g.User)
+	}
+	return users
+}
+
+// grantees describes the users that gain approval rights
+func (r *OwnersReview) grantees() string {
+	if r.CompareError != "" {
+		return "unknown users (unable to compare the OWNERS files: " + r.CompareError + ")"
+	}
+	return strings.Join(r.newUsers(), ", ")
+}
+
+// checkResult builds the check run that reports the review
+func (r *OwnersReview) checkResult() *CheckResult {
+	errorCount, warningCount := 0, 0
+	for _, p := range r.Problems {
+		if p.Severity == owners.SeverityError {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+
+	check := &CheckResult{
+		Name:       OwnersCheckName,
+		Conclusion: "success",
+		Title:      "OWNERS files are valid",
+		Problems:   r.Problems,
+	}
+	if errorCount > 0 {
+		check.Conclusion = "failure"
+	}
+	if len(r.Problems) > 0 {
+		check.Title = fmt.Sprintf("%d errors and %d warnings in OWNERS files", errorCount, warningCount)
+	}
+
+	check.Summary = "Checked files:\n\n"
+	for _, f := range r.Files {
+		check.Summary += "- `" + f + "`\n"
+	}
+	if r.NeedsRootApproval() {
+		check.Summary += "\nThis pull request grants approval rights to: " + r.grantees() + ".\n" +
+			"It needs the approval of a root approver to merge: " +
+			strings.Join(r.RootApprovers, ", ") + ".\n"
+	}
+	return check
+}
+
+// isOwnersFile returns true if a path is an OWNERS or OWNERS_ALIASES file
+func isOwnersFile(path string) bool {
+	return filepath.Base(path) == owners.OwnersFileName || path == owners.AliasesFileName
+}
+
+// ReadOwnersReview checks the OWNERS and OWNERS_ALIASES files changed in
+// the pull request. It returns nil if the PR does not modify any. The
+// linter runs on the PR version of the files, downloaded from GitHub.
+//
+// The returned overlay has the base branch version of the changed files,
+// indexed by absolute path, so that owners are computed without the
+// changes made by the pull request.
+func (b *Broker) ReadOwnersReview(repoRoot string) (*OwnersReview, map[string][]byte, error) {
+	files, err := b.impl.GetChangedFiles(b.ctx, b.GitHub(), b.State)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing pull request files: %w", err)
+	}
+	changed := []string{}
+	for _, f := range files {
+		for _, name := range []string{f.GetFilename(), f.GetPreviousFilename()} {
+			if name != "" && isOwnersFile(name) {
+				changed = append(changed, name)
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+	logrus.Infof("🔏 Pull request modifies %d OWNERS files", len(changed))
+
+	// The aliases file is always read to expand the aliases, and the
+	// parent OWNERS files to check the changes to no_parent_owners
+	paths := append([]string{}, changed...)
+	for _, path := range append(parentOwnersFiles(changed), owners.AliasesFileName) {
+		if !contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	base := map[string][]byte{}
+	head := map[string][]byte{}
+	for _, path := range paths {
+		if base[path], err = b.readFileAt(path, b.State.PullRequest.GetBase().GetSHA()); err != nil {
+			return nil, nil, err
+		}
+		if head[path], err = b.readFileAt(path, b.State.PullRequest.GetHead().GetSHA()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	review := &OwnersReview{Files: changed, Problems: []owners.Problem{}, RootApprovers: []string{}}
+
+	// Lint the PR version, reporting only the files it changes
+	lint := map[string][]byte{}
+	for path, data := range head {
+		if data != nil {
+			lint[path] = data
+		}
+	}
+	problems, err := owners.ValidateContents(lint, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("linting OWNERS files: %w", err)
+	}
+	for _, p := range problems {
+		if contains(changed, p.File) {
+			review.Problems = append(review.Problems, p)
+		}
+	}
+
+	// Broken files may grant approval rights, they need a root approver
+	review.NewApprovers, err = owners.NewApprovers(base, head)
+	if err != nil {
+		logrus.Warnf("Unable to compare OWNERS files: %v", err)
+		review.NewApprovers = []owners.Grant{}
+		review.CompareError = err.Error()
+	}
+
+	overlay := map[string][]byte{}
+	for _, path := range changed {
+		overlay[filepath.Join(repoRoot, path)] = base[path]
+	}
+
+	if review.NeedsRootApproval() {
+		list, err := owners.NewReaderWithOptions(&owners.Options{Overlay: overlay}).GetPathOwners(repoRoot)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading root approvers: %w", err)
+		}
+		for _, u := range list.Approvers {
+			review.RootApprovers = append(review.RootApprovers, string(u))
+		}
+		sort.Strings(review.RootApprovers)
+		logrus.Infof(
+			"Pull request grants approval rights to %s, root approval required",
+			review.grantees(),
+		)
+	}
+	return review, overlay, nil
+}
+
+// parentOwnersFiles returns the paths of the OWNERS files in the parent
+// directories of a list of OWNERS files
+func parentOwnersFiles(files []string) []string {
+	parents := []string{}
+	for _, path := range files {
+		if filepath.Base(path) != owners.OwnersFileName {
+			continue
+		}
+		for dir := filepath.Dir(path); dir != "."; {
+			dir = filepath.Dir(dir)
+			if parent := filepath.Join(dir, owners.OwnersFileName); !contains(parents, parent) {
+				parents = append(parents, parent)
+			}
+		}
+	}
+	return parents
+}
+
+// readFileAt downloads a file at a git ref. It returns nil if the file
+// does not exist.
+func (b *Broker) readFileAt(path, ref string) ([]byte, error) {
+	data, err := b.impl.GetFileContents(b.ctx, b.GitHub(), path, ref)
+	if errors.Is(err, github.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at %s: %w", path, ref, err)
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+// contains returns true if a list of strings has s
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
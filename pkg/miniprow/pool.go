@@ -0,0 +1,308 @@
+package miniprow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+	"github.com/uservers/miniprow/pkg/git"
+	"github.com/uservers/miniprow/pkg/github"
+)
+
+// PoolCheckName is the name of the check run that marks the pull
+// requests that broke the tests of the merge pool. The failed check
+// keeps them out of the pool until a new commit is pushed.
+const PoolCheckName = "miniprow/merge-pool"
+
+// checksPollInterval is the time between two reads of the checks of
+// the staging branch
+var checksPollInterval = 30 * time.Second
+
+// mergePool tests pull requests on top of the current base branch
+// before merging them. The merge commits are built in a worktree of the
+// repository clone and pushed to the staging branch where CI tests them.
+type mergePool struct {
+	broker  *Broker
+	repo    *git.Repo
+	base    string // Base branch of the pull requests in the pool
+	results map[int]SweepResult
+}
+
+// runMergePool merges the pull requests in the pool, the brokers of
+// pull requests ready to merge. Returns what was done with each one.
+func (b *Broker) runMergePool(prs []*Broker) (map[int]SweepResult, error) {
+	results := map[int]SweepResult{}
+	repo, err := git.Open(b.RepoRoot())
+	if err != nil {
+		return results, fmt.Errorf("opening repository clone: %w", err)
+	}
+
+	// Pull requests to other branches are tested separately
+	byBase := map[string][]*Broker{}
+	for _, pb := range prs {
+		base := pb.State.PullRequest.GetBase().GetRef()
+		byBase[base] = append(byBase[base], pb)
+	}
+	bases := []string{}
+	for base := range byBase {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	for _, base := range bases {
+		pool := &mergePool{broker: b, repo: repo, base: base, results: results}
+		if err := pool.run(byBase[base]); err != nil {
+			return results, fmt.Errorf("merging pull requests into %s: %w", base, err)
+		}
+	}
+	return results, nil
+}
+
+// run tests and merges the pull requests according to the pool mode
+func (p *mergePool) run(prs []*Broker) error {
+	conf := p.broker.config.MergePool()
+	if conf.Mode == MergePoolRetest {
+		for _, pb := range prs {
+			if err := p.runBatch([]*Broker{pb}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	size := conf.MaxBatchSize
+	if size > len(prs) {
+		size = len(prs)
+	}
+	p.skip(prs[size:], "waiting for the next batch")
+	return p.runBatch(prs[:size])
+}
+
+// runBatch tests the pull requests together on top of the base branch
+// and merges them if the tests pass. If they fail, the batch is bisected
+// looking for the first pull request that breaks it. Those before it
+// are merged.
+func (p *mergePool) runBatch(batch []*Broker) error {
+	tip, err := p.repo.FetchBranch(p.base)
+	if err != nil {
+		return err
+	}
+
+	// A pull request tested on the current base does not need a test
+	if len(batch) == 1 && batch[0].State.PullRequest.GetBase().GetSHA() == tip {
+		logrus.Infof("PR #%d was tested on the current %s, merging", number(batch[0]), p.base)
+		return p.merge(tip, batch, "tested on the current base")
+	}
+
+	wt, err := p.repo.Worktree(tip)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := wt.Remove(); err != nil {
+			logrus.Warnf("Unable to clean up the merge pool worktree: %v", err)
+		}
+	}()
+
+	batch, err = p.stage(wt, tip, batch)
+	if err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	passed, err := p.test(wt, batch)
+	if err != nil {
+		return err
+	}
+	if passed {
+		return p.merge(tip, batch, "passed the merge pool tests")
+	}
+
+	// The first good pull requests are always good, the last bad ones
+	// always bad. Find the longest prefix that passes. Pull requests are
+	// staged again, only those that still merge are tested and merged.
+	good, bad := 0, len(batch)
+	passing := []*Broker{}
+	for bad-good > 1 {
+		mid := (good + bad) / 2
+		staged, err := p.stage(wt, tip, batch[:mid])
+		if err != nil {
+			return err
+		}
+		passed := true
+		if len(staged) > 0 {
+			passed, err = p.test(wt, staged)
+			if err != nil {
+				return err
+			}
+		}
+		if passed {
+			good, passing = mid, staged
+		} else {
+			bad = mid
+		}
+	}
+
+	culprit := batch[bad-1]
+	if err := p.reportFailure(culprit, passing); err != nil {
+		return err
+	}
+	p.skip(batch[bad:], "waiting for the next batch")
+	return p.merge(tip, passing, "passed the merge pool tests")
+}
+
+// stage builds the merge commits of the batch on top of the base in the
+// worktree. Pull requests that do not merge cleanly are left out, the
+// rest are returned.
+func (p *mergePool) stage(wt *git.Repo, tip string, batch []*Broker) ([]*Broker, error) {
+	if err := wt.Reset(tip); err != nil {
+		return nil, err
+	}
+	staged := []*Broker{}
+	for _, pb := range batch {
+		head, err := p.repo.FetchPullRequest(number(pb))
+		if err != nil {
+			return nil, err
+		}
+		if head != pb.State.PullRequest.GetHead().GetSHA() {
+			p.skip([]*Broker{pb}, "head changed since it was evaluated")
+			continue
+		}
+		err = wt.Merge(head, fmt.Sprintf("Merge pull request #%d", number(pb)))
+		if errors.Is(err, git.ErrConflict) {
+			p.skip([]*Broker{pb}, "conflicts with the base branch or the batch")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, pb)
+	}
+	return staged, nil
+}
+
+// test pushes the commit in the worktree to the staging branch and
+// waits for its checks. In dry-run mode the push is only recorded and
+// the batch is considered to pass.
+func (p *mergePool) test(wt *git.Repo, batch []*Broker) (bool, error) {
+	sha, err := wt.Head()
+	if err != nil {
+		return false, err
+	}
+	branch := p.broker.config.MergePool().Branch
+	if gh := p.broker.GitHub(); gh.DryRun() {
+		logrus.Infof("[dry-run] Not pushing %s to %s to test %s", sha, branch, numbers(batch))
+		gh.PlanAction(github.PlannedAction{
+			Action: "PushStagingBranch", Repo: p.broker.ctx.Value(ckey).(ContextData).Repository(),
+			Detail: fmt.Sprintf("%s at %s to test %s", branch, sha, numbers(batch)),
+		})
+		return true, nil
+	}
+	if err := wt.ForcePush(branch); err != nil {
+		return false, err
+	}
+	logrus.Infof("🧪 Testing %s on %s (%s)", numbers(batch), branch, sha)
+	passed, err := p.broker.impl.WaitForChecks(p.broker.ctx, p.broker.GitHub(), &p.broker.config, sha)
+	if err != nil {
+		return false, err
+	}
+	logrus.Infof("Merge pool tests of %s passed: %t", numbers(batch), passed)
+	return passed, nil
+}
+
+// merge merges the batch if the base branch did not move while testing
+func (p *mergePool) merge(tip string, batch []*Broker, reason string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	current, err := p.repo.RemoteHead(p.base)
+	if err != nil {
+		return err
+	}
+	if current != tip {
+		p.skip(batch, "base branch moved while testing")
+		return nil
+	}
+	for i, pb := range batch {
+		merged, err := pb.mergePullRequest(false)
+		if err != nil {
+			return fmt.Errorf("merging PR #%d: %w", number(pb), err)
+		}
+		if !merged {
+			// The rest were tested with this one
+			p.skip(batch[i:], "a previous pull request of the batch did not merge")
+			p.results[number(pb)] = SweepResult{Number: number(pb), Reason: "GitHub did not merge it"}
+			return nil
+		}
+		p.results[number(pb)] = SweepResult{Number: number(pb), Merged: true, Reason: reason}
+	}
+	return nil
+}
+
+// reportFailure publishes a failed check run on the pull request that
+// broke the batch. The pull requests merged before it passed the tests.
+func (p *mergePool) reportFailure(culprit *Broker, passed []*Broker) error {
+	p.skip([]*Broker{culprit}, "failed the merge pool tests")
+	summary := fmt.Sprintf(
+		"The tests failed after merging this pull request into %s on top of %s. "+
+			"Push a new commit once fixed to add it to the merge pool again.",
+		p.base, numbers(passed),
+	)
+	if len(passed) == 0 {
+		summary = fmt.Sprintf(
+			"The tests failed after merging this pull request into the current %s. "+
+				"Push a new commit once fixed to add it to the merge pool again.",
+			p.base,
+		)
+	}
+	err := culprit.impl.CreateCheckRun(culprit.ctx, culprit.GitHub(), culprit.State, &CheckResult{
+		Name:       PoolCheckName,
+		Conclusion: "failure",
+		Title:      "Merge pool tests failed",
+		Summary:    summary,
+	})
+	if err != nil {
+		return fmt.Errorf("reporting merge pool failure of PR #%d: %w", number(culprit), err)
+	}
+	return nil
+}
+
+// skip records why pull requests were not merged
+func (p *mergePool) skip(batch []*Broker, reason string) {
+	for _, pb := range batch {
+		p.results[number(pb)] = SweepResult{Number: number(pb), Reason: reason}
+	}
+}
+
+// number returns the number of the pull request handled by a broker
+func number(pb *Broker) int {
+	return pb.State.PullRequest.GetNumber()
+}
+
+// numbers returns the list of pull requests in a batch
+func numbers(batch []*Broker) string {
+	list := []string{}
+	for _, pb := range batch {
+		list = append(list, fmt.Sprintf("#%d", number(pb)))
+	}
+	return strings.Join(list, ", ")
+}
+
+// testedOnCurrentBase returns true if the base branch has not moved
+// since the pull request was tested
+func (b *Broker) testedOnCurrentBase(pr *gogithub.PullRequest) (bool, error) {
+	repo, err := git.Open(b.RepoRoot())
+	if err != nil {
+		return false, fmt.Errorf("opening repository clone: %w", err)
+	}
+	tip, err := repo.RemoteHead(pr.GetBase().GetRef())
+	if err != nil {
+		return false, err
+	}
+	return tip == pr.GetBase().GetSHA(), nil
+}
@@ -0,0 +1,254 @@
+package miniprow
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/stretchr/testify/require"
+	"github.com/uservers/miniprow/pkg/github"
+	"github.com/uservers/miniprow/pkg/github/githubfake"
+)
+
+// poolTestImpl replaces the CI of the staging branch: commits with a
+// broken.txt file fail the tests. onTest runs while each test runs.
+type poolTestImpl struct {
+	*defaultBrokerImplementation
+	t      *testing.T
+	remote string
+	tested []string
+	onTest func()
+}
+
+func (i *poolTestImpl) WaitForChecks(_ context.Context, _ *github.GitHub, conf *Config, sha string) (bool, error) {
+	require.Equal(i.t, sha, runGit(i.t, i.remote, "rev-parse", conf.MergePool().Branch))
+	i.tested = append(i.tested, sha)
+	if i.onTest != nil {
+		i.onTest()
+	}
+	return exec.Command("git", "-C", i.remote, "cat-file", "-e", sha+":broken.txt").Run() != nil, nil
+}
+
+// runGit runs git in dir and returns the output
+func runGit(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// poolRepo is a remote repository with pull requests and a clone of it
+type poolRepo struct {
+	t      *testing.T
+	remote string
+	work   string
+	first  string // First commit of main, the base of all the pull requests
+}
+
+// mkPoolRepo creates the remote with the OWNERS files in main
+func mkPoolRepo(t *testing.T) *poolRepo {
+	tmp := t.TempDir()
+	r := &poolRepo{t: t, remote: filepath.Join(tmp, "remote.git"), work: filepath.Join(tmp, "work")}
+	runGit(t, tmp, "init", "--quiet", "--bare", "-b", "main", r.remote)
+	runGit(t, tmp, "clone", "--quiet", r.remote, r.work)
+	r.commit("OWNERS", "approvers:\n  - alice\nreviewers:\n  - alice\n")
+	r.first = r.commit("README.md", "test\n")
+	runGit(t, r.work, "push", "--quiet", "origin", "HEAD:refs/heads/main")
+	return r
+}
+
+func (r *poolRepo) commit(path, content string) string {
+	require.NoError(r.t, os.WriteFile(filepath.Join(r.work, path), []byte(content), os.FileMode(0o644)))
+	runGit(r.t, r.work, "add", path)
+	runGit(r.t, r.work, "commit", "--quiet", "-m", "update "+path)
+	return runGit(r.t, r.work, "rev-parse", "HEAD")
+}
+
+// addPullRequest pushes a pull request changing a file on top of the
+// first commit and adds it to the fake, ready to merge
+func (r *poolRepo) addPullRequest(fake *githubfake.Client, number int, path, content string) *gogithub.PullRequest {
+	sha := r.pushPullRequest(number, path, content)
+	pr := fake.AddPullRequest(testRepo, number, "eve", []string{path}, "approved", "lgtm")
+	pr.Head.SHA = gogithub.String(sha)
+	pr.Base.SHA = gogithub.String(r.first)
+	fake.AddCheckRun(testRepo, sha, "build", "completed", "success")
+	return pr
+}
+
+// pushPullRequest pushes a new head for a pull request and returns it
+func (r *poolRepo) pushPullRequest(number int, path, content string) string {
+	runGit(r.t, r.work, "checkout", "--quiet", "--detach", r.first)
+	sha := r.commit(path, content)
+	runGit(r.t, r.work, "push", "--quiet", "--force", "origin", "HEAD:refs/pull/"+strconv.Itoa(number)+"/head")
+	return sha
+}
+
+// moveMain pushes a new commit to main and returns it
+func (r *poolRepo) moveMain(path, content string) string {
+	runGit(r.t, r.work, "checkout", "--quiet", "--detach", r.first)
+	sha := r.commit(path, content)
+	runGit(r.t, r.work, "push", "--quiet", "origin", "HEAD:refs/heads/main")
+	return sha
+}
+
+// clone creates the workspace of the broker
+func (r *poolRepo) clone() {
+	dir := filepath.Join(r.t.TempDir(), "clone")
+	runGit(r.t, r.t.TempDir(), "clone", "--quiet", "--depth", "1", "file://"+r.remote, dir)
+	r.t.Setenv("GITHUB_WORKSPACE", dir)
+}
+
+func newPoolBroker(t *testing.T, fake *githubfake.Client, r *poolRepo, event string, pr int, mode string) (*Broker, *poolTestImpl) {
+	b := newTestBroker(t, fake, event, pr, 0)
+	impl := &poolTestImpl{defaultBrokerImplementation: &defaultBrokerImplementation{}, t: t, remote: r.remote}
+	b.impl = impl
+	b.config.mergePool.Mode = mode
+	return b, impl
+}
+
+func TestMergePoolBatch(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	r.addPullRequest(fake, 2, "b.txt", "b\n")
+	broken := r.addPullRequest(fake, 3, "broken.txt", "x\n")
+	r.addPullRequest(fake, 4, "d.txt", "d\n")
+	r.addPullRequest(fake, 5, "README.md", "conflict\n")
+	r.moveMain("README.md", "moved\n")
+	r.clone()
+
+	b, impl := newPoolBroker(t, fake, r, EventSweep, 0, MergePoolBatch)
+	require.NoError(t, b.Run())
+
+	// #5 does not merge, the batch #1-#4 fails. #1 and #2 pass, #3 breaks them.
+	require.Equal(t, []SweepResult{
+		{Number: 1, Merged: true, Reason: "passed the merge pool tests"},
+		{Number: 2, Merged: true, Reason: "passed the merge pool tests"},
+		{Number: 3, Reason: "failed the merge pool tests"},
+		{Number: 4, Reason: "waiting for the next batch"},
+		{Number: 5, Reason: "conflicts with the base branch or the batch"},
+	}, b.SweepResults())
+	require.Equal(t, []int{1, 2}, fake.Merges(testRepo))
+	require.Len(t, impl.tested, 3)
+
+	runs, err := fake.ListCheckRunsForRef(context.Background(), "uservers", "test", broken.GetHead().GetSHA(), nil)
+	require.NoError(t, err)
+	require.Len(t, runs.CheckRuns, 2)
+	require.Equal(t, PoolCheckName, runs.CheckRuns[1].GetName())
+	require.Equal(t, "failure", runs.CheckRuns[1].GetConclusion())
+}
+
+func TestMergePoolRetest(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	fresh := r.addPullRequest(fake, 2, "b.txt", "b\n")
+	fresh.Base.SHA = gogithub.String(r.moveMain("c.txt", "c\n"))
+	r.clone()
+
+	// Events do not merge pull requests tested on an old base
+	b, impl := newPoolBroker(t, fake, r, EventCheckMerge, 1, MergePoolRetest)
+	require.NoError(t, b.Run())
+	require.Empty(t, fake.Merges(testRepo))
+	require.Empty(t, impl.tested)
+
+	// The sweep tests #1 on the current main, #2 does not need it
+	b, impl = newPoolBroker(t, fake, r, EventSweep, 0, MergePoolRetest)
+	require.NoError(t, b.Run())
+	require.Equal(t, []SweepResult{
+		{Number: 1, Merged: true, Reason: "passed the merge pool tests"},
+		{Number: 2, Merged: true, Reason: "tested on the current base"},
+	}, b.SweepResults())
+	require.Equal(t, []int{1, 2}, fake.Merges(testRepo))
+	require.Len(t, impl.tested, 1)
+}
+
+func TestMergePoolHeadPushedWhileTesting(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	r.addPullRequest(fake, 2, "b.txt", "b\n")
+	r.clone()
+
+	// A new commit in #2 while the batch is tested is not merged untested
+	b, impl := newPoolBroker(t, fake, r, EventSweep, 0, MergePoolBatch)
+	impl.onTest = func() {
+		fake.SetHead(testRepo, 2, r.pushPullRequest(2, "b.txt", "untested\n"))
+	}
+	require.NoError(t, b.Run())
+	require.Equal(t, []SweepResult{
+		{Number: 1, Merged: true, Reason: "passed the merge pool tests"},
+		{Number: 2, Reason: "GitHub did not merge it"},
+	}, b.SweepResults())
+	require.Equal(t, []int{1}, fake.Merges(testRepo))
+}
+
+func TestMergePoolBisectSkipsChangedHeads(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	r.addPullRequest(fake, 2, "b.txt", "b\n")
+	r.addPullRequest(fake, 3, "broken.txt", "x\n")
+	r.clone()
+
+	// #1 changes after the batch fails, it is left out of the bisection
+	b, impl := newPoolBroker(t, fake, r, EventSweep, 0, MergePoolBatch)
+	impl.onTest = func() {
+		if len(impl.tested) == 1 {
+			fake.SetHead(testRepo, 1, r.pushPullRequest(1, "a.txt", "untested\n"))
+		}
+	}
+	require.NoError(t, b.Run())
+	require.Equal(t, []SweepResult{
+		{Number: 1, Reason: "head changed since it was evaluated"},
+		{Number: 2, Merged: true, Reason: "passed the merge pool tests"},
+		{Number: 3, Reason: "failed the merge pool tests"},
+	}, b.SweepResults())
+	require.Equal(t, []int{2}, fake.Merges(testRepo))
+	require.Len(t, impl.tested, 2)
+}
+
+func TestMergePoolDryRun(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	r.addPullRequest(fake, 2, "b.txt", "b\n")
+	r.clone()
+
+	// The staging branch is not pushed and its checks are not awaited
+	b, impl := newPoolBroker(t, fake, r, EventSweep, 0, MergePoolBatch)
+	b.GitHub().EnableDryRun()
+	require.NoError(t, b.Run())
+	require.Empty(t, impl.tested)
+	require.Empty(t, fake.Merges(testRepo))
+	require.Error(t, exec.Command("git", "-C", r.remote, "rev-parse", "--verify", b.config.MergePool().Branch).Run())
+
+	actions := []string{}
+	for _, a := range b.GitHub().PlannedActions() {
+		actions = append(actions, a.Action)
+	}
+	require.Equal(t, []string{"PushStagingBranch", "MergePullRequest", "MergePullRequest"}, actions)
+}
+
+func TestMergePoolKeepsNotifierWhenBaseMoved(t *testing.T) {
+	r := mkPoolRepo(t)
+	fake := newTestFake()
+	r.addPullRequest(fake, 1, "a.txt", "a\n")
+	r.moveMain("c.txt", "c\n")
+	r.clone()
+	notifier := fake.AddComment(
+		testRepo, 1, githubfake.DefaultBotUser, "["+approvalNotifierFlag+"] This PR is __APPROVED__",
+	)
+
+	// The pull request is left to the pool, its notifier keeps its state
+	b := newTestBroker(t, fake, EventComment, 1, notifier.GetID())
+	b.config.mergePool.Mode = MergePoolRetest
+	require.NoError(t, b.Run())
+	require.Empty(t, fake.Merges(testRepo))
+	require.Len(t, notifierComments(fake, 1), 1)
+}
@@ -0,0 +1,197 @@
+package miniprow
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+)
+
+// retestHandler re-runs the failed jobs of the workflow runs of the
+// pull request head
+type retestHandler struct{}
+
+func (h *retestHandler) Decide(d *decision, commandName string, arguments []string) error {
+	if !d.trustedCommand(commandName) {
+		return nil
+	}
+	lines := []string{}
+	for _, run := range d.state.WorkflowRuns {
+		if !workflowRunFailed(run) {
+			continue
+		}
+		d.add(Action{Type: ActionRerunFailedJobs, RunID: run.GetID(), Reason: "/" + commandName})
+		lines = append(lines, fmt.Sprintf("- `%s`: re-running the failed jobs", run.GetName()))
+	}
+	if len(lines) == 0 {
+		d.reply(commandName, "there are no failed workflow runs to re-run.")
+		return nil
+	}
+	d.reply(commandName, "re-triggered these workflows:\n\n"+strings.Join(lines, "\n"))
+	return nil
+}
+
+// testHandler re-runs the workflows passed as arguments. Workflows that
+// did not run on the pull request head are dispatched on its branch.
+type testHandler struct{}
+
+func (h *testHandler) Decide(d *decision, commandName string, arguments []string) error {
+	if !d.trustedCommand(commandName) {
+		return nil
+	}
+	if len(arguments) == 0 {
+		names := []string{}
+		for _, w := range d.state.Workflows {
+			names = append(names, "`"+w.GetName()+"`")
+		}
+		d.reply(commandName, fmt.Sprintf(
+			"/%s needs the name of a workflow. The workflows of the repository are: %s",
+			commandName, strings.Join(names, ", "),
+		))
+		return nil
+	}
+
+	lines := []string{}
+	for _, name := range arguments {
+		lines = append(lines, d.testWorkflow(commandName, name))
+	}
+	d.reply(commandName, "\n\n"+strings.Join(lines, "\n"))
+	return nil
+}
+
+// testWorkflow adds the action that runs a workflow again and returns
+// the line reporting it
+func (d *decision) testWorkflow(commandName, name string) string {
+	workflow := findWorkflow(d.state.Workflows, name)
+	if workflow == nil {
+		return fmt.Sprintf("- `%s`: there is no workflow with this name", name)
+	}
+	if workflow.GetState() != "active" {
+		return fmt.Sprintf("- `%s`: the workflow is disabled", workflow.GetName())
+	}
+	for _, run := range d.state.WorkflowRuns {
+		if run.GetWorkflowID() != workflow.GetID() {
+			continue
+		}
+		if run.GetStatus() != "completed" {
+			return fmt.Sprintf("- `%s`: the workflow is already running", workflow.GetName())
+		}
+		d.add(Action{Type: ActionRerunWorkflow, RunID: run.GetID(), Reason: "/" + commandName + " " + name})
+		return fmt.Sprintf("- `%s`: re-running the workflow", workflow.GetName())
+	}
+
+	// Branches in forks cannot be used to dispatch workflows
+	if d.state.HeadBranch == "" {
+		return fmt.Sprintf(
+			"- `%s`: the workflow did not run and it cannot be started in pull requests from forks",
+			workflow.GetName(),
+		)
+	}
+	d.add(Action{
+		Type: ActionDispatchWorkflow, WorkflowID: workflow.GetID(), Ref: d.state.HeadBranch,
+		Reason: "/" + commandName + " " + name,
+	})
+	return fmt.Sprintf("- `%s`: started the workflow on %s", workflow.GetName(), d.state.HeadBranch)
+}
+
+// trustedCommand returns true if the commenter can run commands that
+// trigger workflows, otherwise it replies explaining why not
+func (d *decision) trustedCommand(commandName string) bool {
+	if d.state.CommenterTrusted {
+		return true
+	}
+	logrus.Infof("User %s is not allowed to run /%s", d.state.Commenter, commandName)
+	d.reply(commandName, fmt.Sprintf(
+		"/%s can only be used by collaborators of the repository and the users in its root OWNERS file.",
+		commandName,
+	))
+	return false
+}
+
+// reply adds a comment answering the commenter
+func (d *decision) reply(commandName, text string) {
+	if !strings.HasPrefix(text, "\n") {
+		text = " " + text
+	}
+	d.add(Action{
+		Type: ActionCreateComment, Body: "@" + d.state.Commenter + ":" + text, Reason: "/" + commandName,
+	})
+}
+
+// findWorkflow returns the workflow matching name, compared with the
+// workflow name and its file name, with or without extension
+func findWorkflow(workflows []*gogithub.Workflow, name string) *gogithub.Workflow {
+	for _, w := range workflows {
+		file := path.Base(w.GetPath())
+		if strings.EqualFold(w.GetName(), name) || file == name ||
+			strings.TrimSuffix(file, path.Ext(file)) == name {
+			return w
+		}
+	}
+	return nil
+}
+
+// workflowRunFailed returns true if the run has jobs that can be re-run
+func workflowRunFailed(run *gogithub.WorkflowRun) bool {
+	if run.GetStatus() != "completed" {
+		return false
+	}
+	switch run.GetConclusion() {
+	case "failure", "timed_out", "cancelled":
+		return true
+	}
+	return false
+}
+
+// runsWorkflows returns true if the comment has commands that trigger
+// workflows. The workflow data is only read for those comments.
+func runsWorkflows(comment *gogithub.IssueComment) bool {
+	commands, err := ParseSlashCommands(strings.TrimSpace(comment.GetBody()))
+	if err != nil {
+		return false
+	}
+	for _, cmd := range commands {
+		if cmd.Command == RetestCommand || cmd.Command == TestCommand {
+			return true
+		}
+	}
+	return false
+}
+
+// readWorkflowState fills the data used by /retest and /test. The
+// workflows are only read if the commenter is trusted.
+func (b *Broker) readWorkflowState(s *PRState, comment *gogithub.IssueComment, overlay map[string][]byte) error {
+	repo := b.ctx.Value(ckey).(ContextData).Repository()
+	pr := b.State.PullRequest
+	s.Commenter = comment.GetUser().GetLogin()
+	if pr.GetHead().GetRepo().GetFullName() == pr.GetBase().GetRepo().GetFullName() {
+		s.HeadBranch = pr.GetHead().GetRef()
+	}
+
+	userPerms, err := b.impl.GetUserPerms(b.ctx, s.Commenter, overlay)
+	if err != nil {
+		return fmt.Errorf("getting the commenter's permissions: %w", err)
+	}
+	s.CommenterTrusted = userPerms["approver"] || userPerms["reviewer"]
+	if !s.CommenterTrusted {
+		s.CommenterTrusted, err = b.impl.IsCollaborator(b.ctx, b.GitHub(), repo, s.Commenter)
+		if err != nil {
+			return fmt.Errorf("checking if %s is a collaborator: %w", s.Commenter, err)
+		}
+	}
+	if !s.CommenterTrusted {
+		return nil
+	}
+
+	s.Workflows, err = b.impl.ListWorkflows(b.ctx, b.GitHub(), repo)
+	if err != nil {
+		return fmt.Errorf("listing workflows: %w", err)
+	}
+	s.WorkflowRuns, err = b.impl.ListWorkflowRuns(b.ctx, b.GitHub(), repo, pr.GetHead().GetSHA())
+	if err != nil {
+		return fmt.Errorf("listing workflow runs: %w", err)
+	}
+	return nil
+}
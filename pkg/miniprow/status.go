@@ -0,0 +1,75 @@
+package miniprow
+
+import (
+	"fmt"
+)
+
+// PRStatus summarizes if a pull request can be merged by miniprow
+type PRStatus struct {
+	Repo          string        `json:"repo"`
+	Number        int           `json:"number"`
+	Author        string        `json:"author"`
+	Labels        []string      `json:"labels"`
+	MissingLabels []string      `json:"missingLabels"`
+	Merged        bool          `json:"merged"`
+	Mergeable     bool          `json:"mergeable"`
+	Checks        []CheckStatus `json:"checks"`
+	LabelsReady   bool          `json:"labelsReady"`
+	ChecksReady   bool          `json:"checksReady"`
+	Actions       []Action      `json:"actions"` // What a merge check would do now
+}
+
+// CheckStatus is the result of a check run or commit status
+type CheckStatus struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`  // run or status
+	State   string `json:"state"` // Conclusion of the run or status state
+	Ignored bool   `json:"ignored,omitempty"`
+}
+
+// Status reads the pull request and computes its merge status
+func (b *Broker) Status() (*PRStatus, error) {
+	event := Event{Type: EventCheckMerge}
+	state, err := b.ReadPRState(event)
+	if err != nil {
+		return nil, fmt.Errorf("reading pull request state: %w", err)
+	}
+
+	status := &PRStatus{
+		Repo:          b.ctx.Value(ckey).(ContextData).Repository(),
+		Number:        state.Number,
+		Author:        state.Author,
+		Labels:        state.Labels,
+		MissingLabels: []string{},
+		Merged:        state.Merged,
+		Mergeable:     state.Mergeable,
+		Checks:        []CheckStatus{},
+		LabelsReady:   b.config.labelsVerdict(state),
+		ChecksReady:   b.config.checksVerdict(state.CheckRuns, state.Statuses),
+	}
+	for _, l := range b.config.RequiredLabels() {
+		if !state.HasLabel(l) {
+			status.MissingLabels = append(status.MissingLabels, l)
+		}
+	}
+	for _, run := range state.CheckRuns {
+		result := run.GetConclusion()
+		if run.GetStatus() != "completed" {
+			result = run.GetStatus()
+		}
+		status.Checks = append(status.Checks, CheckStatus{
+			Name: run.GetName(), Kind: "run", State: result, Ignored: b.config.IsCheckIgnored(run.GetName()),
+		})
+	}
+	for _, s := range state.Statuses {
+		status.Checks = append(status.Checks, CheckStatus{
+			Name: s.GetContext(), Kind: "status", State: s.GetState(), Ignored: b.config.IsCheckIgnored(s.GetContext()),
+		})
+	}
+
+	status.Actions, err = Decide(state, event, &b.config)
+	if err != nil {
+		return nil, fmt.Errorf("deciding merge: %w", err)
+	}
+	return status, nil
+}
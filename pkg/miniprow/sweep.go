@@ -0,0 +1,263 @@
+package miniprow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	gogithub "github.com/google/go-github/v48/github"
+	"github.com/sirupsen/logrus"
+)
+
+// SweepResult is what a sweep did with an open pull request
+type SweepResult struct {
+	Number int    `json:"number"`
+	Merged bool   `json:"merged"`
+	Reason string `json:"reason"` // Why it was merged or skipped
+}
+
+// String returns a one line description of the result
+func (r SweepResult) String() string {
+	if r.Merged {
+		return fmt.Sprintf("#%d merged (%s)", r.Number, r.Reason)
+	}
+	return fmt.Sprintf("#%d skipped (%s)", r.Number, r.Reason)
+}
+
+// Sweep looks for open pull requests ready to merge and merges them. It
+// catches the pull requests that became ready without an event the
+// broker reacts to, it is meant to run on a schedule. Pull requests are
+// evaluated oldest first, in ascending number order, and merging stops
+// when the maximum number of merges in the config is reached. As each
+// merge moves the base branch, pull requests are read again right
+// before merging them. When the merge pool is on, the ready pull
+// requests are merged by the pool after testing them on the current base.
+// With the auto-merge and merge queue strategies, the ready pull requests
+// are handed over to GitHub instead of merging them.
+func (b *Broker) Sweep() ([]SweepResult, error) {
+	repo := b.ctx.Value(ckey).(ContextData).Repository()
+	prs, err := b.impl.ListOpenPullRequests(b.ctx, b.GitHub(), repo)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(prs, func(i, j int) bool { return prs[i].GetNumber() < prs[j].GetNumber() })
+	logrus.WithField("step", "Sweep").Infof("🧹 Sweeping %d open pull requests in %s", len(prs), repo)
+
+	results := []SweepResult{}
+	errs := []error{}
+	pool := []*Broker{}
+	merges := 0
+	for _, pr := range prs {
+		res := SweepResult{Number: pr.GetNumber()}
+		switch {
+		case merges >= b.config.SweepMaxMerges():
+			res.Reason = "merge limit reached"
+		case b.GitHub().BudgetBelow(b.config.RateLimitFloor()):
+			res.Reason = "API budget is low"
+		default:
+			var pb *Broker
+			res, pb, err = b.sweepPullRequest(pr)
+			if err != nil {
+				logrus.WithField("step", "Sweep").Errorf("Sweeping PR #%d: %v", pr.GetNumber(), err)
+				errs = append(errs, fmt.Errorf("sweeping PR #%d: %w", pr.GetNumber(), err))
+				res = SweepResult{Number: pr.GetNumber(), Reason: "error: " + err.Error()}
+			}
+			if pb != nil {
+				pool = append(pool, pb)
+				merges++
+			}
+		}
+		if res.Merged {
+			merges++
+		}
+		logrus.WithField("step", "Sweep").Infof("PR %s", res)
+		results = append(results, res)
+	}
+
+	if len(pool) > 0 {
+		poolResults, err := b.runMergePool(pool)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("running the merge pool: %w", err))
+		}
+		for i := range results {
+			if res, ok := poolResults[results[i].Number]; ok {
+				logrus.WithField("step", "Sweep").Infof("PR %s", res)
+				results[i] = res
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// sweepPullRequest evaluates an open pull request and merges it if
+// ready. With the merge pool on, ready pull requests are not merged, the
+// broker of the pull request is returned to add it to the pool.
+func (b *Broker) sweepPullRequest(pr *gogithub.PullRequest) (SweepResult, *Broker, error) {
+	res := SweepResult{Number: pr.GetNumber()}
+	if pr.GetDraft() {
+		res.Reason = "draft"
+		return res, nil, nil
+	}
+
+	// The list has the labels, most pull requests are skipped without
+	// reading anything else
+	labels := []string{}
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
+	}
+	if missing := b.config.missingLabels(labels); len(missing) > 0 {
+		res.Reason = "missing labels: " + strings.Join(missing, ", ")
+		return res, nil, nil
+	}
+
+	pb, err := b.forPullRequest(pr.GetNumber())
+	if err != nil {
+		return res, nil, err
+	}
+	event := Event{Type: EventCheckMerge}
+	state, err := pb.ReadPRState(event)
+	if err != nil {
+		return res, nil, fmt.Errorf("reading pull request state: %w", err)
+	}
+	actions, err := Decide(state, event, &pb.config)
+	if err != nil {
+		return res, nil, err
+	}
+	// With the auto-merge and merge queue strategies GitHub merges the
+	// pull request, the actions handing it over are applied as decided
+	if b.config.MergeStrategy() != MergeStrategyDirect {
+		res.Reason, err = pb.handOver(state, actions)
+		return res, nil, err
+	}
+
+	ready := false
+	for _, a := range actions {
+		if a.Type == ActionMerge {
+			ready = true
+		}
+	}
+	if !ready {
+		res.Reason = "not ready to merge"
+		for _, a := range actions {
+			if a.Type != ActionRerunCheck {
+				continue
+			}
+			if err := pb.Apply(actions); err != nil {
+				return res, nil, fmt.Errorf("applying actions: %w", err)
+			}
+			res.Reason = "retrying flaky checks"
+			break
+		}
+		return res, nil, nil
+	}
+
+	reason, err := pb.recheckBase()
+	if err != nil {
+		return res, nil, err
+	}
+	if reason != "" {
+		res.Reason = reason
+		return res, nil, nil
+	}
+
+	if b.config.MergePool().Mode != MergePoolOff {
+		res.Reason = "waiting in the merge pool"
+		return res, pb, nil
+	}
+
+	res.Merged, err = pb.mergePullRequest(true)
+	if err != nil {
+		return res, nil, err
+	}
+	res.Reason = "labels and checks are ready"
+	if !res.Merged {
+		res.Reason = "GitHub did not merge it"
+	}
+	return res, nil, nil
+}
+
+// handOver applies the actions that enable auto-merge or add the pull
+// request to the merge queue and returns what was done
+func (b *Broker) handOver(state *PRState, actions []Action) (string, error) {
+	reason := "not ready to merge"
+	switch {
+	case state.NotifierState.AutoMerge:
+		reason = "auto-merge is enabled"
+	case state.NotifierState.Queued:
+		reason = "in the merge queue"
+	}
+	for _, a := range actions {
+		switch a.Type {
+		case ActionEnableAutoMerge:
+			reason = "auto-merge enabled"
+		case ActionEnqueue:
+			reason = "added to the merge queue"
+		case ActionDisableAutoMerge, ActionDequeue:
+			reason = a.Reason
+		case ActionRerunCheck:
+			reason = "retrying flaky checks"
+		}
+	}
+	if len(actions) == 0 {
+		return reason, nil
+	}
+	if err := b.Apply(actions); err != nil {
+		return "", fmt.Errorf("applying actions: %w", err)
+	}
+	return reason, nil
+}
+
+// forPullRequest returns a broker that handles one pull request of the
+// repository. It shares the GitHub client, caches and config.
+func (b *Broker) forPullRequest(number int) (*Broker, error) {
+	data := ContextData{}
+	for k, v := range b.ctx.Value(ckey).(ContextData) {
+		data[k] = v
+	}
+	data["event"] = EventCheckMerge
+	data["pr"] = strconv.Itoa(number)
+	data["issue"] = ""
+	data["comment"] = ""
+
+	pb := &Broker{
+		ctx:    context.WithValue(b.ctx, ckey, data),
+		impl:   b.impl,
+		github: b.GitHub(),
+		config: b.config,
+	}
+	if err := pb.InitState(); err != nil {
+		return nil, err
+	}
+	return pb, nil
+}
+
+// recheckBase reads the pull request again before merging it. Previous
+// merges move the base branch and GitHub recomputes the mergeable flag
+// against it. Returns why the pull request should not be merged now, or
+// an empty string.
+func (b *Broker) recheckBase() (string, error) {
+	pr, err := b.impl.GetPullRequest(
+		b.ctx, b.GitHub(),
+		b.ctx.Value(ckey).(ContextData).Repository(),
+		b.ctx.Value(ckey).(ContextData).PullRequest(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("fetching PR from GitHub: %w", err)
+	}
+	switch {
+	case pr.GetMerged() || pr.GetState() != "open":
+		return "no longer open", nil
+	case pr.GetHead().GetSHA() != b.State.PullRequest.GetHead().GetSHA():
+		return "head changed since it was evaluated", nil
+	case pr.Mergeable == nil:
+		return "GitHub is checking if it merges into the current base", nil
+	case !pr.GetMergeable():
+		return "does not merge into the current base", nil
+	case pr.GetMergeableState() == "behind":
+		return "branch is behind the base branch", nil
+	}
+	return "", nil
+}
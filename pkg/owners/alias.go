@@ -3,6 +3,8 @@
 
 package owners
 
+import "strings"
+
 type Alias []User
 
 type AliasList struct {
@@ -14,3 +16,20 @@ func NewAliasList() *AliasList {
 		Aliases: map[string]Alias{},
 	}
 }
+
+// Lookup returns the members of an alias. Alias names are not case
+// sensitive.
+func (al *AliasList) Lookup(name string) (Alias, bool) {
+	if al == nil {
+		return nil, false
+	}
+	if alias, ok := al.Aliases[name]; ok {
+		return alias, true
+	}
+	for aliasName, alias := range al.Aliases {
+		if strings.EqualFold(aliasName, name) {
+			return alias, true
+		}
+	}
+	return nil, false
+}
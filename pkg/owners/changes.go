@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NewApprovers compares two versions of a set of OWNERS files and
+// returns the approval rights granted by the new version. Files are
+// indexed by their path relative to the repository root, a nil value
+// means the file does not exist in that version. OWNERS_ALIASES should
+// be included in both versions to expand the aliases, and the OWNERS
+// files of the parent directories to check no_parent_owners.
+//
+// Users added to an alias are reported in OWNERS_ALIASES as the alias
+// may be used as approver in files that are not in the set. When a file
+// stops setting no_parent_owners, or a file that set it is removed, the
+// approvers of the parent directories are reported in their files.
+func NewApprovers(base, head map[string][]byte) ([]Grant, error) {
+	baseAliases, err := parseAliasEntries(base[AliasesFileName])
+	if err != nil {
+		return nil, fmt.Errorf("parsing base %s: %w", AliasesFileName, err)
+	}
+	headAliases, err := parseAliasEntries(head[AliasesFileName])
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", AliasesFileName, err)
+	}
+
+	grants := []Grant{}
+	for name, entries := range headAliases {
+		old := entryNames(baseAliases[name])
+		for _, e := range entries {
+			if _, ok := old[strings.ToLower(e.Name)]; !ok {
+				grants = append(grants, Grant{User: e.Name, File: AliasesFileName, Line: e.Line, Alias: name})
+			}
+		}
+	}
+
+	for path, data := range head {
+		if filepath.Base(path) != OwnersFileName || data == nil {
+			continue
+		}
+		headFile, err := parseOwnersData(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		old := map[string]struct{}{}
+		if base[path] != nil {
+			baseFile, err := parseOwnersData(path, base[path])
+			if err != nil {
+				return nil, fmt.Errorf("parsing base %s: %w", path, err)
+			}
+			for _, g := range expandEntries(baseFile.Approvers, path, aliasList(baseAliases), &Explanation{}) {
+				old[strings.ToLower(g.User)] = struct{}{}
+			}
+		}
+		for _, g := range expandEntries(headFile.Approvers, path, aliasList(headAliases), &Explanation{}) {
+			if _, ok := old[strings.ToLower(g.User)]; ok {
+				continue
+			}
+			old[strings.ToLower(g.User)] = struct{}{}
+			grants = append(grants, g)
+		}
+	}
+
+	// Dropping no_parent_owners lets the parent approvers into the subtree
+	reported := map[Grant]struct{}{}
+	for _, g := range grants {
+		reported[g] = struct{}{}
+	}
+	for path, data := range base {
+		if filepath.Base(path) != OwnersFileName || data == nil {
+			continue
+		}
+		baseFile, err := parseOwnersData(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing base %s: %w", path, err)
+		}
+		if !baseFile.NoParentOwners {
+			continue
+		}
+		if head[path] != nil {
+			headFile, err := parseOwnersData(path, head[path])
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			if headFile.NoParentOwners {
+				continue
+			}
+		}
+		parents, err := parentApprovers(path, head, aliasList(headAliases))
+		if err != nil {
+			return nil, err
+		}
+		old := map[string]struct{}{}
+		for _, g := range expandEntries(baseFile.Approvers, path, aliasList(baseAliases), &Explanation{}) {
+			old[strings.ToLower(g.User)] = struct{}{}
+		}
+		for _, g := range parents {
+			if _, ok := old[strings.ToLower(g.User)]; ok {
+				continue
+			}
+			old[strings.ToLower(g.User)] = struct{}{}
+			// Parent approvers reaching several subtrees are reported once
+			if _, ok := reported[g]; !ok {
+				reported[g] = struct{}{}
+				grants = append(grants, g)
+			}
+		}
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		if grants[i].File != grants[j].File {
+			return grants[i].File < grants[j].File
+		}
+		if grants[i].Line != grants[j].Line {
+			return grants[i].Line < grants[j].Line
+		}
+		return grants[i].User < grants[j].User
+	})
+	return grants, nil
+}
+
+// parentApprovers returns the approvers inherited by the directory of
+// an OWNERS file from the files in its parent directories, up to the
+// first one that sets no_parent_owners
+func parentApprovers(path string, files map[string][]byte, aliases *AliasList) ([]Grant, error) {
+	grants := []Grant{}
+	for dir := filepath.Dir(path); dir != "."; {
+		dir = filepath.Dir(dir)
+		parent := filepath.Join(dir, OwnersFileName)
+		if files[parent] == nil {
+			continue
+		}
+		of, err := parseOwnersData(parent, files[parent])
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", parent, err)
+		}
+		grants = append(grants, expandEntries(of.Approvers, parent, aliases, &Explanation{})...)
+		if of.NoParentOwners {
+			break
+		}
+	}
+	return grants, nil
+}
+
+// parseAliasEntries parses an OWNERS_ALIASES file keeping the line
+// numbers. Alias names are returned in lowercase.
+func parseAliasEntries(data []byte) (map[string][]ownersEntry, error) {
+	aliases := map[string][]ownersEntry{}
+	if data == nil {
+		return aliases, nil
+	}
+	list := struct {
+		Aliases yaml.Node `yaml:"aliases"`
+	}{}
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	if list.Aliases.Kind == 0 {
+		return aliases, nil
+	}
+	if list.Aliases.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: aliases must be a mapping", list.Aliases.Line)
+	}
+	for i := 0; i+1 < len(list.Aliases.Content); i += 2 {
+		entries, err := parseOwnersEntries(list.Aliases.Content[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("alias %q: %w", list.Aliases.Content[i].Value, err)
+		}
+		name := strings.ToLower(list.Aliases.Content[i].Value)
+		aliases[name] = append(aliases[name], entries...)
+	}
+	return aliases, nil
+}
+
+// aliasList builds an alias list from parsed entries
+func aliasList(entries map[string][]ownersEntry) *AliasList {
+	list := NewAliasList()
+	for name, members := range entries {
+		for _, e := range members {
+			list.Aliases[name] = append(list.Aliases[name], User(e.Name))
+		}
+	}
+	return list
+}
+
+// entryNames returns the lowercase names in a list of entries
+func entryNames(entries []ownersEntry) map[string]struct{} {
+	names := map[string]struct{}{}
+	for _, e := range entries {
+		names[strings.ToLower(e.Name)] = struct{}{}
+	}
+	return names
+}
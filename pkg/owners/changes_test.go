@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewApprovers(t *testing.T) {
+	base := map[string][]byte{
+		AliasesFileName: []byte("aliases:\n  leads:\n    - alice\n"),
+		OwnersFileName:  []byte("approvers:\n  - leads\nreviewers:\n  - bob\n"),
+		"sub/OWNERS":    []byte("approvers:\n  - carol\n"),
+		"new/OWNERS":    nil,
+	}
+	head := map[string][]byte{
+		AliasesFileName: []byte("aliases:\n  Leads:\n    - alice\n    - dave\n"),
+		// Moving bob from reviewers to approvers grants him approval
+		OwnersFileName: []byte("approvers:\n  - leads\n  - bob\n"),
+		// Removing approvers does not grant anything
+		"sub/OWNERS": []byte("reviewers:\n  - carol\n"),
+		"new/OWNERS": []byte("approvers:\n  - erin\n  - Alice\n"),
+	}
+
+	grants, err := NewApprovers(base, head)
+	require.NoError(t, err)
+	require.Equal(t, []Grant{
+		{User: "dave", File: OwnersFileName, Line: 2, Alias: "leads"},
+		{User: "bob", File: OwnersFileName, Line: 3},
+		{User: "dave", File: AliasesFileName, Line: 4, Alias: "leads"},
+		{User: "erin", File: "new/OWNERS", Line: 2},
+		{User: "Alice", File: "new/OWNERS", Line: 3},
+	}, grants)
+
+	// No changes, no grants
+	grants, err = NewApprovers(base, base)
+	require.NoError(t, err)
+	require.Empty(t, grants)
+
+	// Invalid files are reported
+	_, err = NewApprovers(base, map[string][]byte{OwnersFileName: []byte("approvers: alice\n")})
+	require.Error(t, err)
+}
+
+func TestNewApproversNoParentOwners(t *testing.T) {
+	cutOff := "options:\n  no_parent_owners: true\napprovers:\n  - carol\n  - bob\n"
+	base := map[string][]byte{
+		OwnersFileName:  []byte("approvers:\n  - alice\n  - bob\n"),
+		"sub/OWNERS":    []byte(cutOff),
+		"sub/x/OWNERS":  []byte(cutOff),
+		"other/OWNERS":  []byte(cutOff),
+		"closed/OWNERS": []byte(cutOff),
+	}
+	head := map[string][]byte{
+		OwnersFileName: base[OwnersFileName],
+		"sub/OWNERS":   base["sub/OWNERS"],
+		// Removing the option gives the approvers of sub rights on sub/x
+		"sub/x/OWNERS": []byte("approvers:\n  - carol\n"),
+		// Removing the file gives the root approvers rights on other
+		"other/OWNERS":  nil,
+		"closed/OWNERS": base["closed/OWNERS"],
+	}
+
+	grants, err := NewApprovers(base, head)
+	require.NoError(t, err)
+	require.Equal(t, []Grant{
+		{User: "alice", File: OwnersFileName, Line: 2},
+	}, grants)
+
+	// Without the option in sub, the root approvers reach sub/x too
+	head["sub/OWNERS"] = []byte("approvers:\n  - carol\n  - erin\n")
+	grants, err = NewApprovers(base, head)
+	require.NoError(t, err)
+	require.Equal(t, []Grant{
+		{User: "alice", File: OwnersFileName, Line: 2},
+		{User: "erin", File: "sub/OWNERS", Line: 3},
+	}, grants)
+
+	// Removing it from the root does not grant anything
+	grants, err = NewApprovers(
+		map[string][]byte{OwnersFileName: []byte("options:\n  no_parent_owners: true\napprovers:\n  - alice\n")},
+		map[string][]byte{OwnersFileName: []byte("approvers:\n  - alice\n")},
+	)
+	require.NoError(t, err)
+	require.Empty(t, grants)
+}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Grant records the OWNERS file entry that gives a user approval or
+// review rights
+type Grant struct {
+	User  string `json:"user"`
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	Alias string `json:"alias,omitempty"` // Alias the user was expanded from
+}
+
+// String returns the grant as user (file:line)
+func (g Grant) String() string {
+	s := fmt.Sprintf("%s (%s:%d", g.User, g.File, g.Line)
+	if g.Alias != "" {
+		s += " via " + g.Alias
+	}
+	return s + ")"
+}
+
+// AliasExpansion is an alias found in an OWNERS file and the users it
+// was replaced with
+type AliasExpansion struct {
+	Alias string   `json:"alias"`
+	Users []string `json:"users"`
+	File  string   `json:"file"`
+	Line  int      `json:"line"`
+}
+
+// ExplainedFile is an OWNERS file consulted to compute the owners of a path
+type ExplainedFile struct {
+	Path           string  `json:"path"`
+	NoParentOwners bool    `json:"noParentOwners,omitempty"`
+	Approvers      []Grant `json:"approvers"`
+	Reviewers      []Grant `json:"reviewers"`
+}
+
+// Explanation describes how the owners of a path are computed
+type Explanation struct {
+	Path string `json:"path"`
+
+	// Files are the OWNERS files consulted, closest to the path first
+	Files   []ExplainedFile  `json:"files"`
+	Aliases []AliasExpansion `json:"aliases"`
+
+	// CutOff is the OWNERS file that set no_parent_owners, if any
+	CutOff string `json:"cutOff,omitempty"`
+
+	// Final sets, with the closest file that granted each user
+	Approvers []Grant `json:"approvers"`
+	Reviewers []Grant `json:"reviewers"`
+}
+
+// Explain returns the chain of OWNERS files, alias expansions and
+// cut-offs that determine the approvers and reviewers of a path
+func (reader *Reader) Explain(path string) (*Explanation, error) {
+	return reader.impl.explainOwners(path)
+}
+
+// ownersEntry is a user or alias in an OWNERS file
+type ownersEntry struct {
+	Name string
+	Line int
+}
+
+// ownersFile is an OWNERS file parsed keeping the line numbers
+type ownersFile struct {
+	Path           string
+	Approvers      []ownersEntry
+	Reviewers      []ownersEntry
+	NoParentOwners bool
+}
+
+// parseOwnersData parses the contents of an OWNERS file
+func parseOwnersData(path string, data []byte) (*ownersFile, error) {
+	of := &ownersFile{Path: path, Approvers: []ownersEntry{}, Reviewers: []ownersEntry{}}
+
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshaling OWNERS data: %w", err)
+	}
+	// Empty file
+	if len(doc.Content) == 0 {
+		return of, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("unmarshaling OWNERS data: line %d: expected a mapping", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "approvers", "reviewers":
+			entries, err := parseOwnersEntries(value)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshaling OWNERS %s: %w", key.Value, err)
+			}
+			if key.Value == "approvers" {
+				of.Approvers = entries
+			} else {
+				of.Reviewers = entries
+			}
+		case "options":
+			opts := struct {
+				NoParentOwners bool `yaml:"no_parent_owners"`
+			}{}
+			if err := value.Decode(&opts); err != nil {
+				return nil, fmt.Errorf("unmarshaling OWNERS options: %w", err)
+			}
+			of.NoParentOwners = opts.NoParentOwners
+		}
+	}
+	return of, nil
+}
+
+// parseOwnersEntries reads a list of users from a sequence node
+func parseOwnersEntries(node *yaml.Node) ([]ownersEntry, error) {
+	entries := []ownersEntry{}
+	// An empty key (approvers:) is a null scalar
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return entries, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of users", node.Line)
+	}
+	for _, n := range node.Content {
+		if n.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: expected a user name", n.Line)
+		}
+		entries = append(entries, ownersEntry{Name: n.Value, Line: n.Line})
+	}
+	return entries, nil
+}
+
+// expandEntries resolves the aliases in a list of entries and returns
+// the resulting grants. Expansions are recorded in the explanation.
+func expandEntries(
+	entries []ownersEntry, file string, aliases *AliasList, exp *Explanation,
+) []Grant {
+	grants := []Grant{}
+	for _, e := range entries {
+		users, ok := aliases.Lookup(e.Name)
+		if !ok {
+			grants = append(grants, Grant{User: e.Name, File: file, Line: e.Line})
+			continue
+		}
+		expansion := AliasExpansion{Alias: e.Name, Users: []string{}, File: file, Line: e.Line}
+		for _, u := range users {
+			expansion.Users = append(expansion.Users, string(u))
+			grants = append(grants, Grant{User: string(u), File: file, Line: e.Line, Alias: e.Name})
+		}
+		exp.Aliases = append(exp.Aliases, expansion)
+	}
+	return grants
+}
+
+// finalGrants returns one grant per user, keeping the first one found,
+// sorted by user name
+func finalGrants(files []ExplainedFile, approvers bool) []Grant {
+	seen := map[string]struct{}{}
+	res := []Grant{}
+	for _, f := range files {
+		grants := f.Reviewers
+		if approvers {
+			grants = f.Approvers
+		}
+		for _, g := range grants {
+			if _, ok := seen[g.User]; ok {
+				continue
+			}
+			seen[g.User] = struct{}{}
+			res = append(res, g)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].User < res[j].User })
+	return res
+}
+
+// grantUsers returns the users in a list of grants
+func grantUsers(grants []Grant) []User {
+	users := []User{}
+	for _, g := range grants {
+		users = append(users, User(g.User))
+	}
+	return users
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	dir := mkTempRepo(t)
+	defer os.RemoveAll(dir)
+
+	for path, data := range map[string]string{
+		AliasesFileName: "aliases:\n  Release-Leads:\n    - carol\n    - dave\n",
+		OwnersFileName:  "approvers:\n  - alice\nreviewers:\n  - bob\n",
+		"sub/OWNERS":    "approvers:\n  - release-leads\n  - alice\n",
+		"sub/deep/OWNERS": "options:\n  no_parent_owners: true\n" +
+			"approvers:\n  - erin\nreviewers:\n  - frank\n",
+		"sub/deep/file.txt": "test",
+		"sub/file.txt":      "test",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+
+	reader := NewReader()
+
+	// Aliases are expanded and the closest file grants the user
+	exp, err := reader.Explain(filepath.Join(dir, "sub", "file.txt"))
+	require.NoError(t, err)
+	require.Len(t, exp.Files, 2)
+	require.Equal(t, filepath.Join(dir, "sub", OwnersFileName), exp.Files[0].Path)
+	require.Equal(t, filepath.Join(dir, OwnersFileName), exp.Files[1].Path)
+	require.Empty(t, exp.CutOff)
+	require.Equal(t, []AliasExpansion{{
+		Alias: "release-leads", Users: []string{"carol", "dave"},
+		File: filepath.Join(dir, "sub", OwnersFileName), Line: 2,
+	}}, exp.Aliases)
+	require.Equal(t, []Grant{
+		{User: "alice", File: filepath.Join(dir, "sub", OwnersFileName), Line: 3},
+		{User: "carol", File: filepath.Join(dir, "sub", OwnersFileName), Line: 2, Alias: "release-leads"},
+		{User: "dave", File: filepath.Join(dir, "sub", OwnersFileName), Line: 2, Alias: "release-leads"},
+	}, exp.Approvers)
+	require.Equal(t, []Grant{{User: "bob", File: filepath.Join(dir, OwnersFileName), Line: 4}}, exp.Reviewers)
+
+	// no_parent_owners stops the walk
+	exp, err = reader.Explain(filepath.Join(dir, "sub", "deep", "file.txt"))
+	require.NoError(t, err)
+	require.Len(t, exp.Files, 1)
+	require.Equal(t, filepath.Join(dir, "sub", "deep", OwnersFileName), exp.CutOff)
+	require.Equal(t, []Grant{{User: "erin", File: exp.CutOff, Line: 4}}, exp.Approvers)
+	require.Equal(t, []Grant{{User: "frank", File: exp.CutOff, Line: 6}}, exp.Reviewers)
+
+	// Owner resolution uses the same rules
+	list, err := reader.GetPathOwners(filepath.Join(dir, "sub", "deep", "file.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []User{"erin"}, list.Approvers)
+	require.True(t, list.Files[0].NoParentOwners)
+
+	list, err = reader.GetPathOwners(filepath.Join(dir, "sub", "file.txt"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []User{"alice", "carol", "dave"}, list.Approvers)
+
+	// The overlay replaces and hides files on disk
+	overlayReader := NewReaderWithOptions(&Options{Overlay: map[string][]byte{
+		filepath.Join(dir, "sub", OwnersFileName):         []byte("approvers:\n  - grace\n"),
+		filepath.Join(dir, "sub", "deep", OwnersFileName): nil,
+	}})
+	list, err = overlayReader.GetPathOwners(filepath.Join(dir, "sub", "deep", "file.txt"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []User{"alice", "grace"}, list.Approvers)
+
+	// Invalid files are reported
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "sub", OwnersFileName), []byte("approvers: alice\n"), os.FileMode(0o644),
+	))
+	_, err = reader.Explain(filepath.Join(dir, "sub", "file.txt"))
+	require.Error(t, err)
+}
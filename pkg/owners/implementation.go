@@ -19,11 +19,35 @@ import (
 type readerImplementation interface {
 	readDirectoryOwners(string) (*List, error)
 	computeOwners(path string) (*List, error)
+	explainOwners(path string) (*Explanation, error)
+	buildIndex(root string) (*Index, error)
 	parseAliasFile(string) (*AliasList, error)
 	readRespositoryAlias(string) (*AliasList, error)
 }
 
-type defaultReaderImplementation struct{}
+type defaultReaderImplementation struct {
+	// overlay replaces files on disk, see Options.Overlay
+	overlay map[string][]byte
+}
+
+// exists returns true if a file exists in the overlay or on disk
+func (ri *defaultReaderImplementation) exists(path string) bool {
+	if data, ok := ri.overlay[path]; ok {
+		return data != nil
+	}
+	return util.Exists(path)
+}
+
+// readFile returns the contents of a file from the overlay or the disk
+func (ri *defaultReaderImplementation) readFile(path string) ([]byte, error) {
+	if data, ok := ri.overlay[path]; ok {
+		if data == nil {
+			return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+		}
+		return data, nil
+	}
+	return os.ReadFile(path)
+}
 
 /*
 // readPathOwners traverses a path getting owners info until it reaches the git root
@@ -90,7 +114,7 @@ func (ri *defaultReaderImplementation) readRespositoryAlias(path string) (*Alias
 	subpath := path
 	for {
 		if isRepoRoot(subpath) {
-			if !util.Exists(filepath.Join(subpath, AliasesFileName)) {
+			if !ri.exists(filepath.Join(subpath, AliasesFileName)) {
 				return NewAliasList(), nil
 			}
 			return ri.parseAliasFile(filepath.Join(subpath, AliasesFileName))
@@ -111,16 +135,50 @@ func (ri *defaultReaderImplementation) readRespositoryAlias(path string) (*Alias
 // computeOwners gets a path and traverses the directory finding
 // the required approvers and reviewers
 func (ri *defaultReaderImplementation) computeOwners(path string) (*List, error) {
-	var list *List
+	exp, err := ri.explainOwners(path)
+	if err != nil {
+		return nil, err
+	}
+	return explanationList(exp), nil
+}
+
+// explanationList builds the owners list from an explanation
+func explanationList(exp *Explanation) *List {
+	list := &List{}
+	for _, f := range exp.Files {
+		approvers := grantUsers(f.Approvers)
+		reviewers := grantUsers(f.Reviewers)
+		list.Append(&List{
+			Files: []File{{
+				Path: f.Path, Approvers: approvers, Reviewers: reviewers, NoParentOwners: f.NoParentOwners,
+			}},
+			Approvers: approvers,
+			Reviewers: reviewers,
+		})
+	}
+	return list
+}
+
+// explainOwners traverses the directories from path to the repository
+// root reading the OWNERS files. Aliases are expanded and the walk stops
+// at the first file that sets no_parent_owners.
+func (ri *defaultReaderImplementation) explainOwners(path string) (*Explanation, error) {
 	finfo, err := os.Stat(path)
 	if err != nil {
-		return list, fmt.Errorf("path not found when computing owners: %w", err)
+		return nil, fmt.Errorf("path not found when computing owners: %w", err)
+	}
+	exp := &Explanation{
+		Path:      path,
+		Files:     []ExplainedFile{},
+		Aliases:   []AliasExpansion{},
+		Approvers: []Grant{},
+		Reviewers: []Grant{},
 	}
 	if !finfo.IsDir() {
 		path = filepath.Dir(path)
 	}
 
-	_, err = ri.readRespositoryAlias(path)
+	aliases, err := ri.readRespositoryAlias(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading repo aliases: %w", err)
 	}
@@ -135,17 +193,22 @@ func (ri *defaultReaderImplementation) computeOwners(path string) (*List, error)
 			return nil, fmt.Errorf("unable to detect repository root")
 		}
 
-		if util.Exists(filepath.Join(subpath, OwnersFileName)) {
-			localList, err := ri.readDirectoryOwners(subpath)
+		ownersPath := filepath.Join(subpath, OwnersFileName)
+		if ri.exists(ownersPath) {
+			of, err := ri.parseOwnersFile(ownersPath)
 			if err != nil {
 				return nil, fmt.Errorf("parsing owners file in path: %w", err)
 			}
-
-			if list == nil {
-				list = &List{}
+			exp.Files = append(exp.Files, ExplainedFile{
+				Path:           ownersPath,
+				NoParentOwners: of.NoParentOwners,
+				Approvers:      expandEntries(of.Approvers, ownersPath, aliases, exp),
+				Reviewers:      expandEntries(of.Reviewers, ownersPath, aliases, exp),
+			})
+			if of.NoParentOwners {
+				exp.CutOff = ownersPath
+				break
 			}
-
-			list.Append(localList)
 		}
 		if isRepoRoot(subpath) {
 			break
@@ -153,10 +216,21 @@ func (ri *defaultReaderImplementation) computeOwners(path string) (*List, error)
 		subpath = filepath.Dir(subpath)
 	}
 
-	if list == nil {
+	if len(exp.Files) == 0 {
 		return nil, fmt.Errorf("unable to find any approvers for path")
 	}
-	return list, nil
+	exp.Approvers = finalGrants(exp.Files, true)
+	exp.Reviewers = finalGrants(exp.Files, false)
+	return exp, nil
+}
+
+// parseOwnersFile reads and parses an OWNERS file
+func (ri *defaultReaderImplementation) parseOwnersFile(path string) (*ownersFile, error) {
+	data, err := ri.readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading OWNERS YAML data: %w", err)
+	}
+	return parseOwnersData(path, data)
 }
 
 // isRepoRoot is a utility function that returns true if a dir is the root of a repository
@@ -164,7 +238,8 @@ func isRepoRoot(path string) bool {
 	return util.Exists(filepath.Join(path, ".git/config"))
 }
 
-// readDirectoryOwners gets the owners from a directory
+// readDirectoryOwners gets the owners from a directory. Aliases are
+// expanded as in computeOwners.
 func (ri *defaultReaderImplementation) readDirectoryOwners(path string) (list *List, err error) {
 	list = NewList()
 
@@ -176,26 +251,32 @@ func (ri *defaultReaderImplementation) readDirectoryOwners(path string) (list *L
 		return nil, errors.New("unable to parse owners, path is not a directory")
 	}
 
-	if !util.Exists(filepath.Join(path, OwnersFileName)) {
+	if !ri.exists(filepath.Join(path, OwnersFileName)) {
 		logrus.Infof("OWNERS file not found: %s", filepath.Join(path, OwnersFileName))
 		return nil, nil
 	}
 
 	logrus.Infof("Parsing owners file: %s", path)
 
-	yamlData, err := os.ReadFile(filepath.Join(path, OwnersFileName))
+	ownersPath := filepath.Join(path, OwnersFileName)
+	of, err := ri.parseOwnersFile(ownersPath)
 	if err != nil {
-		return list, fmt.Errorf("reading OWNERS YAML data: %w", err)
+		return list, err
 	}
-	if err := yaml.Unmarshal(yamlData, list); err != nil {
-		return list, fmt.Errorf("unmarshaling OWNERS data: %w", err)
+	aliases, err := ri.readRespositoryAlias(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo aliases: %w", err)
 	}
+	exp := &Explanation{}
+	list.Approvers = grantUsers(expandEntries(of.Approvers, ownersPath, aliases, exp))
+	list.Reviewers = grantUsers(expandEntries(of.Reviewers, ownersPath, aliases, exp))
 
 	// Build the file entry
 	f := File{
-		Path:      filepath.Join(path, OwnersFileName),
-		Approvers: list.Approvers,
-		Reviewers: list.Reviewers,
+		Path:           ownersPath,
+		Approvers:      list.Approvers,
+		Reviewers:      list.Reviewers,
+		NoParentOwners: of.NoParentOwners,
 	}
 	list.Files = append(list.Files, f)
 	return list, nil
@@ -203,12 +284,12 @@ func (ri *defaultReaderImplementation) readDirectoryOwners(path string) (list *L
 
 // parseAliasFile parses an OWNERS_ALIAS file
 func (ri *defaultReaderImplementation) parseAliasFile(path string) (list *AliasList, err error) {
-	if !util.Exists(path) {
+	if !ri.exists(path) {
 		return nil, errors.Wrap(err, "file not found")
 	}
 	list = NewAliasList()
 
-	yamlData, err := os.ReadFile(path)
+	yamlData, err := ri.readFile(path)
 	if err != nil {
 		return list, errors.Wrap(err, "reading OWNERS_ALIAS YAML data")
 	}
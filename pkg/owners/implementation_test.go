@@ -85,8 +85,7 @@ approvers:
   - test-infra-oncall # oncall
 `
 
-	dir, err := os.MkdirTemp(os.TempDir(), "owners-test-")
-	require.Nil(t, err)
+	dir := mkTempRepo(t)
 	defer os.RemoveAll(dir)
 	require.Nil(t, os.WriteFile(filepath.Join(dir, "OWNERS"), []byte(fileData), os.FileMode(0o644)))
 
@@ -95,9 +94,20 @@ approvers:
 	require.Nil(t, err, err)
 	require.Equal(t, 4, len(owners.Reviewers))
 	require.Equal(t, 4, len(owners.Approvers))
+
+	// Aliases are expanded, as when computing the owners of a path
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, AliasesFileName), []byte("aliases:\n  test-infra-oncall:\n    - alice\n    - bob\n"),
+		os.FileMode(0o644),
+	))
+	owners, err = impl.readDirectoryOwners(dir)
+	require.Nil(t, err, err)
+	require.Equal(t, []User{"BenTheElder", "spiffxp", "stevekuznetsov", "alice", "bob"}, owners.Approvers)
+	require.Equal(t, owners.Approvers, owners.Files[0].Approvers)
+	require.Len(t, owners.Reviewers, 5)
 }
 
-func mkTempRepo(t *testing.T) string {
+func mkTempRepo(t testing.TB) string {
 	dir, err := os.MkdirTemp("", "owners-test-")
 	require.Nil(t, err)
 	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), os.FileMode(0o755)))
@@ -0,0 +1,338 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Role is the kind of rights an OWNERS file grants to a user
+type Role string
+
+const (
+	RoleApprover Role = "approver"
+	RoleReviewer Role = "reviewer"
+)
+
+// Ownership is a directory where a user is an approver or reviewer.
+// The rights extend to the subdirectories, except the ones that set
+// no_parent_owners.
+type Ownership struct {
+	User  string `json:"user"`
+	Path  string `json:"path"` // Directory, relative to the repository root
+	Role  Role   `json:"role"`
+	File  string `json:"file"` // OWNERS file that grants the rights
+	Line  int    `json:"line"`
+	Alias string `json:"alias,omitempty"` // Alias the user was expanded from
+
+	// Except are the subdirectories that set no_parent_owners
+	Except []string `json:"except,omitempty"`
+}
+
+// indexedFile is an OWNERS file loaded in the index
+type indexedFile struct {
+	ExplainedFile
+	aliases []AliasExpansion
+}
+
+// Index has all the OWNERS files of a repository. It is built walking
+// the tree once and answers which directories a user owns and who owns
+// a path without reading the files again. The owners of each directory
+// are computed once and memoized. An index is safe for concurrent use.
+type Index struct {
+	root    string
+	workers int
+	files   map[string]*indexedFile // Indexed by absolute directory
+	users   map[string][]Ownership  // Indexed by lowercase login
+
+	mu    sync.RWMutex
+	cache map[string]*Explanation // Explanations by directory
+}
+
+// BuildIndex reads all the OWNERS files in the repository at root
+func (reader *Reader) BuildIndex(root string) (*Index, error) {
+	idx, err := reader.impl.buildIndex(root)
+	if err != nil {
+		return nil, err
+	}
+	if reader.opts != nil && reader.opts.Workers > 0 {
+		idx.workers = reader.opts.Workers
+	}
+	return idx, nil
+}
+
+// buildIndex walks the repository reading the OWNERS files
+func (ri *defaultReaderImplementation) buildIndex(root string) (*Index, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("computing absolute path: %w", err)
+	}
+	aliases, err := ri.readRespositoryAlias(root)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo aliases: %w", err)
+	}
+
+	paths := map[string]struct{}{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == OwnersFileName {
+			paths[path] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking repository: %w", err)
+	}
+	// Files in the overlay may not exist on disk
+	for path := range ri.overlay {
+		if filepath.Base(path) == OwnersFileName && strings.HasPrefix(path, root+string(filepath.Separator)) {
+			paths[path] = struct{}{}
+		}
+	}
+
+	idx := &Index{
+		root:    root,
+		workers: runtime.NumCPU(),
+		files:   map[string]*indexedFile{},
+		users:   map[string][]Ownership{},
+		cache:   map[string]*Explanation{},
+	}
+	for path := range paths {
+		if !ri.exists(path) {
+			continue
+		}
+		of, err := ri.parseOwnersFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		exp := &Explanation{Aliases: []AliasExpansion{}}
+		idx.files[filepath.Dir(path)] = &indexedFile{
+			ExplainedFile: ExplainedFile{
+				Path:           path,
+				NoParentOwners: of.NoParentOwners,
+				Approvers:      expandEntries(of.Approvers, path, aliases, exp),
+				Reviewers:      expandEntries(of.Reviewers, path, aliases, exp),
+			},
+			aliases: exp.Aliases,
+		}
+	}
+
+	for dir, f := range idx.files {
+		except := idx.cutOffsBelow(dir)
+		for role, grants := range map[Role][]Grant{RoleApprover: f.Approvers, RoleReviewer: f.Reviewers} {
+			for _, g := range grants {
+				login := strings.ToLower(g.User)
+				idx.users[login] = append(idx.users[login], Ownership{
+					User: g.User, Path: idx.relative(dir), Role: role, File: idx.relative(g.File),
+					Line: g.Line, Alias: g.Alias, Except: except,
+				})
+			}
+		}
+	}
+	for _, list := range idx.users {
+		sortOwnerships(list)
+	}
+	return idx, nil
+}
+
+// cutOffsBelow returns the subdirectories of dir that set
+// no_parent_owners, not counting those inside another cut-off
+func (idx *Index) cutOffsBelow(dir string) []string {
+	cutoffs := []string{}
+	for d, f := range idx.files {
+		if f.NoParentOwners && strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			cutoffs = append(cutoffs, d)
+		}
+	}
+	if len(cutoffs) == 0 {
+		return nil
+	}
+	sort.Strings(cutoffs)
+	kept := []string{}
+	for _, d := range cutoffs {
+		if len(kept) > 0 && strings.HasPrefix(d, kept[len(kept)-1]+string(filepath.Separator)) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	res := []string{}
+	for _, d := range kept {
+		res = append(res, idx.relative(d))
+	}
+	return res
+}
+
+// relative returns a path relative to the repository root
+func (idx *Index) relative(path string) string {
+	rel, err := filepath.Rel(idx.root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// Users returns the logins of all the users in the OWNERS files
+func (idx *Index) Users() []string {
+	users := []string{}
+	for _, list := range idx.users {
+		users = append(users, list[0].User)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// UserOwnerships returns the directories where user is an approver or a
+// reviewer, sorted by path. Logins are not case sensitive.
+func (idx *Index) UserOwnerships(user string) []Ownership {
+	return append([]Ownership{}, idx.users[strings.ToLower(user)]...)
+}
+
+// Ownerships returns the ownerships of all users, sorted by path
+func (idx *Index) Ownerships() []Ownership {
+	res := []Ownership{}
+	for _, list := range idx.users {
+		res = append(res, list...)
+	}
+	sortOwnerships(res)
+	return res
+}
+
+// Explain returns how the owners of a path are computed, like
+// Reader.Explain, using the files in the index. The path does not need
+// to exist: paths that are not directories on disk are treated as files.
+func (idx *Index) Explain(path string) (*Explanation, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("computing absolute path: %w", err)
+	}
+	if path != idx.root && !strings.HasPrefix(path, idx.root+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is not in the repository", path)
+	}
+
+	dir := path
+	if finfo, err := os.Stat(path); err != nil || !finfo.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	idx.mu.RLock()
+	exp, ok := idx.cache[dir]
+	idx.mu.RUnlock()
+	if !ok {
+		exp, err = idx.explainDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		idx.mu.Lock()
+		idx.cache[dir] = exp
+		idx.mu.Unlock()
+	}
+
+	// Return a copy, the caller may modify it
+	res := *exp
+	res.Path = path
+	res.Files = make([]ExplainedFile, 0, len(exp.Files))
+	for _, f := range exp.Files {
+		f.Approvers = append([]Grant{}, f.Approvers...)
+		f.Reviewers = append([]Grant{}, f.Reviewers...)
+		res.Files = append(res.Files, f)
+	}
+	res.Aliases = append([]AliasExpansion{}, exp.Aliases...)
+	res.Approvers = append([]Grant{}, exp.Approvers...)
+	res.Reviewers = append([]Grant{}, exp.Reviewers...)
+	return &res, nil
+}
+
+// explainDir computes the owners of a directory walking up to the root
+func (idx *Index) explainDir(dir string) (*Explanation, error) {
+	exp := &Explanation{
+		Files:     []ExplainedFile{},
+		Aliases:   []AliasExpansion{},
+		Approvers: []Grant{},
+		Reviewers: []Grant{},
+	}
+	for {
+		if f, ok := idx.files[dir]; ok {
+			exp.Files = append(exp.Files, f.ExplainedFile)
+			exp.Aliases = append(exp.Aliases, f.aliases...)
+			if f.NoParentOwners {
+				exp.CutOff = f.Path
+				break
+			}
+		}
+		if dir == idx.root {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	if len(exp.Files) == 0 {
+		return nil, fmt.Errorf("unable to find any approvers for path")
+	}
+	exp.Approvers = finalGrants(exp.Files, true)
+	exp.Reviewers = finalGrants(exp.Files, false)
+	return exp, nil
+}
+
+// GetPathOwners returns the owners of a path from the index
+func (idx *Index) GetPathOwners(path string) (*List, error) {
+	exp, err := idx.Explain(path)
+	if err != nil {
+		return nil, err
+	}
+	return explanationList(exp), nil
+}
+
+// GetPathsOwners returns the owners of many paths, in the same order.
+// Paths are resolved in parallel by up to Options.Workers goroutines.
+func (idx *Index) GetPathsOwners(paths []string) ([]*List, error) {
+	lists := make([]*List, len(paths))
+	errs := make([]error, len(paths))
+	sem := make(chan struct{}, idx.workers)
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			lists[i], errs[i] = idx.GetPathOwners(paths[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("getting owners of %s: %w", paths[i], err)
+		}
+	}
+	return lists, nil
+}
+
+// sortOwnerships orders ownerships by path, role and user
+func sortOwnerships(list []Ownership) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		if list[i].Role != list[j].Role {
+			return list[i].Role < list[j].Role
+		}
+		if list[i].User != list[j].User {
+			return list[i].User < list[j].User
+		}
+		return list[i].Line < list[j].Line
+	})
+}
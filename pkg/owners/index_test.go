@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	dir := mkTempRepo(t)
+	defer os.RemoveAll(dir)
+
+	for path, data := range map[string]string{
+		AliasesFileName: "aliases:\n  leads:\n    - alice\n    - dave\n",
+		OwnersFileName:  "approvers:\n  - alice\nreviewers:\n  - bob\n",
+		"sub/OWNERS":    "approvers:\n  - leads\nreviewers:\n  - Alice\n",
+		"sub/deep/OWNERS": "options:\n  no_parent_owners: true\n" +
+			"approvers:\n  - erin\n",
+		"sub/deep/more/OWNERS": "options:\n  no_parent_owners: true\napprovers:\n  - frank\n",
+		"sub/deep/file.txt":    "test",
+		"other/file.txt":       "test",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+
+	idx, err := NewReader().BuildIndex(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob", "dave", "erin", "frank"}, idx.Users())
+
+	// What can alice approve?
+	require.Equal(t, []Ownership{
+		{User: "alice", Path: ".", Role: RoleApprover, File: OwnersFileName, Line: 2, Except: []string{"sub/deep"}},
+		{
+			User: "alice", Path: "sub", Role: RoleApprover, File: "sub/OWNERS", Line: 2, Alias: "leads",
+			Except: []string{"sub/deep"},
+		},
+		{User: "Alice", Path: "sub", Role: RoleReviewer, File: "sub/OWNERS", Line: 4, Except: []string{"sub/deep"}},
+	}, idx.UserOwnerships("ALICE"))
+	require.Empty(t, idx.UserOwnerships("mallory"))
+	require.Len(t, idx.Ownerships(), 7)
+
+	// Path lookups give the same result as the reader
+	reader := NewReader()
+	for _, path := range []string{"sub/deep/file.txt", "other/file.txt", "sub", "."} {
+		expected, err := reader.Explain(filepath.Join(dir, path))
+		require.NoError(t, err)
+		exp, err := idx.Explain(filepath.Join(dir, path))
+		require.NoError(t, err)
+		require.Equal(t, expected, exp, path)
+	}
+
+	// Files removed in the PR are still resolved
+	list, err := idx.GetPathOwners(filepath.Join(dir, "sub", "deep", "removed.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []User{"erin"}, list.Approvers)
+
+	_, err = idx.Explain(filepath.Join(t.TempDir(), "file.txt"))
+	require.Error(t, err)
+}
+
+func TestGetPathsOwners(t *testing.T) {
+	dir := mkTempRepo(t)
+	defer os.RemoveAll(dir)
+	paths := writeBenchmarkTree(t, dir, 10, 100)
+
+	idx, err := NewReaderWithOptions(&Options{Workers: 3}).BuildIndex(dir)
+	require.NoError(t, err)
+	lists, err := idx.GetPathsOwners(paths)
+	require.NoError(t, err)
+	require.Len(t, lists, len(paths))
+
+	// Parallel resolution and merging are deterministic
+	reader := NewReader()
+	merged := NewList()
+	for i, path := range paths {
+		expected, err := reader.GetPathOwners(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, lists[i], path)
+		merged.Append(lists[len(lists)-1-i])
+	}
+	require.Equal(t, []User{"approver-0", "approver-1", "approver-2", "approver-3", "approver-4"}, merged.Approvers[:5])
+	require.Len(t, merged.Files, 11)
+	require.Equal(t, filepath.Join(dir, OwnersFileName), merged.Files[0].Path)
+	require.Equal(t, merged, MergeLists(lists...))
+
+	_, err = idx.GetPathsOwners([]string{filepath.Join(t.TempDir(), "outside.txt")})
+	require.Error(t, err)
+}
+
+// writeBenchmarkTree creates a repository with an OWNERS file at the
+// root and in each of dirs directories, each one with its own approver.
+// It returns the paths of n files spread over the directories.
+func writeBenchmarkTree(tb testing.TB, dir string, dirs, n int) []string {
+	files := map[string]string{OwnersFileName: "approvers:\n  - root-approver\nreviewers:\n  - root-reviewer\n"}
+	for i := 0; i < dirs; i++ {
+		files[filepath.Join(fmt.Sprintf("dir%d", i), "sub", OwnersFileName)] = fmt.Sprintf(
+			"approvers:\n  - approver-%d\nreviewers:\n  - reviewer-%d\n", i, i,
+		)
+	}
+	paths := []string{}
+	for i := 0; i < n; i++ {
+		path := filepath.Join(fmt.Sprintf("dir%d", i%dirs), "sub", "deep", fmt.Sprintf("file%d.go", i))
+		files[path] = "package test\n"
+		paths = append(paths, filepath.Join(dir, path))
+	}
+	for path, data := range files {
+		require.NoError(tb, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(tb, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+	return paths
+}
+
+// BenchmarkOwnersLargePR compares resolving the owners of a 2,000 file
+// pull request file by file with the reader and with the index
+func BenchmarkOwnersLargePR(b *testing.B) {
+	dir := mkTempRepo(b)
+	defer os.RemoveAll(dir)
+	paths := writeBenchmarkTree(b, dir, 50, 2000)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	b.Run("reader", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			reader := NewReader()
+			list := NewList()
+			for _, path := range paths {
+				l, err := reader.GetPathOwners(path)
+				require.NoError(b, err)
+				list.Append(l)
+			}
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			idx, err := NewReader().BuildIndex(dir)
+			require.NoError(b, err)
+			lists, err := idx.GetPathsOwners(paths)
+			require.NoError(b, err)
+			MergeLists(lists...)
+		}
+	})
+}
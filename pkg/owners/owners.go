@@ -3,6 +3,8 @@
 
 package owners
 
+import "sort"
+
 type Reader struct {
 	impl readerImplementation
 	opts *Options
@@ -16,7 +18,25 @@ func NewReader() *Reader {
 	return r
 }
 
-type Options struct{}
+// NewReaderWithOptions returns a reader configured with opts
+func NewReaderWithOptions(opts *Options) *Reader {
+	return &Reader{
+		impl: &defaultReaderImplementation{overlay: opts.Overlay},
+		opts: opts,
+	}
+}
+
+type Options struct {
+	// Workers is the number of paths resolved in parallel by
+	// Index.GetPathsOwners. If zero, the number of CPUs is used.
+	Workers int
+
+	// Overlay replaces the contents of OWNERS and OWNERS_ALIASES files
+	// on disk, indexed by absolute path. A nil value hides the file.
+	// It is used to read the owners of the base branch of a pull
+	// request from a checkout that has its changes.
+	Overlay map[string][]byte
+}
 
 func (reader *Reader) Options() *Options {
 	return reader.opts
@@ -44,65 +64,70 @@ func NewList() *List {
 	}
 }
 
-// Appends
+// Append merges the users and files of extraData into the list. The
+// result is sorted so that merging the same lists in any order gives
+// the same output.
 func (l *List) Append(extraData *List) {
 	if extraData == nil {
 		return
 	}
+	*l = *MergeLists(l, extraData)
+}
 
-	apps := map[User]struct{}{}
-	for _, name := range l.Approvers {
-		apps[name] = struct{}{}
-	}
-	for _, name := range extraData.Approvers {
-		if _, ok := apps[name]; !ok {
-			apps[name] = struct{}{}
+// MergeLists returns a new list with the users and files of all the
+// lists, sorted. When a file is in more than one list, the first one
+// is kept. Merging many lists at once is faster than appending them
+// one by one as the result is sorted only once.
+func MergeLists(lists ...*List) *List {
+	approvers := map[User]struct{}{}
+	reviewers := map[User]struct{}{}
+	files := map[string]File{}
+	for _, l := range lists {
+		if l == nil {
+			continue
 		}
-	}
-	l.Approvers = []User{}
-	for user := range apps {
-		l.Approvers = append(l.Approvers, user)
-	}
-
-	// Append to the reviewers
-	apps = map[User]struct{}{}
-	for _, name := range l.Reviewers {
-		apps[name] = struct{}{}
-	}
-	for _, name := range extraData.Reviewers {
-		if _, ok := apps[name]; !ok {
-			apps[name] = struct{}{}
+		for _, name := range l.Approvers {
+			approvers[name] = struct{}{}
+		}
+		for _, name := range l.Reviewers {
+			reviewers[name] = struct{}{}
+		}
+		for _, file := range l.Files {
+			if _, ok := files[file.Path]; !ok {
+				files[file.Path] = file
+			}
 		}
-	}
-	l.Reviewers = []User{}
-	for user := range apps {
-		l.Reviewers = append(l.Reviewers, user)
-	}
-
-	// Append to the files
-	files := map[string]File{}
-	for _, file := range l.Files {
-		files[file.Path] = file
 	}
 
-	// Cycle the values we're merging
-	for _, file := range extraData.Files {
-		if _, ok := files[file.Path]; !ok {
-			files[file.Path] = file
-		}
+	res := &List{
+		Files:     make([]File, 0, len(files)),
+		Approvers: sortedUsers(approvers),
+		Reviewers: sortedUsers(reviewers),
 	}
-	l.Files = []File{}
 	for _, file := range files {
-		l.Files = append(l.Files, file)
+		res.Files = append(res.Files, file)
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Path < res.Files[j].Path })
+	return res
+}
+
+// sortedUsers returns the users in a set, sorted
+func sortedUsers(set map[User]struct{}) []User {
+	users := make([]User, 0, len(set))
+	for user := range set {
+		users = append(users, user)
 	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return users
 }
 
 type (
 	User string
 	File struct {
-		Path      string
-		Approvers []User
-		Reviewers []User
+		Path           string
+		Approvers      []User
+		Reviewers      []User
+		NoParentOwners bool // Owners of parent directories are not inherited
 	}
 )
 
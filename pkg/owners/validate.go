@@ -0,0 +1,469 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity is the level of a problem found by the linter
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is an issue found in an OWNERS or OWNERS_ALIASES file
+type Problem struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// String returns the problem as file:line: severity: message
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.Severity, p.Message)
+}
+
+// ValidateOptions control the optional checks of the linter
+type ValidateOptions struct {
+	// IsCollaborator, if set, is called to check that the users in
+	// the OWNERS files are collaborators of the repository
+	IsCollaborator func(user string) (bool, error)
+}
+
+// Keys understood in OWNERS files. Only approvers, reviewers and
+// options are used by miniprow, the rest are accepted for compatibility
+// with Prow.
+var (
+	ownersUserKeys = map[string]struct{}{
+		"approvers": {}, "reviewers": {}, "required_reviewers": {},
+		"emeritus_approvers": {}, "emeritus_reviewers": {},
+	}
+	ownersKeys = map[string]struct{}{
+		"approvers": {}, "reviewers": {}, "required_reviewers": {},
+		"emeritus_approvers": {}, "emeritus_reviewers": {},
+		"labels": {}, "options": {}, "filters": {},
+	}
+	ownersOptionKeys = map[string]struct{}{
+		"no_parent_owners": {}, "auto_approve_unowned_subfolders": {},
+	}
+)
+
+// githubLogin matches valid GitHub user names
+var githubLogin = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9]|-[a-zA-Z0-9]){0,38}$`)
+
+// Validate lints all the OWNERS files in the repository and the
+// OWNERS_ALIASES file at its root
+func Validate(root string) ([]Problem, error) {
+	return ValidateWithOptions(root, &ValidateOptions{})
+}
+
+// ValidateWithOptions lints the OWNERS files in the repository
+func ValidateWithOptions(root string, opts *ValidateOptions) ([]Problem, error) {
+	v := &validator{
+		root:          root,
+		opts:          opts,
+		problems:      []Problem{},
+		aliases:       map[string]*aliasDefinition{},
+		collaborators: map[string]bool{},
+		users:         map[string]struct{}{},
+	}
+	if err := v.validateAliases(); err != nil {
+		return nil, err
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() != OwnersFileName {
+			return nil
+		}
+		return v.validateOwnersFile(path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking repository: %w", err)
+	}
+
+	// Aliases not referenced by any OWNERS file
+	for _, a := range v.aliases {
+		if !a.used {
+			v.add(v.aliasesFile(), a.line, SeverityWarning, fmt.Sprintf("alias %q is not used", a.name))
+		}
+	}
+
+	v.sort()
+	return v.problems, nil
+}
+
+// ValidateContents lints OWNERS files that are not on disk, such as the
+// versions in a pull request. Files are indexed by their path relative
+// to the repository root and OWNERS_ALIASES, if present, defines the
+// aliases. Unused aliases are not reported as the linter does not see
+// all the OWNERS files of the repository.
+func ValidateContents(files map[string][]byte, opts *ValidateOptions) ([]Problem, error) {
+	v := &validator{
+		root:          ".",
+		opts:          opts,
+		contents:      files,
+		problems:      []Problem{},
+		aliases:       map[string]*aliasDefinition{},
+		collaborators: map[string]bool{},
+		users:         map[string]struct{}{},
+	}
+	if err := v.validateAliases(); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range files {
+		if filepath.Base(path) == OwnersFileName {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := v.validateOwnersFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	v.sort()
+	return v.problems, nil
+}
+
+type aliasDefinition struct {
+	name string
+	line int
+	used bool
+}
+
+type validator struct {
+	root          string
+	opts          *ValidateOptions
+	problems      []Problem
+	aliases       map[string]*aliasDefinition // Indexed by lowercase name
+	collaborators map[string]bool
+
+	// users are the lowercase names known to be users: the members of
+	// the aliases and the names already reported as unknown
+	users map[string]struct{}
+
+	// contents, when set, replaces the files on disk
+	contents map[string][]byte
+}
+
+// sort orders the problems by file and line
+func (v *validator) sort() {
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].File != v.problems[j].File {
+			return v.problems[i].File < v.problems[j].File
+		}
+		return v.problems[i].Line < v.problems[j].Line
+	})
+}
+
+// readFile returns the data of a file, nil if it does not exist
+func (v *validator) readFile(path string) ([]byte, error) {
+	if v.contents != nil {
+		return v.contents[path], nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func (v *validator) add(file string, line int, severity Severity, msg string) {
+	if rel, err := filepath.Rel(v.root, file); err == nil {
+		file = rel
+	}
+	v.problems = append(v.problems, Problem{File: file, Line: line, Severity: severity, Message: msg})
+}
+
+func (v *validator) aliasesFile() string {
+	return filepath.Join(v.root, AliasesFileName)
+}
+
+// readYAML parses a file into a node. Syntax errors are recorded as
+// problems and a nil node is returned.
+func (v *validator) readYAML(path string) (*yaml.Node, error) {
+	data, err := v.readFile(path)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		v.add(path, yamlErrorLine(err), SeverityError, err.Error())
+		return nil, nil
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Line: 1}, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		v.add(path, doc.Content[0].Line, SeverityError, "expected a mapping at the top level")
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
+// validateAliases lints the OWNERS_ALIASES file and loads the aliases
+func (v *validator) validateAliases() error {
+	root, err := v.readYAML(v.aliasesFile())
+	if err != nil || root == nil {
+		return err
+	}
+	path := v.aliasesFile()
+
+	var aliasesNode *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "aliases" {
+			v.add(path, key.Line, SeverityError, fmt.Sprintf("unknown key %q", key.Value))
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			v.add(path, value.Line, SeverityError, "aliases must be a mapping of alias names to users")
+			continue
+		}
+		aliasesNode = value
+	}
+	if aliasesNode == nil {
+		return nil
+	}
+
+	// First pass: alias names
+	for i := 0; i+1 < len(aliasesNode.Content); i += 2 {
+		key := aliasesNode.Content[i]
+		name := strings.ToLower(key.Value)
+		if prev, ok := v.aliases[name]; ok {
+			v.add(path, key.Line, SeverityError, fmt.Sprintf(
+				"alias %q is already defined in line %d", key.Value, prev.line,
+			))
+			continue
+		}
+		v.aliases[name] = &aliasDefinition{name: key.Value, line: key.Line}
+	}
+
+	// Second pass: members
+	for i := 0; i+1 < len(aliasesNode.Content); i += 2 {
+		key, value := aliasesNode.Content[i], aliasesNode.Content[i+1]
+		entries, err := parseOwnersEntries(value)
+		if err != nil {
+			v.add(path, value.Line, SeverityError, fmt.Sprintf("alias %q: %v", key.Value, err))
+			continue
+		}
+		if len(entries) == 0 {
+			v.add(path, key.Line, SeverityWarning, fmt.Sprintf("alias %q has no members", key.Value))
+		}
+		v.checkDuplicates(path, entries)
+		for _, e := range entries {
+			if _, ok := v.aliases[strings.ToLower(e.Name)]; ok {
+				v.add(path, e.Line, SeverityError, fmt.Sprintf(
+					"alias %q references alias %q, aliases cannot be nested", key.Value, e.Name,
+				))
+				continue
+			}
+			v.users[strings.ToLower(e.Name)] = struct{}{}
+			v.checkUser(path, e)
+		}
+	}
+	return nil
+}
+
+// validateOwnersFile lints an OWNERS file
+func (v *validator) validateOwnersFile(path string) error {
+	root, err := v.readYAML(path)
+	if err != nil || root == nil {
+		return err
+	}
+
+	approvers := 0
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if _, ok := ownersKeys[key.Value]; !ok {
+			v.add(path, key.Line, SeverityError, fmt.Sprintf("unknown key %q", key.Value))
+			continue
+		}
+		switch key.Value {
+		case "options":
+			v.validateOptions(path, value)
+		case "filters":
+			approvers += v.validateFilters(path, value)
+		case "labels":
+			if _, err := parseOwnersEntries(value); err != nil {
+				v.add(path, value.Line, SeverityError, fmt.Sprintf("labels: %v", err))
+			}
+		default:
+			n := v.validateUsers(path, key.Value, value)
+			if key.Value == "approvers" {
+				approvers += n
+			}
+		}
+	}
+
+	if approvers == 0 {
+		v.add(path, root.Line, SeverityWarning, "file does not define any approvers")
+	}
+	return nil
+}
+
+// validateUsers lints a list of users and returns how many entries it has
+func (v *validator) validateUsers(path, key string, node *yaml.Node) int {
+	entries, err := parseOwnersEntries(node)
+	if err != nil {
+		v.add(path, node.Line, SeverityError, fmt.Sprintf("%s: %v", key, err))
+		return 0
+	}
+	v.checkDuplicates(path, entries)
+	for _, e := range entries {
+		if a, ok := v.aliases[strings.ToLower(e.Name)]; ok {
+			a.used = true
+			continue
+		}
+		if !githubLogin.MatchString(e.Name) {
+			v.add(path, e.Line, SeverityError, fmt.Sprintf(
+				"%q is not a valid user name nor a defined alias", e.Name,
+			))
+			continue
+		}
+		if v.opts == nil || v.opts.IsCollaborator == nil {
+			v.checkKnownUser(path, e)
+			continue
+		}
+		v.checkUser(path, e)
+	}
+	return len(entries)
+}
+
+// checkKnownUser warns about names that are not known to be users when
+// the collaborators are not checked, they may be undefined aliases. Each
+// name is reported once.
+func (v *validator) checkKnownUser(path string, e ownersEntry) {
+	name := strings.ToLower(e.Name)
+	if _, ok := v.users[name]; ok {
+		return
+	}
+	v.users[name] = struct{}{}
+	v.add(path, e.Line, SeverityWarning, fmt.Sprintf(
+		"%q is not a defined alias nor a member of one, check that it is a user", e.Name,
+	))
+}
+
+// validateOptions checks the keys in the options block
+func (v *validator) validateOptions(path string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.add(path, node.Line, SeverityError, "options must be a mapping")
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if _, ok := ownersOptionKeys[key.Value]; !ok {
+			v.add(path, key.Line, SeverityError, fmt.Sprintf("unknown option %q", key.Value))
+			continue
+		}
+		b := false
+		if err := value.Decode(&b); err != nil {
+			v.add(path, value.Line, SeverityError, fmt.Sprintf("option %q must be true or false", key.Value))
+		}
+	}
+}
+
+// validateFilters checks the filter regular expressions and their users.
+// It returns the number of approvers defined in the filters.
+func (v *validator) validateFilters(path string, node *yaml.Node) int {
+	if node.Kind != yaml.MappingNode {
+		v.add(path, node.Line, SeverityError, "filters must be a mapping of regular expressions")
+		return 0
+	}
+	approvers := 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if _, err := regexp.Compile(key.Value); err != nil {
+			v.add(path, key.Line, SeverityError, fmt.Sprintf("invalid filter regex %q: %v", key.Value, err))
+		}
+		if value.Kind != yaml.MappingNode {
+			v.add(path, value.Line, SeverityError, fmt.Sprintf("filter %q must be a mapping", key.Value))
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			fkey, fvalue := value.Content[j], value.Content[j+1]
+			if _, ok := ownersUserKeys[fkey.Value]; !ok && fkey.Value != "labels" {
+				v.add(path, fkey.Line, SeverityError, fmt.Sprintf("unknown key %q in filter", fkey.Value))
+				continue
+			}
+			if fkey.Value == "labels" {
+				continue
+			}
+			n := v.validateUsers(path, fkey.Value, fvalue)
+			if fkey.Value == "approvers" {
+				approvers += n
+			}
+		}
+	}
+	return approvers
+}
+
+// checkDuplicates reports users listed more than once
+func (v *validator) checkDuplicates(path string, entries []ownersEntry) {
+	seen := map[string]int{}
+	for _, e := range entries {
+		name := strings.ToLower(e.Name)
+		if line, ok := seen[name]; ok {
+			v.add(path, e.Line, SeverityWarning, fmt.Sprintf("%q is already listed in line %d", e.Name, line))
+			continue
+		}
+		seen[name] = e.Line
+	}
+}
+
+// checkUser verifies the user is a collaborator, if enabled
+func (v *validator) checkUser(path string, e ownersEntry) {
+	if v.opts == nil || v.opts.IsCollaborator == nil {
+		return
+	}
+	name := strings.ToLower(e.Name)
+	isCollaborator, ok := v.collaborators[name]
+	if !ok {
+		var err error
+		isCollaborator, err = v.opts.IsCollaborator(e.Name)
+		if err != nil {
+			v.add(path, e.Line, SeverityWarning, fmt.Sprintf("unable to check if %q is a collaborator: %v", e.Name, err))
+			return
+		}
+		v.collaborators[name] = isCollaborator
+	}
+	if !isCollaborator {
+		v.add(path, e.Line, SeverityError, fmt.Sprintf("%q is not a collaborator of the repository", e.Name))
+	}
+}
+
+// yamlErrorLine extracts the line number from a yaml error message
+func yamlErrorLine(err error) int {
+	line := 0
+	msg := err.Error()
+	if i := strings.Index(msg, "line "); i >= 0 {
+		_, _ = fmt.Sscanf(msg[i:], "line %d", &line)
+	}
+	return line
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2022 U Servers Comunicaciones, SC
+// SPDX-License-Identifier: Apache-2.0
+
+package owners
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	dir := mkTempRepo(t)
+	defer os.RemoveAll(dir)
+
+	for path, data := range map[string]string{
+		AliasesFileName: "aliases:\n  leads:\n    - alice\n    - alice\n  unused:\n    - bob\n" +
+			"  nested:\n    - leads\n",
+		OwnersFileName: "approvers:\n  - leads\n  - nested\nreviewers:\n  - bob\n",
+		"sub/OWNERS": "aprovers:\n  - carol\nreviewers:\n  - carol\n  - Carol\n  - team/sig\n" +
+			"options:\n  no_parent_owner: true\nfilters:\n  \"[\":\n    approvers:\n      - dave\n",
+		"ok/OWNERS":     "approvers:\n  - erin\n",
+		"empty/OWNERS":  "reviewers:\n  - bob\n",
+		"broken/OWNERS": "approvers: [\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(data), os.FileMode(0o644)))
+	}
+
+	problems, err := Validate(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Problem{
+		{AliasesFileName, 4, SeverityWarning, `"alice" is already listed in line 3`},
+		{AliasesFileName, 5, SeverityWarning, `alias "unused" is not used`},
+		{AliasesFileName, 8, SeverityError, `alias "nested" references alias "leads", aliases cannot be nested`},
+		{"broken/OWNERS", 1, SeverityError, "yaml: line 1: did not find expected node content"},
+		{"empty/OWNERS", 1, SeverityWarning, "file does not define any approvers"},
+		{"ok/OWNERS", 2, SeverityWarning, `"erin" is not a defined alias nor a member of one, check that it is a user`},
+		{"sub/OWNERS", 1, SeverityError, `unknown key "aprovers"`},
+		{"sub/OWNERS", 4, SeverityWarning, `"carol" is not a defined alias nor a member of one, check that it is a user`},
+		{"sub/OWNERS", 5, SeverityWarning, `"Carol" is already listed in line 4`},
+		{"sub/OWNERS", 6, SeverityError, `"team/sig" is not a valid user name nor a defined alias`},
+		{"sub/OWNERS", 8, SeverityError, `unknown option "no_parent_owner"`},
+		{
+			"sub/OWNERS", 10, SeverityError,
+			"invalid filter regex \"[\": error parsing regexp: missing closing ]: `[`",
+		},
+		{"sub/OWNERS", 12, SeverityWarning, `"dave" is not a defined alias nor a member of one, check that it is a user`},
+	}, problems)
+
+	// Collaborator check
+	problems, err = ValidateWithOptions(filepath.Join(dir, "ok"), &ValidateOptions{
+		IsCollaborator: func(user string) (bool, error) { return user != "erin", nil },
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Problem{
+		{OwnersFileName, 2, SeverityError, `"erin" is not a collaborator of the repository`},
+	}, problems)
+}
+
+func TestValidateContents(t *testing.T) {
+	problems, err := ValidateContents(map[string][]byte{
+		AliasesFileName: []byte("aliases:\n  leads:\n    - alice\n  unused:\n    - bob\n"),
+		"sub/OWNERS":    []byte("approvers:\n  - leads\n  - team/sig\n"),
+		"new/OWNERS":    []byte("approvers: [\n"),
+		"README.md":     []byte("not an OWNERS file"),
+	}, nil)
+	require.NoError(t, err)
+	// Unused aliases are not reported as not all files are known
+	require.Equal(t, []Problem{
+		{"new/OWNERS", 1, SeverityError, "yaml: line 1: did not find expected node content"},
+		{"sub/OWNERS", 3, SeverityError, `"team/sig" is not a valid user name nor a defined alias`},
+	}, problems)
+}
+
+func TestValidateUndefinedAliases(t *testing.T) {
+	files := map[string][]byte{
+		AliasesFileName: []byte("aliases:\n  sig-leads:\n    - alice\n"),
+		OwnersFileName:  []byte("approvers:\n  - sig-leads\n  - alice\nreviewers:\n  - sig-reviewers\n"),
+		"sub/OWNERS":    []byte("approvers:\n  - sig-reviewers\n"),
+	}
+
+	// Names that look like users but are not defined aliases nor alias
+	// members are reported once
+	problems, err := ValidateContents(files, nil)
+	require.NoError(t, err)
+	require.Equal(t, []Problem{
+		{
+			OwnersFileName, 5, SeverityWarning,
+			`"sig-reviewers" is not a defined alias nor a member of one, check that it is a user`,
+		},
+	}, problems)
+
+	// The collaborator check confirms the users instead
+	problems, err = ValidateContents(files, &ValidateOptions{
+		IsCollaborator: func(user string) (bool, error) { return user == "alice", nil },
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Problem{
+		{OwnersFileName, 5, SeverityError, `"sig-reviewers" is not a collaborator of the repository`},
+		{"sub/OWNERS", 2, SeverityError, `"sig-reviewers" is not a collaborator of the repository`},
+	}, problems)
+}